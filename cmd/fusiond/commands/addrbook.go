@@ -0,0 +1,211 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	nm "github.com/go-fusion/node"
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+// addrBookAdmin is implemented by pex.AddrBookAdmin, which edits the
+// address book file, and by node.AdminClient, which edits the book of a
+// running node over the admin RPC.
+type addrBookAdmin interface {
+	List() ([]pex.AddressInfo, error)
+	Add(addr, src string) error
+	Remove(addr string) error
+	MarkBad(addr string) error
+	Prune() (int, error)
+	Import(infos []pex.AddressInfo) (int, error)
+}
+
+// NOTE: without --rpc, the address book is edited in place, so the node
+// must be stopped, or it overwrites the changes when it saves the book.
+var addrBookCmd = &cobra.Command{
+	Use:   "addrbook",
+	Short: "Inspect and edit the address book",
+}
+
+var addrBookListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the known addresses",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			infos, err := book.List()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ADDRESS\tBUCKET\tATTEMPTS\tLAST SUCCESS\tSOURCE\tLATENCY\tLAST ERROR")
+			for _, info := range infos {
+				bucket := info.Bucket
+				if info.Local {
+					bucket += " (local)"
+				}
+				if info.Banned {
+					bucket += " (banned)"
+				}
+				lastSuccess := "never"
+				if !info.LastSuccess.IsZero() {
+					lastSuccess = info.LastSuccess.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%v\t%s\n",
+					info.Addr, bucket, info.Attempts, lastSuccess, info.Src, info.Latency, info.LastError)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+var addrBookAddCmd = &cobra.Command{
+	Use:   "add <ID@host:port>",
+	Short: "Add an address",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, err := cmd.Flags().GetString("src")
+		if err != nil {
+			return err
+		}
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			return book.Add(args[0], src)
+		})
+	},
+}
+
+var addrBookRemoveCmd = &cobra.Command{
+	Use:   "remove <ID@host:port>",
+	Short: "Remove the address of a peer",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			return book.Remove(args[0])
+		})
+	},
+}
+
+var addrBookMarkBadCmd = &cobra.Command{
+	Use:   "mark-bad <ID@host:port>",
+	Short: "Mark the address of a peer as bad",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			return book.MarkBad(args[0])
+		})
+	},
+}
+
+var addrBookPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the addresses that failed too often or weren't tried in a long time",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			n, err := book.Prune()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Removed %d addresses\n", n)
+			return nil
+		})
+	},
+}
+
+var addrBookExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the known addresses as JSON, to stdout if no file is given",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			infos, err := book.List()
+			if err != nil {
+				return err
+			}
+			jsonBytes, err := json.MarshalIndent(infos, "", "\t")
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				_, err = fmt.Println(string(jsonBytes))
+				return err
+			}
+			return ioutil.WriteFile(args[0], jsonBytes, 0644)
+		})
+	},
+}
+
+var addrBookImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Add the addresses of an export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonBytes, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		var infos []pex.AddressInfo
+		if err := json.Unmarshal(jsonBytes, &infos); err != nil {
+			return err
+		}
+		return withAddrBook(cmd, func(book addrBookAdmin) error {
+			n, err := book.Import(infos)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Added %d of %d addresses\n", n, len(infos))
+			return nil
+		})
+	},
+}
+
+// withAddrBook runs fn on the book of the node at --rpc, or on the address
+// book of the stopped node, which is saved afterwards.
+func withAddrBook(cmd *cobra.Command, fn func(addrBookAdmin) error) error {
+	rpcAddr, err := cmd.Flags().GetString("rpc")
+	if err != nil {
+		return err
+	}
+	if rpcAddr != "" {
+		return fn(nm.NewAdminClient(rpcAddr))
+	}
+
+	// the book of the node, with its asmap to keep its buckets and its bans
+	banListDB, err := nm.DefaultDBProvider(&nm.DBContext{ID: "banlist", Config: config})
+	if err != nil {
+		return err
+	}
+	defer banListDB.Close()
+	book, err := nm.NewAddrBook(config, nm.DefaultDBProvider, p2p.NewBanList(banListDB), logger)
+	if err != nil {
+		return err
+	}
+	if err := book.Start(); err != nil {
+		return err
+	}
+	err = fn(pex.NewAddrBookAdmin(book))
+	book.Save()
+	book.Stop()
+	return err
+}
+
+func init() {
+	addrBookCmd.PersistentFlags().String("rpc", "", "Admin RPC address of a running node, e.g. unix://admin.sock (edits the file when empty)")
+	addrBookAddCmd.Flags().String("src", "", "Address the address was heard from (defaults to itself)")
+
+	addrBookCmd.AddCommand(addrBookListCmd)
+	addrBookCmd.AddCommand(addrBookAddCmd)
+	addrBookCmd.AddCommand(addrBookRemoveCmd)
+	addrBookCmd.AddCommand(addrBookMarkBadCmd)
+	addrBookCmd.AddCommand(addrBookPruneCmd)
+	addrBookCmd.AddCommand(addrBookExportCmd)
+	addrBookCmd.AddCommand(addrBookImportCmd)
+	rootCmd.AddCommand(addrBookCmd)
+}
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/cobra"
+
+	"github.com/go-fusion/p2p"
+)
+
+// asmapCmd checks an asmap before it is set in the config, as the node
+// refuses to start with a malformed one.
+var asmapCmd = &cobra.Command{
+	Use:   "asmap <file> [IP...]",
+	Short: "Check an asmap file and show the ASN of the given IPs",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asmap, err := p2p.LoadASMap(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: valid, sha256 %s\n", args[0], asmap.Checksum())
+		for _, arg := range args[1:] {
+			ip := net.ParseIP(arg)
+			if ip == nil {
+				return fmt.Errorf("Invalid IP %q", arg)
+			}
+			if asn := asmap.ASN(ip); asn != 0 {
+				fmt.Printf("%s AS%d\n", ip, asn)
+			} else {
+				fmt.Printf("%s unknown\n", ip)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(asmapCmd)
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	nm "github.com/go-fusion/node"
+	"github.com/go-fusion/p2p"
+)
+
+// banAdmin is implemented by localBanList, which edits the ban list in the
+// node's DB, and by node.AdminClient, which edits the bans of a running
+// node over the admin RPC.
+type banAdmin interface {
+	BanList() ([]*p2p.BanEntry, error)
+	Ban(target string, duration time.Duration, reason string) error
+	Unban(target string) error
+}
+
+// localBanList edits the ban list in the node's DB.
+type localBanList struct {
+	bl *p2p.BanList
+}
+
+func (l localBanList) BanList() ([]*p2p.BanEntry, error) {
+	return l.bl.List(), nil
+}
+
+func (l localBanList) Ban(target string, duration time.Duration, reason string) error {
+	return l.bl.Ban(target, duration, reason)
+}
+
+func (l localBanList) Unban(target string) error {
+	return l.bl.Unban(target)
+}
+
+// NOTE: without --rpc, the ban list is edited in the node's DB, so the node
+// must be stopped.
+var banCmd = &cobra.Command{
+	Use:   "ban",
+	Short: "Manage the list of banned peer IDs, IPs and CIDR ranges",
+}
+
+var banListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the bans in force",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withBanList(cmd, func(bans banAdmin) error {
+			entries, err := bans.BanList()
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				fmt.Println(entry)
+			}
+			return nil
+		})
+	},
+}
+
+var banAddCmd = &cobra.Command{
+	Use:   "add <peer ID | IP | CIDR>",
+	Short: "Ban a peer ID, an IP or a CIDR range",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		duration, err := cmd.Flags().GetDuration("duration")
+		if err != nil {
+			return err
+		}
+		reason, err := cmd.Flags().GetString("reason")
+		if err != nil {
+			return err
+		}
+		return withBanList(cmd, func(bans banAdmin) error {
+			return bans.Ban(args[0], duration, reason)
+		})
+	},
+}
+
+var banRemoveCmd = &cobra.Command{
+	Use:   "remove <peer ID | IP | CIDR>",
+	Short: "Lift a ban",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withBanList(cmd, func(bans banAdmin) error {
+			return bans.Unban(args[0])
+		})
+	},
+}
+
+// withBanList runs fn on the bans of the node at --rpc, or on the ban list
+// in the node's DB.
+func withBanList(cmd *cobra.Command, fn func(banAdmin) error) error {
+	rpcAddr, err := cmd.Flags().GetString("rpc")
+	if err != nil {
+		return err
+	}
+	if rpcAddr != "" {
+		return fn(nm.NewAdminClient(rpcAddr))
+	}
+
+	db, err := nm.DefaultDBProvider(&nm.DBContext{ID: "banlist", Config: config})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(localBanList{p2p.NewBanList(db)})
+}
+
+func init() {
+	banCmd.PersistentFlags().String("rpc", "", "Admin RPC address of a running node, e.g. unix://admin.sock (edits the DB when empty)")
+	banAddCmd.Flags().Duration("duration", 0, "How long the ban lasts (0 bans forever)")
+	banAddCmd.Flags().String("reason", "banned by operator", "Why the ban was set")
+
+	banCmd.AddCommand(banListCmd)
+	banCmd.AddCommand(banAddCmd)
+	banCmd.AddCommand(banRemoveCmd)
+	rootCmd.AddCommand(banCmd)
+}
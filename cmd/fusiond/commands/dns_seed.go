@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	nm "github.com/go-fusion/node"
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+// NOTE: the host name must be delegated to the seeder by an NS record,
+// and nodes configured with it as "<node ID>@<host>" in p2p.dns_seeds.
+func newDNSSeedCmd(nodeProvider nm.Provider) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dnsseed",
+		Short: "Crawl the network in seed mode and serve the addresses found as DNS seed records",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			host, err := cmd.Flags().GetString("dns.host")
+			if err != nil {
+				return err
+			}
+			if host == "" {
+				return fmt.Errorf("Missing --dns.host")
+			}
+			laddr, err := cmd.Flags().GetString("dns.laddr")
+			if err != nil {
+				return err
+			}
+
+			config.P2P.PexReactor = true
+			config.P2P.SeedMode = true
+			n, err := nodeProvider(config, logger)
+			if err != nil {
+				return fmt.Errorf("Failed to create node: %v", err)
+			}
+			if err := n.Start(); err != nil {
+				return fmt.Errorf("Failed to start node: %v", err)
+			}
+			logger.Info("Started node", "nodeInfo", n.Switch().NodeInfo())
+
+			nodeKey, err := p2p.LoadOrGenNodeKey(config.NodeKeyFile())
+			if err != nil {
+				return err
+			}
+			seeder := pex.NewDNSSeeder(n.AddrBook(), host, laddr, nodeKey.PrivKey)
+			seeder.SetLogger(logger.With("module", "dnsseed"))
+			if err := seeder.Start(); err != nil {
+				n.Stop() // nolint: errcheck
+				return fmt.Errorf("Failed to start DNS seeder: %v", err)
+			}
+			logger.Info("Serving DNS seed records", "host", host, "seed", p2p.IDAddressString(nodeKey.ID(), host))
+
+			cmn.TrapSignal(func() {
+				seeder.Stop() // nolint: errcheck
+				n.Stop()      // nolint: errcheck
+			})
+			return nil
+		},
+	}
+	addNodeFlags(cmd)
+	cmd.Flags().String("dns.host", "", "Host name to serve the DNS seed records for, e.g. seed.example.com")
+	cmd.Flags().String("dns.laddr", "0.0.0.0:53", "UDP address to serve DNS queries on")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newDNSSeedCmd(nm.DefaultNewNode))
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	nm "github.com/go-fusion/node"
+)
+
+var filtersCmd = &cobra.Command{
+	Use:   "filters",
+	Short: "Manage the connection filters of a running node",
+}
+
+var filtersReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Apply the connection filters of the config file to a running node",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rpcAddr, err := cmd.Flags().GetString("rpc")
+		if err != nil {
+			return err
+		}
+		if rpcAddr == "" {
+			rpcAddr = config.AdminListenAddress
+		}
+		if rpcAddr == "" {
+			return errors.New("no admin RPC address: set --rpc or admin_laddr")
+		}
+		return nm.NewAdminClient(rpcAddr).ReloadConnFilters(config.P2P)
+	},
+}
+
+func init() {
+	filtersCmd.PersistentFlags().String("rpc", "", "Admin RPC address of the running node, e.g. unix://admin.sock (defaults to admin_laddr)")
+
+	filtersCmd.AddCommand(filtersReloadCmd)
+	rootCmd.AddCommand(filtersCmd)
+}
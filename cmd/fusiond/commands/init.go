@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"github.com/spf13/cobra"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/protocol/state"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a genesis file with no balance, unless there is one",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		genFile := config.GenesisFile()
+		if cmn.FileExists(genFile) {
+			logger.Info("Found genesis file", "path", genFile)
+			return nil
+		}
+		if err := state.DefaultGenesis(config.ChainID).SaveAs(genFile); err != nil {
+			return err
+		}
+		logger.Info("Generated genesis file", "path", genFile)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+}
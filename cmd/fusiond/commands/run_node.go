@@ -2,16 +2,23 @@ package commands
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	nm "github.com/go-fusion/node"
 )
 
 func addNodeFlags(cmd *cobra.Command) {
 
-	cmd.Flags().String("p2p.laddr", config.P2P.ListenAddress, "Node listen address. (0.0.0.0:0 means any interface, any port)")
+	cmd.Flags().String("p2p.laddr", config.P2P.ListenAddress, "Comma separated list of node listen addresses. (0.0.0.0:0 means any interface, any port)")
 	cmd.Flags().String("p2p.seeds", config.P2P.Seeds, "Comma-delimited ID@host:port seed nodes")
+	cmd.Flags().String("p2p.crawl_report_laddr", config.P2P.CrawlReportListenAddress, "Seed mode crawl report listen address, e.g. tcp://0.0.0.0:12381 (empty to disable)")
+	cmd.Flags().String("rpc_laddr", config.RPCListenAddress, "RPC listen address, e.g. tcp://0.0.0.0:12382 (empty to disable)")
+	cmd.Flags().String("admin_laddr", config.AdminListenAddress, "Admin RPC listen address, e.g. unix://admin.sock (empty to disable)")
 }
 
 func newRunNodeCmd(nodeProvider nm.Provider) *cobra.Command {
@@ -27,6 +34,7 @@ func newRunNodeCmd(nodeProvider nm.Provider) *cobra.Command {
 				return fmt.Errorf("Failed to start node: %v", err)
 			}
 			logger.Info("Started node", "nodeInfo", n.Switch().NodeInfo())
+			go reloadOnSIGHUP(n)
 			n.RunForever()
 			return nil
 		},
@@ -35,6 +43,27 @@ func newRunNodeCmd(nodeProvider nm.Provider) *cobra.Command {
 	return cmd
 }
 
+// reloadOnSIGHUP re-reads the config file on every SIGHUP and applies its
+// connection filters to the running node.
+func reloadOnSIGHUP(n *nm.Node) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := viper.ReadInConfig(); err != nil {
+			logger.Error("Failed to read config file", "err", err)
+			continue
+		}
+		conf, err := ParseConfig()
+		if err != nil {
+			logger.Error("Failed to parse config", "err", err)
+			continue
+		}
+		if err := n.ReloadConnFilters(conf.P2P); err != nil {
+			logger.Error("Failed to reload connection filters", "err", err)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(newRunNodeCmd(nm.DefaultNewNode))
 }
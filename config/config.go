@@ -22,12 +22,16 @@ var (
 
 	defaultChainID = "testnet"
 
+	defaultGenesisName  = "genesis.json"
 	defaultNodeKeyName  = "node_key.json"
 	defaultAddrBookName = "addrbook.json"
+	defaultAnchorsName  = "anchors.json"
 
 	defaultConfigFilePath = filepath.Join(defaultConfigDir, defaultConfigFileName)
+	defaultGenesisPath    = filepath.Join(defaultConfigDir, defaultGenesisName)
 	defaultNodeKeyPath    = filepath.Join(defaultConfigDir, defaultNodeKeyName)
 	defaultAddrBookPath   = filepath.Join(defaultConfigDir, defaultAddrBookName)
+	defaultAnchorsPath    = filepath.Join(defaultConfigDir, defaultAnchorsName)
 )
 
 // Config defines the top level configuration for a Tendermint node
@@ -66,6 +70,10 @@ type BaseConfig struct {
 	// This should be set in viper so it can unmarshal into this struct
 	RootDir string `mapstructure:"home"`
 
+	// A JSON file containing the genesis of the chain: its chain ID, the
+	// values its first block is checked against and its initial balances
+	Genesis string `mapstructure:"genesis_file"`
+
 	// A JSON file containing the private key to use for p2p authenticated encryption
 	NodeKey string `mapstructure:"node_key_file"`
 
@@ -80,12 +88,22 @@ type BaseConfig struct {
 
 	// Database directory
 	DBPath string `mapstructure:"db_dir"`
+
+	// Address of the admin RPC, e.g. "unix:///var/run/fusiond-admin.sock"
+	// or "tcp://127.0.0.1:12380". Empty to disable it.
+	// NOTE: it has no authentication, so other addresses are refused
+	AdminListenAddress string `mapstructure:"admin_laddr"`
+
+	// Address of the public RPC, e.g. "tcp://0.0.0.0:12382", which answers
+	// chain queries such as getLogs. Empty to disable it.
+	RPCListenAddress string `mapstructure:"rpc_laddr"`
 }
 
 // DefaultBaseConfig returns a default base configuration for a Tendermint node
 func DefaultBaseConfig() BaseConfig {
 	return BaseConfig{
 		ChainID:   defaultChainID,
+		Genesis:   defaultGenesisPath,
 		NodeKey:   defaultNodeKeyPath,
 		Moniker:   defaultMoniker,
 		LogLevel:  DefaultPackageLogLevels(),
@@ -98,29 +116,106 @@ func DefaultBaseConfig() BaseConfig {
 type P2PConfig struct {
 	RootDir string `mapstructure:"home"`
 
-	// Address to listen for incoming connections
+	// Comma separated list of addresses to listen for incoming connections,
+	// e.g. "tcp4://0.0.0.0:12345,tcp6://[::]:12345,unix:///var/run/fusiond.sock"
+	// The transports are tcp, tcp4, tcp6, unix, memory and ws (WebSocket,
+	// e.g. "ws://0.0.0.0:12346/p2p"). Unix sockets are not advertised to peers
 	ListenAddress string `mapstructure:"laddr"`
 
 	// Comma separated list of seed nodes to connect to
 	// We only use these if we can’t connect to peers in the addrbook
 	Seeds string `mapstructure:"seeds"`
 
+	// Comma separated list of DNS seeds, as ID@hostname, whose TXT records
+	// list peer addresses signed by the node with that ID
+	// Resolved at startup, then whenever the addrbook needs more addresses
+	DNSSeeds string `mapstructure:"dns_seeds"`
+
+	// Set true to discover peers over UDP, in addition to PEX
+	Discovery bool `mapstructure:"discovery"`
+
+	// UDP address the discovery service listens on
+	DiscoveryListenAddress string `mapstructure:"discovery_laddr"`
+
+	// Comma separated list of ID@host:port discovery nodes to bootstrap from
+	Bootnodes string `mapstructure:"bootnodes"`
+
+	// Set true to announce ourselves on the local network by multicast, and
+	// add the nodes of the same chain heard there to the addrbook.
+	// For private and dev clusters on one network segment
+	LocalDiscovery bool `mapstructure:"local_discovery"`
+
+	// Multicast group and port of the local discovery
+	LocalDiscoveryAddress string `mapstructure:"local_discovery_addr"`
+
 	// Comma separated list of nodes to keep persistent connections to
 	// Do not add private peers to this list if you don't want them advertised
 	PersistentPeers string `mapstructure:"persistent_peers"`
 
-	// Skip UPNP port forwarding
+	// NAT port mapping mechanism: "none", "any", "upnp", "pmp", "pmp:<gateway IP>"
+	// or "extip:<external IP>"
+	NAT string `mapstructure:"nat"`
+
+	// Deprecated: use nat. If true, the same as nat = "none"
 	SkipUPNP bool `mapstructure:"skip_upnp"`
 
+	// SOCKS5 proxy to dial TCP peers through, e.g. "socks5://127.0.0.1:9050"
+	// for Tor. Empty to dial directly. Only the nodes with a proxy dial
+	// .onion addresses, and get them from PEX
+	Proxy string `mapstructure:"proxy"`
+
+	// Use different proxy credentials for each peer, so that Tor uses a
+	// separate circuit for each of them
+	ProxyIsolateStreams bool `mapstructure:"proxy_isolate_streams"`
+
 	// Path to address book
 	AddrBook string `mapstructure:"addr_book_file"`
 
 	// Set true for strict address routability rules
 	AddrBookStrict bool `mapstructure:"addr_book_strict"`
 
-	// Maximum number of peers to connect to
+	// Where the address book is stored: "file" saves addr_book_file every
+	// two minutes, "db" writes each address to the node's DB on change,
+	// after moving the addresses of addr_book_file to it once
+	AddrBookBackend string `mapstructure:"addr_book_backend"`
+
+	// Path to an IP to ASN map, in the asmap format of Bitcoin Core.
+	// When set, the address book groups addresses, and the outbound peers
+	// are spread, by autonomous system instead of by /16 (IPv4) or /32 (IPv6)
+	ASMap string `mapstructure:"asmap_file"`
+
+	// Path to the anchor peers, saved on shutdown and dialed first on
+	// startup. Empty to not use anchors
+	Anchors string `mapstructure:"anchors_file"`
+
+	// Path to the report of the nodes crawled in seed mode, saved after
+	// each crawl. Empty to not save it
+	CrawlReport string `mapstructure:"crawl_report_file"`
+
+	// Address to serve the crawl report on in seed mode, as JSON over HTTP,
+	// e.g. "tcp://0.0.0.0:12381". Empty to not serve it
+	CrawlReportListenAddress string `mapstructure:"crawl_report_laddr"`
+
+	// Maximum number of inbound peers
+	MaxInbound int `mapstructure:"max_inbound"`
+
+	// Maximum number of outbound peers, dialing ones included
+	MaxOutbound int `mapstructure:"max_outbound"`
+
+	// Number of outbound peers the peer-exchange reactor tries to keep
+	MinOutbound int `mapstructure:"min_outbound"`
+
+	// Maximum number of inbound peers from the same /24 (IPv4) or /48 (IPv6)
+	MaxInboundPerSubnet int `mapstructure:"max_inbound_per_subnet"`
+
+	// Deprecated: use max_inbound and max_outbound. Maximum number of
+	// peers, split by ApplyDeprecated into min_outbound outbound peers and
+	// the rest inbound. 0 to not use it
 	MaxNumPeers int `mapstructure:"max_num_peers"`
 
+	// Comma separated list of peer IDs exempt from the peer limits
+	UnconditionalPeerIDs string `mapstructure:"unconditional_peer_ids"`
+
 	// Time to wait before flushing messages out on the connection, in ms
 	FlushThrottleTimeout int `mapstructure:"flush_throttle_timeout"`
 
@@ -147,15 +242,65 @@ type P2PConfig struct {
 
 	// Comma separated list of peer IDs to keep private (will not be gossiped to other peers)
 	PrivatePeerIDs string `mapstructure:"private_peer_ids"`
+
+	// Peers with a trust score (0-100) below this are not redialed
+	MinTrustScore int `mapstructure:"min_trust_score"`
+
+	// Penalties added to a peer's misbehaviour score, keyed by behaviour name
+	// (bad_message, invalid_block, invalid_tx, spam, useful_block, useful_tx,
+	// invalid_record).
+	// Useful behaviours take negative values. Missing names use the defaults.
+	BehaviourWeights map[string]int `mapstructure:"behaviour_weights"`
+
+	// Misbehaviour score at which a peer is disconnected
+	DisconnectScore int `mapstructure:"disconnect_score"`
+
+	// Misbehaviour score at which a peer is banned
+	BanScore int `mapstructure:"ban_score"`
+
+	// Time in seconds after which a misbehaviour score is halved, so that
+	// old faults are forgiven. 0 keeps scores until the peer is banned
+	BehaviourScoreHalfLife int `mapstructure:"behaviour_score_half_life"`
+
+	// How long a misbehaving peer stays banned, in seconds. 0 bans forever
+	BanTime int `mapstructure:"ban_time"`
+
+	// Comma separated list of CIDRs to accept connections from and to.
+	// Empty allows every address not denied
+	AllowedCIDRs string `mapstructure:"allowed_cidrs"`
+
+	// Comma separated list of CIDRs to refuse connections from and to
+	DeniedCIDRs string `mapstructure:"denied_cidrs"`
+
+	// Comma separated list of peer IDs accepted in private network mode
+	AllowedPeerIDs string `mapstructure:"allowed_peer_ids"`
+
+	// Comma separated list of peer IDs to refuse connections from and to
+	DeniedPeerIDs string `mapstructure:"denied_peer_ids"`
+
+	// Private network mode, in which only the allowed peer IDs are accepted.
+	// The connection filters are reloaded on SIGHUP, or by
+	// "fusiond filters reload --rpc <admin_laddr>"
+	PrivateNetwork bool `mapstructure:"private_network"`
 }
 
 // DefaultP2PConfig returns a default configuration for the peer-to-peer layer
 func DefaultP2PConfig() *P2PConfig {
 	return &P2PConfig{
 		ListenAddress:           "tcp://0.0.0.0:12345",
+		NAT:                     "any",
+		Discovery:               false,
+		DiscoveryListenAddress:  "0.0.0.0:12345",
+		LocalDiscovery:          false,
+		LocalDiscoveryAddress:   "239.255.70.85:12346",
 		AddrBook:                defaultAddrBookPath,
 		AddrBookStrict:          true,
-		MaxNumPeers:             50,
+		AddrBookBackend:         "file",
+		Anchors:                 defaultAnchorsPath,
+		MaxInbound:              40,
+		MaxOutbound:             20,
+		MinOutbound:             10,
+		MaxInboundPerSubnet:     4,
 		FlushThrottleTimeout:    100,
 		MaxPacketMsgPayloadSize: 1024,   // 1 kB
 		SendRate:                512000, // 500 kB/s
@@ -163,9 +308,19 @@ func DefaultP2PConfig() *P2PConfig {
 		PexReactor:              true,
 		SeedMode:                false,
 		AuthEnc:                 true,
+		MinTrustScore:           20,
+		DisconnectScore:         100,
+		BanScore:                200,
+		BehaviourScoreHalfLife:  3600,  // 1 hour
+		BanTime:                 86400, // 24 hours
 	}
 }
 
+// GenesisFile returns the full path to the genesis.json file
+func (cfg BaseConfig) GenesisFile() string {
+	return rootify(cfg.Genesis, cfg.RootDir)
+}
+
 // NodeKeyFile returns the full path to the node_key.json file
 func (cfg BaseConfig) NodeKeyFile() string {
 	return rootify(cfg.NodeKey, cfg.RootDir)
@@ -187,11 +342,61 @@ func DefaultPackageLogLevels() string {
 	return fmt.Sprintf("main:info,state:info,*:%s", DefaultLogLevel())
 }
 
+// ApplyDeprecated maps the deprecated options that are set onto the ones
+// that replace them, and returns a notice for each of them. The deprecated
+// options are cleared, so it can be called more than once.
+func (cfg *P2PConfig) ApplyDeprecated() []string {
+	var notices []string
+	if n := cfg.MaxNumPeers; n > 0 {
+		// like before the inbound and outbound limits, keep min_outbound
+		// of the peers for outbound connections
+		if cfg.MinOutbound > n {
+			cfg.MinOutbound = n
+		}
+		cfg.MaxOutbound = cfg.MinOutbound
+		cfg.MaxInbound = n - cfg.MaxOutbound
+		cfg.MaxNumPeers = 0
+		notices = append(notices, fmt.Sprintf("max_num_peers is deprecated, use max_inbound and max_outbound: "+
+			"using max_inbound = %d and max_outbound = %d", cfg.MaxInbound, cfg.MaxOutbound))
+	}
+	if cfg.SkipUPNP {
+		cfg.NAT = "none"
+		cfg.SkipUPNP = false
+		notices = append(notices, `skip_upnp is deprecated, use nat: using nat = "none"`)
+	}
+	return notices
+}
+
 // AddrBookFile returns the full path to the address book
 func (cfg *P2PConfig) AddrBookFile() string {
 	return rootify(cfg.AddrBook, cfg.RootDir)
 }
 
+// AnchorsFile returns the full path to the anchor peers, empty if there are none
+func (cfg *P2PConfig) AnchorsFile() string {
+	if cfg.Anchors == "" {
+		return ""
+	}
+	return rootify(cfg.Anchors, cfg.RootDir)
+}
+
+// CrawlReportFile returns the full path to the crawl report, empty if it
+// isn't saved
+func (cfg *P2PConfig) CrawlReportFile() string {
+	if cfg.CrawlReport == "" {
+		return ""
+	}
+	return rootify(cfg.CrawlReport, cfg.RootDir)
+}
+
+// ASMapFile returns the full path to the asmap, empty if there is none
+func (cfg *P2PConfig) ASMapFile() string {
+	if cfg.ASMap == "" {
+		return ""
+	}
+	return rootify(cfg.ASMap, cfg.RootDir)
+}
+
 // helper function to make config creation independent of root dir
 func rootify(path, root string) string {
 	if filepath.IsAbs(path) {
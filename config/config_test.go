@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestP2PConfigApplyDeprecated(t *testing.T) {
+	tests := []struct {
+		maxNumPeers                          int
+		maxInbound, maxOutbound, minOutbound int
+	}{
+		{0, 40, 20, 10}, // not set, the defaults are kept
+		{50, 40, 10, 10},
+		{6, 0, 6, 6},
+	}
+	for _, tt := range tests {
+		config := DefaultP2PConfig()
+		config.MaxNumPeers = tt.maxNumPeers
+		notices := config.ApplyDeprecated()
+		if (len(notices) > 0) != (tt.maxNumPeers > 0) {
+			t.Errorf("max_num_peers = %d: got notices %q", tt.maxNumPeers, notices)
+		}
+		if config.MaxInbound != tt.maxInbound || config.MaxOutbound != tt.maxOutbound || config.MinOutbound != tt.minOutbound {
+			t.Errorf("max_num_peers = %d: got max_inbound = %d, max_outbound = %d, min_outbound = %d, want %d, %d, %d",
+				tt.maxNumPeers, config.MaxInbound, config.MaxOutbound, config.MinOutbound, tt.maxInbound, tt.maxOutbound, tt.minOutbound)
+		}
+		if notices := config.ApplyDeprecated(); len(notices) > 0 {
+			t.Errorf("max_num_peers = %d: applied twice", tt.maxNumPeers)
+		}
+	}
+}
+
+func TestP2PConfigApplyDeprecatedSkipUPNP(t *testing.T) {
+	config := DefaultP2PConfig()
+	config.SkipUPNP = true
+	if notices := config.ApplyDeprecated(); len(notices) != 1 {
+		t.Errorf("got notices %q", notices)
+	}
+	if config.NAT != "none" {
+		t.Errorf("got nat = %q, want none", config.NAT)
+	}
+	if notices := config.ApplyDeprecated(); len(notices) > 0 {
+		t.Error("applied twice")
+	}
+}
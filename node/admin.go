@@ -0,0 +1,267 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	cfg "github.com/go-fusion/config"
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+// AdminServer serves the admin RPC of a node: JSON-RPC 2.0 over HTTP, for
+// operators to inspect and edit the address book, the bans and the
+// connection filters of a running node.
+// NOTE: it has no authentication, so it only listens on a unix socket,
+// readable by the user of the node only, or on a loopback address. It
+// also refuses requests a browser could be tricked into sending: ones
+// that are not JSON or not addressed to a loopback host.
+type AdminServer struct {
+	cmn.BaseService
+
+	laddr    string
+	book     *pex.AddrBookAdmin
+	sw       *p2p.Switch
+	reload   func(*cfg.P2PConfig) error // replaces the connection filters
+	unix     bool                       // listening on a unix socket
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewAdminServer returns an admin RPC server listening on laddr, e.g.
+// "unix:///var/run/fusiond-admin.sock" or "tcp://127.0.0.1:12380".
+// reloadFilters replaces the connection filters of the node, see
+// Node.ReloadConnFilters.
+func NewAdminServer(laddr string, book pex.AddrBook, sw *p2p.Switch, reloadFilters func(*cfg.P2PConfig) error) *AdminServer {
+	protocol, _ := cmn.ProtocolAndAddress(laddr)
+	s := &AdminServer{
+		laddr:  laddr,
+		book:   pex.NewAddrBookAdmin(book),
+		sw:     sw,
+		reload: reloadFilters,
+		unix:   protocol == "unix",
+	}
+	s.BaseService = *cmn.NewBaseService(nil, "AdminServer", s)
+	return s
+}
+
+// OnStart implements BaseService
+func (s *AdminServer) OnStart() error {
+	listener, err := listenHTTP(s.laddr)
+	if err != nil {
+		return err
+	}
+	switch addr := listener.Addr().(type) {
+	case *net.UnixAddr:
+		err = os.Chmod(addr.Name, 0600)
+	case *net.TCPAddr:
+		if !addr.IP.IsLoopback() {
+			err = fmt.Errorf("admin RPC must listen on a unix socket or a loopback address, not %v", addr)
+		}
+	}
+	if err != nil {
+		listener.Close() // nolint: errcheck
+		return err
+	}
+	s.listener = listener
+	s.server = newHTTPServer(s)
+	s.Logger.Info("Admin RPC listening", "laddr", s.laddr)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.Logger.Error("Admin RPC stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// OnStop implements BaseService
+func (s *AdminServer) OnStop() {
+	s.server.Close() // nolint: errcheck
+}
+
+// adminParams are the params of all the methods, each uses a few of them.
+type adminParams struct {
+	Addr  string            `json:"addr,omitempty"`
+	Src   string            `json:"src,omitempty"`
+	Addrs []pex.AddressInfo `json:"addrs,omitempty"`
+
+	Target   string        `json:"target,omitempty"`   // peer ID, IP or CIDR
+	Duration time.Duration `json:"duration,omitempty"` // of a ban, 0 for forever
+	Reason   string        `json:"reason,omitempty"`
+
+	// only the connection filter settings are used
+	Filters *cfg.P2PConfig `json:"filters,omitempty"`
+}
+
+// ServeHTTP implements http.Handler
+func (s *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// a web page can post forms, but not JSON, to other origins
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	// a web page can rebind its own host name to 127.0.0.1
+	if !s.unix && !loopbackHost(r.Host) {
+		http.Error(w, "Host must be a loopback address", http.StatusForbidden)
+		return
+	}
+	serveJSONRPC(w, r, s.call, s.Logger)
+}
+
+// loopbackHost returns true if host, with an optional port, is localhost
+// or a loopback IP.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *AdminServer) call(method string, rawParams json.RawMessage) (interface{}, *rpcError) {
+	var params adminParams
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, &rpcError{rpcErrInvalidParams, err.Error()}
+		}
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch method {
+	case "addrbook_list":
+		result, err = s.book.List()
+	case "addrbook_add":
+		err = s.book.Add(params.Addr, params.Src)
+	case "addrbook_remove":
+		err = s.book.Remove(params.Addr)
+	case "addrbook_mark_bad":
+		err = s.book.MarkBad(params.Addr)
+	case "addrbook_prune":
+		result, err = s.book.Prune()
+	case "addrbook_import":
+		result, err = s.book.Import(params.Addrs)
+	case "ban_list":
+		result = s.sw.BanList().List()
+	case "ban_add":
+		err = s.sw.Ban(params.Target, params.Duration, params.Reason)
+	case "ban_remove":
+		err = s.sw.BanList().Unban(params.Target)
+	case "filters_reload":
+		if params.Filters == nil {
+			return nil, &rpcError{rpcErrInvalidParams, "missing filters"}
+		}
+		err = s.reload(params.Filters)
+	default:
+		return nil, &rpcError{rpcErrMethodNotFound, "unknown method " + method}
+	}
+	if err != nil {
+		return nil, &rpcError{rpcErrInternal, err.Error()}
+	}
+	s.Logger.Info("Admin RPC call", "method", method, "addr", params.Addr, "target", params.Target)
+	return result, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// AdminClient calls the admin RPC of a running node. Its address book
+// methods match the ones of pex.AddrBookAdmin.
+type AdminClient struct {
+	url    string
+	client *http.Client
+}
+
+// NewAdminClient returns a client of the admin RPC listening on laddr.
+func NewAdminClient(laddr string) *AdminClient {
+	protocol, address := cmn.ProtocolAndAddress(laddr)
+	if protocol != "unix" {
+		return &AdminClient{url: "http://" + address, client: http.DefaultClient}
+	}
+	return &AdminClient{
+		url: "http://unix/",
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", address)
+			},
+		}},
+	}
+}
+
+func (c *AdminClient) call(method string, params adminParams, result interface{}) error {
+	return callJSONRPC(c.client, c.url, method, params, result)
+}
+
+// List returns all the addresses of the book.
+func (c *AdminClient) List() ([]pex.AddressInfo, error) {
+	var infos []pex.AddressInfo
+	err := c.call("addrbook_list", adminParams{}, &infos)
+	return infos, err
+}
+
+// Add adds addr, heard from src. addr is its own source if src is empty.
+func (c *AdminClient) Add(addr, src string) error {
+	return c.call("addrbook_add", adminParams{Addr: addr, Src: src}, nil)
+}
+
+// Remove removes the address of the peer of addr.
+func (c *AdminClient) Remove(addr string) error {
+	return c.call("addrbook_remove", adminParams{Addr: addr}, nil)
+}
+
+// MarkBad marks the address of the peer of addr as bad.
+func (c *AdminClient) MarkBad(addr string) error {
+	return c.call("addrbook_mark_bad", adminParams{Addr: addr}, nil)
+}
+
+// Prune removes the addresses that look bad, and returns how many.
+func (c *AdminClient) Prune() (int, error) {
+	var n int
+	err := c.call("addrbook_prune", adminParams{}, &n)
+	return n, err
+}
+
+// Import adds the addresses of an export, and returns how many were added.
+func (c *AdminClient) Import(infos []pex.AddressInfo) (int, error) {
+	var n int
+	err := c.call("addrbook_import", adminParams{Addrs: infos}, &n)
+	return n, err
+}
+
+// BanList returns the bans in force.
+func (c *AdminClient) BanList() ([]*p2p.BanEntry, error) {
+	var entries []*p2p.BanEntry
+	err := c.call("ban_list", adminParams{}, &entries)
+	return entries, err
+}
+
+// Ban bans target, a peer ID, an IP or a CIDR range, for duration, or
+// forever if it is 0, and disconnects the peers it covers.
+func (c *AdminClient) Ban(target string, duration time.Duration, reason string) error {
+	return c.call("ban_add", adminParams{Target: target, Duration: duration, Reason: reason}, nil)
+}
+
+// Unban lifts the ban on target.
+func (c *AdminClient) Unban(target string) error {
+	return c.call("ban_remove", adminParams{Target: target}, nil)
+}
+
+// ReloadConnFilters replaces the allow and deny lists of the node with the
+// ones of config.
+func (c *AdminClient) ReloadConnFilters(config *cfg.P2PConfig) error {
+	return c.call("filters_reload", adminParams{Filters: config}, nil)
+}
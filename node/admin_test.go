@@ -0,0 +1,171 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cfg "github.com/go-fusion/config"
+	"github.com/go-fusion/p2p"
+)
+
+func TestAdminBans(t *testing.T) {
+	sw := p2p.NewSwitch(cfg.DefaultP2PConfig())
+	server := httptest.NewServer(NewAdminServer("", nil, sw, nil))
+	defer server.Close()
+	client := NewAdminClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+
+	if err := client.Ban("10.0.0.0/8", 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := client.BanList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "10.0.0.0/8" || entries[0].Reason != "test" {
+		t.Fatalf("expected the ban to be listed, got %v", entries)
+	}
+	if !sw.BanList().IsBanned("", []byte{10, 1, 2, 3}) {
+		t.Error("expected the ban to be in force in the switch")
+	}
+
+	if err := client.Unban("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Unban("10.0.0.0/8"); err == nil {
+		t.Error("expected an error lifting a missing ban")
+	}
+	if err := client.Ban("nonsense", 0, ""); err == nil {
+		t.Error("expected an invalid target to be refused")
+	}
+}
+
+func TestAdminReloadConnFilters(t *testing.T) {
+	filter, err := p2p.NewConnFilter(cfg.DefaultP2PConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewAdminServer("", nil, nil, filter.Reload))
+	defer server.Close()
+	client := NewAdminClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+
+	addr := &net.TCPAddr{IP: net.IP{10, 1, 2, 3}, Port: 26656}
+	if err := filter.FilterAddr(addr); err != nil {
+		t.Fatalf("expected the address to be allowed before the reload: %v", err)
+	}
+
+	config := cfg.DefaultP2PConfig()
+	config.DeniedCIDRs = "10.0.0.0/8"
+	if err := client.ReloadConnFilters(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.FilterAddr(addr); err == nil {
+		t.Error("expected the address to be denied after the reload")
+	}
+
+	// an invalid config leaves the filters unchanged
+	config.DeniedCIDRs = "not a cidr"
+	if err := client.ReloadConnFilters(config); err == nil {
+		t.Error("expected an invalid config to be refused")
+	}
+	if err := filter.FilterAddr(addr); err == nil {
+		t.Error("expected the filters to be unchanged")
+	}
+}
+
+func TestAdminServerListen(t *testing.T) {
+	sw := p2p.NewSwitch(cfg.DefaultP2PConfig())
+
+	// no unauthenticated admin RPC for others
+	for _, laddr := range []string{"tcp://0.0.0.0:0", "tcp://:0"} {
+		s := NewAdminServer(laddr, nil, sw, nil)
+		if err := s.Start(); err == nil {
+			s.Stop() // nolint: errcheck
+			t.Errorf("%s: expected an error", laddr)
+		}
+	}
+
+	s := NewAdminServer("tcp://127.0.0.1:0", nil, sw, nil)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if s.server.ReadTimeout == 0 || s.server.WriteTimeout == 0 {
+		t.Error("expected the server to have timeouts")
+	}
+	s.Stop() // nolint: errcheck
+
+	// the socket is for the user of the node only
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	s = NewAdminServer("unix://"+sock, nil, sw, nil)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop() // nolint: errcheck
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("got socket permissions %o, want 600", perm)
+	}
+	if _, err := NewAdminClient("unix://" + sock).BanList(); err != nil {
+		t.Errorf("call over the socket: %v", err)
+	}
+}
+
+func TestAdminServerRequests(t *testing.T) {
+	sw := p2p.NewSwitch(cfg.DefaultP2PConfig())
+	server := httptest.NewServer(NewAdminServer("", nil, sw, nil))
+	defer server.Close()
+
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "ban_list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(contentType, host string, body []byte) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		if host != "" {
+			req.Host = host
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close() // nolint: errcheck
+		return res
+	}
+
+	if res := post("application/json; charset=utf-8", "", body); res.StatusCode != http.StatusOK {
+		t.Errorf("JSON request: got status %d", res.StatusCode)
+	}
+	if res := post("text/plain", "", body); res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain: got status %d", res.StatusCode)
+	}
+	// a host name rebound to 127.0.0.1
+	if res := post("application/json", "attacker.example:12380", body); res.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign host: got status %d", res.StatusCode)
+	}
+	if res := post("application/json", "localhost:12380", body); res.StatusCode != http.StatusOK {
+		t.Errorf("localhost: got status %d", res.StatusCode)
+	}
+
+	// the body is capped
+	err = callJSONRPC(http.DefaultClient, server.URL, "ban_add",
+		adminParams{Target: "10.0.0.0/8", Reason: strings.Repeat("a", maxRPCRequestSize)}, nil)
+	if rpcErr, ok := err.(*rpcError); !ok || rpcErr.Code != rpcErrParse {
+		t.Errorf("oversized request: got %v", err)
+	}
+	if len(sw.BanList().List()) != 0 {
+		t.Error("the oversized request was served")
+	}
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"net/http"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/p2p/pex"
+)
+
+// CrawlReportServer serves the crawl report of a seed as JSON over HTTP,
+// to monitor the health of the network and version rollouts.
+type CrawlReportServer struct {
+	cmn.BaseService
+
+	laddr  string
+	report *pex.CrawlReport
+	server *http.Server
+}
+
+// NewCrawlReportServer returns a server of report listening on laddr,
+// e.g. "tcp://0.0.0.0:12381".
+func NewCrawlReportServer(laddr string, report *pex.CrawlReport) *CrawlReportServer {
+	s := &CrawlReportServer{
+		laddr:  laddr,
+		report: report,
+	}
+	s.BaseService = *cmn.NewBaseService(nil, "CrawlReportServer", s)
+	return s
+}
+
+// OnStart implements BaseService
+func (s *CrawlReportServer) OnStart() error {
+	listener, err := listenHTTP(s.laddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", s.report)
+	s.server = newHTTPServer(mux)
+	s.Logger.Info("Crawl report listening", "laddr", s.laddr)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.Logger.Error("Crawl report server stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// OnStop implements BaseService
+func (s *CrawlReportServer) OnStop() {
+	s.server.Close() // nolint: errcheck
+}
@@ -0,0 +1,35 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-fusion/p2p/pex"
+)
+
+func TestCrawlReportServer(t *testing.T) {
+	s := NewCrawlReportServer("tcp://127.0.0.1:0", pex.NewCrawlReport())
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop() // nolint: errcheck
+
+	// public, so slow clients must not hold connections
+	if s.server.ReadHeaderTimeout == 0 || s.server.ReadTimeout == 0 || s.server.WriteTimeout == 0 || s.server.IdleTimeout == 0 {
+		t.Error("expected the server to have timeouts")
+	}
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var report struct {
+		Summary pex.CrawlReportSummary `json:"summary"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Summary.Nodes != 0 {
+		t.Errorf("got %d nodes", report.Summary.Nodes)
+	}
+}
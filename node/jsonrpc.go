@@ -0,0 +1,148 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
+	"github.com/tendermint/tmlibs/log"
+)
+
+const (
+	// how long a client may take to send a request, and we to answer it
+	httpReadTimeout  = 10 * time.Second
+	httpWriteTimeout = 10 * time.Second
+
+	// how long an idle keep-alive connection is kept open
+	httpIdleTimeout = 60 * time.Second
+
+	// max size of a JSON-RPC request
+	maxRPCRequestSize = 1 << 20 // 1MB
+)
+
+// listenHTTP listens on laddr, a unix socket or a TCP address.
+func listenHTTP(laddr string) (net.Listener, error) {
+	protocol, address := cmn.ProtocolAndAddress(laddr)
+	if protocol == "unix" {
+		// remove the socket of a node that didn't stop cleanly
+		os.Remove(address) // nolint: errcheck
+	}
+	return net.Listen(protocol, address)
+}
+
+// newHTTPServer returns a server of handler with timeouts, so that slow
+// clients can't hold connections open.
+func newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// JSON-RPC 2.0 over HTTP, shared by the admin and the public RPC
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcErrParse          = -32700
+	rpcErrMethodNotFound = -32601
+	rpcErrInvalidParams  = -32602
+	rpcErrInternal       = -32603
+)
+
+// rpcFunc calls method with the raw params of a request.
+type rpcFunc func(method string, params json.RawMessage) (interface{}, *rpcError)
+
+// serveJSONRPC answers the JSON-RPC request of r with call.
+func serveJSONRPC(w http.ResponseWriter, r *http.Request, call rpcFunc, logger log.Logger) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a JSON-RPC request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req rpcRequest
+	res := rpcResponse{JSONRPC: "2.0"}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRPCRequestSize)).Decode(&req)
+	if err != nil {
+		res.Error = &rpcError{rpcErrParse, err.Error()}
+	} else {
+		res.ID = req.ID
+		result, callErr := call(req.Method, req.Params)
+		if callErr != nil {
+			res.Error = callErr
+		} else if res.Result, err = json.Marshal(result); err != nil {
+			res.Error = &rpcError{rpcErrInternal, err.Error()}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.Error("Failed to write RPC response", "err", err)
+	}
+}
+
+// callJSONRPC calls method on the JSON-RPC server at url, and decodes its
+// result into result unless it is nil.
+func callJSONRPC(client *http.Client, url, method string, params, result interface{}) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	reqBytes, err := json.Marshal(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage(`1`),
+		Method:  method,
+		Params:  rawParams,
+	})
+	if err != nil {
+		return err
+	}
+	httpRes, err := client.Post(url, "application/json", bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close() // nolint: errcheck
+
+	var res rpcResponse
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result == nil || len(res.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
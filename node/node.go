@@ -1,6 +1,9 @@
 package node
 
 import (
+	"fmt"
+	"net"
+
 	amino "github.com/tendermint/go-amino"
 	cmn "github.com/tendermint/tmlibs/common"
 	dbm "github.com/tendermint/tmlibs/db"
@@ -8,7 +11,13 @@ import (
 
 	cfg "github.com/go-fusion/config"
 	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/discover"
+	"github.com/go-fusion/p2p/nat"
 	"github.com/go-fusion/p2p/pex"
+	"github.com/go-fusion/p2p/trust"
+	"github.com/go-fusion/protocol/state"
+	"github.com/go-fusion/protocol/store"
+	"github.com/go-fusion/protocol/types"
 	"github.com/go-fusion/sync"
 	"github.com/go-fusion/version"
 )
@@ -31,14 +40,25 @@ func DefaultDBProvider(ctx *DBContext) (dbm.DB, error) {
 	return dbm.NewDB(ctx.ID, dbType, ctx.Config.DBDir()), nil
 }
 
+// GenesisProvider returns the genesis of the chain the node runs.
+type GenesisProvider func() (*state.Genesis, error)
+
+// DefaultGenesisProvider returns a GenesisProvider that loads the genesis
+// from the GenesisFile specified in the config.
+func DefaultGenesisProvider(config *cfg.Config) GenesisProvider {
+	return func() (*state.Genesis, error) {
+		return state.LoadGenesis(config.GenesisFile())
+	}
+}
+
 // Provider takes a config and a logger and returns a ready to go Node.
 type Provider func(*cfg.Config, log.Logger) (*Node, error)
 
 // DefaultNewNode returns a Tendermint node with default settings for the
-// PrivValidator, ClientCreator, GenesisDoc, and DBProvider.
+// GenesisProvider and DBProvider.
 // It implements NodeProvider.
 func DefaultNewNode(config *cfg.Config, logger log.Logger) (*Node, error) {
-	return NewNode(config, DefaultDBProvider, logger)
+	return NewNode(config, DefaultGenesisProvider(config), DefaultDBProvider, logger)
 }
 
 //------------------------------------------------------------------------------
@@ -49,16 +69,44 @@ type Node struct {
 	cmn.BaseService
 
 	// config
-	config *cfg.Config
+	config  *cfg.Config
+	genesis *state.Genesis
 
 	// network
-	sw       *p2p.Switch  // p2p connections
-	addrBook pex.AddrBook // known peers
-
+	sw               *p2p.Switch              // p2p connections
+	addrBook         pex.AddrBook             // known peers
+	trustMetricStore *trust.TrustMetricStore  // trust metrics for all peers
+	connFilter       *p2p.ConnFilter          // allow and deny lists
+	discovery        *discover.Discovery      // UDP peer discovery, if enabled
+	localDiscovery   *discover.LocalDiscovery // LAN peer discovery, if enabled
+
+	// admin
+	admin       *AdminServer       // admin RPC, if enabled
+	rpc         *RPCServer         // public RPC, if enabled
+	crawlReport *CrawlReportServer // crawl report of a seed, if enabled
+
+	// services
+	blockStore *store.BlockStore    // store the block headers and receipts
+	blockExec  *state.BlockExecutor // apply blocks and save them to blockStore
 }
 
 // NewNode returns a new, ready to go, Tendermint Node.
-func NewNode(config *cfg.Config, dbProvider DBProvider, logger log.Logger) (*Node, error) {
+func NewNode(config *cfg.Config, genesisProvider GenesisProvider, dbProvider DBProvider, logger log.Logger) (*Node, error) {
+	genesis, err := genesisProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get BlockStore, and the state saved with it
+	blockStoreDB, err := dbProvider(&DBContext{"blockstore", config})
+	if err != nil {
+		return nil, err
+	}
+	blockExec, err := state.NewBlockExecutor(blockStoreDB, genesis)
+	if err != nil {
+		return nil, err
+	}
+	blockStore := blockExec.Store()
 
 	txpoolLogger := logger.With("module", "txpool")
 	txpoolReactor := sync.NewPoolReactor()
@@ -69,42 +117,141 @@ func NewNode(config *cfg.Config, dbProvider DBProvider, logger log.Logger) (*Nod
 	blockReactor.SetLogger(blockLogger)
 
 	p2pLogger := logger.With("module", "p2p")
+	for _, notice := range config.P2P.ApplyDeprecated() {
+		p2pLogger.Error(notice)
+	}
+
+	// Get TrustMetricStore
+	trustHistoryDB, err := dbProvider(&DBContext{"trusthistory", config})
+	if err != nil {
+		return nil, err
+	}
+	trustMetricStore := trust.NewTrustMetricStore(trustHistoryDB, trust.DefaultConfig())
+	trustMetricStore.SetLogger(p2pLogger)
+
+	// Get BanList, loaded with the bans saved by earlier runs
+	banListDB, err := dbProvider(&DBContext{"banlist", config})
+	if err != nil {
+		return nil, err
+	}
+	banList := p2p.NewBanList(banListDB)
 
 	sw := p2p.NewSwitch(config.P2P)
 	sw.SetLogger(p2pLogger)
+	sw.SetTrustMetricStore(trustMetricStore)
+	sw.SetBanList(banList)
+
+	// Filter peers by the allow and deny lists of the config
+	connFilter, err := p2p.NewConnFilter(config.P2P)
+	if err != nil {
+		return nil, err
+	}
+	sw.SetAddrFilter(connFilter.FilterAddr)
+	sw.SetIDFilter(connFilter.FilterID)
+
+	// Dial TCP peers through the proxy
+	if config.P2P.Proxy != "" {
+		protocol, proxyAddr := cmn.ProtocolAndAddress(config.P2P.Proxy)
+		if protocol != "socks5" && protocol != "tcp" {
+			return nil, fmt.Errorf("Unsupported proxy protocol %q, expected socks5", protocol)
+		}
+		for _, scheme := range []string{p2p.SchemeTCP, p2p.SchemeTCP4, p2p.SchemeTCP6} {
+			sw.AddTransport(p2p.NewSOCKS5Transport(scheme, proxyAddr, config.P2P.ProxyIsolateStreams))
+		}
+	}
 
 	sw.AddReactor("TXPOOL", txpoolReactor)
 	sw.AddReactor("BLOCK", blockReactor)
 
-	addrBook := pex.NewAddrBook(config.P2P.AddrBookFile(), config.P2P.AddrBookStrict)
-	addrBook.SetLogger(p2pLogger.With("book", config.P2P.AddrBookFile()))
+	// Advertise the new head to the peers we connect to from now on
+	blockExec.OnCommit(func(header *types.BlockHeader) {
+		if header.Height == 0 {
+			sw.SetHead(0, nil)
+			return
+		}
+		hash := header.Hash()
+		sw.SetHead(header.Height, hash[:])
+	})
+
+	addrBook, err := NewAddrBook(config, dbProvider, banList, p2pLogger)
+	if err != nil {
+		return nil, err
+	}
 	if config.P2P.PexReactor {
 		// TODO persistent peers ? so we can have their DNS addrs saved
 		pexReactor := pex.NewPEXReactor(addrBook,
 			&pex.PEXReactorConfig{
-				Seeds:          cmn.SplitAndTrim(config.P2P.Seeds, ",", " "),
-				SeedMode:       config.P2P.SeedMode,
-				PrivatePeerIDs: cmn.SplitAndTrim(config.P2P.PrivatePeerIDs, ",", " ")})
+				Seeds:           cmn.SplitAndTrim(config.P2P.Seeds, ",", " "),
+				SeedMode:        config.P2P.SeedMode,
+				PrivatePeerIDs:  cmn.SplitAndTrim(config.P2P.PrivatePeerIDs, ",", " "),
+				DNSSeeds:        cmn.SplitAndTrim(config.P2P.DNSSeeds, ",", " "),
+				AnchorsFile:     config.P2P.AnchorsFile(),
+				CrawlReportFile: config.P2P.CrawlReportFile()})
 		pexReactor.SetLogger(p2pLogger)
 		sw.AddReactor("PEX", pexReactor)
 	}
 	sw.SetAddrBook(addrBook)
 
 	node := &Node{
-		config:   config,
-		sw:       sw,
-		addrBook: addrBook,
+		config:           config,
+		genesis:          genesis,
+		sw:               sw,
+		addrBook:         addrBook,
+		trustMetricStore: trustMetricStore,
+		connFilter:       connFilter,
+
+		blockStore: blockStore,
+		blockExec:  blockExec,
 	}
 	node.BaseService = *cmn.NewBaseService(logger, "Node", node)
 	return node, nil
 }
 
+// NewAddrBook returns the address book of the node, stored in the JSON
+// file or in the DB as configured, and grouping addresses with the asmap
+// if one is set. The book skips the addresses banned by banList.
+func NewAddrBook(config *cfg.Config, dbProvider DBProvider, banList *p2p.BanList, logger log.Logger) (pex.AddrBook, error) {
+	type addrBook interface {
+		pex.AddrBook
+		SetASMap(*p2p.ASMap)
+		SetBanList(*p2p.BanList)
+	}
+
+	var book addrBook
+	switch config.P2P.AddrBookBackend {
+	case "", "file":
+		book = pex.NewAddrBook(config.P2P.AddrBookFile(), config.P2P.AddrBookStrict)
+		book.SetLogger(logger.With("book", config.P2P.AddrBookFile()))
+	case "db":
+		db, err := dbProvider(&DBContext{"addrbook", config})
+		if err != nil {
+			return nil, err
+		}
+		book = pex.NewDBAddrBook(db, config.P2P.AddrBookFile(), config.P2P.AddrBookStrict)
+		book.SetLogger(logger.With("book", "db"))
+	default:
+		return nil, fmt.Errorf("Unknown addr_book_backend %q, expected file or db", config.P2P.AddrBookBackend)
+	}
+
+	if asmapFile := config.P2P.ASMapFile(); asmapFile != "" {
+		asmap, err := p2p.LoadASMap(asmapFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Loaded asmap", "file", asmapFile, "checksum", asmap.Checksum())
+		book.SetASMap(asmap)
+	}
+	book.SetBanList(banList)
+	return book, nil
+}
+
 // OnStart starts the Node. It implements cmn.Service.
 func (n *Node) OnStart() error {
 
-	protocol, address := cmn.ProtocolAndAddress(n.config.P2P.ListenAddress)
-	l := p2p.NewDefaultListener(protocol, address, n.config.P2P.SkipUPNP, n.Logger.With("module", "p2p"))
-	n.sw.AddListener(l)
+	natm, err := nat.Parse(n.config.P2P.NAT)
+	if err != nil {
+		return err
+	}
 
 	nodeKey, err := p2p.LoadOrGenNodeKey(n.config.NodeKeyFile())
 	if err != nil {
@@ -112,12 +259,56 @@ func (n *Node) OnStart() error {
 	}
 	n.Logger.Info("P2P Node ID", "ID", nodeKey.ID(), "file", n.config.NodeKeyFile())
 
+	// Only the first IPv4 TCP listener is mapped on the NAT
+	for _, laddr := range cmn.SplitAndTrim(n.config.P2P.ListenAddress, ",", " ") {
+		if laddr == "" {
+			continue
+		}
+		protocol, address := cmn.ProtocolAndAddress(laddr)
+		transport := n.sw.Transport(protocol)
+		if transport == nil {
+			return p2p.ErrTransportNotFound{Scheme: protocol}
+		}
+		var lNAT nat.Interface
+		if natm != nil && natCapable(protocol, address) {
+			lNAT, natm = natm, nil
+		}
+		l := p2p.NewDefaultListener(transport, address, lNAT, n.Logger.With("module", "p2p"))
+		n.sw.AddListener(l)
+
+		if lNAT == nil {
+			continue
+		}
+		// Advertise the new address when the external IP of the NAT changes
+		l.(*p2p.DefaultListener).OnExternalAddressChange(func(oldAddr, newAddr *p2p.NetAddress) {
+			n.sw.ReplaceListenAddr(oldAddr, newAddr)
+			ourAddr := p2p.NewNetAddressIPPort(newAddr.IP, newAddr.Port)
+			ourAddr.ID = nodeKey.ID()
+			n.addrBook.AddOurAddress(ourAddr)
+		})
+	}
+
 	nodeInfo := n.makeNodeInfo(nodeKey.ID())
 	n.sw.SetNodeInfo(nodeInfo)
 	n.sw.SetNodeKey(nodeKey)
 
 	// Add ourselves to addrbook to prevent dialing ourselves
-	n.addrBook.AddOurAddress(nodeInfo.NetAddress())
+	for _, addr := range nodeInfo.NetAddresses() {
+		n.addrBook.AddOurAddress(addr)
+	}
+
+	// Start the trust metric store before the switch records any event
+	err = n.trustMetricStore.Start()
+	if err != nil {
+		return err
+	}
+
+	// The book is shared by the PEX reactor and the discovery services,
+	// so it is started before and stopped after all of them
+	err = n.addrBook.Start()
+	if err != nil {
+		return err
+	}
 
 	// Start the switch (the P2P server).
 	err = n.sw.Start()
@@ -125,6 +316,76 @@ func (n *Node) OnStart() error {
 		return err
 	}
 
+	// Discover peers over UDP, advertising our first TCP address
+	if n.config.P2P.Discovery {
+		bootnodes, err := discover.ParseNodes(cmn.SplitAndTrim(n.config.P2P.Bootnodes, ",", " "))
+		if err != nil {
+			return err
+		}
+		var ourAddr *p2p.NetAddress
+		for _, addr := range nodeInfo.NetAddresses() {
+			if addr.Scheme == "" && addr.IP != nil && !addr.Onion() {
+				ourAddr = addr
+				break
+			}
+		}
+		n.discovery = discover.NewDiscovery(n.addrBook, &discover.Config{
+			PrivKey:       nodeKey.PrivKey,
+			Network:       nodeInfo.Network,
+			ListenAddress: n.config.P2P.DiscoveryListenAddress,
+			NetAddress:    ourAddr,
+			Bootnodes:     bootnodes,
+		})
+		n.discovery.SetLogger(n.Logger.With("module", "discover"))
+		if err := n.discovery.Start(); err != nil {
+			return err
+		}
+	}
+
+	// Find the peers of our network segment
+	if n.config.P2P.LocalDiscovery {
+		n.localDiscovery = discover.NewLocalDiscovery(n.addrBook, &discover.LocalConfig{
+			PrivKey:      nodeKey.PrivKey,
+			GroupAddress: n.config.P2P.LocalDiscoveryAddress,
+			NodeInfo:     n.sw.NodeInfo,
+		})
+		n.localDiscovery.SetLogger(n.Logger.With("module", "discover"))
+		if err := n.localDiscovery.Start(); err != nil {
+			return err
+		}
+	}
+
+	// Let operators manage the address book, the bans and the filters
+	if n.config.AdminListenAddress != "" {
+		n.admin = NewAdminServer(n.config.AdminListenAddress, n.addrBook, n.sw, n.ReloadConnFilters)
+		n.admin.SetLogger(n.Logger.With("module", "admin"))
+		if err := n.admin.Start(); err != nil {
+			return err
+		}
+	}
+
+	// Answer chain queries
+	if n.config.RPCListenAddress != "" {
+		n.rpc = NewRPCServer(n.config.RPCListenAddress, n.blockStore)
+		n.rpc.SetLogger(n.Logger.With("module", "rpc"))
+		if err := n.rpc.Start(); err != nil {
+			return err
+		}
+	}
+
+	// Let operators monitor the network crawled by a seed
+	if n.config.P2P.SeedMode && n.config.P2P.CrawlReportListenAddress != "" {
+		pexReactor, ok := n.sw.Reactor("PEX").(*pex.PEXReactor)
+		if !ok {
+			return fmt.Errorf("crawl_report_laddr is set, but the peer-exchange reactor is disabled")
+		}
+		n.crawlReport = NewCrawlReportServer(n.config.P2P.CrawlReportListenAddress, pexReactor.CrawlReport())
+		n.crawlReport.SetLogger(n.Logger.With("module", "crawl-report"))
+		if err := n.crawlReport.Start(); err != nil {
+			return err
+		}
+	}
+
 	// Always connect to persistent peers
 	if n.config.P2P.PersistentPeers != "" {
 		err = n.sw.DialPeersAsync(n.addrBook, cmn.SplitAndTrim(n.config.P2P.PersistentPeers, ",", " "), true)
@@ -140,8 +401,24 @@ func (n *Node) OnStop() {
 	n.BaseService.OnStop()
 
 	n.Logger.Info("Stopping Node")
+	if n.admin != nil {
+		n.admin.Stop()
+	}
+	if n.crawlReport != nil {
+		n.crawlReport.Stop()
+	}
+	if n.rpc != nil {
+		n.rpc.Stop()
+	}
+	if n.discovery != nil {
+		n.discovery.Stop()
+	}
+	if n.localDiscovery != nil {
+		n.localDiscovery.Stop()
+	}
 	n.sw.Stop()
-
+	n.addrBook.Stop()
+	n.trustMetricStore.Stop()
 }
 
 // RunForever waits for an interrupt signal and stops the node.
@@ -151,16 +428,42 @@ func (n *Node) RunForever() {
 	})
 }
 
+// ReloadConnFilters replaces the allow and deny lists of the node with the
+// ones of the given config without restarting the switch.
+func (n *Node) ReloadConnFilters(config *cfg.P2PConfig) error {
+	if err := n.connFilter.Reload(config); err != nil {
+		return err
+	}
+	n.Logger.Info("Reloaded connection filters")
+	return nil
+}
+
 // Switch returns the Node's Switch.
 func (n *Node) Switch() *p2p.Switch {
 	return n.sw
 }
 
+// AddrBook returns the Node's AddrBook.
+func (n *Node) AddrBook() pex.AddrBook {
+	return n.addrBook
+}
+
+// BlockStore returns the Node's BlockStore.
+func (n *Node) BlockStore() *store.BlockStore {
+	return n.blockStore
+}
+
+// BlockExecutor returns the Node's BlockExecutor, through which blocks are
+// applied and saved.
+func (n *Node) BlockExecutor() *state.BlockExecutor {
+	return n.blockExec
+}
+
 func (n *Node) makeNodeInfo(nodeID p2p.ID) p2p.NodeInfo {
 
 	nodeInfo := p2p.NodeInfo{
 		ID:       nodeID,
-		Network:  n.config.ChainID,
+		Network:  n.genesis.ChainID,
 		Version:  version.MainVersion.StringValue,
 		Channels: []byte{},
 		Moniker:  n.config.Moniker,
@@ -174,14 +477,53 @@ func (n *Node) makeNodeInfo(nodeID p2p.ID) p2p.NodeInfo {
 		nodeInfo.Channels = append(nodeInfo.Channels, pex.PexChannel)
 	}
 
+	if n.config.P2P.Proxy != "" {
+		nodeInfo.Other = append(nodeInfo.Other, p2p.OnionSupport)
+	}
+
+	nodeInfo.Capabilities = n.sw.Capabilities()
+
+	genesisHash := n.genesis.Hash()
+	nodeInfo.GenesisHash = genesisHash[:]
+	if height := n.blockStore.Height(); height > 0 {
+		headHash := n.blockStore.LoadBlockHeader(height).Hash()
+		nodeInfo.HeadHeight = height
+		nodeInfo.HeadHash = headHash[:]
+	}
+
 	if !n.sw.IsListening() {
 		return nodeInfo
 	}
 
-	p2pListener := n.sw.Listeners()[0]
-	p2pHost := p2pListener.ExternalAddress().IP.String()
-	p2pPort := p2pListener.ExternalAddress().Port
-	nodeInfo.ListenAddr = cmn.Fmt("%v:%v", p2pHost, p2pPort)
+	// The first listener with an external address is the main one,
+	// unix sockets are not advertised
+	for _, l := range n.sw.Listeners() {
+		extAddr := l.ExternalAddress()
+		if extAddr == nil {
+			continue
+		}
+		if nodeInfo.ListenAddr == "" {
+			nodeInfo.ListenAddr = extAddr.AddressString()
+		} else {
+			nodeInfo.ListenAddrs = append(nodeInfo.ListenAddrs, extAddr.AddressString())
+		}
+	}
 
 	return nodeInfo
 }
+
+// natCapable returns true if a listener on the given protocol and address
+// can have its port mapped on the NAT, which only deals with IPv4 TCP, and
+// is only used for listeners on all the IPv4 addresses.
+func natCapable(protocol, address string) bool {
+	switch protocol {
+	case "tcp", "tcp4":
+	default:
+		return false
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	return host == "" || net.ParseIP(host).Equal(net.IPv4zero)
+}
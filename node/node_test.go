@@ -0,0 +1,56 @@
+package node
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
+
+	cfg "github.com/go-fusion/config"
+	"github.com/go-fusion/protocol/state"
+	"github.com/go-fusion/protocol/types"
+)
+
+func memDBProvider(*DBContext) (dbm.DB, error) {
+	return dbm.NewMemDB(), nil
+}
+
+func testGenesisProvider() (*state.Genesis, error) {
+	return state.DefaultGenesis("test"), nil
+}
+
+func TestNodeInfoFollowsHead(t *testing.T) {
+	config := cfg.DefaultConfig()
+	genesis, _ := testGenesisProvider()
+	n, err := NewNode(config, testGenesisProvider, memDBProvider, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Switch().SetNodeInfo(n.makeNodeInfo("0123456789abcdef0123456789abcdef01234567"))
+
+	// the genesis hash is known before any block
+	info := n.Switch().NodeInfo()
+	genesisHash := genesis.Hash()
+	if info.Network != genesis.ChainID || !bytes.Equal(info.GenesisHash, genesisHash[:]) || info.HeadHeight != 0 {
+		t.Fatalf("got genesis %X at height %d, want %X at 0", info.GenesisHash, info.HeadHeight, genesisHash)
+	}
+
+	block := &types.Block{BlockHeader: types.BlockHeader{
+		Height:                 1,
+		PreviousBlockHash:      genesis.Header().Hash(),
+		GasLimit:               state.DefaultBlockGasLimit,
+		BaseFee:                big.NewInt(state.InitialBaseFee),
+		TransactionsStatusHash: types.ReceiptsHash(nil),
+		StateRoot:              state.New().Root(), // an empty block leaves the state empty
+	}}
+	if _, err := n.BlockExecutor().ApplyBlock(block, nil); err != nil {
+		t.Fatal(err)
+	}
+	info = n.Switch().NodeInfo()
+	headHash := block.Hash()
+	if info.HeadHeight != 1 || !bytes.Equal(info.HeadHash, headHash[:]) {
+		t.Errorf("got head %X at height %d, want %X at 1", info.HeadHash, info.HeadHeight, headHash)
+	}
+}
@@ -0,0 +1,81 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/protocol/store"
+	"github.com/go-fusion/protocol/types"
+)
+
+// RPCServer serves the public RPC of a node: JSON-RPC 2.0 over HTTP, to
+// query the chain it stores.
+type RPCServer struct {
+	cmn.BaseService
+
+	laddr      string
+	blockStore *store.BlockStore
+	server     *http.Server
+}
+
+// NewRPCServer returns an RPC server listening on laddr, e.g.
+// "tcp://0.0.0.0:12382".
+func NewRPCServer(laddr string, blockStore *store.BlockStore) *RPCServer {
+	s := &RPCServer{
+		laddr:      laddr,
+		blockStore: blockStore,
+	}
+	s.BaseService = *cmn.NewBaseService(nil, "RPCServer", s)
+	return s
+}
+
+// OnStart implements BaseService
+func (s *RPCServer) OnStart() error {
+	listener, err := listenHTTP(s.laddr)
+	if err != nil {
+		return err
+	}
+	s.server = newHTTPServer(s)
+	s.Logger.Info("RPC listening", "laddr", s.laddr)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.Logger.Error("RPC stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// OnStop implements BaseService
+func (s *RPCServer) OnStop() {
+	s.server.Close() // nolint: errcheck
+}
+
+// ServeHTTP implements http.Handler
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveJSONRPC(w, r, s.call, s.Logger)
+}
+
+func (s *RPCServer) call(method string, rawParams json.RawMessage) (interface{}, *rpcError) {
+	switch method {
+	case "getLogs":
+		var q store.FilterQuery
+		if len(rawParams) > 0 {
+			if err := json.Unmarshal(rawParams, &q); err != nil {
+				return nil, &rpcError{rpcErrInvalidParams, err.Error()}
+			}
+		}
+		logs, err := s.blockStore.GetLogs(&q)
+		if err != nil {
+			return nil, &rpcError{rpcErrInvalidParams, err.Error()}
+		}
+		if logs == nil {
+			logs = []*types.Log{}
+		}
+		return logs, nil
+	default:
+		return nil, &rpcError{rpcErrMethodNotFound, "unknown method " + method}
+	}
+}
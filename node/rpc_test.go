@@ -0,0 +1,58 @@
+package node
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/store"
+	"github.com/go-fusion/protocol/types"
+)
+
+func TestRPCGetLogs(t *testing.T) {
+	bs := store.NewBlockStore(dbm.NewMemDB())
+	emitter := types.Address{1}
+	topic := types.Hash{2}
+	logs := []*types.Log{{Address: emitter, Topics: []types.Hash{topic}, Data: []byte{3}, BlockHeight: 1}}
+	receipts := []*types.Receipt{types.NewReceipt(types.Hash{4}, types.ReceiptStatusSuccessful, 21000, 21000, logs)}
+	bs.SaveBlock(nil, &types.BlockHeader{
+		Height:    1,
+		LogsBloom: types.CreateBloom(receipts),
+		BaseFee:   big.NewInt(1),
+	}, receipts)
+
+	s := NewRPCServer("", bs)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	var got []*types.Log
+	err := callJSONRPC(http.DefaultClient, server.URL, "getLogs", store.FilterQuery{
+		Addresses: []types.Address{emitter},
+		Topics:    [][]types.Hash{{topic}},
+	}, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Address != emitter || got[0].Topics[0] != topic {
+		t.Fatalf("expected the log of block 1, got %+v", got)
+	}
+
+	got = nil
+	err = callJSONRPC(http.DefaultClient, server.URL, "getLogs", store.FilterQuery{
+		Topics: [][]types.Hash{{{9}}},
+	}, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no logs, got %+v", got)
+	}
+
+	err = callJSONRPC(http.DefaultClient, server.URL, "getBlock", nil, nil)
+	if rpcErr, ok := err.(*rpcError); !ok || rpcErr.Code != rpcErrMethodNotFound {
+		t.Fatalf("expected a method not found error, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"net"
+)
+
+// max size of an asmap file. Full maps of the internet are ~2MB
+const maxASMapSize = 8 * 1024 * 1024
+
+// ASMap maps IPs to the number of the autonomous system (ASN) announcing
+// them, so addresses can be grouped by network operator rather than by
+// prefix. It uses the compact format of Bitcoin Core's asmap: a program
+// for a small interpreter that walks the bits of the IPv6, or IPv4-mapped,
+// address. Maps are built from BGP dumps with Bitcoin's asmap tool.
+type ASMap struct {
+	bz       []byte
+	checksum string
+}
+
+// NewASMap checks bz is a well formed asmap and returns it.
+func NewASMap(bz []byte) (*ASMap, error) {
+	if len(bz) > maxASMapSize {
+		return nil, ErrASMapInvalid{"", fmt.Sprintf("too big (%d > %d bytes)", len(bz), maxASMapSize)}
+	}
+	if !sanityCheckASMap(bz, 128) {
+		return nil, ErrASMapInvalid{"", "malformed"}
+	}
+	sum := sha256.Sum256(bz)
+	return &ASMap{bz: bz, checksum: hex.EncodeToString(sum[:])}, nil
+}
+
+// LoadASMap reads and checks the asmap file at path.
+func LoadASMap(path string) (*ASMap, error) {
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewASMap(bz)
+	if err != nil {
+		e := err.(ErrASMapInvalid)
+		e.Path = path
+		return nil, e
+	}
+	return m, nil
+}
+
+// ASN returns the ASN announcing ip, 0 if it is unknown.
+func (m *ASMap) ASN(ip net.IP) uint32 {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return 0
+	}
+	return interpretASMap(m.bz, ip16)
+}
+
+// Checksum returns the hex encoded sha256 of the map.
+func (m *ASMap) Checksum() string {
+	return m.checksum
+}
+
+//-------------------------------------------------------------------
+// Interpreter
+
+// Instructions of the asmap program
+const (
+	asmapReturn  = 0 // return an ASN
+	asmapJump    = 1 // skip ahead if the next IP bit is set
+	asmapMatch   = 2 // compare the next IP bits, return the default ASN on mismatch
+	asmapDefault = 3 // set the default ASN
+)
+
+const asmapInvalid = 0xffffffff
+
+// Variable length encodings of the instruction arguments: the value is
+// minval plus, for the first class whose continuation bit is 0, a number
+// of that many bits, after the sizes of all the previous classes.
+var (
+	asmapTypeBitSizes  = []uint8{0, 0, 1}
+	asmapASNBitSizes   = []uint8{15, 16, 17, 18, 19, 20, 21, 22, 23, 24}
+	asmapMatchBitSizes = []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+	asmapJumpBitSizes  = []uint8{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30}
+)
+
+// asmapBit returns the bit at pos of the map, bits are stored LSB first.
+func asmapBit(bz []byte, pos int) bool {
+	return bz[pos/8]>>(uint(pos)%8)&1 == 1
+}
+
+// ipBit returns the bit at pos of the IP, MSB first.
+func ipBit(ip net.IP, pos int) bool {
+	return ip[pos/8]>>(7-uint(pos)%8)&1 == 1
+}
+
+func asmapDecodeBits(bz []byte, pos *int, minval uint32, sizes []uint8) uint32 {
+	end := len(bz) * 8
+	val := minval
+	for i, size := range sizes {
+		bit := false
+		if i+1 != len(sizes) {
+			if *pos == end {
+				break
+			}
+			bit = asmapBit(bz, *pos)
+			*pos++
+		}
+		if bit {
+			val += 1 << size
+			continue
+		}
+		for b := uint8(0); b < size; b++ {
+			if *pos == end {
+				return asmapInvalid
+			}
+			if asmapBit(bz, *pos) {
+				val += 1 << (size - 1 - b)
+			}
+			*pos++
+		}
+		return val
+	}
+	return asmapInvalid
+}
+
+func asmapDecodeType(bz []byte, pos *int) uint32 {
+	return asmapDecodeBits(bz, pos, 0, asmapTypeBitSizes)
+}
+
+func asmapDecodeASN(bz []byte, pos *int) uint32 {
+	return asmapDecodeBits(bz, pos, 1, asmapASNBitSizes)
+}
+
+func asmapDecodeMatch(bz []byte, pos *int) uint32 {
+	return asmapDecodeBits(bz, pos, 2, asmapMatchBitSizes)
+}
+
+func asmapDecodeJump(bz []byte, pos *int) uint32 {
+	return asmapDecodeBits(bz, pos, 17, asmapJumpBitSizes)
+}
+
+// interpretASMap runs the asmap program on the 16 bytes of ip.
+func interpretASMap(bz []byte, ip net.IP) uint32 {
+	var (
+		pos        = 0
+		end        = len(bz) * 8
+		ipBits     = len(ip) * 8
+		left       = ipBits
+		defaultASN = uint32(0)
+	)
+	for pos != end {
+		switch asmapDecodeType(bz, &pos) {
+		case asmapReturn:
+			asn := asmapDecodeASN(bz, &pos)
+			if asn == asmapInvalid {
+				return 0
+			}
+			return asn
+		case asmapJump:
+			jump := asmapDecodeJump(bz, &pos)
+			if jump == asmapInvalid || left == 0 {
+				return 0
+			}
+			if ipBit(ip, ipBits-left) {
+				if int(jump) >= end-pos {
+					return 0
+				}
+				pos += int(jump)
+			}
+			left--
+		case asmapMatch:
+			match := asmapDecodeMatch(bz, &pos)
+			if match == asmapInvalid {
+				return 0
+			}
+			matchLen := bits.Len32(match) - 1
+			if left < matchLen {
+				return 0
+			}
+			for b := 0; b < matchLen; b++ {
+				if ipBit(ip, ipBits-left) != (match>>uint(matchLen-1-b)&1 == 1) {
+					return defaultASN
+				}
+				left--
+			}
+		case asmapDefault:
+			defaultASN = asmapDecodeASN(bz, &pos)
+			if defaultASN == asmapInvalid {
+				return 0
+			}
+		default:
+			return 0
+		}
+	}
+	// reached the end without a return: the map is malformed
+	return 0
+}
+
+// sanityCheckASMap checks that every path of the program on an input of
+// the given number of bits ends with a return, without running past the
+// input or the map, as Bitcoin Core does before using a map.
+func sanityCheckASMap(bz []byte, ipBits int) bool {
+	type jumpTarget struct {
+		pos  int
+		left int // input bits left after the jump
+	}
+	var (
+		pos           = 0
+		end           = len(bz) * 8
+		jumps         []jumpTarget
+		prevOpcode    uint32 = asmapJump
+		hadIncomplete        = false
+	)
+	for pos != end {
+		if len(jumps) > 0 && pos >= jumps[len(jumps)-1].pos {
+			// jumped into the middle of the previous instruction
+			return false
+		}
+		switch asmapDecodeType(bz, &pos) {
+		case asmapReturn:
+			if prevOpcode == asmapDefault {
+				// could be a single return
+				return false
+			}
+			if asmapDecodeASN(bz, &pos) == asmapInvalid {
+				return false
+			}
+			if len(jumps) == 0 {
+				// nothing left to run, only zero padding may follow
+				if end-pos > 7 {
+					return false
+				}
+				for ; pos != end; pos++ {
+					if asmapBit(bz, pos) {
+						return false
+					}
+				}
+				return true
+			}
+			// continue as if we had jumped to the next instruction
+			target := jumps[len(jumps)-1]
+			if pos != target.pos {
+				// unreachable code
+				return false
+			}
+			ipBits = target.left
+			jumps = jumps[:len(jumps)-1]
+			prevOpcode = asmapJump
+		case asmapJump:
+			jump := asmapDecodeJump(bz, &pos)
+			if jump == asmapInvalid || int(jump) >= end-pos || ipBits == 0 {
+				return false
+			}
+			target := pos + int(jump)
+			if len(jumps) > 0 && target >= jumps[len(jumps)-1].pos {
+				// intersecting jumps
+				return false
+			}
+			jumps = append(jumps, jumpTarget{target, ipBits - 1})
+			ipBits--
+			prevOpcode = asmapJump
+		case asmapMatch:
+			match := asmapDecodeMatch(bz, &pos)
+			if match == asmapInvalid {
+				return false
+			}
+			matchLen := bits.Len32(match) - 1
+			if prevOpcode != asmapMatch {
+				hadIncomplete = false
+			}
+			if matchLen < 8 && hadIncomplete {
+				// only the last of a sequence of matches may be short
+				return false
+			}
+			hadIncomplete = matchLen < 8
+			if ipBits < matchLen {
+				return false
+			}
+			ipBits -= matchLen
+			prevOpcode = asmapMatch
+		case asmapDefault:
+			if prevOpcode == asmapDefault {
+				// could be a single default
+				return false
+			}
+			if asmapDecodeASN(bz, &pos) == asmapInvalid {
+				return false
+			}
+			prevOpcode = asmapDefault
+		default:
+			// instruction straddles the end
+			return false
+		}
+	}
+	// reached the end without a return
+	return false
+}
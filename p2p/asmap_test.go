@@ -0,0 +1,201 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+// asmapWriter assembles asmap programs with the encoding of Bitcoin Core's
+// contrib/asmap/asmap.py, bits LSB first.
+type asmapWriter struct {
+	bits []bool
+}
+
+func (w *asmapWriter) writeBits(minval uint32, sizes []uint8, val uint32) {
+	val -= minval
+	for i, size := range sizes {
+		if i+1 != len(sizes) {
+			if val >= 1<<size {
+				w.bits = append(w.bits, true)
+				val -= 1 << size
+				continue
+			}
+			w.bits = append(w.bits, false)
+		}
+		for b := int(size) - 1; b >= 0; b-- {
+			w.bits = append(w.bits, val>>uint(b)&1 == 1)
+		}
+		return
+	}
+}
+
+func (w *asmapWriter) ret(asn uint32) *asmapWriter {
+	w.writeBits(0, asmapTypeBitSizes, asmapReturn)
+	w.writeBits(1, asmapASNBitSizes, asn)
+	return w
+}
+
+func (w *asmapWriter) defaultASN(asn uint32) *asmapWriter {
+	w.writeBits(0, asmapTypeBitSizes, asmapDefault)
+	w.writeBits(1, asmapASNBitSizes, asn)
+	return w
+}
+
+// match compares the next n bits of the IP with the low n bits of val.
+func (w *asmapWriter) match(val uint32, n uint) *asmapWriter {
+	w.writeBits(0, asmapTypeBitSizes, asmapMatch)
+	w.writeBits(2, asmapMatchBitSizes, 1<<n|val)
+	return w
+}
+
+// jump runs zero, or skips it and runs one, on the next bit of the IP.
+func (w *asmapWriter) jump(zero, one *asmapWriter) *asmapWriter {
+	w.writeBits(0, asmapTypeBitSizes, asmapJump)
+	w.writeBits(17, asmapJumpBitSizes, uint32(len(zero.bits)))
+	w.bits = append(w.bits, zero.bits...)
+	w.bits = append(w.bits, one.bits...)
+	return w
+}
+
+func (w *asmapWriter) bytes() []byte {
+	bz := make([]byte, (len(w.bits)+7)/8)
+	for i, bit := range w.bits {
+		if bit {
+			bz[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return bz
+}
+
+// testASMap maps the IPv4 addresses
+//
+//	0.0.0.0/2 to AS100
+//	127.0.0.0/8 to AS150
+//	128.0.0.0/2 to AS200
+//	234.0.0.0/8 to AS400
+//	the rest of 192.0.0.0/2 to AS300
+//
+// and the rest of 64.0.0.0/2 and IPv6 addresses to nothing.
+func testASMap() *asmapWriter {
+	w := new(asmapWriter)
+	// ::ffff:0:0/96
+	for i := 0; i < 10; i++ {
+		w.match(0, 8)
+	}
+	w.match(0xff, 8).match(0xff, 8)
+	return w.jump(
+		new(asmapWriter).jump(
+			new(asmapWriter).ret(100),
+			new(asmapWriter).match(0x3f, 6).ret(150),
+		),
+		new(asmapWriter).jump(
+			new(asmapWriter).ret(200),
+			new(asmapWriter).defaultASN(300).match(0x2a, 6).ret(400),
+		),
+	)
+}
+
+// testASMapHex is testASMap assembled, which the address book tests use.
+const testASMapHex = "fb03ec0fb03fc0fe00fb03ec0fb03fc0fe00fb03ec0fb0fffffeff45040030def703480d00307e40ea7d15c078"
+
+func TestASMapLookup(t *testing.T) {
+	bz := testASMap().bytes()
+	if hex.EncodeToString(bz) != testASMapHex {
+		t.Fatalf("assembled %x, want %s", bz, testASMapHex)
+	}
+	m, err := NewASMap(bz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip  string
+		asn uint32
+	}{
+		{"1.2.3.4", 100},
+		{"63.255.255.255", 100},
+		{"127.0.0.1", 150},
+		{"127.255.255.255", 150},
+		{"64.1.2.3", 0},
+		{"126.255.255.255", 0},
+		{"128.0.0.1", 200},
+		{"191.255.0.1", 200},
+		{"234.1.2.3", 400},
+		{"234.255.255.255", 400},
+		{"192.168.1.1", 300},
+		{"235.0.0.1", 300},
+		{"2001:db8::1", 0},
+		{"::1", 0},
+		{"::fffe:102:304", 0},
+	}
+	for _, tc := range cases {
+		if asn := m.ASN(net.ParseIP(tc.ip)); asn != tc.asn {
+			t.Errorf("%v: got AS%d, want AS%d", tc.ip, asn, tc.asn)
+		}
+	}
+	if m.ASN(nil) != 0 {
+		t.Error("expected no ASN for a nil IP")
+	}
+}
+
+func TestASMapMalformed(t *testing.T) {
+	valid := testASMap()
+	bz := valid.bytes()
+
+	// every truncation cuts off a return
+	for n := 0; n < len(bz); n++ {
+		if _, err := NewASMap(bz[:n]); err == nil {
+			t.Errorf("map truncated to %d bytes was accepted", n)
+		}
+	}
+
+	// the bits after the last return must be a zero padding of the last byte
+	if len(valid.bits)%8 == 0 {
+		t.Fatal("the test map has no padding")
+	}
+	padded := append([]byte(nil), bz...)
+	padded[len(padded)-1] |= 0x80
+	if _, err := NewASMap(padded); err == nil {
+		t.Error("map with a nonzero padding was accepted")
+	}
+	if _, err := NewASMap(append(bz, 0)); err == nil {
+		t.Error("map with a padding byte was accepted")
+	}
+
+	// a jump may not land past the one it is nested in
+	inner := new(asmapWriter).jump(new(asmapWriter).ret(1), new(asmapWriter))
+	intersecting := new(asmapWriter).jump(inner, new(asmapWriter).ret(2).ret(3))
+	if _, err := NewASMap(intersecting.bytes()); err == nil {
+		t.Error("map with intersecting jumps was accepted")
+	}
+
+	malformed := map[string]*asmapWriter{
+		// a return after a default could be a single return
+		"default then return": new(asmapWriter).match(0, 8).defaultASN(1).ret(2),
+		// more bits matched than an IP has
+		"match past the IP": func() *asmapWriter {
+			w := new(asmapWriter)
+			for i := 0; i < 17; i++ {
+				w.match(0, 8)
+			}
+			return w.ret(1)
+		}(),
+		// only the last of a sequence of matches may be short
+		"short match": new(asmapWriter).match(0, 4).match(0, 4).ret(1),
+		// code after the last return can't be reached
+		"unreachable": new(asmapWriter).jump(new(asmapWriter).ret(1).ret(2), new(asmapWriter).ret(3)),
+		"no return":   new(asmapWriter).match(0, 8),
+	}
+	for name, w := range malformed {
+		if _, err := NewASMap(w.bytes()); err == nil {
+			t.Errorf("%s: malformed map was accepted", name)
+		} else if _, ok := err.(ErrASMapInvalid); !ok {
+			t.Errorf("%s: expected ErrASMapInvalid, got %v", name, err)
+		}
+	}
+
+	if _, err := NewASMap(make([]byte, maxASMapSize+1)); err == nil {
+		t.Error("oversized map was accepted")
+	}
+}
@@ -0,0 +1,240 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+)
+
+var banListKey = []byte("banList")
+
+// BanEntry is a ban on a peer ID, an IP or a CIDR range.
+type BanEntry struct {
+	Target  string    `json:"target"`  // peer ID, IP or CIDR
+	Reason  string    `json:"reason"`  // why the ban was set
+	Expires time.Time `json:"expires"` // zero for a ban without expiry
+}
+
+// Expired returns true if the ban is no longer in force at the given time.
+func (e *BanEntry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+func (e *BanEntry) String() string {
+	if e.Expires.IsZero() {
+		return fmt.Sprintf("%s (forever): %s", e.Target, e.Reason)
+	}
+	return fmt.Sprintf("%s (until %v): %s", e.Target, e.Expires.Format(time.RFC3339), e.Reason)
+}
+
+// BanList keeps the peers we refuse to connect to, by peer ID, IP or CIDR.
+// It is saved to the DB on every change and loaded when created, so bans
+// survive restarts. Expired bans are ignored, and dropped from the DB by
+// PruneExpired, which the owner calls periodically.
+type BanList struct {
+	mtx     sync.Mutex
+	db      dbm.DB
+	entries map[string]*BanEntry // keyed by normalized target
+	nets    map[string]*net.IPNet
+}
+
+// NewBanList returns a ban list backed by db, loaded with the bans saved in it.
+func NewBanList(db dbm.DB) *BanList {
+	bl := &BanList{
+		db:      db,
+		entries: make(map[string]*BanEntry),
+		nets:    make(map[string]*net.IPNet),
+	}
+	bl.loadFromDB()
+	return bl
+}
+
+// Ban bans target, a peer ID, an IP or a CIDR range, for the given duration.
+// A zero duration bans forever. Banning an already banned target replaces
+// its entry.
+func (bl *BanList) Ban(target string, duration time.Duration, reason string) error {
+	key, ipNet, err := parseBanTarget(target)
+	if err != nil {
+		return err
+	}
+	entry := &BanEntry{Target: key, Reason: reason}
+	if duration > 0 {
+		entry.Expires = time.Now().Add(duration)
+	}
+
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	bl.entries[key] = entry
+	if ipNet != nil {
+		bl.nets[key] = ipNet
+	}
+	bl.saveToDB()
+	return nil
+}
+
+// Unban lifts the ban on target.
+// It returns ErrBanNotFound if target is not banned.
+func (bl *BanList) Unban(target string) error {
+	key, _, err := parseBanTarget(target)
+	if err != nil {
+		return err
+	}
+
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	if _, ok := bl.entries[key]; !ok {
+		return ErrBanNotFound{target}
+	}
+	bl.remove(key)
+	bl.saveToDB()
+	return nil
+}
+
+// List returns the bans in force, sorted by target.
+func (bl *BanList) List() []*BanEntry {
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	now := time.Now()
+	list := make([]*BanEntry, 0, len(bl.entries))
+	for _, entry := range bl.entries {
+		if entry.Expired(now) {
+			continue
+		}
+		e := *entry
+		list = append(list, &e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+	return list
+}
+
+// IsBanned returns true if the peer ID or the IP is banned.
+// Either may be empty.
+func (bl *BanList) IsBanned(id ID, ip net.IP) bool {
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	now := time.Now()
+	if id != "" {
+		if entry, ok := bl.entries[strings.ToLower(string(id))]; ok && !entry.Expired(now) {
+			return true
+		}
+	}
+	if ip != nil {
+		for key, ipNet := range bl.nets {
+			if ipNet.Contains(ip) && !bl.entries[key].Expired(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PruneExpired drops the expired bans and saves the list if any was.
+func (bl *BanList) PruneExpired() {
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	bl.pruneExpired(time.Now())
+}
+
+// IsBannedAddr returns true if the ID or the IP of addr is banned.
+func (bl *BanList) IsBannedAddr(addr *NetAddress) bool {
+	return bl.IsBanned(addr.ID, addr.IP)
+}
+
+/* Private methods */
+/* All methods below assume the mutex has been acquired */
+
+func (bl *BanList) remove(key string) {
+	delete(bl.entries, key)
+	delete(bl.nets, key)
+}
+
+func (bl *BanList) pruneExpired(now time.Time) {
+	pruned := false
+	for key, entry := range bl.entries {
+		if entry.Expired(now) {
+			bl.remove(key)
+			pruned = true
+		}
+	}
+	if pruned {
+		bl.saveToDB()
+	}
+}
+
+// Loads the bans from the DB, skipping the expired ones.
+// cmn.Panics if the data is corrupt
+func (bl *BanList) loadFromDB() {
+	bz := bl.db.Get(banListKey)
+	if len(bz) == 0 {
+		return
+	}
+
+	var entries []*BanEntry
+	if err := json.Unmarshal(bz, &entries); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Could not unmarshal ban list: %v", err))
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Expired(now) {
+			continue
+		}
+		key, ipNet, err := parseBanTarget(entry.Target)
+		if err != nil {
+			cmn.PanicCrisis(cmn.Fmt("Invalid ban list entry %v: %v", entry.Target, err))
+		}
+		bl.entries[key] = entry
+		if ipNet != nil {
+			bl.nets[key] = ipNet
+		}
+	}
+}
+
+func (bl *BanList) saveToDB() {
+	entries := make([]*BanEntry, 0, len(bl.entries))
+	for _, entry := range bl.entries {
+		entries = append(entries, entry)
+	}
+	bz, err := json.Marshal(entries)
+	if err != nil {
+		cmn.PanicSanity(cmn.Fmt("Could not marshal ban list: %v", err))
+	}
+	bl.db.SetSync(banListKey, bz)
+}
+
+// parseBanTarget returns the normalized form of a peer ID, IP or CIDR
+// target, and the range it covers if it is an IP or a CIDR.
+func parseBanTarget(target string) (string, *net.IPNet, error) {
+	target = strings.TrimSpace(target)
+	if strings.Contains(target, "/") {
+		_, ipNet, err := net.ParseCIDR(target)
+		if err != nil {
+			return "", nil, ErrBanTargetInvalid{target, err}
+		}
+		return ipNet.String(), ipNet, nil
+	}
+	if ip := net.ParseIP(target); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return ip.String(), &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	id, err := parseID(target)
+	if err != nil {
+		return "", nil, ErrBanTargetInvalid{target, err}
+	}
+	return string(id), nil, nil
+}
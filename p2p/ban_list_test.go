@@ -0,0 +1,84 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	dbm "github.com/tendermint/tmlibs/db"
+)
+
+func TestBanListTargets(t *testing.T) {
+	bl := NewBanList(dbm.NewMemDB())
+	id := ID("0123456789abcdef0123456789abcdef01234567")
+	for _, target := range []string{string(id), "10.0.0.1", "192.168.0.0/16"} {
+		if err := bl.Ban(target, 0, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bl.Ban("not a target", 0, "test"); err == nil {
+		t.Error("expected an invalid target to be refused")
+	}
+
+	tests := []struct {
+		id     ID
+		ip     string
+		banned bool
+	}{
+		{id, "", true},
+		{"", "10.0.0.1", true},
+		{"", "10.0.0.2", false},
+		{"", "192.168.5.5", true},
+		{"fedcba9876543210fedcba9876543210fedcba98", "1.2.3.4", false},
+	}
+	for _, tt := range tests {
+		if got := bl.IsBanned(tt.id, net.ParseIP(tt.ip)); got != tt.banned {
+			t.Errorf("IsBanned(%q, %q) = %v, want %v", tt.id, tt.ip, got, tt.banned)
+		}
+	}
+
+	if err := bl.Unban("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if bl.IsBanned("", net.ParseIP("10.0.0.1")) {
+		t.Error("expected the IP to be unbanned")
+	}
+	if err := bl.Unban("10.0.0.1"); err == nil {
+		t.Error("expected an error lifting a missing ban")
+	}
+}
+
+func TestBanListExpiry(t *testing.T) {
+	db := dbm.NewMemDB()
+	bl := NewBanList(db)
+	if err := bl.Ban("10.0.0.1", time.Millisecond, "short"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bl.Ban("10.0.0.2", 0, "forever"); err != nil {
+		t.Fatal(err)
+	}
+	saved := string(db.Get(banListKey))
+	time.Sleep(5 * time.Millisecond)
+
+	// expired bans are ignored without touching the DB
+	if bl.IsBanned("", net.ParseIP("10.0.0.1")) {
+		t.Error("expected the expired ban to be ignored")
+	}
+	if len(bl.List()) != 1 {
+		t.Errorf("expected only the ban in force to be listed, got %v", bl.List())
+	}
+	if string(db.Get(banListKey)) != saved {
+		t.Error("expected IsBanned and List not to save the list")
+	}
+
+	bl.PruneExpired()
+	if string(db.Get(banListKey)) == saved {
+		t.Error("expected PruneExpired to save the list")
+	}
+
+	// the bans survive a restart
+	bl = NewBanList(db)
+	if !bl.IsBanned("", net.ParseIP("10.0.0.2")) || len(bl.List()) != 1 {
+		t.Errorf("expected the ban to be loaded, got %v", bl.List())
+	}
+}
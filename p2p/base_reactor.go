@@ -30,6 +30,16 @@ type Reactor interface {
 	Receive(chID byte, peer Peer, msgBytes []byte)
 }
 
+// VersionedReactor is a Reactor that advertises the version of its protocol
+// in the capabilities of the NodeInfo, under the name it was added to the
+// switch with.
+type VersionedReactor interface {
+	Reactor
+
+	// ProtocolVersion returns the version of the protocol spoken by the reactor.
+	ProtocolVersion() uint64
+}
+
 //--------------------------------------
 
 type BaseReactor struct {
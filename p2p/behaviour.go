@@ -0,0 +1,262 @@
+package p2p
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	cfg "github.com/go-fusion/config"
+)
+
+// BehaviourKind identifies something a peer did that reactors report to the switch.
+type BehaviourKind int
+
+const (
+	// BehaviourBadMessage is a message that could not be decoded or was not expected.
+	BehaviourBadMessage BehaviourKind = iota
+	// BehaviourInvalidBlock is a block that failed validation.
+	BehaviourInvalidBlock
+	// BehaviourInvalidTx is a transaction that failed validation.
+	BehaviourInvalidTx
+	// BehaviourSpam is a message sent more often than the protocol allows.
+	BehaviourSpam
+	// BehaviourUsefulBlock is a valid block we did not have yet.
+	BehaviourUsefulBlock
+	// BehaviourUsefulTx is a valid transaction we did not have yet.
+	BehaviourUsefulTx
+	// BehaviourInvalidRecord is a relayed node record with a bad signature.
+	BehaviourInvalidRecord
+)
+
+var behaviourNames = map[BehaviourKind]string{
+	BehaviourBadMessage:    "bad_message",
+	BehaviourInvalidBlock:  "invalid_block",
+	BehaviourInvalidTx:     "invalid_tx",
+	BehaviourSpam:          "spam",
+	BehaviourUsefulBlock:   "useful_block",
+	BehaviourUsefulTx:      "useful_tx",
+	BehaviourInvalidRecord: "invalid_record",
+}
+
+// String returns the name used for the behaviour in the config.
+func (k BehaviourKind) String() string {
+	if name, ok := behaviourNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("BehaviourKind(%d)", int(k))
+}
+
+// useful returns true for behaviours that make the peer good.
+func (k BehaviourKind) useful() bool {
+	return k == BehaviourUsefulBlock || k == BehaviourUsefulTx
+}
+
+// DefaultBehaviourWeights returns the penalty added to a peer's misbehaviour
+// score for each behaviour. Useful behaviours have negative weights and
+// reduce the score.
+func DefaultBehaviourWeights() map[BehaviourKind]int {
+	return map[BehaviourKind]int{
+		BehaviourBadMessage:    50,
+		BehaviourInvalidBlock:  200,
+		BehaviourInvalidTx:     10,
+		BehaviourSpam:          25,
+		BehaviourUsefulBlock:   -5,
+		BehaviourUsefulTx:      -1,
+		BehaviourInvalidRecord: 100,
+	}
+}
+
+// PeerBehaviour is a behaviour reported by a reactor together with the
+// reason for it, which is logged and passed to the reactors if the peer is
+// disconnected.
+type PeerBehaviour struct {
+	Kind   BehaviourKind
+	Reason interface{}
+}
+
+// BadMessage returns a BehaviourBadMessage caused by reason.
+func BadMessage(reason interface{}) PeerBehaviour {
+	return PeerBehaviour{BehaviourBadMessage, reason}
+}
+
+// InvalidBlock returns a BehaviourInvalidBlock caused by reason.
+func InvalidBlock(reason interface{}) PeerBehaviour {
+	return PeerBehaviour{BehaviourInvalidBlock, reason}
+}
+
+// InvalidTx returns a BehaviourInvalidTx caused by reason.
+func InvalidTx(reason interface{}) PeerBehaviour {
+	return PeerBehaviour{BehaviourInvalidTx, reason}
+}
+
+// Spam returns a BehaviourSpam caused by reason.
+func Spam(reason interface{}) PeerBehaviour {
+	return PeerBehaviour{BehaviourSpam, reason}
+}
+
+// InvalidRecord returns a BehaviourInvalidRecord caused by reason.
+func InvalidRecord(reason interface{}) PeerBehaviour {
+	return PeerBehaviour{BehaviourInvalidRecord, reason}
+}
+
+// UsefulBlock returns a BehaviourUsefulBlock.
+func UsefulBlock() PeerBehaviour {
+	return PeerBehaviour{Kind: BehaviourUsefulBlock}
+}
+
+// UsefulTx returns a BehaviourUsefulTx.
+func UsefulTx() PeerBehaviour {
+	return PeerBehaviour{Kind: BehaviourUsefulTx}
+}
+
+func (b PeerBehaviour) String() string {
+	if b.Reason == nil {
+		return b.Kind.String()
+	}
+	return fmt.Sprintf("%v: %v", b.Kind, b.Reason)
+}
+
+//-----------------------------------------------------------------------------
+
+// BehaviourAction is what the switch does about a reported behaviour.
+type BehaviourAction int
+
+const (
+	// ActionIgnore does nothing.
+	ActionIgnore BehaviourAction = iota
+	// ActionWarn logs the behaviour and keeps the peer.
+	ActionWarn
+	// ActionDisconnect stops the peer.
+	ActionDisconnect
+	// ActionBan stops the peer and refuses to connect to it again.
+	ActionBan
+)
+
+// BehaviourScorer is the scoring backend of Switch.ReportBehaviour.
+type BehaviourScorer interface {
+	// Score records the behaviour of the peer with the given ID and
+	// returns the action the switch should take.
+	Score(id ID, b PeerBehaviour) BehaviourAction
+}
+
+const (
+	// scorePruneInterval is how often decayed scores are dropped
+	scorePruneInterval = time.Minute
+
+	// maxScores bounds the number of peers with a score. Past it, the
+	// lowest scores are dropped
+	maxScores = 10000
+)
+
+// scoreBook is the default BehaviourScorer. It keeps a misbehaviour score per
+// peer ID: each behaviour adds its weight, with a floor at zero, and the
+// score is compared against the disconnect and ban thresholds. Scores
+// outlive connections so a peer that misbehaves again after a reconnect
+// gets banned, but they are halved every halfLife, so that a peer is not
+// disconnected for faults spread over days. Scores that decayed below 1 are
+// dropped.
+type scoreBook struct {
+	mtx             sync.Mutex
+	scores          map[ID]*peerScore
+	weights         map[BehaviourKind]int
+	disconnectScore int
+	banScore        int
+	halfLife        time.Duration // 0 disables the decay
+	lastPrune       time.Time
+	now             func() time.Time
+}
+
+// peerScore is a misbehaviour score as of updated.
+type peerScore struct {
+	score   float64
+	updated time.Time
+}
+
+// NewBehaviourScorer returns the default BehaviourScorer configured by the
+// behaviour weights, thresholds and score half-life of config. Weights
+// missing from the config keep their DefaultBehaviourWeights value.
+func NewBehaviourScorer(config *cfg.P2PConfig) BehaviourScorer {
+	weights := DefaultBehaviourWeights()
+	for kind, name := range behaviourNames {
+		if w, ok := config.BehaviourWeights[name]; ok {
+			weights[kind] = w
+		}
+	}
+	return &scoreBook{
+		scores:          make(map[ID]*peerScore),
+		weights:         weights,
+		disconnectScore: config.DisconnectScore,
+		banScore:        config.BanScore,
+		halfLife:        time.Duration(config.BehaviourScoreHalfLife) * time.Second,
+		now:             time.Now,
+	}
+}
+
+// Score implements BehaviourScorer.
+func (sb *scoreBook) Score(id ID, b PeerBehaviour) BehaviourAction {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+
+	now := sb.now()
+	sb.prune(now)
+
+	weight := sb.weights[b.Kind]
+	score := sb.decayed(id, now) + float64(weight)
+	if score < 1 {
+		delete(sb.scores, id)
+	} else {
+		sb.scores[id] = &peerScore{score, now}
+	}
+
+	switch {
+	case weight <= 0:
+		return ActionIgnore
+	case score >= float64(sb.banScore):
+		// the peer is gone for good, start afresh if it is ever unbanned
+		delete(sb.scores, id)
+		return ActionBan
+	case score >= float64(sb.disconnectScore):
+		return ActionDisconnect
+	default:
+		return ActionWarn
+	}
+}
+
+// decayed returns the score of the peer with the given ID at now.
+func (sb *scoreBook) decayed(id ID, now time.Time) float64 {
+	ps, ok := sb.scores[id]
+	if !ok {
+		return 0
+	}
+	if sb.halfLife <= 0 {
+		return ps.score
+	}
+	halvings := float64(now.Sub(ps.updated)) / float64(sb.halfLife)
+	return ps.score * math.Pow(0.5, halvings)
+}
+
+// prune drops the scores that decayed below 1, at most every
+// scorePruneInterval, and the lowest scores past maxScores.
+func (sb *scoreBook) prune(now time.Time) {
+	if now.Sub(sb.lastPrune) >= scorePruneInterval {
+		sb.lastPrune = now
+		for id := range sb.scores {
+			if sb.decayed(id, now) < 1 {
+				delete(sb.scores, id)
+			}
+		}
+	}
+	for len(sb.scores) >= maxScores {
+		var (
+			lowest      ID
+			lowestScore float64
+		)
+		for id := range sb.scores {
+			if score := sb.decayed(id, now); lowest == "" || score < lowestScore {
+				lowest, lowestScore = id, score
+			}
+		}
+		delete(sb.scores, lowest)
+	}
+}
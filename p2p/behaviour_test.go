@@ -0,0 +1,141 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	cfg "github.com/go-fusion/config"
+)
+
+// newTestScoreBook returns the score book of config, on a clock that only
+// moves when advanced.
+func newTestScoreBook(config *cfg.P2PConfig) (*scoreBook, func(time.Duration)) {
+	sb := NewBehaviourScorer(config).(*scoreBook)
+	now := time.Now()
+	sb.now = func() time.Time { return now }
+	return sb, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestSwitchReportBehaviour(t *testing.T) {
+	config := cfg.DefaultP2PConfig()
+	config.BehaviourWeights = map[string]int{"invalid_tx": 40}
+	sw, peers := newLimitedSwitch(t, 3)
+	sw.SetBehaviourScorer(NewBehaviourScorer(config))
+
+	// below the disconnect score the peer is kept, and useful behaviours
+	// take their weight off the score
+	peer := peers[0]
+	sw.ReportBehaviour(peer, InvalidTx("bad signature"))
+	sw.ReportBehaviour(peer, UsefulBlock())
+	sw.ReportBehaviour(peer, InvalidTx("bad signature"))
+	if !sw.peers.Has(peer.ID()) {
+		t.Fatal("expected the peer kept at a score of 75")
+	}
+	// the configured weight replaces the default one
+	sw.ReportBehaviour(peer, InvalidTx("bad signature"))
+	if sw.peers.Has(peer.ID()) {
+		t.Fatal("expected the peer disconnected at a score of 115")
+	}
+	if sw.banList.IsBanned(peer.ID(), peer.RemoteIP()) {
+		t.Fatal("expected the peer not banned below the ban score")
+	}
+
+	// the score outlives the connection, up to the ban
+	if err := sw.peers.Add(peer); err != nil {
+		t.Fatal(err)
+	}
+	sw.ReportBehaviour(peer, InvalidRecord("bad signature"))
+	if sw.peers.Has(peer.ID()) || !sw.banList.IsBanned(peer.ID(), nil) {
+		t.Fatal("expected the peer banned at a score of 215")
+	}
+
+	// a behaviour over the ban score bans at once
+	sw.ReportBehaviour(peers[1], InvalidBlock("bad state root"))
+	if sw.peers.Has(peers[1].ID()) || !sw.banList.IsBanned(peers[1].ID(), nil) {
+		t.Fatal("expected the peer banned for an invalid block")
+	}
+
+	// useful behaviours mark the peer as good
+	sw.ReportBehaviour(peers[2], UsefulTx())
+	if !sw.hasTrustHistory(peers[2].ID()) || sw.peerTrustScore(peers[2].ID()) != maxTrustScore {
+		t.Fatal("expected a good event recorded for the useful peer")
+	}
+}
+
+func TestScoreBookThresholds(t *testing.T) {
+	sb, _ := newTestScoreBook(cfg.DefaultP2PConfig())
+	id := mockID(1)
+
+	cases := []struct {
+		b      PeerBehaviour
+		action BehaviourAction
+	}{
+		{UsefulBlock(), ActionIgnore},           // 0, floored
+		{Spam(nil), ActionWarn},                 // 25
+		{Spam(nil), ActionWarn},                 // 50
+		{UsefulTx(), ActionIgnore},              // 49
+		{BadMessage(nil), ActionWarn},           // 99
+		{UsefulTx(), ActionIgnore},              // 98
+		{Spam(nil), ActionDisconnect},           // 123
+		{InvalidRecord(nil), ActionBan},         // 223
+		{Spam(nil), ActionWarn},                 // 25, afresh after the ban
+		{InvalidBlock(nil), ActionBan},          // 225
+		{PeerBehaviour{Kind: 42}, ActionIgnore}, // unknown kinds weigh nothing
+	}
+	for i, tc := range cases {
+		if action := sb.Score(id, tc.b); action != tc.action {
+			t.Errorf("case %d (%v): got action %d, want %d", i, tc.b, action, tc.action)
+		}
+	}
+}
+
+func TestScoreBookDecay(t *testing.T) {
+	config := cfg.DefaultP2PConfig()
+	config.BehaviourScoreHalfLife = 3600
+	sb, advance := newTestScoreBook(config)
+	id := mockID(1)
+
+	// 75 halves to 37.5 in an hour, so 50 more stays below 100
+	for i := 0; i < 3; i++ {
+		sb.Score(id, Spam(nil))
+	}
+	advance(time.Hour)
+	if action := sb.Score(id, BadMessage(nil)); action != ActionWarn {
+		t.Fatalf("expected the decayed score to stay below the disconnect score, got action %d", action)
+	}
+	// the next fault takes it past it
+	if action := sb.Score(id, Spam(nil)); action != ActionDisconnect {
+		t.Fatalf("expected 112.5 to disconnect, got action %d", action)
+	}
+
+	// decayed scores are pruned
+	sb.Score(mockID(2), InvalidTx(nil))
+	advance(10 * time.Hour)
+	sb.Score(mockID(3), InvalidTx(nil))
+	if _, ok := sb.scores[mockID(2)]; ok || len(sb.scores) != 1 {
+		t.Fatalf("expected only the fresh score kept, got %d scores", len(sb.scores))
+	}
+
+	// without a half-life scores last until the ban
+	config.BehaviourScoreHalfLife = 0
+	sb, advance = newTestScoreBook(config)
+	sb.Score(id, BadMessage(nil))
+	advance(1000 * time.Hour)
+	if action := sb.Score(id, BadMessage(nil)); action != ActionDisconnect {
+		t.Fatalf("expected the score kept without a half-life, got action %d", action)
+	}
+}
+
+func TestScoreBookMaxScores(t *testing.T) {
+	sb, _ := newTestScoreBook(cfg.DefaultP2PConfig())
+	sb.Score(mockID(0), BadMessage(nil))
+	for i := 1; i < maxScores+10; i++ {
+		sb.Score(mockID(i), InvalidTx(nil))
+	}
+	if len(sb.scores) > maxScores {
+		t.Fatalf("expected at most %d scores, got %d", maxScores, len(sb.scores))
+	}
+	if _, ok := sb.scores[mockID(0)]; !ok {
+		t.Fatal("expected the highest score kept")
+	}
+}
@@ -0,0 +1,143 @@
+package p2p
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	cfg "github.com/go-fusion/config"
+)
+
+// ConnFilter filters connections by the CIDR and peer ID allow and deny
+// lists of the P2P config. Its FilterAddr and FilterID methods are meant to
+// be set on the switch with SetAddrFilter and SetIDFilter. The lists can be
+// swapped with Reload while the switch runs.
+//
+// Denied entries always win. A non-empty CIDR allowlist rejects every IP
+// outside of it. In private network mode only the allowlisted IDs are
+// accepted.
+type ConnFilter struct {
+	mtx            sync.RWMutex
+	allowedNets    []*net.IPNet
+	deniedNets     []*net.IPNet
+	allowedIDs     map[ID]struct{}
+	deniedIDs      map[ID]struct{}
+	privateNetwork bool
+}
+
+// NewConnFilter returns a filter built from config.
+func NewConnFilter(config *cfg.P2PConfig) (*ConnFilter, error) {
+	f := &ConnFilter{}
+	if err := f.Reload(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload replaces the lists of the filter with the ones of config.
+// On error the filter is left unchanged.
+// NOTE: peers that are already connected are not filtered again.
+func (f *ConnFilter) Reload(config *cfg.P2PConfig) error {
+	allowedNets, err := parseCIDRs(config.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+	deniedNets, err := parseCIDRs(config.DeniedCIDRs)
+	if err != nil {
+		return err
+	}
+	allowedIDs, err := parseIDs(config.AllowedPeerIDs)
+	if err != nil {
+		return err
+	}
+	deniedIDs, err := parseIDs(config.DeniedPeerIDs)
+	if err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.allowedNets = allowedNets
+	f.deniedNets = deniedNets
+	f.allowedIDs = allowedIDs
+	f.deniedIDs = deniedIDs
+	f.privateNetwork = config.PrivateNetwork
+	return nil
+}
+
+// FilterAddr returns an error if connections from or to addr are forbidden.
+func (f *ConnFilter) FilterAddr(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	for _, ipNet := range f.deniedNets {
+		if ipNet.Contains(ip) {
+			return ErrFilterAddrRejected{addr, "denied"}
+		}
+	}
+	if len(f.allowedNets) == 0 {
+		return nil
+	}
+	for _, ipNet := range f.allowedNets {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrFilterAddrRejected{addr, "not allowed"}
+}
+
+// FilterID returns an error if connections with the peer ID are forbidden.
+func (f *ConnFilter) FilterID(id ID) error {
+	id = ID(strings.ToLower(string(id)))
+
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	if _, ok := f.deniedIDs[id]; ok {
+		return ErrFilterIDRejected{id, "denied"}
+	}
+	if !f.privateNetwork {
+		return nil
+	}
+	if _, ok := f.allowedIDs[id]; !ok {
+		return ErrFilterIDRejected{id, "not allowed in private network"}
+	}
+	return nil
+}
+
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range cmn.SplitAndTrim(list, ",", " ") {
+		if s == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, ErrNetAddressInvalid{s, err}
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func parseIDs(list string) (map[ID]struct{}, error) {
+	ids := make(map[ID]struct{})
+	for _, s := range cmn.SplitAndTrim(list, ",", " ") {
+		if s == "" {
+			continue
+		}
+		id, err := parseID(s)
+		if err != nil {
+			return nil, ErrNetAddressInvalid{s, err}
+		}
+		ids[id] = struct{}{}
+	}
+	return ids, nil
+}
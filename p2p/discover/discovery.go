@@ -0,0 +1,640 @@
+package discover
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+const (
+	// DiscoveryProtocolVersion is the version sent in pings
+	DiscoveryProtocolVersion = 1
+
+	// how long a message is valid after it was sent
+	expiration = 20 * time.Second
+
+	// how long we wait for a reply
+	respTimeout = 500 * time.Millisecond
+
+	// how long a node that answered our ping may send us findnodes,
+	// and a node that pinged us answers ours
+	bondExpiration = 24 * time.Hour
+
+	// concurrent findnodes of a lookup
+	alpha = 3
+
+	// how often we check whether we need more nodes
+	lookupInterval = 30 * time.Second
+
+	// how often we look up random targets when the book has enough addresses
+	refreshInterval = 30 * time.Minute
+
+	// how often the least recently seen node of a bucket is pinged
+	revalidateInterval = 10 * time.Second
+)
+
+var (
+	errTimeout         = errors.New("RPC timeout")
+	errClosed          = errors.New("discovery closed")
+	errUnsolicited     = errors.New("unsolicited reply")
+	errUnknownNode     = errors.New("unknown node")
+	errInvalidTarget   = errors.New("invalid findnode target")
+	errInvalidEndpoint = errors.New("invalid endpoint")
+	errWrongNetwork    = errors.New("wrong network")
+)
+
+// Config holds the configuration of the discovery service.
+type Config struct {
+	// Key signing our messages, the node key
+	PrivKey crypto.PrivKey
+
+	// Network we are on, the chain ID. Nodes of other networks are
+	// never bonded with
+	Network string
+
+	// UDP address to listen on, e.g. "0.0.0.0:12345"
+	ListenAddress string
+
+	// Our p2p address, nil if we don't accept connections.
+	// Its IP is advertised, unless unspecified
+	NetAddress *p2p.NetAddress
+
+	// Nodes to bootstrap the table from
+	Bootnodes []*Node
+}
+
+// Discovery is a Kademlia-like peer discovery service over UDP, run
+// alongside PEX. The p2p addresses of the nodes it finds are added to
+// the address book, which must be started and stopped by its owner.
+//
+// Nodes prove they own their endpoint by answering a ping. A node only
+// answers the findnodes of nodes which did, which keeps it from being
+// used to flood a spoofed address.
+type Discovery struct {
+	cmn.BaseService
+
+	book   pex.AddrBook
+	config *Config
+	self   *Node
+	table  *Table
+	conn   *net.UDPConn
+
+	pendingMtx sync.Mutex
+	pending    []*replyMatcher
+
+	bondMtx  sync.Mutex
+	lastPing map[p2p.ID]time.Time // when the node last pinged us
+	lastPong map[p2p.ID]time.Time // when the node last answered our ping
+
+	refreshMtx  sync.Mutex // guards lastRefresh
+	lastRefresh time.Time
+}
+
+type replyKind byte
+
+const (
+	pingKind replyKind = iota + 1
+	pongKind
+	neighborsKind
+)
+
+// replyMatcher waits for a reply of the given kind from a node.
+// callback is called with each such reply, and returns whether it was
+// the one expected, and whether it was the last one.
+type replyMatcher struct {
+	from     p2p.ID
+	kind     replyKind
+	callback func(msg DiscoveryMessage) (matched, done bool)
+	errc     chan error
+}
+
+// NewDiscovery creates a new discovery service.
+func NewDiscovery(book pex.AddrBook, config *Config) *Discovery {
+	d := &Discovery{
+		book:     book,
+		config:   config,
+		lastPing: make(map[p2p.ID]time.Time),
+		lastPong: make(map[p2p.ID]time.Time),
+	}
+	d.BaseService = *cmn.NewBaseService(nil, "Discovery", d)
+	return d
+}
+
+// OnStart implements BaseService
+func (d *Discovery) OnStart() error {
+	if err := d.BaseService.OnStart(); err != nil {
+		return err
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", d.config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+
+	laddr := conn.LocalAddr().(*net.UDPAddr)
+	ip, tcpPort := laddr.IP, uint16(0)
+	if na := d.config.NetAddress; na != nil {
+		tcpPort = na.Port
+		if na.IP != nil && !na.IP.IsUnspecified() {
+			ip = na.IP
+		}
+	}
+	d.self = NewNode(p2p.PubKeyToID(d.config.PrivKey.PubKey()), ip, uint16(laddr.Port), tcpPort)
+	d.table = newTable(d.self.ID)
+	d.Logger.Info("Discovery listening", "self", d.self)
+
+	go d.readLoop()
+	go d.loop()
+	return nil
+}
+
+// OnStop implements BaseService
+func (d *Discovery) OnStop() {
+	d.BaseService.OnStop()
+	d.conn.Close() // nolint: errcheck
+}
+
+// Self returns our own node.
+func (d *Discovery) Self() *Node {
+	return d.self
+}
+
+// Table returns the routing table.
+func (d *Discovery) Table() *Table {
+	return d.table
+}
+
+// loop bootstraps the table, then keeps it populated and fresh.
+func (d *Discovery) loop() {
+	d.refresh()
+
+	lookup := time.NewTicker(lookupInterval)
+	revalidate := time.NewTicker(revalidateInterval)
+	defer lookup.Stop()
+	defer revalidate.Stop()
+	for {
+		select {
+		case <-lookup.C:
+			d.refreshMtx.Lock()
+			due := time.Since(d.lastRefresh) >= refreshInterval
+			d.refreshMtx.Unlock()
+			if due || d.table.Len() == 0 || d.book.NeedMoreAddrs() {
+				d.refresh()
+			}
+		case <-revalidate.C:
+			d.revalidate()
+		case <-d.Quit():
+			return
+		}
+	}
+}
+
+// refresh pings the bootnodes if the table is empty, then looks up our
+// own ID, to fill the close buckets, and a random one.
+func (d *Discovery) refresh() {
+	d.refreshMtx.Lock()
+	d.lastRefresh = time.Now()
+	d.refreshMtx.Unlock()
+
+	if d.table.Len() == 0 {
+		var wg sync.WaitGroup
+		for _, n := range d.config.Bootnodes {
+			if n.ID == d.self.ID {
+				continue
+			}
+			wg.Add(1)
+			go func(n *Node) {
+				defer wg.Done()
+				if err := d.ping(n); err != nil {
+					d.Logger.Error("Failed to ping bootnode", "node", n, "err", err)
+				}
+			}(n)
+		}
+		wg.Wait()
+	}
+
+	d.Lookup(d.self.ID)
+	d.Lookup(p2p.ID(hex.EncodeToString(cmn.RandBytes(p2p.IDByteLength))))
+}
+
+// revalidate pings the least recently seen node of a random bucket, and
+// replaces it if it does not answer.
+func (d *Discovery) revalidate() {
+	n := d.table.nodeToRevalidate()
+	if n == nil {
+		return
+	}
+	if err := d.ping(n); err != nil {
+		d.Logger.Debug("Removing unresponsive node", "node", n, "err", err)
+		d.table.delete(n)
+	}
+
+	// forget the bonds that expired
+	d.bondMtx.Lock()
+	for id, t := range d.lastPing {
+		if time.Since(t) > bondExpiration {
+			delete(d.lastPing, id)
+		}
+	}
+	for id, t := range d.lastPong {
+		if time.Since(t) > bondExpiration {
+			delete(d.lastPong, id)
+		}
+	}
+	d.bondMtx.Unlock()
+}
+
+// Lookup returns the nodes closest to target, found by iteratively
+// asking the closest nodes we know for closer ones.
+func (d *Discovery) Lookup(target p2p.ID) []*Node {
+	asked := map[p2p.ID]bool{d.self.ID: true}
+	seen := map[p2p.ID]bool{d.self.ID: true}
+	result := &nodesByDistance{target: target}
+	for _, n := range d.table.closest(target, bucketSize) {
+		seen[n.ID] = true
+		result.push(n, bucketSize)
+	}
+
+	reply := make(chan []*Node, alpha)
+	pending := 0
+	for {
+		for i := 0; i < len(result.entries) && pending < alpha; i++ {
+			n := result.entries[i]
+			if asked[n.ID] {
+				continue
+			}
+			asked[n.ID] = true
+			pending++
+			go func(n *Node) {
+				nodes, err := d.findnode(n, target)
+				if err != nil {
+					d.Logger.Debug("Findnode failed", "node", n, "err", err)
+				}
+				reply <- nodes
+			}(n)
+		}
+		if pending == 0 {
+			break
+		}
+		for _, n := range <-reply {
+			if !seen[n.ID] {
+				seen[n.ID] = true
+				result.push(n, bucketSize)
+			}
+		}
+		pending--
+	}
+	return result.entries
+}
+
+//-----------------------------------------------------------------------------
+// RPCs
+
+// ping pings n and waits for its pong. The node is then added to the
+// table and its address to the book.
+func (d *Discovery) ping(n *Node) error {
+	bz, hash, err := encodePacket(d.config.PrivKey, &pingMessage{
+		Version:    DiscoveryProtocolVersion,
+		Network:    d.config.Network,
+		From:       makeEndpoint(d.conn.LocalAddr().(*net.UDPAddr), d.self.TCP),
+		To:         makeEndpoint(n.UDPAddr(), n.TCP),
+		Expiration: time.Now().Add(expiration).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	m := d.expectReply(n.ID, pongKind, func(msg DiscoveryMessage) (bool, bool) {
+		matched := string(msg.(*pongMessage).ReplyToken) == string(hash)
+		return matched, matched
+	})
+	if err := d.write(n.UDPAddr(), bz); err != nil {
+		d.cancelReply(m)
+		return err
+	}
+	if err := d.waitReply(m); err != nil {
+		return err
+	}
+
+	d.bondMtx.Lock()
+	d.lastPong[n.ID] = time.Now()
+	d.bondMtx.Unlock()
+	d.table.add(n)
+	d.addToBook(n, n)
+	return nil
+}
+
+// findnode asks n for the nodes closest to target. We make sure n knows
+// our endpoint first, or it would not answer.
+func (d *Discovery) findnode(n *Node, target p2p.ID) ([]*Node, error) {
+	if err := d.ensureBond(n); err != nil {
+		return nil, err
+	}
+
+	bz, _, err := encodePacket(d.config.PrivKey, &findNodeMessage{
+		Target:     target,
+		Expiration: time.Now().Add(expiration).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*Node
+	m := d.expectReply(n.ID, neighborsKind, func(msg DiscoveryMessage) (bool, bool) {
+		neighbors := msg.(*neighborsMessage)
+		for _, rn := range neighbors.Nodes {
+			nn, err := d.nodeFromRPC(n, rn)
+			if err != nil {
+				d.Logger.Debug("Invalid neighbor", "from", n, "node", rn, "err", err)
+				continue
+			}
+			if len(nodes) < bucketSize {
+				nodes = append(nodes, nn)
+			}
+		}
+		return true, neighbors.Last || len(nodes) >= bucketSize
+	})
+	if err := d.write(n.UDPAddr(), bz); err != nil {
+		d.cancelReply(m)
+		return nil, err
+	}
+	err = d.waitReply(m)
+	if err == errTimeout && len(nodes) > 0 {
+		err = nil // the last neighbors got lost
+	}
+
+	for _, nn := range nodes {
+		d.addToBook(nn, n)
+	}
+	return nodes, err
+}
+
+// ensureBond pings n and waits for its ping back, unless it pinged us
+// recently. Nodes that still know us from an earlier bond don't ping
+// back, so the wait is best effort.
+func (d *Discovery) ensureBond(n *Node) error {
+	d.bondMtx.Lock()
+	bonded := time.Since(d.lastPing[n.ID]) < bondExpiration
+	d.bondMtx.Unlock()
+	if bonded {
+		return nil
+	}
+
+	m := d.expectReply(n.ID, pingKind, func(DiscoveryMessage) (bool, bool) {
+		return true, true
+	})
+	if err := d.ping(n); err != nil {
+		d.cancelReply(m)
+		return err
+	}
+	d.waitReply(m) // nolint: errcheck
+	return nil
+}
+
+func (d *Discovery) expectReply(from p2p.ID, kind replyKind,
+	callback func(DiscoveryMessage) (bool, bool)) *replyMatcher {
+	m := &replyMatcher{from: from, kind: kind, callback: callback, errc: make(chan error, 1)}
+	d.pendingMtx.Lock()
+	d.pending = append(d.pending, m)
+	d.pendingMtx.Unlock()
+	return m
+}
+
+func (d *Discovery) cancelReply(m *replyMatcher) {
+	d.pendingMtx.Lock()
+	defer d.pendingMtx.Unlock()
+	for i, p := range d.pending {
+		if p == m {
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Discovery) waitReply(m *replyMatcher) error {
+	timer := time.NewTimer(respTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-m.errc:
+		return err
+	case <-timer.C:
+		d.cancelReply(m)
+		return errTimeout
+	case <-d.Quit():
+		d.cancelReply(m)
+		return errClosed
+	}
+}
+
+// handleReply passes msg to the matchers waiting for it, and returns
+// whether one of them expected it.
+func (d *Discovery) handleReply(from p2p.ID, kind replyKind, msg DiscoveryMessage) bool {
+	d.pendingMtx.Lock()
+	defer d.pendingMtx.Unlock()
+
+	matchedAny := false
+	pending := d.pending[:0]
+	for _, m := range d.pending {
+		if m.from == from && m.kind == kind {
+			matched, done := m.callback(msg)
+			matchedAny = matchedAny || matched
+			if done {
+				m.errc <- nil
+				continue
+			}
+		}
+		pending = append(pending, m)
+	}
+	d.pending = pending
+	return matchedAny
+}
+
+//-----------------------------------------------------------------------------
+// Packet handling
+
+func (d *Discovery) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if !d.IsRunning() {
+			return
+		}
+		if err != nil {
+			d.Logger.Error("Failed to read packet", "err", err)
+			continue
+		}
+		if err := d.handlePacket(from, buf[:n]); err != nil {
+			d.Logger.Debug("Bad discovery packet", "from", from, "err", err)
+		}
+	}
+}
+
+func (d *Discovery) handlePacket(from *net.UDPAddr, bz []byte) error {
+	msg, fromID, hash, err := decodePacket(bz)
+	if err != nil {
+		return err
+	}
+	if fromID == d.self.ID {
+		return nil
+	}
+
+	switch msg := msg.(type) {
+	case *pingMessage:
+		if expired(msg.Expiration) {
+			return errExpired
+		}
+		return d.handlePing(from, fromID, hash, msg)
+	case *pongMessage:
+		if expired(msg.Expiration) {
+			return errExpired
+		}
+		if !d.handleReply(fromID, pongKind, msg) {
+			return errUnsolicited
+		}
+	case *findNodeMessage:
+		if expired(msg.Expiration) {
+			return errExpired
+		}
+		return d.handleFindNode(from, fromID, msg)
+	case *neighborsMessage:
+		if expired(msg.Expiration) {
+			return errExpired
+		}
+		if !d.handleReply(fromID, neighborsKind, msg) {
+			return errUnsolicited
+		}
+	default:
+		return errUnknownMessage
+	}
+	return nil
+}
+
+// handlePing answers a ping of a node of our network, and pings the node
+// back if we don't know its endpoint yet.
+func (d *Discovery) handlePing(from *net.UDPAddr, fromID p2p.ID, hash []byte, msg *pingMessage) error {
+	if msg.Network != d.config.Network {
+		return errWrongNetwork
+	}
+	bz, _, err := encodePacket(d.config.PrivKey, &pongMessage{
+		To:         makeEndpoint(from, msg.From.TCP),
+		ReplyToken: hash,
+		Expiration: time.Now().Add(expiration).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.write(from, bz); err != nil {
+		return err
+	}
+
+	d.bondMtx.Lock()
+	d.lastPing[fromID] = time.Now()
+	bonded := time.Since(d.lastPong[fromID]) < bondExpiration
+	d.bondMtx.Unlock()
+	d.handleReply(fromID, pingKind, msg)
+
+	// the sender's IP is the one we saw, not the one it claims
+	n := NewNode(fromID, from.IP, uint16(from.Port), msg.From.TCP)
+	if bonded {
+		d.table.add(n)
+		d.addToBook(n, n)
+	} else {
+		go d.ping(n) // nolint: errcheck
+	}
+	return nil
+}
+
+// handleFindNode sends the closest nodes to the target, if the sender
+// proved its endpoint.
+func (d *Discovery) handleFindNode(from *net.UDPAddr, fromID p2p.ID, msg *findNodeMessage) error {
+	d.bondMtx.Lock()
+	bonded := time.Since(d.lastPong[fromID]) < bondExpiration
+	d.bondMtx.Unlock()
+	if !bonded {
+		return errUnknownNode
+	}
+	if !validID(msg.Target) {
+		return errInvalidTarget
+	}
+
+	closest := d.table.closest(msg.Target, bucketSize)
+	neighbors := &neighborsMessage{Nodes: []rpcNode{}}
+	for i, n := range closest {
+		neighbors.Nodes = append(neighbors.Nodes, nodeToRPC(n))
+		last := i == len(closest)-1
+		if len(neighbors.Nodes) == maxNeighbors || last {
+			neighbors.Last = last
+			neighbors.Expiration = time.Now().Add(expiration).Unix()
+			bz, _, err := encodePacket(d.config.PrivKey, neighbors)
+			if err != nil {
+				return err
+			}
+			if err := d.write(from, bz); err != nil {
+				return err
+			}
+			neighbors.Nodes = neighbors.Nodes[:0]
+		}
+	}
+	if len(closest) == 0 {
+		neighbors.Last = true
+		neighbors.Expiration = time.Now().Add(expiration).Unix()
+		bz, _, err := encodePacket(d.config.PrivKey, neighbors)
+		if err != nil {
+			return err
+		}
+		return d.write(from, bz)
+	}
+	return nil
+}
+
+// nodeFromRPC validates a node sent by sender.
+// Nodes on loopback or LAN addresses are only accepted from senders on
+// such addresses, so a public node can't make us dial our own network.
+func (d *Discovery) nodeFromRPC(sender *Node, rn rpcNode) (*Node, error) {
+	if !validID(rn.ID) || rn.UDP == 0 {
+		return nil, errInvalidEndpoint
+	}
+	if rn.IP == nil || rn.IP.IsUnspecified() || rn.IP.IsMulticast() {
+		return nil, errInvalidEndpoint
+	}
+	relayed := p2p.NewNetAddressIPPort(rn.IP, rn.UDP)
+	if !relayed.Routable() && p2p.NewNetAddressIPPort(sender.IP, sender.UDP).Routable() {
+		return nil, errInvalidEndpoint
+	}
+	return NewNode(rn.ID, rn.IP, rn.UDP, rn.TCP), nil
+}
+
+// addToBook adds the p2p address of n, learnt from src, to the book.
+func (d *Discovery) addToBook(n, src *Node) {
+	addr := n.NetAddress()
+	if addr == nil {
+		return
+	}
+	srcAddr := src.NetAddress()
+	if srcAddr == nil {
+		srcAddr = addr
+	}
+	if err := d.book.AddAddress(addr, srcAddr); err != nil {
+		d.Logger.Debug("Failed to add discovered address", "addr", addr, "err", err)
+	}
+}
+
+func (d *Discovery) write(to *net.UDPAddr, bz []byte) error {
+	_, err := d.conn.WriteToUDP(bz, to)
+	return err
+}
+
+func expired(ts int64) bool {
+	return time.Unix(ts, 0).Before(time.Now())
+}
@@ -0,0 +1,113 @@
+package discover
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+const testNetwork = "test-chain"
+
+// startDiscovery starts a discovery service on loopback, advertising
+// tcpPort, with its own book.
+func startDiscovery(t *testing.T, network string, tcpPort uint16, bootnodes ...*Node) (*Discovery, pex.AddrBook) {
+	privKey := crypto.GenPrivKeyEd25519()
+	addr, err := p2p.NewNetAddressString(fmt.Sprintf("%v@127.0.0.1:%d", p2p.PubKeyToID(privKey.PubKey()), tcpPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := pex.NewAddrBook("", false)
+	d := NewDiscovery(book, &Config{
+		PrivKey:       privKey,
+		Network:       network,
+		ListenAddress: "127.0.0.1:0",
+		NetAddress:    addr,
+		Bootnodes:     bootnodes,
+	})
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return d, book
+}
+
+// waitFor polls cond for a few seconds.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestDiscoveryLoopback(t *testing.T) {
+	const numNodes = 8
+	boot, bootBook := startDiscovery(t, testNetwork, 20000)
+	defer boot.Stop() // nolint: errcheck
+
+	nodes := []*Discovery{boot}
+	books := []pex.AddrBook{bootBook}
+	for i := 1; i < numNodes; i++ {
+		bootnode := *boot.Self()
+		d, book := startDiscovery(t, testNetwork, uint16(20000+i), &bootnode)
+		defer d.Stop() // nolint: errcheck
+		nodes, books = append(nodes, d), append(books, book)
+	}
+	bootnode := *boot.Self()
+	other, _ := startDiscovery(t, "other-chain", 21000, &bootnode)
+	defer other.Stop() // nolint: errcheck
+
+	// the bootnode bonds with everyone, and the last node finds them all
+	// through it
+	for _, d := range []*Discovery{boot, nodes[numNodes-1]} {
+		d := d
+		if !waitFor(func() bool { return d.Table().Len() == numNodes-1 }) {
+			t.Errorf("%v: %d nodes in the table, want %d", d.Self(), d.Table().Len(), numNodes-1)
+		}
+	}
+	for i, d := range nodes {
+		if d.Table().Len() == 0 {
+			t.Errorf("node %d found no nodes", i)
+		}
+		// the p2p addresses of the nodes found are in the book
+		for _, n := range d.Table().closest(d.Self().ID, bucketSize) {
+			if !books[i].HasAddress(n.NetAddress()) {
+				t.Errorf("node %d: %v is not in the book", i, n.NetAddress())
+			}
+		}
+	}
+
+	// nodes of another network are never bonded with
+	for i, d := range nodes {
+		if d.Table().get(other.Self().ID) != nil {
+			t.Errorf("node %d bonded with a node of another network", i)
+		}
+	}
+	if other.Table().Len() != 0 {
+		t.Errorf("the node of another network found %d nodes", other.Table().Len())
+	}
+}
+
+func TestDiscoveryLeavesTheBook(t *testing.T) {
+	d, book := startDiscovery(t, testNetwork, 20000)
+	if book.IsRunning() {
+		t.Error("discovery started the book")
+	}
+	d.Stop() // nolint: errcheck
+
+	d, book = startDiscovery(t, testNetwork, 20000)
+	if err := book.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer book.Stop() // nolint: errcheck
+	d.Stop()          // nolint: errcheck
+	if !book.IsRunning() {
+		t.Error("discovery stopped the book")
+	}
+}
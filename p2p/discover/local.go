@@ -0,0 +1,201 @@
+package discover
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+const (
+	// how often we announce ourselves on the local network
+	announceInterval = 10 * time.Second
+
+	// how far the timestamp of an announcement may be from our clock
+	announceMaxSkew = time.Minute
+)
+
+var (
+	errAnnounceIDMismatch = errors.New("announced ID does not match the key")
+	errAnnounceStale      = errors.New("stale announcement")
+)
+
+// LocalConfig holds the configuration of the local discovery service.
+type LocalConfig struct {
+	// Key signing our announcements, the node key
+	PrivKey crypto.PrivKey
+
+	// Multicast group and port to announce on, e.g. "239.255.70.85:12346"
+	GroupAddress string
+
+	// Returns our current node info, whose ID, network and listen
+	// addresses are announced
+	NodeInfo func() p2p.NodeInfo
+}
+
+// LocalDiscovery finds the peers of a private or dev cluster sharing our
+// network segment. It multicasts signed announcements of our ID, network
+// and listen addresses, and adds the addresses announced by the nodes of
+// the same network to the book as local addresses, which are never
+// gossiped. The book must be started and stopped by its owner.
+type LocalDiscovery struct {
+	cmn.BaseService
+
+	book   pex.AddrBook
+	config *LocalConfig
+	group  *net.UDPAddr
+	conn   *net.UDPConn // receives the announcements of the group
+	self   p2p.ID
+}
+
+// NewLocalDiscovery creates a new local discovery service.
+func NewLocalDiscovery(book pex.AddrBook, config *LocalConfig) *LocalDiscovery {
+	ld := &LocalDiscovery{
+		book:   book,
+		config: config,
+		self:   p2p.PubKeyToID(config.PrivKey.PubKey()),
+	}
+	ld.BaseService = *cmn.NewBaseService(nil, "LocalDiscovery", ld)
+	return ld
+}
+
+// OnStart implements BaseService
+func (ld *LocalDiscovery) OnStart() error {
+	if err := ld.BaseService.OnStart(); err != nil {
+		return err
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", ld.config.GroupAddress)
+	if err != nil {
+		return err
+	}
+	if !group.IP.IsMulticast() {
+		return errors.New("local discovery address is not a multicast group: " + ld.config.GroupAddress)
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	ld.group, ld.conn = group, conn
+	ld.Logger.Info("Local discovery listening", "group", group)
+
+	go ld.announceRoutine()
+	go ld.readLoop()
+	return nil
+}
+
+// OnStop implements BaseService
+func (ld *LocalDiscovery) OnStop() {
+	ld.BaseService.OnStop()
+	ld.conn.Close() // nolint: errcheck
+}
+
+func (ld *LocalDiscovery) announceRoutine() {
+	conn, err := net.DialUDP("udp4", nil, ld.group)
+	if err != nil {
+		ld.Logger.Error("Failed to dial multicast group", "group", ld.group, "err", err)
+		return
+	}
+	defer conn.Close() // nolint: errcheck
+
+	ld.announce(conn)
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ld.announce(conn)
+		case <-ld.Quit():
+			return
+		}
+	}
+}
+
+func (ld *LocalDiscovery) announce(conn *net.UDPConn) {
+	nodeInfo := ld.config.NodeInfo()
+	bz, _, err := encodePacket(ld.config.PrivKey, &announceMessage{
+		ID:          nodeInfo.ID,
+		Network:     nodeInfo.Network,
+		ListenAddr:  nodeInfo.ListenAddr,
+		ListenAddrs: nodeInfo.ListenAddrs,
+		Timestamp:   time.Now().Unix(),
+	})
+	if err != nil {
+		ld.Logger.Error("Failed to encode announcement", "err", err)
+		return
+	}
+	if _, err := conn.Write(bz); err != nil {
+		ld.Logger.Debug("Failed to announce", "err", err)
+	}
+}
+
+func (ld *LocalDiscovery) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := ld.conn.ReadFromUDP(buf)
+		if !ld.IsRunning() {
+			return
+		}
+		if err != nil {
+			ld.Logger.Error("Failed to read announcement", "err", err)
+			continue
+		}
+		if err := ld.handlePacket(from, buf[:n]); err != nil {
+			ld.Logger.Debug("Bad announcement", "from", from, "err", err)
+		}
+	}
+}
+
+func (ld *LocalDiscovery) handlePacket(from *net.UDPAddr, bz []byte) error {
+	msg, fromID, _, err := decodePacket(bz)
+	if err != nil {
+		return err
+	}
+	announce, ok := msg.(*announceMessage)
+	if !ok {
+		return errUnknownMessage
+	}
+	if announce.ID != fromID {
+		return errAnnounceIDMismatch
+	}
+	if fromID == ld.self || announce.Network != ld.config.NodeInfo().Network {
+		return nil
+	}
+	skew := time.Since(time.Unix(announce.Timestamp, 0))
+	if skew > announceMaxSkew || skew < -announceMaxSkew {
+		return errAnnounceStale
+	}
+
+	laddrs := append([]string{announce.ListenAddr}, announce.ListenAddrs...)
+	for _, laddr := range laddrs {
+		// host names would be looked up in the read loop
+		if !p2p.LiteralHost(laddr) {
+			ld.Logger.Debug("Ignoring announced host name", "from", from, "addr", laddr)
+			continue
+		}
+		addr, err := p2p.NewNetAddressString(p2p.IDAddressString(fromID, laddr))
+		if err != nil {
+			ld.Logger.Debug("Invalid announced address", "from", from, "addr", laddr, "err", err)
+			continue
+		}
+		// unix and memory addresses are of no use from another host
+		if addr.IP == nil {
+			continue
+		}
+		// the node may listen on all interfaces: reach it where we heard it
+		// instead. Any other IP is the one it signed, as the sender of a
+		// replayed announcement could put its own in place of it.
+		if addr.Scheme == "" && addr.IP.IsUnspecified() {
+			addr.IP = from.IP
+		}
+		if err := ld.book.AddLocalAddress(addr); err != nil {
+			ld.Logger.Debug("Failed to add local address", "addr", addr, "err", err)
+		}
+	}
+	return nil
+}
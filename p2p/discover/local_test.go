@@ -0,0 +1,94 @@
+package discover
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+
+	"github.com/go-fusion/p2p"
+	"github.com/go-fusion/p2p/pex"
+)
+
+func TestLocalDiscoveryHandlePacket(t *testing.T) {
+	book := pex.NewAddrBook("", false)
+	ld := NewLocalDiscovery(book, &LocalConfig{
+		PrivKey:  crypto.GenPrivKeyEd25519(),
+		NodeInfo: func() p2p.NodeInfo { return p2p.NodeInfo{Network: testNetwork} },
+	})
+
+	privKey := crypto.GenPrivKeyEd25519()
+	id := p2p.PubKeyToID(privKey.PubKey())
+	bz, _, err := encodePacket(privKey, &announceMessage{
+		ID:         id,
+		Network:    testNetwork,
+		ListenAddr: "tcp://10.0.0.5:26656",
+		ListenAddrs: []string{
+			"localhost:26657",
+			"seed.invalid:26658",
+			"unix:///tmp/fusion.sock",
+		},
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := &net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 12346}
+	if err := ld.handlePacket(from, bz); err != nil {
+		t.Fatal(err)
+	}
+
+	// host names are not looked up, and paths are of no use
+	addr, err := p2p.NewNetAddressString(p2p.IDAddressString(id, "tcp://10.0.0.5:26656"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !book.HasAddress(addr) {
+		t.Errorf("%v is not in the book", addr)
+	}
+	if book.Size() != 1 {
+		t.Errorf("got %d addresses, want 1", book.Size())
+	}
+}
+
+func TestLocalDiscoveryKeepsAnnouncedIP(t *testing.T) {
+	cases := []struct {
+		laddr, from, want string
+	}{
+		// a node listening on all interfaces is reached where it was heard
+		{"0.0.0.0:26656", "10.0.0.5", "10.0.0.5:26656"},
+		// any other IP is kept, whoever replays the announcement
+		{"8.8.8.8:26656", "10.0.0.66", "8.8.8.8:26656"},
+		{"10.0.0.7:26656", "10.0.0.66", "10.0.0.7:26656"},
+	}
+	for _, tc := range cases {
+		book := pex.NewAddrBook("", false)
+		ld := NewLocalDiscovery(book, &LocalConfig{
+			PrivKey:  crypto.GenPrivKeyEd25519(),
+			NodeInfo: func() p2p.NodeInfo { return p2p.NodeInfo{Network: testNetwork} },
+		})
+		privKey := crypto.GenPrivKeyEd25519()
+		id := p2p.PubKeyToID(privKey.PubKey())
+		bz, _, err := encodePacket(privKey, &announceMessage{
+			ID:         id,
+			Network:    testNetwork,
+			ListenAddr: tc.laddr,
+			Timestamp:  time.Now().Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ld.handlePacket(&net.UDPAddr{IP: net.ParseIP(tc.from), Port: 12346}, bz); err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := p2p.NewNetAddressString(p2p.IDAddressString(id, tc.want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := book.PickAddress(50); addr == nil || !addr.Equals(want) {
+			t.Errorf("%v heard from %v: got %v, want %v", tc.laddr, tc.from, addr, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package discover
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"time"
+
+	"github.com/go-fusion/p2p"
+)
+
+// Node is a node of the discovery network.
+type Node struct {
+	ID  p2p.ID
+	IP  net.IP
+	UDP uint16 // discovery port
+	TCP uint16 // p2p port, 0 if the node does not accept connections
+
+	addedAt time.Time // when it was added to the table
+}
+
+// NewNode returns a new Node.
+func NewNode(id p2p.ID, ip net.IP, udpPort, tcpPort uint16) *Node {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &Node{ID: id, IP: ip, UDP: udpPort, TCP: tcpPort}
+}
+
+// ParseNode parses a node of the form "ID@host:port", where port is both
+// its discovery and p2p port, like the seeds.
+func ParseNode(s string) (*Node, error) {
+	addr, err := p2p.NewNetAddressString(s)
+	if err != nil {
+		return nil, err
+	}
+	if addr.Scheme != "" || addr.IP == nil {
+		return nil, fmt.Errorf("Invalid discovery node %v: not a UDP address", s)
+	}
+	return NewNode(addr.ID, addr.IP, addr.Port, addr.Port), nil
+}
+
+// ParseNodes parses a list of nodes, returning the first error.
+func ParseNodes(ss []string) ([]*Node, error) {
+	nodes := make([]*Node, 0, len(ss))
+	for _, s := range ss {
+		if s == "" {
+			continue
+		}
+		n, err := ParseNode(s)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// UDPAddr returns the discovery address of the node.
+func (n *Node) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: n.IP, Port: int(n.UDP)}
+}
+
+// NetAddress returns the p2p address of the node,
+// nil if it does not accept connections.
+func (n *Node) NetAddress() *p2p.NetAddress {
+	if n.TCP == 0 {
+		return nil
+	}
+	addr := p2p.NewNetAddressIPPort(n.IP, n.TCP)
+	addr.ID = n.ID
+	return addr
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("%v@%v (tcp %v)", n.ID, n.UDPAddr(), n.TCP)
+}
+
+//-----------------------------------------------------------------------------
+
+// idBytes returns the bytes of a hex encoded ID, nil if it is malformed.
+func idBytes(id p2p.ID) []byte {
+	bz, err := hex.DecodeString(string(id))
+	if err != nil || len(bz) != p2p.IDByteLength {
+		return nil
+	}
+	return bz
+}
+
+// validID reports whether id is a well formed node ID.
+func validID(id p2p.ID) bool {
+	return idBytes(id) != nil
+}
+
+// logDist returns the logarithmic distance between a and b, i.e. the
+// index of the highest bit set in a XOR b, 1 to 160. 0 if a == b.
+func logDist(a, b p2p.ID) int {
+	ab, bb := idBytes(a), idBytes(b)
+	for i := range ab {
+		if x := ab[i] ^ bb[i]; x != 0 {
+			return (len(ab)-i)*8 - bits.LeadingZeros8(x)
+		}
+	}
+	return 0
+}
+
+// distCmp compares the distances of a and b to target: -1 if a is closer,
+// 1 if b is closer, 0 if they are equal.
+func distCmp(target, a, b p2p.ID) int {
+	tb, ab, bb := idBytes(target), idBytes(a), idBytes(b)
+	for i := range tb {
+		da, db := ab[i]^tb[i], bb[i]^tb[i]
+		if da > db {
+			return 1
+		} else if da < db {
+			return -1
+		}
+	}
+	return 0
+}
+
+// nodesByDistance is a list of nodes, ordered by distance to target.
+type nodesByDistance struct {
+	entries []*Node
+	target  p2p.ID
+}
+
+// push adds n to the list, keeping at most maxElems nodes.
+func (h *nodesByDistance) push(n *Node, maxElems int) {
+	i := 0
+	for ; i < len(h.entries); i++ {
+		if distCmp(h.target, h.entries[i].ID, n.ID) > 0 {
+			break
+		}
+	}
+	if i == maxElems {
+		return
+	}
+	h.entries = append(h.entries, nil)
+	copy(h.entries[i+1:], h.entries[i:])
+	h.entries[i] = n
+	if len(h.entries) > maxElems {
+		h.entries = h.entries[:maxElems]
+	}
+}
@@ -0,0 +1,165 @@
+package discover
+
+import (
+	"sync"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/p2p"
+)
+
+const (
+	// nodes per bucket, and results of a findnode
+	bucketSize = 16
+
+	// nodes kept to replace the dead nodes of a bucket
+	maxReplacements = 10
+
+	// one bucket per logarithmic distance to our ID
+	nBuckets = p2p.IDByteLength * 8
+)
+
+// Table is a Kademlia routing table of the nodes known to the discovery
+// service, bucketed by their logarithmic distance to our ID.
+// Entries are ordered from the most to the least recently seen.
+type Table struct {
+	mtx     sync.Mutex
+	self    p2p.ID
+	buckets [nBuckets]*bucket
+}
+
+type bucket struct {
+	entries      []*Node
+	replacements []*Node
+}
+
+func newTable(self p2p.ID) *Table {
+	t := &Table{self: self}
+	for i := range t.buckets {
+		t.buckets[i] = &bucket{}
+	}
+	return t
+}
+
+func (t *Table) bucket(id p2p.ID) *bucket {
+	return t.buckets[logDist(t.self, id)-1]
+}
+
+// add adds n to the front of its bucket, or updates it and moves it there
+// if it is known. When the bucket is full, n becomes a replacement and
+// add returns false.
+func (t *Table) add(n *Node) bool {
+	if n.ID == t.self {
+		return false
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b := t.bucket(n.ID)
+	if i := indexOf(b.entries, n.ID); i >= 0 {
+		n.addedAt = b.entries[i].addedAt
+		b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	} else if len(b.entries) >= bucketSize {
+		if i := indexOf(b.replacements, n.ID); i >= 0 {
+			b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+		}
+		b.replacements = append([]*Node{n}, b.replacements...)
+		if len(b.replacements) > maxReplacements {
+			b.replacements = b.replacements[:maxReplacements]
+		}
+		return false
+	} else {
+		n.addedAt = time.Now()
+		if i := indexOf(b.replacements, n.ID); i >= 0 {
+			b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+		}
+	}
+	b.entries = append([]*Node{n}, b.entries...)
+	return true
+}
+
+// delete removes n from the table, replacing it with the most recently
+// seen replacement of its bucket.
+func (t *Table) delete(n *Node) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b := t.bucket(n.ID)
+	i := indexOf(b.entries, n.ID)
+	if i < 0 {
+		return
+	}
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	if len(b.replacements) > 0 {
+		r := b.replacements[0]
+		b.replacements = b.replacements[1:]
+		r.addedAt = time.Now()
+		b.entries = append(b.entries, r)
+	}
+}
+
+// get returns the node with the given ID, nil if it is not in the table.
+func (t *Table) get(id p2p.ID) *Node {
+	if id == t.self {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b := t.bucket(id)
+	if i := indexOf(b.entries, id); i >= 0 {
+		return b.entries[i]
+	}
+	return nil
+}
+
+// closest returns the nresults nodes of the table closest to target.
+func (t *Table) closest(target p2p.ID, nresults int) []*Node {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	h := &nodesByDistance{target: target}
+	for _, b := range t.buckets {
+		for _, n := range b.entries {
+			h.push(n, nresults)
+		}
+	}
+	return h.entries
+}
+
+// nodeToRevalidate returns the least recently seen node of a random
+// non-empty bucket, nil if the table is empty.
+func (t *Table) nodeToRevalidate() *Node {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, i := range cmn.RandPerm(len(t.buckets)) {
+		b := t.buckets[i]
+		if len(b.entries) > 0 {
+			return b.entries[len(b.entries)-1]
+		}
+	}
+	return nil
+}
+
+// Len returns the number of nodes in the table.
+func (t *Table) Len() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	n := 0
+	for _, b := range t.buckets {
+		n += len(b.entries)
+	}
+	return n
+}
+
+func indexOf(nodes []*Node, id p2p.ID) int {
+	for i, n := range nodes {
+		if n.ID == id {
+			return i
+		}
+	}
+	return -1
+}
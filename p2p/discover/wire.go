@@ -0,0 +1,200 @@
+package discover
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/tendermint/go-amino"
+	"github.com/tendermint/go-crypto"
+
+	"github.com/go-fusion/p2p"
+)
+
+var cdc = amino.NewCodec()
+
+func init() {
+	RegisterDiscoveryMessage(cdc)
+	crypto.RegisterAmino(cdc)
+}
+
+const (
+	// max size of a packet. Fits in the minimum IPv6 MTU
+	maxPacketSize = 1280
+
+	// nodes per neighbors message, to stay under maxPacketSize
+	maxNeighbors = 12
+)
+
+var (
+	errPacketTooBig   = errors.New("packet too big")
+	errBadSignature   = errors.New("invalid signature")
+	errExpired        = errors.New("message expired")
+	errUnknownMessage = errors.New("unknown message type")
+)
+
+// DiscoveryMessage is a primary type for the discovery messages.
+// Underneath, it could contain a pingMessage, pongMessage,
+// findNodeMessage, neighborsMessage or announceMessage.
+type DiscoveryMessage interface{}
+
+func RegisterDiscoveryMessage(cdc *amino.Codec) {
+	cdc.RegisterInterface((*DiscoveryMessage)(nil), nil)
+	cdc.RegisterConcrete(&pingMessage{}, "tendermint/p2p/DiscoveryPingMessage", nil)
+	cdc.RegisterConcrete(&pongMessage{}, "tendermint/p2p/DiscoveryPongMessage", nil)
+	cdc.RegisterConcrete(&findNodeMessage{}, "tendermint/p2p/DiscoveryFindNodeMessage", nil)
+	cdc.RegisterConcrete(&neighborsMessage{}, "tendermint/p2p/DiscoveryNeighborsMessage", nil)
+	cdc.RegisterConcrete(&announceMessage{}, "tendermint/p2p/DiscoveryAnnounceMessage", nil)
+}
+
+// packet is what is sent over UDP: a message signed by the node key of
+// the sender, whose ID is derived from PubKey.
+type packet struct {
+	PubKey    crypto.PubKey
+	Signature crypto.Signature
+	Msg       []byte // amino encoded DiscoveryMessage
+}
+
+// encodePacket signs msg with privKey and returns the packet along with
+// the hash of the message, which pongs reply with.
+func encodePacket(privKey crypto.PrivKey, msg DiscoveryMessage) (bz []byte, hash []byte, err error) {
+	msgBytes, err := cdc.MarshalBinary(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	bz, err = cdc.MarshalBinaryBare(packet{
+		PubKey:    privKey.PubKey(),
+		Signature: privKey.Sign(msgBytes),
+		Msg:       msgBytes,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bz) > maxPacketSize {
+		return nil, nil, errPacketTooBig
+	}
+	h := sha256.Sum256(msgBytes)
+	return bz, h[:], nil
+}
+
+// decodePacket checks the signature of a packet and returns its message,
+// the ID of the sender and the hash of the message.
+func decodePacket(bz []byte) (msg DiscoveryMessage, from p2p.ID, hash []byte, err error) {
+	if len(bz) > maxPacketSize {
+		return nil, "", nil, errPacketTooBig
+	}
+	var pkt packet
+	if err := cdc.UnmarshalBinaryBare(bz, &pkt); err != nil {
+		return nil, "", nil, err
+	}
+	if pkt.PubKey == nil || pkt.Signature == nil || !pkt.PubKey.VerifyBytes(pkt.Msg, pkt.Signature) {
+		return nil, "", nil, errBadSignature
+	}
+	if err := cdc.UnmarshalBinary(pkt.Msg, &msg); err != nil {
+		return nil, "", nil, err
+	}
+	h := sha256.Sum256(pkt.Msg)
+	return msg, p2p.PubKeyToID(pkt.PubKey), h[:], nil
+}
+
+//-----------------------------------------------------------------------------
+// Messages
+
+// Endpoint is the address of a node, as seen by the sender.
+type Endpoint struct {
+	IP  net.IP
+	UDP uint16
+	TCP uint16
+}
+
+func makeEndpoint(addr *net.UDPAddr, tcpPort uint16) Endpoint {
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return Endpoint{IP: ip, UDP: uint16(addr.Port), TCP: tcpPort}
+}
+
+// rpcNode is a node sent in a neighbors message.
+type rpcNode struct {
+	ID  p2p.ID
+	IP  net.IP
+	UDP uint16
+	TCP uint16
+}
+
+func nodeToRPC(n *Node) rpcNode {
+	return rpcNode{ID: n.ID, IP: n.IP, UDP: n.UDP, TCP: n.TCP}
+}
+
+/*
+A pingMessage checks a node is alive, and proves we own our endpoint
+when it replies with a pongMessage. Nodes only answer the pings of their
+own network.
+*/
+type pingMessage struct {
+	Version    uint64
+	Network    string
+	From       Endpoint
+	To         Endpoint
+	Expiration int64 // unix time
+}
+
+func (m *pingMessage) String() string {
+	return fmt.Sprintf("[ping %v -> %v %v]", m.From, m.To, m.Network)
+}
+
+/*
+A pongMessage replies to a pingMessage.
+*/
+type pongMessage struct {
+	To         Endpoint
+	ReplyToken []byte // hash of the ping
+	Expiration int64
+}
+
+func (m *pongMessage) String() string {
+	return fmt.Sprintf("[pong %v]", m.To)
+}
+
+/*
+A findNodeMessage asks for the nodes closest to Target.
+*/
+type findNodeMessage struct {
+	Target     p2p.ID
+	Expiration int64
+}
+
+func (m *findNodeMessage) String() string {
+	return fmt.Sprintf("[findnode %v]", m.Target)
+}
+
+/*
+A neighborsMessage replies to a findNodeMessage. The reply is split over
+several messages, the last one has Last set.
+*/
+type neighborsMessage struct {
+	Nodes      []rpcNode
+	Last       bool
+	Expiration int64
+}
+
+func (m *neighborsMessage) String() string {
+	return fmt.Sprintf("[neighbors %d]", len(m.Nodes))
+}
+
+/*
+An announceMessage is multicast on the local network by LocalDiscovery.
+*/
+type announceMessage struct {
+	ID          p2p.ID
+	Network     string
+	ListenAddr  string
+	ListenAddrs []string
+	Timestamp   int64 // unix time
+}
+
+func (m *announceMessage) String() string {
+	return fmt.Sprintf("[announce %v@%v %v]", m.ID, m.ListenAddr, m.Network)
+}
@@ -3,13 +3,66 @@ package p2p
 import (
 	"errors"
 	"fmt"
+	"net"
 )
 
 var (
 	ErrSwitchDuplicatePeer = errors.New("Duplicate peer")
 	ErrSwitchConnectToSelf = errors.New("Connect to self")
+	ErrSwitchBannedPeer    = errors.New("Peer is banned")
+	ErrSwitchTooManyPeers  = errors.New("Too many peers")
 )
 
+type ErrSwitchSubnetFull struct {
+	Subnet string
+}
+
+func (e ErrSwitchSubnetFull) Error() string {
+	return fmt.Sprintf("Too many inbound peers from %s", e.Subnet)
+}
+
+type ErrSwitchLowTrustPeer struct {
+	ID    ID
+	Score int
+}
+
+func (e ErrSwitchLowTrustPeer) Error() string {
+	return fmt.Sprintf("Peer %s has a trust score of %d, too low to dial", e.ID, e.Score)
+}
+
+type ErrFilterAddrRejected struct {
+	Addr   net.Addr
+	Reason string
+}
+
+func (e ErrFilterAddrRejected) Error() string {
+	return fmt.Sprintf("Connection with %v rejected: %s", e.Addr, e.Reason)
+}
+
+type ErrFilterIDRejected struct {
+	ID     ID
+	Reason string
+}
+
+func (e ErrFilterIDRejected) Error() string {
+	return fmt.Sprintf("Connection with peer %s rejected: %s", e.ID, e.Reason)
+}
+
+// IsLocalDialError returns true if err is a reason of ours not to connect
+// to a peer, like our peer limits, bans or filters, rather than a failure
+// to reach it or to handshake with it.
+func IsLocalDialError(err error) bool {
+	switch err.(type) {
+	case ErrSwitchLowTrustPeer, ErrFilterAddrRejected, ErrFilterIDRejected, ErrTransportNotFound:
+		return true
+	}
+	switch err {
+	case ErrSwitchTooManyPeers, ErrSwitchBannedPeer, ErrSwitchDuplicatePeer, ErrSwitchConnectToSelf:
+		return true
+	}
+	return false
+}
+
 type ErrSwitchAuthenticationFailure struct {
 	Dialed *NetAddress
 	Got    ID
@@ -21,6 +74,25 @@ func (e ErrSwitchAuthenticationFailure) Error() string {
 
 //-------------------------------------------------------------------
 
+type ErrBanTargetInvalid struct {
+	Target string
+	Err    error
+}
+
+func (e ErrBanTargetInvalid) Error() string {
+	return fmt.Sprintf("Invalid ban target (%s), expected a peer ID, IP or CIDR: %v", e.Target, e.Err)
+}
+
+type ErrBanNotFound struct {
+	Target string
+}
+
+func (e ErrBanNotFound) Error() string {
+	return fmt.Sprintf("%s is not banned", e.Target)
+}
+
+//-------------------------------------------------------------------
+
 type ErrNetAddressNoID struct {
 	Addr string
 }
@@ -46,3 +118,107 @@ type ErrNetAddressLookup struct {
 func (e ErrNetAddressLookup) Error() string {
 	return fmt.Sprintf("Error looking up host (%s): %v", e.Addr, e.Err)
 }
+
+//-------------------------------------------------------------------
+
+type ErrTransportNotFound struct {
+	Scheme string
+}
+
+func (e ErrTransportNotFound) Error() string {
+	return fmt.Sprintf("No transport for scheme %q", e.Scheme)
+}
+
+type ErrNodeRecordInvalid struct {
+	ID  ID
+	Err error
+}
+
+func (e ErrNodeRecordInvalid) Error() string {
+	return fmt.Sprintf("Invalid node record of %s: %v", e.ID, e.Err)
+}
+
+type ErrNodeRecordBadSignature struct {
+	ID ID
+}
+
+func (e ErrNodeRecordBadSignature) Error() string {
+	return fmt.Sprintf("Node record of %s has a bad signature", e.ID)
+}
+
+//-------------------------------------------------------------------
+
+type ErrASMapInvalid struct {
+	Path   string
+	Reason string
+}
+
+func (e ErrASMapInvalid) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("Invalid asmap: %s", e.Reason)
+	}
+	return fmt.Sprintf("Invalid asmap %s: %s", e.Path, e.Reason)
+}
+
+//-------------------------------------------------------------------
+
+type ErrRequestTimeout struct {
+	ID ID
+}
+
+func (e ErrRequestTimeout) Error() string {
+	return fmt.Sprintf("Request to peer %s timed out", e.ID)
+}
+
+type ErrRequestTooMany struct {
+	ID  ID
+	Max int
+}
+
+func (e ErrRequestTooMany) Error() string {
+	return fmt.Sprintf("Too many pending requests to peer %s (max %d)", e.ID, e.Max)
+}
+
+type ErrRequestSendFailed struct {
+	ID ID
+}
+
+func (e ErrRequestSendFailed) Error() string {
+	return fmt.Sprintf("Failed to send request to peer %s", e.ID)
+}
+
+type ErrRequestPeerRemoved struct {
+	ID ID
+}
+
+func (e ErrRequestPeerRemoved) Error() string {
+	return fmt.Sprintf("Peer %s was removed before responding", e.ID)
+}
+
+type ErrRequestFailed struct {
+	ID     ID
+	Reason string
+}
+
+func (e ErrRequestFailed) Error() string {
+	return fmt.Sprintf("Request to peer %s failed: %s", e.ID, e.Reason)
+}
+
+type ErrResponseTooLarge struct {
+	ID   ID
+	Size int
+	Max  int
+}
+
+func (e ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("Response of peer %s is too large (%d > %d)", e.ID, e.Size, e.Max)
+}
+
+type ErrResponseUnexpected struct {
+	ID        ID
+	RequestID uint64
+}
+
+func (e ErrResponseUnexpected) Error() string {
+	return fmt.Sprintf("Unexpected response of peer %s to request %d", e.ID, e.RequestID)
+}
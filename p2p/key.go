@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	crypto "github.com/tendermint/go-crypto"
 	cmn "github.com/tendermint/tmlibs/common"
@@ -17,6 +18,19 @@ type ID string
 // TODO: support other length addresses ?
 const IDByteLength = 20
 
+// parseID checks that s is a hex-encoded crypto.Address and returns it
+// as a lower case ID.
+func parseID(s string) (ID, error) {
+	idBytes, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	if len(idBytes) != IDByteLength {
+		return "", fmt.Errorf("invalid hex length - got %d, expected %d", len(idBytes), IDByteLength)
+	}
+	return ID(strings.ToLower(s)), nil
+}
+
 //------------------------------------------------------------------------------
 // Persistent peer ID
 // TODO: encrypt on disk
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-fusion/p2p/nat"
@@ -15,8 +16,8 @@ import (
 // Listener Interface
 type Listener interface {
 	Connections() <-chan net.Conn
-	InternalAddress() *NetAddress
-	ExternalAddress() *NetAddress
+	InternalAddress() *NetAddress // nil for unix sockets
+	ExternalAddress() *NetAddress // nil for unix sockets
 	String() string
 	Stop() error
 }
@@ -27,14 +28,26 @@ type DefaultListener struct {
 
 	listener    net.Listener
 	intAddr     *NetAddress
-	extAddr     *NetAddress
 	connections chan net.Conn
+
+	mtx             sync.Mutex
+	extAddr         *NetAddress
+	onExtAddrChange func(oldAddr, newAddr *NetAddress)
+
+	natm            nat.Interface
+	natQuit         chan struct{} // closed to remove the port mapping
+	natDone         chan struct{} // closed once the port mapping is removed
+	natExternalPort int
+	natInternalPort int
 }
 
 const (
 	numBufferedConnections = 10
 	defaultExternalPort    = 8770
 	tryListenSeconds       = 5
+
+	// how often the external IP of the NAT is checked for changes
+	natCheckExtIPInterval = 5 * time.Minute
 )
 
 func splitHostPort(addr string) (host string, port int) {
@@ -49,16 +62,18 @@ func splitHostPort(addr string) (host string, port int) {
 	return host, port
 }
 
-// skipUPNP: If true, does not try getUPNPExternalAddress()
-func NewDefaultListener(protocol string, lAddr string, skipUPNP bool, logger log.Logger) Listener {
-	// Local listen IP & port
-	lAddrIP, lAddrPort := splitHostPort(lAddr)
-
+// transport: listens on lAddr. A unix listener serves local processes only,
+// it has no internal nor external address.
+// natm: If not nil, maps the listening port on the NAT and advertises its
+// external IP. See nat.Parse. It is only used when listening on all the IPv4
+// addresses, i.e. 0.0.0.0 or no IP: a listener bound to a specific IP is
+// reached on that IP.
+func NewDefaultListener(transport Transport, lAddr string, natm nat.Interface, logger log.Logger) Listener {
 	// Create listener
 	var listener net.Listener
 	var err error
 	for i := 0; i < tryListenSeconds; i++ {
-		listener, err = net.Listen(protocol, lAddr)
+		listener, err = transport.Listen(lAddr)
 		if err == nil {
 			break
 		} else if i < tryListenSeconds-1 {
@@ -68,45 +83,85 @@ func NewDefaultListener(protocol string, lAddr string, skipUPNP bool, logger log
 	if err != nil {
 		panic(err)
 	}
+
+	dl := &DefaultListener{
+		listener:    listener,
+		connections: make(chan net.Conn, numBufferedConnections),
+	}
+	dl.BaseService = *cmn.NewBaseService(logger, "DefaultListener", dl)
+
+	switch transport.Scheme() {
+	case SchemeUnix:
+		logger.Info("Local listener", "path", lAddr)
+	case SchemeMemory:
+		logger.Info("Memory listener", "name", lAddr)
+		dl.intAddr = &NetAddress{Scheme: SchemeMemory, Path: lAddr}
+		dl.extAddr = dl.intAddr
+	default:
+		dl.setupAddresses(transport.Scheme(), lAddr, natm, logger)
+	}
+
+	err = dl.Start() // Started upon construction
+	if err != nil {
+		logger.Error("Error starting base service", "err", err)
+	}
+	return dl
+}
+
+// Determines the internal and external addresses of a listener on an IP
+// and a port, i.e. TCP or WebSocket.
+func (l *DefaultListener) setupAddresses(scheme string, lAddr string, natm nat.Interface, logger log.Logger) {
+	// Local listen IP & port
+	lAddrHostPort, _ := splitPath(lAddr)
+	lAddrHost, lAddrPort := splitHostPort(lAddrHostPort)
+	lAddrIP := net.ParseIP(lAddrHost)
+	ipv6 := scheme == SchemeTCP6 || (lAddrIP != nil && lAddrIP.To4() == nil)
+
 	// Actual listener local IP & port
-	listenerIP, listenerPort := splitHostPort(listener.Addr().String())
-	logger.Info("Local listener", "ip", listenerIP, "port", listenerPort)
+	listenerIP, listenerPort := splitHostPort(l.listener.Addr().String())
+	logger.Info("Local listener", "scheme", scheme, "ip", listenerIP, "port", listenerPort)
 
 	// Determine internal address...
-	var intAddr *NetAddress
-	intAddr, err = NewNetAddressStringWithOptionalID(lAddr)
+	intAddr, err := NewNetAddressStringWithOptionalID(scheme + "://" + lAddr)
 	if err != nil {
 		panic(err)
 	}
 
+	// UPnP can't seem to get the external port, so let's just be explicit.
+	externalPort := lAddrPort
+	if externalPort == 0 {
+		externalPort = defaultExternalPort
+	}
+
+	if natm != nil && !(lAddrHost == "" || lAddrIP.Equal(net.IPv4zero)) {
+		logger.Info("Not using the NAT for a listener bound to an IP", "nat", natm, "ip", lAddrHost)
+		natm = nil
+	}
+
 	// Determine external address...
 	var extAddr *NetAddress
-	if !skipUPNP {
-		// If the lAddrIP is INADDR_ANY, try UPnP
-		if lAddrIP == "" || lAddrIP == "0.0.0.0" {
-			extAddr = getUPNPExternalAddress(lAddrPort, listenerPort, logger)
-		}
+	if natm != nil {
+		extAddr = getNATExternalAddress(natm, externalPort, logger)
+	}
+	// Otherwise use the IP we are bound to, if any...
+	if extAddr == nil && lAddrIP != nil && !lAddrIP.IsUnspecified() {
+		extAddr = NewNetAddressIPPort(lAddrIP, uint16(listenerPort))
 	}
 	// Otherwise just use the local address...
 	if extAddr == nil {
-		extAddr = getNaiveExternalAddress(listenerPort, false, logger)
+		extAddr = getNaiveExternalAddress(listenerPort, ipv6, false, logger)
 	}
 	if extAddr == nil {
 		panic("Could not determine external address!")
 	}
-
-	dl := &DefaultListener{
-		listener:    listener,
-		intAddr:     intAddr,
-		extAddr:     extAddr,
-		connections: make(chan net.Conn, numBufferedConnections),
-	}
-	dl.BaseService = *cmn.NewBaseService(logger, "DefaultListener", dl)
-	err = dl.Start() // Started upon construction
-	if err != nil {
-		logger.Error("Error starting base service", "err", err)
-	}
-	return dl
+	extAddr.Scheme = intAddr.Scheme
+	extAddr.Path = intAddr.Path
+
+	l.intAddr = intAddr
+	l.extAddr = extAddr
+	l.natm = natm
+	l.natExternalPort = externalPort
+	l.natInternalPort = listenerPort
 }
 
 func (l *DefaultListener) OnStart() error {
@@ -114,12 +169,73 @@ func (l *DefaultListener) OnStart() error {
 		return err
 	}
 	go l.listenRoutine()
+	if l.natm != nil {
+		l.natQuit = make(chan struct{})
+		l.natDone = make(chan struct{})
+		go func() {
+			defer close(l.natDone)
+			nat.Map(l.natm, l.natQuit, "tcp", l.natExternalPort, l.natInternalPort, "fusiond", l.Logger)
+		}()
+		go l.natCheckExtIPRoutine()
+	}
 	return nil
 }
 
 func (l *DefaultListener) OnStop() {
 	l.BaseService.OnStop()
 	l.listener.Close() // nolint: errcheck
+	if l.natm != nil {
+		// remove the port mapping before returning
+		close(l.natQuit)
+		<-l.natDone
+	}
+}
+
+// Periodically checks the external IP of the NAT and updates the
+// external address when it changes.
+func (l *DefaultListener) natCheckExtIPRoutine() {
+	ticker := time.NewTicker(natCheckExtIPInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			extIP, err := l.natm.ExternalIP()
+			if err != nil {
+				l.Logger.Info("Could not get NAT external address", "nat", l.natm, "err", err)
+				continue
+			}
+			l.setExternalIP(extIP)
+		case <-l.natQuit:
+			return
+		}
+	}
+}
+
+func (l *DefaultListener) setExternalIP(extIP net.IP) {
+	l.mtx.Lock()
+	if l.extAddr.IP.Equal(extIP) {
+		l.mtx.Unlock()
+		return
+	}
+	oldAddr := l.extAddr
+	extAddr := NewNetAddressIPPort(extIP, uint16(l.natExternalPort))
+	extAddr.Scheme, extAddr.Path = oldAddr.Scheme, oldAddr.Path
+	l.extAddr = extAddr
+	cb := l.onExtAddrChange
+	l.mtx.Unlock()
+
+	l.Logger.Info("External address changed", "address", extAddr)
+	if cb != nil {
+		cb(oldAddr, extAddr)
+	}
+}
+
+// OnExternalAddressChange sets a callback run when the NAT reports a new
+// external IP, to update what we advertise to peers.
+func (l *DefaultListener) OnExternalAddressChange(cb func(oldAddr, newAddr *NetAddress)) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.onExtAddrChange = cb
 }
 
 // Accept connections and pass on the channel
@@ -158,6 +274,8 @@ func (l *DefaultListener) InternalAddress() *NetAddress {
 }
 
 func (l *DefaultListener) ExternalAddress() *NetAddress {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
 	return l.extAddr
 }
 
@@ -168,39 +286,30 @@ func (l *DefaultListener) NetListener() net.Listener {
 }
 
 func (l *DefaultListener) String() string {
-	return fmt.Sprintf("Listener(@%v)", l.extAddr)
+	if extAddr := l.ExternalAddress(); extAddr != nil {
+		return fmt.Sprintf("Listener(@%v)", extAddr)
+	}
+	return fmt.Sprintf("Listener(@%v)", l.listener.Addr())
 }
 
 /* external address helpers */
 
-// UPNP external address discovery & port mapping
-func getUPNPExternalAddress(externalPort, internalPort int, logger log.Logger) *NetAddress {
-	logger.Info("Getting UPNP external address")
-	upnp := nat.UPnP()
-	var extIP, err = upnp.ExternalIP()
+// NAT external address discovery. The port mapping is done by nat.Map
+// once the listener is started.
+func getNATExternalAddress(natm nat.Interface, externalPort int, logger log.Logger) *NetAddress {
+	logger.Info("Getting NAT external address", "nat", natm)
+	extIP, err := natm.ExternalIP()
 	if err != nil {
-		logger.Info("Could not get UPNP external address", "err", err)
+		logger.Info("Could not get NAT external address", "nat", natm, "err", err)
 		return nil
 	}
 
-	// UPnP can't seem to get the external port, so let's just be explicit.
-	if externalPort == 0 {
-		externalPort = defaultExternalPort
-	}
-
-	upnp.AddMapping("tcp", externalPort, internalPort, "fusiond", 0)
-
-	if err != nil {
-		logger.Info("Could not add UPNP port mapping", "err", err)
-		return nil
-	}
-
-	logger.Info("Got UPNP external address", "address", extIP)
+	logger.Info("Got NAT external address", "address", extIP)
 	return NewNetAddressIPPort(extIP, uint16(externalPort))
 }
 
 // TODO: use syscalls: see issue #712
-func getNaiveExternalAddress(port int, settleForLocal bool, logger log.Logger) *NetAddress {
+func getNaiveExternalAddress(port int, ipv6 bool, settleForLocal bool, logger log.Logger) *NetAddress {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		panic(cmn.Fmt("Could not fetch interface addresses: %v", err))
@@ -211,14 +320,27 @@ func getNaiveExternalAddress(port int, settleForLocal bool, logger log.Logger) *
 		if !ok {
 			continue
 		}
-		v4 := ipnet.IP.To4()
-		if v4 == nil || (!settleForLocal && v4[0] == 127) {
+		if (ipnet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if !settleForLocal && ipnet.IP.IsLoopback() {
 			continue
-		} // loopback
+		}
 		return NewNetAddressIPPort(ipnet.IP, uint16(port))
 	}
 
+	if settleForLocal {
+		// no loopback interface either
+		if ipv6 {
+			return NewNetAddressIPPort(net.IPv6loopback, uint16(port))
+		}
+		return NewNetAddressIPPort(net.IPv4(127, 0, 0, 1), uint16(port))
+	}
+
 	// try again, but settle for local
 	logger.Info("Node may not be connected to internet. Settling for local address")
-	return getNaiveExternalAddress(port, true, logger)
+	return getNaiveExternalAddress(port, ipv6, true, logger)
 }
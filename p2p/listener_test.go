@@ -0,0 +1,103 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tendermint/tmlibs/log"
+)
+
+// fakeNAT is a nat.Interface recording the port mappings.
+type fakeNAT struct {
+	mtx      sync.Mutex
+	extIP    net.IP
+	mappings map[int]int // external port to internal port
+	added    chan int    // external ports, as they are mapped
+}
+
+func newFakeNAT(extIP string) *fakeNAT {
+	return &fakeNAT{
+		extIP:    net.ParseIP(extIP),
+		mappings: make(map[int]int),
+		added:    make(chan int, 10),
+	}
+}
+
+func (n *fakeNAT) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	n.mtx.Lock()
+	n.mappings[extport] = intport
+	n.mtx.Unlock()
+	n.added <- extport
+	return nil
+}
+
+func (n *fakeNAT) DeleteMapping(protocol string, extport, intport int) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	delete(n.mappings, extport)
+	return nil
+}
+
+func (n *fakeNAT) ExternalIP() (net.IP, error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.extIP, nil
+}
+
+func (n *fakeNAT) String() string { return "FakeNAT" }
+
+func (n *fakeNAT) numMappings() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return len(n.mappings)
+}
+
+func TestListenerNAT(t *testing.T) {
+	natm := newFakeNAT("203.0.113.7")
+	l := NewDefaultListener(NewTCPTransport(SchemeTCP), "0.0.0.0:0", natm, log.NewNopLogger()).(*DefaultListener)
+
+	if extAddr := l.ExternalAddress(); !extAddr.IP.Equal(natm.extIP) || extAddr.Port != defaultExternalPort {
+		t.Errorf("got external address %v, want %v:%d", extAddr, natm.extIP, defaultExternalPort)
+	}
+	select {
+	case port := <-natm.added:
+		if port != defaultExternalPort {
+			t.Errorf("mapped port %d, want %d", port, defaultExternalPort)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the port was not mapped")
+	}
+
+	// a new external IP is advertised
+	changed := make(chan *NetAddress, 1)
+	l.OnExternalAddressChange(func(oldAddr, newAddr *NetAddress) { changed <- newAddr })
+	l.setExternalIP(net.ParseIP("203.0.113.8"))
+	if newAddr := <-changed; newAddr.String() != "203.0.113.8:8770" {
+		t.Errorf("got new address %v", newAddr)
+	}
+
+	// the mapping is removed on stop
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if n := natm.numMappings(); n != 0 {
+		t.Errorf("%d mappings left after stop", n)
+	}
+}
+
+func TestListenerNATBoundIP(t *testing.T) {
+	natm := newFakeNAT("203.0.113.7")
+	l := NewDefaultListener(NewTCPTransport(SchemeTCP), "127.0.0.1:0", natm, log.NewNopLogger()).(*DefaultListener)
+	defer l.Stop() // nolint: errcheck
+
+	if extAddr := l.ExternalAddress(); !extAddr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("got external address %v, want the bound IP", extAddr)
+	}
+	select {
+	case <-natm.added:
+		t.Error("the port of a listener bound to an IP was mapped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
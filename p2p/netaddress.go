@@ -18,10 +18,15 @@ import (
 
 // NetAddress defines information about a peer on the network
 // including its ID, IP address, and port.
+// Addresses of transports other than TCP carry their scheme, and a path for
+// the unix and memory transports or the HTTP path for WebSocket.
 type NetAddress struct {
-	ID   ID     `json:"id"`
-	IP   net.IP `json:"ip"`
-	Port uint16 `json:"port"`
+	ID     ID     `json:"id"`
+	IP     net.IP `json:"ip"`
+	Port   uint16 `json:"port"`
+	Scheme string `json:"scheme,omitempty"` // empty for TCP
+	Path   string `json:"path,omitempty"`
+	Host   string `json:"host,omitempty"` // .onion host name, or DNS seed host name of a source; IP is nil
 
 	// TODO:
 	// Name string `json:"name"` // optional DNS name
@@ -30,8 +35,12 @@ type NetAddress struct {
 	str string
 }
 
-// IDAddressString returns id@hostPort.
+// IDAddressString returns id@hostPort, or scheme://id@address if hostPort
+// has a scheme.
 func IDAddressString(id ID, hostPort string) string {
+	if i := strings.Index(hostPort, "://"); i >= 0 {
+		return fmt.Sprintf("%s%s@%s", hostPort[:i+3], id, hostPort[i+3:])
+	}
 	return fmt.Sprintf("%s@%s", id, hostPort)
 }
 
@@ -72,9 +81,11 @@ func NewNetAddressString(addr string) (*NetAddress, error) {
 
 // NewNetAddressStringWithOptionalID returns a new NetAddress using the
 // provided address in the form of "ID@IP:Port", where the ID is optional.
+// The address may start with a scheme, e.g. "ws://ID@IP:Port/path" or
+// "unix://ID@/path/to/socket".
 // Also resolves the host if host is not an IP.
 func NewNetAddressStringWithOptionalID(addr string) (*NetAddress, error) {
-	addrWithoutProtocol := removeProtocolIfDefined(addr)
+	scheme, addrWithoutProtocol := splitScheme(addr)
 
 	var id ID
 	spl := strings.Split(addrWithoutProtocol, "@")
@@ -93,13 +104,34 @@ func NewNetAddressStringWithOptionalID(addr string) (*NetAddress, error) {
 		id, addrWithoutProtocol = ID(idStr), spl[1]
 	}
 
+	if pathScheme(scheme) {
+		if addrWithoutProtocol == "" {
+			return nil, ErrNetAddressInvalid{addr, fmt.Errorf("empty path")}
+		}
+		return &NetAddress{ID: id, Scheme: scheme, Path: addrWithoutProtocol}, nil
+	}
+
+	var path string
+	if scheme != "" {
+		addrWithoutProtocol, path = splitPath(addrWithoutProtocol)
+	}
+
 	host, portStr, err := net.SplitHostPort(addrWithoutProtocol)
 	if err != nil {
 		return nil, ErrNetAddressInvalid{addrWithoutProtocol, err}
 	}
 
+	// onion hosts only resolve through the Tor proxy
+	var onionHost string
+	if strings.HasSuffix(strings.ToLower(host), onionSuffix) {
+		if !isOnionHost(host) {
+			return nil, ErrNetAddressInvalid{host, fmt.Errorf("invalid onion host")}
+		}
+		onionHost = strings.ToLower(host)
+	}
+
 	ip := net.ParseIP(host)
-	if ip == nil {
+	if ip == nil && onionHost == "" {
 		if len(host) > 0 {
 			ips, err := net.LookupIP(host)
 			if err != nil {
@@ -116,9 +148,30 @@ func NewNetAddressStringWithOptionalID(addr string) (*NetAddress, error) {
 
 	na := NewNetAddressIPPort(ip, uint16(port))
 	na.ID = id
+	na.Scheme = scheme
+	na.Path = path
+	na.Host = onionHost
 	return na, nil
 }
 
+// LiteralHost returns true if the host of addr, without ID, is an IP or
+// an onion address, or if addr is a path: parsing it never looks up the
+// host.
+func LiteralHost(addr string) bool {
+	scheme, rest := splitScheme(addr)
+	if pathScheme(scheme) {
+		return true
+	}
+	if scheme != "" {
+		rest, _ = splitPath(rest)
+	}
+	host, _, err := net.SplitHostPort(rest)
+	if err != nil {
+		return false
+	}
+	return net.ParseIP(host) != nil || isOnionHost(host)
+}
+
 // NewNetAddressStrings returns an array of NetAddress'es build using
 // the provided strings.
 func NewNetAddressStrings(addrs []string) ([]*NetAddress, []error) {
@@ -153,10 +206,10 @@ func (na *NetAddress) Equals(other interface{}) bool {
 	return false
 }
 
-// Same returns true is na has the same non-empty ID or DialString as other.
+// Same returns true is na has the same non-empty ID or AddressString as other.
 func (na *NetAddress) Same(other interface{}) bool {
 	if o, ok := other.(*NetAddress); ok {
-		if na.DialString() == o.DialString() {
+		if na.AddressString() == o.AddressString() {
 			return true
 		}
 		if na.ID != "" && na.ID == o.ID {
@@ -166,10 +219,11 @@ func (na *NetAddress) Same(other interface{}) bool {
 	return false
 }
 
-// String representation: <ID>@<IP>:<PORT>
+// String representation: <ID>@<IP>:<PORT>, or <SCHEME>://<ID>@<ADDRESS>
+// for transports other than TCP.
 func (na *NetAddress) String() string {
 	if na.str == "" {
-		addrStr := na.DialString()
+		addrStr := na.AddressString()
 		if na.ID != "" {
 			addrStr = IDAddressString(na.ID, addrStr)
 		}
@@ -178,15 +232,38 @@ func (na *NetAddress) String() string {
 	return na.str
 }
 
+// DialString returns the address given to the transport: <IP>:<PORT>,
+// followed by the path if any, or only the path for the unix and memory
+// transports.
 func (na *NetAddress) DialString() string {
+	if pathScheme(na.Scheme) {
+		return na.Path
+	}
+	host := na.IP.String()
+	if na.Host != "" {
+		host = na.Host
+	}
 	return net.JoinHostPort(
-		na.IP.String(),
+		host,
 		strconv.FormatUint(uint64(na.Port), 10),
-	)
+	) + na.Path
+}
+
+// AddressString returns the DialString prefixed with the scheme, unless
+// it is TCP.
+func (na *NetAddress) AddressString() string {
+	if na.Scheme == "" {
+		return na.DialString()
+	}
+	return na.Scheme + "://" + na.DialString()
 }
 
 // Dial calls net.Dial on the address.
+// Only TCP addresses can be dialed this way, see Transport for the others.
 func (na *NetAddress) Dial() (net.Conn, error) {
+	if na.Scheme != "" {
+		return nil, ErrTransportNotFound{na.Scheme}
+	}
 	conn, err := net.Dial("tcp", na.DialString())
 	if err != nil {
 		return nil, err
@@ -195,7 +272,11 @@ func (na *NetAddress) Dial() (net.Conn, error) {
 }
 
 // DialTimeout calls net.DialTimeout on the address.
+// Only TCP addresses can be dialed this way, see Transport for the others.
 func (na *NetAddress) DialTimeout(timeout time.Duration) (net.Conn, error) {
+	if na.Scheme != "" {
+		return nil, ErrTransportNotFound{na.Scheme}
+	}
 	conn, err := net.DialTimeout("tcp", na.DialString(), timeout)
 	if err != nil {
 		return nil, err
@@ -212,16 +293,44 @@ func (na *NetAddress) Routable() bool {
 
 // For IPv4 these are either a 0 or all bits set address. For IPv6 a zero
 // address or one that matches the RFC3849 documentation address format.
+// Unix and memory addresses are valid if they have a path, onion addresses
+// if their host is well formed.
 func (na *NetAddress) Valid() bool {
+	if pathScheme(na.Scheme) {
+		return na.Path != ""
+	}
+	if na.Host != "" {
+		return na.Onion()
+	}
 	return na.IP != nil && !(na.IP.IsUnspecified() || na.RFC3849() ||
 		na.IP.Equal(net.IPv4bcast))
 }
 
 // Local returns true if it is a local address.
+// Unix and memory addresses are always local.
 func (na *NetAddress) Local() bool {
+	if pathScheme(na.Scheme) {
+		return true
+	}
+	if na.Onion() {
+		return false
+	}
 	return na.IP.IsLoopback() || zero4.Contains(na.IP)
 }
 
+// LocalConfigOnly returns true if the address is only to be dialed when it
+// comes from the local config, not from other nodes: unix and memory
+// addresses reach processes of this host, WebSocket addresses any HTTP
+// path of a host.
+func (na *NetAddress) LocalConfigOnly() bool {
+	return pathScheme(na.Scheme) || na.Scheme == SchemeWebSocket
+}
+
+// Onion returns true if it is a Tor onion service address.
+func (na *NetAddress) Onion() bool {
+	return isOnionHost(na.Host)
+}
+
 // ReachabilityTo checks whenever o can be reached from na.
 func (na *NetAddress) ReachabilityTo(o *NetAddress) int {
 	const (
@@ -231,9 +340,17 @@ func (na *NetAddress) ReachabilityTo(o *NetAddress) int {
 		Ipv6_weak
 		Ipv4
 		Ipv6_strong
+		Private
 	)
 	if !na.Routable() {
 		return Unreachable
+	} else if o.Onion() {
+		if na.Onion() {
+			return Private
+		}
+		return Default
+	} else if na.Onion() {
+		return Default
 	} else if na.RFC4380() {
 		if !o.Routable() {
 			return Default
@@ -269,6 +386,26 @@ func (na *NetAddress) ReachabilityTo(o *NetAddress) int {
 	}
 }
 
+// BestNetAddress returns the address of theirs that is the most reachable
+// from one of ours, preferring the first ones on ties.
+// It returns nil if theirs is empty.
+func BestNetAddress(ours, theirs []*NetAddress) *NetAddress {
+	var best *NetAddress
+	bestReach := -1
+	for _, addr := range theirs {
+		reach := 0
+		for _, our := range ours {
+			if r := our.ReachabilityTo(addr); r > reach {
+				reach = r
+			}
+		}
+		if reach > bestReach {
+			best, bestReach = addr, reach
+		}
+	}
+	return best
+}
+
 // RFC1918: IPv4 Private networks (10.0.0.0/8, 192.168.0.0/16, 172.16.0.0/12)
 // RFC3849: IPv6 Documentation address  (2001:0DB8::/32)
 // RFC3927: IPv4 Autoconfig (169.254.0.0/16)
@@ -308,10 +445,43 @@ func (na *NetAddress) RFC4862() bool { return rfc4862.Contains(na.IP) }
 func (na *NetAddress) RFC6052() bool { return rfc6052.Contains(na.IP) }
 func (na *NetAddress) RFC6145() bool { return rfc6145.Contains(na.IP) }
 
-func removeProtocolIfDefined(addr string) string {
-	if strings.Contains(addr, "://") {
-		return strings.Split(addr, "://")[1]
+// splitScheme splits addr into its scheme and the rest. The TCP schemes
+// are returned as the empty scheme.
+func splitScheme(addr string) (string, string) {
+	spl := strings.SplitN(addr, "://", 2)
+	if len(spl) < 2 {
+		return "", addr
+	}
+	switch spl[0] {
+	case "tcp", "tcp4", "tcp6":
+		return "", spl[1]
+	}
+	return spl[0], spl[1]
+}
+
+const onionSuffix = ".onion"
+
+// isOnionHost returns true if host is a v2 or v3 onion service host name,
+// i.e. 16 or 56 base32 characters followed by ".onion".
+func isOnionHost(host string) bool {
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, onionSuffix) {
+		return false
+	}
+	name := strings.TrimSuffix(host, onionSuffix)
+	if len(name) != 16 && len(name) != 56 {
+		return false
 	}
-	return addr
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '2' && c <= '7') {
+			return false
+		}
+	}
+	return true
+}
 
+// pathScheme returns true if addresses of the scheme are a path rather
+// than an IP and a port.
+func pathScheme(scheme string) bool {
+	return scheme == SchemeUnix || scheme == SchemeMemory
 }
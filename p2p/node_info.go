@@ -1,6 +1,7 @@
 package p2p
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 
@@ -8,9 +9,15 @@ import (
 	cmn "github.com/tendermint/tmlibs/common"
 )
 
+// OnionSupport is the NodeInfo.Other entry of the nodes that can dial
+// .onion addresses, i.e. that run behind a Tor proxy.
+const OnionSupport = "onion=yes"
+
 const (
 	maxNodeInfoSize = 10240 // 10Kb
 	maxNumChannels  = 16    // plenty of room for upgrades, for now
+	maxListenAddrs  = 8     // ListenAddr included
+	maxHashSize     = 64
 )
 
 // Max size of the NodeInfo struct
@@ -23,14 +30,23 @@ func MaxNodeInfoSize() int {
 type NodeInfo struct {
 	// Authenticate
 	// TODO: replace with NetAddress
-	ID         ID     `json:"id"`          // authenticated identifier
-	ListenAddr string `json:"listen_addr"` // accepting incoming
+	ID          ID       `json:"id"`           // authenticated identifier
+	ListenAddr  string   `json:"listen_addr"`  // accepting incoming
+	ListenAddrs []string `json:"listen_addrs"` // other addresses accepting incoming, e.g. IPv6
 
 	// Check compatibility.
 	// Channels are HexBytes so easier to read as JSON
-	Network  string       `json:"network"`  // network/chain ID
-	Version  string       `json:"version"`  // major.minor.revision
-	Channels cmn.HexBytes `json:"channels"` // channels this node knows about
+	Network     string       `json:"network"`      // network/chain ID
+	GenesisHash cmn.HexBytes `json:"genesis_hash"` // identifies the chain
+	Version     string       `json:"version"`      // major.minor.revision
+	Channels    cmn.HexBytes `json:"channels"`     // channels this node knows about
+
+	// Head of the chain when the handshake was made
+	HeadHeight uint64       `json:"head_height"`
+	HeadHash   cmn.HexBytes `json:"head_hash"`
+
+	// Protocol version of each reactor
+	Capabilities []Capability `json:"capabilities"`
 
 	// ASCIIText fields
 	Moniker string   `json:"moniker"` // arbitrary moniker
@@ -74,14 +90,43 @@ func (info NodeInfo) Validate() error {
 		channels[ch] = struct{}{}
 	}
 
-	// ensure ListenAddr is good
+	if len(info.GenesisHash) > maxHashSize {
+		return fmt.Errorf("info.GenesisHash is too long (%v). Max is %v", len(info.GenesisHash), maxHashSize)
+	}
+	if len(info.HeadHash) > maxHashSize {
+		return fmt.Errorf("info.HeadHash is too long (%v). Max is %v", len(info.HeadHash), maxHashSize)
+	}
+
+	if len(info.Capabilities) > maxNumChannels {
+		return fmt.Errorf("info.Capabilities is too long (%v). Max is %v", len(info.Capabilities), maxNumChannels)
+	}
+	capabilities := make(map[string]struct{})
+	for i, c := range info.Capabilities {
+		if !cmn.IsASCIIText(c.Name) || cmn.ASCIITrim(c.Name) == "" {
+			return fmt.Errorf("info.Capabilities[%v] must have a valid non-empty ASCII name, but got %v.", i, c.Name)
+		}
+		if _, ok := capabilities[c.Name]; ok {
+			return fmt.Errorf("info.Capabilities contains duplicate capability %v", c.Name)
+		}
+		capabilities[c.Name] = struct{}{}
+	}
+
+	// ensure ListenAddr and ListenAddrs are good
+	if len(info.ListenAddrs) >= maxListenAddrs {
+		return fmt.Errorf("info.ListenAddrs is too long (%v). Max is %v", len(info.ListenAddrs), maxListenAddrs-1)
+	}
+	for _, addr := range info.ListenAddrs {
+		if _, err := NewNetAddressString(IDAddressString(info.ID, addr)); err != nil {
+			return err
+		}
+	}
 	_, err := NewNetAddressString(IDAddressString(info.ID, info.ListenAddr))
 	return err
 }
 
 // CompatibleWith checks if two NodeInfo are compatible with eachother.
-// CONTRACT: two nodes are compatible if the major version matches and network match
-// and they have at least one channel in common.
+// CONTRACT: two nodes are compatible if the major version matches, network and
+// genesis hash match and they have at least one channel in common.
 func (info NodeInfo) CompatibleWith(other NodeInfo) error {
 	iVersion, iErr := version.FromString(info.Version)
 	oVersion, oErr := version.FromString(other.Version)
@@ -105,9 +150,9 @@ func (info NodeInfo) CompatibleWith(other NodeInfo) error {
 		return fmt.Errorf("Peer is on a different network. Got %v, expected %v", other.Network, info.Network)
 	}
 
-	// nodes must be on the same network
-	if info.Network != other.Network {
-		return fmt.Errorf("Peer is on a different network. Got %v, expected %v", other.Network, info.Network)
+	// nodes must be on the same chain
+	if len(other.GenesisHash) == 0 || !bytes.Equal(info.GenesisHash, other.GenesisHash) {
+		return fmt.Errorf("Peer is on a different chain. Got genesis %X, expected %X", other.GenesisHash, info.GenesisHash)
 	}
 
 	// if we have no channels, we're just testing
@@ -151,9 +196,62 @@ func (info NodeInfo) NetAddress() *NetAddress {
 	return netAddr
 }
 
+// Capability returns the version of the protocol with the given name
+// spoken by the node, and false if the node does not speak it.
+func (info NodeInfo) Capability(name string) (uint64, bool) {
+	for _, c := range info.Capabilities {
+		if c.Name == name {
+			return c.Version, true
+		}
+	}
+	return 0, false
+}
+
+// SupportsOnion returns true if the node can dial .onion addresses.
+func (info NodeInfo) SupportsOnion() bool {
+	for _, s := range info.Other {
+		if s == OnionSupport {
+			return true
+		}
+	}
+	return false
+}
+
+// NetAddresses returns the NetAddresses of all the self-reported listen
+// addresses, starting with the one of ListenAddr. Addresses that fail to
+// resolve are skipped.
+func (info NodeInfo) NetAddresses() []*NetAddress {
+	netAddrs := make([]*NetAddress, 0, 1+len(info.ListenAddrs))
+	if netAddr := info.NetAddress(); netAddr != nil {
+		netAddrs = append(netAddrs, netAddr)
+	}
+	for _, addr := range info.ListenAddrs {
+		netAddr, err := NewNetAddressString(IDAddressString(info.ID, addr))
+		if err != nil {
+			continue
+		}
+		netAddrs = append(netAddrs, netAddr)
+	}
+	return netAddrs
+}
+
 func (info NodeInfo) String() string {
-	return fmt.Sprintf("NodeInfo{id: %v, moniker: %v, network: %v [listen %v], version: %v (%v)}",
-		info.ID, info.Moniker, info.Network, info.ListenAddr, info.Version, info.Other)
+	return fmt.Sprintf("NodeInfo{id: %v, moniker: %v, network: %v [listen %v %v], version: %v (%v), head: %v %X, capabilities: %v}",
+		info.ID, info.Moniker, info.Network, info.ListenAddr, info.ListenAddrs, info.Version, info.Other,
+		info.HeadHeight, info.HeadHash, info.Capabilities)
+}
+
+//-----------------------------------------------------------------------------
+
+// Capability is a protocol spoken by a node, with its version.
+// NOTE: NodeInfo keeps a list as amino can't encode maps.
+type Capability struct {
+	Name    string `json:"name"`
+	Version uint64 `json:"version"`
+}
+
+func (c Capability) String() string {
+	return fmt.Sprintf("%s/%d", c.Name, c.Version)
 }
 
 func splitVersion(version string) (string, string, string, error) {
@@ -0,0 +1,22 @@
+package p2p
+
+import "testing"
+
+func TestNodeInfoCompatibleWithGenesis(t *testing.T) {
+	ours := NodeInfo{Network: "testnet", Version: "0.0.0.1", GenesisHash: []byte{1}}
+	tests := []struct {
+		genesisHash []byte
+		compatible  bool
+	}{
+		{[]byte{1}, true},
+		{[]byte{2}, false},
+		{nil, false}, // a peer must tell its chain
+	}
+	for _, tt := range tests {
+		other := ours
+		other.GenesisHash = tt.genesisHash
+		if err := ours.CompatibleWith(other); (err == nil) != tt.compatible {
+			t.Errorf("genesis %X: got %v, want compatible = %v", tt.genesisHash, err, tt.compatible)
+		}
+	}
+}
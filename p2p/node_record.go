@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+)
+
+const (
+	maxNodeRecordSize = 1024
+
+	// how far in the future a record may be signed, for clock skew
+	maxNodeRecordSkew = 10 * time.Minute
+
+	// NodeRecordMaxAge is how long a record is relayed after it was signed.
+	// Nodes sign a new one every nodeRecordRefreshInterval.
+	NodeRecordMaxAge = 7 * 24 * time.Hour
+
+	nodeRecordRefreshInterval = 24 * time.Hour
+)
+
+// NodeRecord is the list of listen addresses of a node, signed by the node
+// itself. PEX relays records rather than bare addresses, so that peers
+// can't make up addresses for other nodes. A record replaces the ones of
+// the same node with a lower Seq.
+type NodeRecord struct {
+	PubKey    crypto.PubKey
+	Addrs     []string // listen addresses without ID, the primary one first
+	Seq       uint64
+	Timestamp int64 // unix time it was signed at
+	Signature crypto.Signature
+}
+
+// the signed part of a NodeRecord
+type nodeRecordSignBytes struct {
+	PubKey    crypto.PubKey
+	Addrs     []string
+	Seq       uint64
+	Timestamp int64
+}
+
+// NewNodeRecord returns a record of addrs signed with privKey.
+func NewNodeRecord(privKey crypto.PrivKey, addrs []string, seq uint64) (*NodeRecord, error) {
+	r := &NodeRecord{
+		PubKey:    privKey.PubKey(),
+		Addrs:     addrs,
+		Seq:       seq,
+		Timestamp: time.Now().Unix(),
+	}
+	signBytes, err := r.signBytes()
+	if err != nil {
+		return nil, err
+	}
+	r.Signature = privKey.Sign(signBytes)
+	return r, nil
+}
+
+func (r *NodeRecord) signBytes() ([]byte, error) {
+	return cdc.MarshalBinaryBare(nodeRecordSignBytes{
+		PubKey:    r.PubKey,
+		Addrs:     r.Addrs,
+		Seq:       r.Seq,
+		Timestamp: r.Timestamp,
+	})
+}
+
+// ID returns the ID of the node that signed the record.
+func (r *NodeRecord) ID() ID {
+	return PubKeyToID(r.PubKey)
+}
+
+// SignedAt returns when the record was signed.
+func (r *NodeRecord) SignedAt() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+// Verify checks the record is signed by its node, well formed, and
+// neither signed in the future nor older than NodeRecordMaxAge.
+// The hosts of the addresses must be IPs or onion addresses, so checking
+// a record never needs a DNS lookup, and can't point at local paths.
+func (r *NodeRecord) Verify() error {
+	if r.PubKey == nil || r.Signature == nil {
+		return ErrNodeRecordInvalid{"", fmt.Errorf("unsigned")}
+	}
+	signBytes, err := r.signBytes()
+	if err != nil {
+		return ErrNodeRecordInvalid{r.ID(), err}
+	}
+	if !r.PubKey.VerifyBytes(signBytes, r.Signature) {
+		return ErrNodeRecordBadSignature{r.ID()}
+	}
+
+	if age := time.Since(r.SignedAt()); age < -maxNodeRecordSkew {
+		return ErrNodeRecordInvalid{r.ID(), fmt.Errorf("signed in the future (%v)", r.SignedAt())}
+	} else if age > NodeRecordMaxAge {
+		return ErrNodeRecordInvalid{r.ID(), fmt.Errorf("expired (signed %v)", r.SignedAt())}
+	}
+
+	if len(r.Addrs) == 0 || len(r.Addrs) > maxListenAddrs {
+		return ErrNodeRecordInvalid{r.ID(), fmt.Errorf("%d addresses, expected 1 to %d", len(r.Addrs), maxListenAddrs)}
+	}
+	for _, addr := range r.Addrs {
+		if !recordAddr(addr) {
+			return ErrNodeRecordInvalid{r.ID(), fmt.Errorf("address %v is not an IP or onion address", addr)}
+		}
+		if _, err := NewNetAddressString(IDAddressString(r.ID(), addr)); err != nil {
+			return ErrNodeRecordInvalid{r.ID(), err}
+		}
+	}
+	return nil
+}
+
+// NetAddresses returns the addresses of the record, the primary one first.
+// NOTE: the record must have been verified.
+func (r *NodeRecord) NetAddresses() []*NetAddress {
+	netAddrs := make([]*NetAddress, 0, len(r.Addrs))
+	for _, addr := range r.Addrs {
+		netAddr, err := NewNetAddressString(IDAddressString(r.ID(), addr))
+		if err != nil {
+			continue
+		}
+		netAddrs = append(netAddrs, netAddr)
+	}
+	return netAddrs
+}
+
+func (r *NodeRecord) String() string {
+	return fmt.Sprintf("NodeRecord{%v %v seq: %v, signed: %v}", r.ID(), r.Addrs, r.Seq, r.SignedAt())
+}
+
+// MarshalJSON encodes the record with amino, as encoding/json can't
+// decode the keys.
+func (r *NodeRecord) MarshalJSON() ([]byte, error) {
+	bz, err := cdc.MarshalBinaryBare(*r)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(bz)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *NodeRecord) UnmarshalJSON(data []byte) error {
+	var bz []byte
+	if err := json.Unmarshal(data, &bz); err != nil {
+		return err
+	}
+	if len(bz) > maxNodeRecordSize {
+		return fmt.Errorf("NodeRecord is too big (%d > %d)", len(bz), maxNodeRecordSize)
+	}
+	var rec NodeRecord
+	if err := cdc.UnmarshalBinaryBare(bz, &rec); err != nil {
+		return err
+	}
+	*r = rec
+	return nil
+}
+
+// recordAddr returns true if addr, without ID, can be in a record: its
+// host is an IP or an onion address.
+func recordAddr(addr string) bool {
+	scheme, _ := splitScheme(addr)
+	return !pathScheme(scheme) && LiteralHost(addr)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package p2p
+
+import (
+	"strings"
+	"testing"
+
+	crypto "github.com/tendermint/go-crypto"
+)
+
+func TestNodeRecordVerifyAddrs(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519()
+	tests := []struct {
+		addr  string
+		valid bool
+	}{
+		{"8.8.8.8:26656", true},
+		{"tcp://[2001:4860::8888]:26656", true},
+		{strings.Repeat("a", 56) + ".onion:26656", true},
+		{"seed.example.org:26656", false},
+		{"unix:///var/run/docker.sock", false},
+		{"memory://node", false},
+	}
+	for _, tt := range tests {
+		r, err := NewNodeRecord(privKey, []string{tt.addr}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Verify(); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid = %v", tt.addr, err, tt.valid)
+		}
+	}
+}
@@ -20,6 +20,7 @@ type Peer interface {
 	IsOutbound() bool   // did we dial the peer
 	IsPersistent() bool // do we redial this peer when we disconnect
 	NodeInfo() NodeInfo // peer's info
+	RemoteIP() net.IP   // IP of the connection, nil if unknown
 	Status() tmconn.ConnectionStatus
 
 	Send(byte, []byte) bool
@@ -108,10 +109,10 @@ func DefaultPeerConfig() *PeerConfig {
 	}
 }
 
-func newOutboundPeerConn(addr *NetAddress, config *PeerConfig, persistent bool, ourNodePrivKey crypto.PrivKey) (peerConn, error) {
+func newOutboundPeerConn(addr *NetAddress, transport Transport, config *PeerConfig, persistent bool, ourNodePrivKey crypto.PrivKey) (peerConn, error) {
 	var pc peerConn
 
-	conn, err := dial(addr, config)
+	conn, err := dial(addr, transport, config)
 	if err != nil {
 		return pc, cmn.ErrorWrap(err, "Error creating peer")
 	}
@@ -319,6 +320,11 @@ func (p *peer) Addr() net.Addr {
 	return p.peerConn.conn.RemoteAddr()
 }
 
+// RemoteIP returns the IP of peer's remote network address.
+func (p *peer) RemoteIP() net.IP {
+	return addrIP(p.Addr())
+}
+
 // CanSend returns true if the send queue is not full, false otherwise.
 func (p *peer) CanSend(chID byte) bool {
 	if !p.IsRunning() {
@@ -339,12 +345,12 @@ func (p *peer) String() string {
 //------------------------------------------------------------------
 // helper funcs
 
-func dial(addr *NetAddress, config *PeerConfig) (net.Conn, error) {
+func dial(addr *NetAddress, transport Transport, config *PeerConfig) (net.Conn, error) {
 	if config.DialFail {
 		return nil, fmt.Errorf("dial err (peerConfig.DialFail == true)")
 	}
 
-	conn, err := addr.DialTimeout(config.DialTimeout * time.Second)
+	conn, err := transport.Dial(addr, config.DialTimeout*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -7,6 +7,7 @@ package pex
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
 	"math"
 	"net"
 	"sync"
@@ -15,6 +16,7 @@ import (
 	"github.com/go-fusion/p2p"
 	crypto "github.com/tendermint/go-crypto"
 	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
 )
 
 const (
@@ -38,9 +40,34 @@ type AddrBook interface {
 	AddAddress(addr *p2p.NetAddress, src *p2p.NetAddress) error
 	RemoveAddress(*p2p.NetAddress)
 
+	// Add an address found on the local network. It may be non-routable,
+	// and is never gossiped
+	AddLocalAddress(*p2p.NetAddress) error
+
+	// Add the listen addresses a peer reported in its handshake, the
+	// primary one first. They are its own source, and the only addresses
+	// besides the ones of a record to become alternative addresses
+	AddListenAddrs([]*p2p.NetAddress) error
+
+	// Add the addresses of a verified node record, replacing the ones of
+	// an older record of the node. Records not newer than the one we
+	// have are rejected. Once a node has a record, the methods above
+	// ignore its addresses
+	AddRecord(record *p2p.NodeRecord, src *p2p.NetAddress) error
+	// Get the fresh records of the nodes of the addresses
+	Records([]*p2p.NetAddress) []*p2p.NodeRecord
+
 	// Check if the address is in the book
 	HasAddress(*p2p.NetAddress) bool
 
+	// Check if the ID or the IP of the address is banned. Banned addresses
+	// are neither picked nor gossiped
+	IsBanned(*p2p.NetAddress) bool
+
+	// Get the network group of the address, addresses of the same group
+	// are likely run by the same operator
+	GroupKey(*p2p.NetAddress) string
+
 	// Do we need more peers?
 	NeedMoreAddrs() bool
 
@@ -52,6 +79,10 @@ type AddrBook interface {
 	MarkAttempt(*p2p.NetAddress)
 	MarkBad(*p2p.NetAddress)
 
+	// Record what we learnt about the peer of the address
+	MarkConnected(addr *p2p.NetAddress, latency time.Duration, caps []p2p.Capability)
+	MarkError(addr *p2p.NetAddress, err error)
+
 	IsGood(*p2p.NetAddress) bool
 
 	// Send a selection of addresses to peers
@@ -74,14 +105,17 @@ type addrBook struct {
 	cmn.BaseService
 
 	// immutable after creation
-	filePath          string
+	filePath          string // JSON file the book is saved to, or migrated from if db is set
+	db                dbm.DB // writes each address on change, nil to use filePath
 	routabilityStrict bool
-	key               string // random prefix for bucket placement
+	key               string       // random prefix for bucket placement
+	asmap             *p2p.ASMap   // set before Start
+	banList           *p2p.BanList // set before Start, nil if nothing is banned
 
 	// accessed concurrently
 	mtx        sync.Mutex
 	rand       *cmn.Rand
-	ourAddrs   map[string]struct{}
+	ourAddrs   map[string]*p2p.NetAddress
 	addrLookup map[p2p.ID]*knownAddress // new & old
 	bucketsOld []map[string]*knownAddress
 	bucketsNew []map[string]*knownAddress
@@ -96,7 +130,7 @@ type addrBook struct {
 func NewAddrBook(filePath string, routabilityStrict bool) *addrBook {
 	am := &addrBook{
 		rand:              cmn.NewRand(),
-		ourAddrs:          make(map[string]struct{}),
+		ourAddrs:          make(map[string]*p2p.NetAddress),
 		addrLookup:        make(map[p2p.ID]*knownAddress),
 		filePath:          filePath,
 		routabilityStrict: routabilityStrict,
@@ -106,6 +140,16 @@ func NewAddrBook(filePath string, routabilityStrict bool) *addrBook {
 	return am
 }
 
+// NewDBAddrBook creates a new address book backed by db, which writes each
+// address when it changes, rather than the whole book every
+// dumpAddressInterval. The addresses of the JSON file at filePath, if
+// any, are moved to the DB when it is started for the first time.
+func NewDBAddrBook(db dbm.DB, filePath string, routabilityStrict bool) *addrBook {
+	am := NewAddrBook(filePath, routabilityStrict)
+	am.db = db
+	return am
+}
+
 // Initialize the buckets.
 // When modifying this, don't forget to update loadFromFile()
 func (a *addrBook) init() {
@@ -122,11 +166,28 @@ func (a *addrBook) init() {
 	}
 }
 
+// SetASMap makes the book group addresses by the ASN announcing them.
+// It must be called before Start. Addresses loaded from a file saved with
+// another map are placed in their buckets again.
+func (a *addrBook) SetASMap(asmap *p2p.ASMap) {
+	a.asmap = asmap
+}
+
+// SetBanList makes the book skip the addresses banned by banList, the
+// ban list of the switch. It must be called before Start.
+func (a *addrBook) SetBanList(banList *p2p.BanList) {
+	a.banList = banList
+}
+
 // OnStart implements Service.
 func (a *addrBook) OnStart() error {
 	if err := a.BaseService.OnStart(); err != nil {
 		return err
 	}
+	if a.db != nil {
+		// every change is written right away
+		return a.loadFromDB()
+	}
 	a.loadFromFile(a.filePath)
 
 	// wg.Add to ensure that any invocation of .Wait()
@@ -157,7 +218,7 @@ func (a *addrBook) AddOurAddress(addr *p2p.NetAddress) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 	a.Logger.Info("Add our address to book", "addr", addr)
-	a.ourAddrs[addr.String()] = struct{}{}
+	a.ourAddrs[addr.String()] = addr
 }
 
 // OurAddress returns true if it is our address.
@@ -175,7 +236,128 @@ func (a *addrBook) OurAddress(addr *p2p.NetAddress) bool {
 func (a *addrBook) AddAddress(addr *p2p.NetAddress, src *p2p.NetAddress) error {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
-	return a.addAddress(addr, src)
+	if addr != nil && a.hasRecord(addr.ID) {
+		return nil
+	}
+	return a.addAddress(addr, src, false)
+}
+
+// AddLocalAddress implements AddrBook
+// Adds an address announced on the local network, which is its own
+// source. It is added even if non-routable, but never gossiped.
+func (a *addrBook) AddLocalAddress(addr *p2p.NetAddress) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if addr != nil && a.hasRecord(addr.ID) {
+		return nil
+	}
+	return a.addAddress(addr, addr, true)
+}
+
+// AddListenAddrs implements AddrBook
+// The primary address is added like AddAddress does, the other ones become
+// its alternative addresses.
+func (a *addrBook) AddListenAddrs(addrs []*p2p.NetAddress) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(addrs) == 0 || addrs[0].LocalConfigOnly() {
+		return ErrAddrBookNilAddr{nil, nil}
+	}
+	if a.hasRecord(addrs[0].ID) {
+		return nil
+	}
+	src := addrs[0]
+	if err := a.addAddress(src, src, false); err != nil {
+		return err
+	}
+	ka := a.addrLookup[src.ID]
+	if ka == nil {
+		return nil
+	}
+	added := false
+	for _, addr := range addrs[1:] {
+		if addr.ID != src.ID || a.checkAltAddr(addr) != nil {
+			continue
+		}
+		if ka.addAltAddr(addr) {
+			added = true
+		}
+	}
+	if added {
+		a.changed(ka)
+	}
+	return nil
+}
+
+// AddRecord implements AddrBook
+// The primary address of the record is added like AddAddress does, the
+// other ones become its alternative addresses. If the node moved, its old
+// addresses are forgotten. Records not newer than the one we have are
+// rejected with ErrAddrBookStaleRecord.
+// NOTE: the record must have been verified
+func (a *addrBook) AddRecord(record *p2p.NodeRecord, src *p2p.NetAddress) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	addrs := record.NetAddresses()
+	if len(addrs) == 0 || addrs[0].LocalConfigOnly() {
+		return ErrAddrBookNilAddr{nil, src}
+	}
+	id := record.ID()
+	ka := a.addrLookup[id]
+	if ka != nil && ka.Record != nil && record.Seq <= ka.Record.Seq {
+		return ErrAddrBookStaleRecord{id, record.Seq, ka.Record.Seq}
+	}
+	if ka != nil && !ka.Addr.Equals(addrs[0]) {
+		a.Logger.Info("Node moved, replacing its address", "ID", id, "old", ka.Addr, "new", addrs[0])
+		a.removeFromAllBuckets(ka)
+	}
+
+	if err := a.addAddress(addrs[0], src, false); err != nil {
+		return err
+	}
+	ka = a.addrLookup[id]
+	if ka == nil {
+		return nil
+	}
+	ka.Record = record
+	ka.AltAddrs = nil
+	for _, addr := range addrs[1:] {
+		if a.checkAltAddr(addr) == nil {
+			ka.addAltAddr(addr)
+		}
+	}
+	a.changed(ka)
+	return nil
+}
+
+// Records implements AddrBook
+// It returns the records of the nodes of addrs that have one signed less
+// than p2p.NodeRecordMaxAge ago, once per node.
+func (a *addrBook) Records(addrs []*p2p.NetAddress) []*p2p.NodeRecord {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	records := make([]*p2p.NodeRecord, 0, len(addrs))
+	seen := make(map[p2p.ID]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := seen[addr.ID]; ok {
+			continue
+		}
+		seen[addr.ID] = struct{}{}
+		ka := a.addrLookup[addr.ID]
+		if ka == nil || ka.Record == nil || time.Since(ka.Record.SignedAt()) > p2p.NodeRecordMaxAge {
+			continue
+		}
+		records = append(records, ka.Record)
+	}
+	return records
+}
+
+// GroupKey implements AddrBook.
+func (a *addrBook) GroupKey(addr *p2p.NetAddress) string {
+	return a.groupKey(addr)
 }
 
 // RemoveAddress implements AddrBook - removes the address from the book.
@@ -215,8 +397,8 @@ func (a *addrBook) NeedMoreAddrs() bool {
 // The address is picked randomly from an old or new bucket according
 // to the biasTowardsNewAddrs argument, which must be between [0, 100] (or else is truncated to that range)
 // and determines how biased we are to pick an address from a new bucket.
-// PickAddress returns nil if the AddrBook is empty or if we try to pick
-// from an empty bucket.
+// PickAddress returns nil if the AddrBook is empty, if we try to pick
+// from an empty bucket or if every address is banned.
 func (a *addrBook) PickAddress(biasTowardsNewAddrs int) *p2p.NetAddress {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
@@ -240,15 +422,38 @@ func (a *addrBook) PickAddress(biasTowardsNewAddrs int) *p2p.NetAddress {
 	newCorrelation := math.Sqrt(float64(a.nNew)) * float64(biasTowardsNewAddrs)
 
 	// pick a random peer from a random bucket
-	var bucket map[string]*knownAddress
 	pickFromOldBucket := (newCorrelation+oldCorrelation)*a.rand.Float64() < oldCorrelation
 	if (pickFromOldBucket && a.nOld == 0) ||
 		(!pickFromOldBucket && a.nNew == 0) {
 		return nil
 	}
+	// banned addresses are skipped, so that the callers don't take a
+	// banned pick for an empty book
+	for i := 0; i < maxPickAttempts; i++ {
+		if ka := a.randomKnownAddress(pickFromOldBucket); !a.isBanned(ka.Addr) {
+			return a.bestAddr(ka)
+		}
+	}
+	// most of the book is banned, pick among the rest
+	var unbanned []*knownAddress
+	for _, ka := range a.addrLookup {
+		if !a.isBanned(ka.Addr) {
+			unbanned = append(unbanned, ka)
+		}
+	}
+	if len(unbanned) == 0 {
+		return nil
+	}
+	return a.bestAddr(unbanned[a.rand.Intn(len(unbanned))])
+}
+
+// randomKnownAddress returns a random address of a random non-empty old or
+// new bucket, of which there must be one.
+func (a *addrBook) randomKnownAddress(fromOldBucket bool) *knownAddress {
+	var bucket map[string]*knownAddress
 	// loop until we pick a random non-empty bucket
 	for len(bucket) == 0 {
-		if pickFromOldBucket {
+		if fromOldBucket {
 			bucket = a.bucketsOld[a.rand.Intn(len(a.bucketsOld))]
 		} else {
 			bucket = a.bucketsNew[a.rand.Intn(len(a.bucketsNew))]
@@ -258,7 +463,7 @@ func (a *addrBook) PickAddress(biasTowardsNewAddrs int) *p2p.NetAddress {
 	randIndex := a.rand.Intn(len(bucket))
 	for _, ka := range bucket {
 		if randIndex == 0 {
-			return ka.Addr
+			return ka
 		}
 		randIndex--
 	}
@@ -275,6 +480,7 @@ func (a *addrBook) MarkGood(addr *p2p.NetAddress) {
 		return
 	}
 	ka.markGood()
+	a.changed(ka)
 	if ka.isNew() {
 		a.moveToOld(ka)
 	}
@@ -289,6 +495,7 @@ func (a *addrBook) MarkAttempt(addr *p2p.NetAddress) {
 		return
 	}
 	ka.markAttempt()
+	a.changed(ka)
 }
 
 // MarkBad implements AddrBook. Currently it just ejects the address.
@@ -297,6 +504,53 @@ func (a *addrBook) MarkBad(addr *p2p.NetAddress) {
 	a.RemoveAddress(addr)
 }
 
+// MarkConnected implements AddrBook - it records the latency and the
+// capabilities of the peer we just connected to.
+func (a *addrBook) MarkConnected(addr *p2p.NetAddress, latency time.Duration, caps []p2p.Capability) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	ka := a.lookup(addr)
+	if ka == nil {
+		return
+	}
+	ka.Latency = latency
+	ka.Capabilities = caps
+	a.changed(ka)
+}
+
+// MarkError implements AddrBook - it records why we failed to dial the
+// peer or dropped it.
+func (a *addrBook) MarkError(addr *p2p.NetAddress, err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	ka := a.lookup(addr)
+	if ka == nil || err == nil {
+		return
+	}
+	ka.LastError = err.Error()
+	a.changed(ka)
+}
+
+// IsBanned implements AddrBook.
+func (a *addrBook) IsBanned(addr *p2p.NetAddress) bool {
+	return a.isBanned(addr)
+}
+
+// isBanned returns true if the ban list bans the ID or the IP of addr.
+// The ban list is the one source of truth of the bans, so that lifting a
+// ban, or banning an IP range, applies to the book right away.
+func (a *addrBook) isBanned(addr *p2p.NetAddress) bool {
+	return a.banList != nil && a.banList.IsBannedAddr(addr)
+}
+
+// lookup returns the known address of the peer of addr, nil if addr is.
+func (a *addrBook) lookup(addr *p2p.NetAddress) *knownAddress {
+	if addr == nil {
+		return nil
+	}
+	return a.addrLookup[addr.ID]
+}
+
 // GetSelection implements AddrBook.
 // It randomly selects some addresses (old & new). Suitable for peer-exchange protocols.
 // Must never return a nil address.
@@ -319,12 +573,14 @@ func (a *addrBook) GetSelection() []*p2p.NetAddress {
 
 	// XXX: instead of making a list of all addresses, shuffling, and slicing a random chunk,
 	// could we just select a random numAddresses of indexes?
-	allAddr := make([]*p2p.NetAddress, bookSize)
-	i := 0
+	allAddr := make([]*p2p.NetAddress, 0, bookSize)
 	for _, ka := range a.addrLookup {
-		allAddr[i] = ka.Addr
-		i++
+		if ka.Local || a.isBanned(ka.Addr) || ka.Addr.LocalConfigOnly() {
+			continue
+		}
+		allAddr = append(allAddr, ka.Addr)
 	}
+	numAddresses = cmn.MinInt(numAddresses, len(allAddr))
 
 	// Fisher-Yates shuffle the array. We only need to do the first
 	// `numAddresses' since we are throwing the rest.
@@ -335,7 +591,7 @@ func (a *addrBook) GetSelection() []*p2p.NetAddress {
 	}
 
 	// slice off the limit we are willing to share.
-	return allAddr[:numAddresses]
+	return a.withAltAddrs(allAddr[:numAddresses])
 }
 
 // GetSelectionWithBias implements AddrBook.
@@ -434,7 +690,7 @@ ADDRS_LOOP:
 		selectionIndex++
 	}
 
-	return selection
+	return a.withAltAddrs(a.withoutUnshared(selection))
 }
 
 // ListOfKnownAddresses returns the new and old addresses.
@@ -466,6 +722,10 @@ func (a *addrBook) size() int {
 
 // Save persists the address book to disk.
 func (a *addrBook) Save() {
+	if a.db != nil {
+		// written on every change
+		return
+	}
 	a.saveToFile(a.filePath) // thread safe
 }
 
@@ -533,6 +793,7 @@ func (a *addrBook) addToNewBucket(ka *knownAddress, bucketIdx int) {
 
 	// Add it to addrLookup
 	a.addrLookup[ka.ID()] = ka
+	a.changed(ka)
 }
 
 // Adds ka to old bucket. Returns false if it couldn't do it cuz buckets full.
@@ -568,6 +829,7 @@ func (a *addrBook) addToOldBucket(ka *knownAddress, bucketIdx int) bool {
 
 	// Ensure in addrLookup
 	a.addrLookup[ka.ID()] = ka
+	a.changed(ka)
 
 	return true
 }
@@ -587,6 +849,7 @@ func (a *addrBook) removeFromBucket(ka *knownAddress, bucketType byte, bucketIdx
 		}
 		delete(a.addrLookup, ka.ID())
 	}
+	a.changed(ka)
 }
 
 func (a *addrBook) removeFromAllBuckets(ka *knownAddress) {
@@ -601,6 +864,7 @@ func (a *addrBook) removeFromAllBuckets(ka *knownAddress) {
 		a.nOld--
 	}
 	delete(a.addrLookup, ka.ID())
+	a.changed(ka)
 }
 
 //----------------------------------------------------------
@@ -618,12 +882,12 @@ func (a *addrBook) pickOldest(bucketType byte, bucketIdx int) *knownAddress {
 
 // adds the address to a "new" bucket. if its already in one,
 // it only adds it probabilistically
-func (a *addrBook) addAddress(addr, src *p2p.NetAddress) error {
+func (a *addrBook) addAddress(addr, src *p2p.NetAddress, local bool) error {
 	if addr == nil || src == nil {
 		return ErrAddrBookNilAddr{addr, src}
 	}
 
-	if a.routabilityStrict && !addr.Routable() {
+	if a.routabilityStrict && !addr.Routable() && !local {
 		return ErrAddrBookNonRoutable{addr}
 	}
 	// TODO: we should track ourAddrs by ID and by IP:PORT and refuse both.
@@ -632,6 +896,17 @@ func (a *addrBook) addAddress(addr, src *p2p.NetAddress) error {
 	}
 
 	ka := a.addrLookup[addr.ID]
+	if ka != nil && !ka.Addr.Equals(addr) {
+		// Another address of a known peer. Anyone can gossip it, so it is
+		// only taken from the peer itself, see AddListenAddrs and AddRecord.
+		return nil
+	}
+	if ka != nil && local {
+		// Heard on the local network, keep it to ourselves.
+		ka.Local = true
+		a.changed(ka)
+		return nil
+	}
 	if ka != nil {
 		// If its already old and the addr is the same, ignore it.
 		if ka.isOld() && ka.Addr.Equals(addr) {
@@ -648,6 +923,7 @@ func (a *addrBook) addAddress(addr, src *p2p.NetAddress) error {
 		}
 	} else {
 		ka = newKnownAddress(addr, src)
+		ka.Local = local
 	}
 
 	bucket := a.calcNewBucket(addr, src)
@@ -655,6 +931,78 @@ func (a *addrBook) addAddress(addr, src *p2p.NetAddress) error {
 	return nil
 }
 
+// hasRecord returns true if we have a record of the node. Its addresses
+// are then only changed by a newer record, see AddRecord.
+func (a *addrBook) hasRecord(id p2p.ID) bool {
+	ka := a.addrLookup[id]
+	return ka != nil && ka.Record != nil
+}
+
+// checkAltAddr returns an error if addr can't be an alternative address,
+// for the same reasons addAddress refuses an address, or because the peer
+// can't tell us to dial an address of the local config only.
+func (a *addrBook) checkAltAddr(addr *p2p.NetAddress) error {
+	if addr.LocalConfigOnly() || (a.routabilityStrict && !addr.Routable()) {
+		return ErrAddrBookNonRoutable{addr}
+	}
+	if _, ok := a.ourAddrs[addr.String()]; ok {
+		return ErrAddrBookSelf{addr}
+	}
+	return nil
+}
+
+// bestAddr returns the address of ka that is the most reachable from ours.
+func (a *addrBook) bestAddr(ka *knownAddress) *p2p.NetAddress {
+	if len(ka.AltAddrs) == 0 {
+		return ka.Addr
+	}
+	ours := make([]*p2p.NetAddress, 0, len(a.ourAddrs))
+	for _, addr := range a.ourAddrs {
+		ours = append(ours, addr)
+	}
+	return p2p.BestNetAddress(ours, ka.addrs())
+}
+
+// withAltAddrs appends the alternative addresses of the selected peers
+// to the selection, so they get gossiped too, up to maxGetSelection
+// addresses in total.
+func (a *addrBook) withAltAddrs(selection []*p2p.NetAddress) []*p2p.NetAddress {
+	numSelected := len(selection)
+	for _, addr := range selection[:numSelected] {
+		ka := a.addrLookup[addr.ID]
+		if ka == nil {
+			continue
+		}
+		for _, altAddr := range ka.AltAddrs {
+			if len(selection) >= maxGetSelection {
+				return selection
+			}
+			// may have been heard on the local network
+			if !altAddr.Routable() || altAddr.LocalConfigOnly() {
+				continue
+			}
+			selection = append(selection, altAddr)
+		}
+	}
+	return selection
+}
+
+// withoutUnshared drops the local, local config only and banned addresses
+// from the selection.
+func (a *addrBook) withoutUnshared(selection []*p2p.NetAddress) []*p2p.NetAddress {
+	filtered := selection[:0]
+	for _, addr := range selection {
+		if addr.LocalConfigOnly() {
+			continue
+		}
+		if ka := a.addrLookup[addr.ID]; (ka != nil && ka.Local) || a.isBanned(addr) {
+			continue
+		}
+		filtered = append(filtered, addr)
+	}
+	return filtered
+}
+
 // Make space in the new buckets by expiring the really bad entries.
 // If no bad entries are available we remove the oldest.
 func (a *addrBook) expireNew(bucketIdx int) {
@@ -754,10 +1102,15 @@ func (a *addrBook) calcOldBucket(addr *p2p.NetAddress) int {
 }
 
 // Return a string representing the network group of this address.
-// This is the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
+// This is the ASN announcing it if we have an asmap that knows it, else
+// the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
 // "local" for a local address and the string "unroutable" for an unroutable
 // address.
 func (a *addrBook) groupKey(na *p2p.NetAddress) string {
+	if na.IP == nil && na.Host != "" && !na.Onion() {
+		// a DNS seed, the source of the addresses it lists
+		return "dns:" + na.Host
+	}
 	if a.routabilityStrict && na.Local() {
 		return "local"
 	}
@@ -765,18 +1118,29 @@ func (a *addrBook) groupKey(na *p2p.NetAddress) string {
 		return "unroutable"
 	}
 
+	if na.Onion() {
+		// onion addrs have no IP, group them by the first character
+		return "onion:" + na.Host[:1]
+	}
+
+	if a.asmap != nil && na.IP != nil {
+		if asn := a.asmap.ASN(na.IP); asn != 0 {
+			return fmt.Sprintf("AS%d", asn)
+		}
+	}
+
 	if ipv4 := na.IP.To4(); ipv4 != nil {
-		return (&net.IPNet{IP: na.IP, Mask: net.CIDRMask(16, 32)}).String()
+		return ipPrefix(na.IP, 16, 32)
 	}
 	if na.RFC6145() || na.RFC6052() {
 		// last four bytes are the ip address
 		ip := net.IP(na.IP[12:16])
-		return (&net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}).String()
+		return ipPrefix(ip, 16, 32)
 	}
 
 	if na.RFC3964() {
-		ip := net.IP(na.IP[2:7])
-		return (&net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}).String()
+		ip := net.IP(na.IP[2:6])
+		return ipPrefix(ip, 16, 32)
 
 	}
 	if na.RFC4380() {
@@ -786,7 +1150,7 @@ func (a *addrBook) groupKey(na *p2p.NetAddress) string {
 		for i, byte := range na.IP[12:16] {
 			ip[i] = byte ^ 0xff
 		}
-		return (&net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}).String()
+		return ipPrefix(ip, 16, 32)
 	}
 
 	// OK, so now we know ourselves to be a IPv6 address.
@@ -799,7 +1163,13 @@ func (a *addrBook) groupKey(na *p2p.NetAddress) string {
 		bits = 36
 	}
 
-	return (&net.IPNet{IP: na.IP, Mask: net.CIDRMask(bits, 128)}).String()
+	return ipPrefix(na.IP, bits, 128)
+}
+
+// ipPrefix returns the CIDR of the network of ones bits around ip.
+func ipPrefix(ip net.IP, ones, bits int) string {
+	mask := net.CIDRMask(ones, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
 }
 
 // doubleSha256 calculates sha256(sha256(b)) and returns the resulting bytes.
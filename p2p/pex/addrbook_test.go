@@ -0,0 +1,173 @@
+package pex
+
+import (
+	"encoding/hex"
+	"testing"
+
+	crypto "github.com/tendermint/go-crypto"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/p2p"
+)
+
+const testID = "0123456789abcdef0123456789abcdef01234567"
+
+func mustNetAddress(t *testing.T, addr string) *p2p.NetAddress {
+	netAddr, err := p2p.NewNetAddressString(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return netAddr
+}
+
+func TestAddrBookAltAddrs(t *testing.T) {
+	book := NewAddrBook("", true)
+	addr := mustNetAddress(t, testID+"@8.8.8.8:12345")
+	alt := mustNetAddress(t, testID+"@9.9.9.9:12345")
+	src := mustNetAddress(t, "89abcdef0123456789abcdef0123456789abcdef@1.1.1.1:12345")
+
+	if err := book.AddAddress(addr, src); err != nil {
+		t.Fatal(err)
+	}
+	// another address of the peer gossiped by anyone is ignored
+	if err := book.AddAddress(alt, src); err != nil {
+		t.Fatal(err)
+	}
+	if ka := book.addrLookup[addr.ID]; len(ka.AltAddrs) != 0 || !ka.Addr.Equals(addr) {
+		t.Fatalf("gossiped address was recorded: %v, %v", ka.Addr, ka.AltAddrs)
+	}
+
+	// the listen addresses of the handshake are taken, non-routable ones
+	// and the ones of other peers left out
+	private := mustNetAddress(t, testID+"@10.0.0.1:12345")
+	other := mustNetAddress(t, "89abcdef0123456789abcdef0123456789abcdef@9.9.9.9:12345")
+	if err := book.AddListenAddrs([]*p2p.NetAddress{addr, alt, private, other}); err != nil {
+		t.Fatal(err)
+	}
+	ka := book.addrLookup[addr.ID]
+	if len(ka.AltAddrs) != 1 || !ka.AltAddrs[0].Equals(alt) {
+		t.Fatalf("got alternative addresses %v, want [%v]", ka.AltAddrs, alt)
+	}
+	if book.HasAddress(other) {
+		t.Error("an address of another peer was added")
+	}
+}
+
+func TestAddrBookRecordOwnsAddrs(t *testing.T) {
+	book := NewAddrBook("", true)
+	privKey := crypto.GenPrivKeyEd25519()
+	id := p2p.PubKeyToID(privKey.PubKey())
+	src := mustNetAddress(t, "89abcdef0123456789abcdef0123456789abcdef@1.1.1.1:12345")
+
+	record, err := p2p.NewNodeRecord(privKey, []string{"8.8.8.8:12345"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.AddRecord(record, src); err != nil {
+		t.Fatal(err)
+	}
+	addr := record.NetAddresses()[0]
+
+	// nothing but a record changes the addresses of the node
+	alt := mustNetAddress(t, string(id)+"@9.9.9.9:12345")
+	if err := book.AddAddress(alt, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.AddListenAddrs([]*p2p.NetAddress{addr, alt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.AddLocalAddress(addr); err != nil {
+		t.Fatal(err)
+	}
+	ka := book.addrLookup[id]
+	if !ka.Addr.Equals(addr) || len(ka.AltAddrs) != 0 || ka.Local {
+		t.Fatalf("got %v, alternatives %v, local %v", ka.Addr, ka.AltAddrs, ka.Local)
+	}
+
+	// a newer record does
+	record, err = p2p.NewNodeRecord(privKey, []string{"9.9.9.9:12345", "7.7.7.7:12345"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.AddRecord(record, src); err != nil {
+		t.Fatal(err)
+	}
+	ka = book.addrLookup[id]
+	if !ka.Addr.Equals(alt) || len(ka.AltAddrs) != 1 {
+		t.Errorf("got %v, alternatives %v", ka.Addr, ka.AltAddrs)
+	}
+}
+
+func TestAddrBookFollowsBanList(t *testing.T) {
+	book := NewAddrBook("", true)
+	banList := p2p.NewBanList(dbm.NewMemDB())
+	book.SetBanList(banList)
+	addr := mustNetAddress(t, testID+"@8.8.8.8:12345")
+	if err := book.AddAddress(addr, addr); err != nil {
+		t.Fatal(err)
+	}
+	banned := func() bool {
+		infos, err := NewAddrBookAdmin(book).List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		excluded := len(book.GetSelection()) == 0 && len(book.withoutUnshared([]*p2p.NetAddress{addr})) == 0
+		if infos[0].Banned != excluded || book.IsBanned(addr) != excluded {
+			t.Fatalf("the book disagrees with itself on the ban of %v", addr)
+		}
+		return excluded
+	}
+
+	// bans of the ID, and of the IP range, apply to the book
+	for _, target := range []string{testID, "8.8.0.0/16"} {
+		if err := banList.Ban(target, 0, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if !banned() {
+			t.Fatalf("expected the address banned by %v", target)
+		}
+		// and so does lifting them
+		if err := banList.Unban(target); err != nil {
+			t.Fatal(err)
+		}
+		if banned() {
+			t.Fatalf("expected the address unbanned with %v", target)
+		}
+	}
+}
+
+func TestAddrBookGroupKeyASMap(t *testing.T) {
+	// the map of TestASMapLookup in p2p
+	bz, err := hex.DecodeString("fb03ec0fb03fc0fe00fb03ec0fb03fc0fe00fb03ec0fb0fffffeff45040030def703480d00307e40ea7d15c078")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmap, err := p2p.NewASMap(bz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := NewAddrBook("", true)
+	plain := NewAddrBook("", true)
+	book.SetASMap(asmap)
+
+	cases := []struct {
+		addr, group, plain string
+	}{
+		{"1.2.3.4:12345", "AS100", "1.2.0.0/16"},
+		{"234.1.2.3:12345", "AS400", "234.1.0.0/16"},
+		// IPs the map does not know fall back to their prefix
+		{"64.1.2.3:12345", "64.1.0.0/16", "64.1.0.0/16"},
+		{"[2600:1::1]:12345", "2600:1::/32", "2600:1::/32"},
+		// 6to4 addresses are grouped by the IPv4 address they embed
+		{"[2002:102:304::1]:12345", "1.2.0.0/16", "1.2.0.0/16"},
+	}
+	for _, tc := range cases {
+		na := mustNetAddress(t, testID+"@"+tc.addr)
+		if group := book.groupKey(na); group != tc.group {
+			t.Errorf("%v: got group %q, want %q", tc.addr, group, tc.group)
+		}
+		if group := plain.groupKey(na); group != tc.plain {
+			t.Errorf("%v: got group %q without an asmap, want %q", tc.addr, group, tc.plain)
+		}
+	}
+}
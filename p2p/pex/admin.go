@@ -0,0 +1,132 @@
+package pex
+
+import (
+	"time"
+
+	"github.com/go-fusion/p2p"
+)
+
+/* Operator commands */
+
+// AddressInfo describes an address of the book for operators. It is what
+// the addrbook command and the admin RPC list, export and import.
+type AddressInfo struct {
+	Addr        string    `json:"addr"`
+	Src         string    `json:"src"`
+	Bucket      string    `json:"bucket"` // "new" or "old"
+	Local       bool      `json:"local,omitempty"`
+	Attempts    int32     `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+
+	Latency      time.Duration    `json:"latency,omitempty"`
+	Capabilities []p2p.Capability `json:"capabilities,omitempty"`
+	Banned       bool             `json:"banned,omitempty"`
+	LastError    string           `json:"last_error,omitempty"`
+}
+
+func newAddressInfo(ka *knownAddress, banned bool) AddressInfo {
+	info := AddressInfo{
+		Addr:        ka.Addr.String(),
+		Bucket:      "new",
+		Local:       ka.Local,
+		Attempts:    ka.Attempts,
+		LastAttempt: ka.LastAttempt,
+		LastSuccess: ka.LastSuccess,
+
+		Latency:      ka.Latency,
+		Capabilities: ka.Capabilities,
+		Banned:       banned,
+		LastError:    ka.LastError,
+	}
+	if ka.Src != nil {
+		info.Src = ka.Src.String()
+	}
+	if ka.isOld() {
+		info.Bucket = "old"
+	}
+	return info
+}
+
+// AddrBookAdmin runs the operator commands on an address book.
+// The methods return errors like the ones of node.AdminClient, which runs
+// them on the book of a running node.
+type AddrBookAdmin struct {
+	book AddrBook
+}
+
+// NewAddrBookAdmin returns the operator commands of book.
+func NewAddrBookAdmin(book AddrBook) *AddrBookAdmin {
+	return &AddrBookAdmin{book: book}
+}
+
+// List returns all the addresses of the book.
+func (ad *AddrBookAdmin) List() ([]AddressInfo, error) {
+	kas := ad.book.ListOfKnownAddresses()
+	infos := make([]AddressInfo, len(kas))
+	for i, ka := range kas {
+		infos[i] = newAddressInfo(ka, ad.book.IsBanned(ka.Addr))
+	}
+	return infos, nil
+}
+
+// Add adds addr, heard from src. addr is its own source if src is empty.
+func (ad *AddrBookAdmin) Add(addr, src string) error {
+	netAddr, err := p2p.NewNetAddressString(addr)
+	if err != nil {
+		return err
+	}
+	srcAddr := netAddr
+	if src != "" {
+		if srcAddr, err = p2p.NewNetAddressString(src); err != nil {
+			return err
+		}
+	}
+	return ad.book.AddAddress(netAddr, srcAddr)
+}
+
+// Remove removes the address of the peer of addr.
+func (ad *AddrBookAdmin) Remove(addr string) error {
+	netAddr, err := p2p.NewNetAddressString(addr)
+	if err != nil {
+		return err
+	}
+	ad.book.RemoveAddress(netAddr)
+	return nil
+}
+
+// MarkBad marks the address of the peer of addr as bad.
+func (ad *AddrBookAdmin) MarkBad(addr string) error {
+	netAddr, err := p2p.NewNetAddressString(addr)
+	if err != nil {
+		return err
+	}
+	ad.book.MarkBad(netAddr)
+	return nil
+}
+
+// Prune removes the addresses that look bad: new addresses that failed
+// too often or that we haven't tried in a long time. It returns how many
+// were removed.
+func (ad *AddrBookAdmin) Prune() (int, error) {
+	n := 0
+	for _, ka := range ad.book.ListOfKnownAddresses() {
+		if ka.isBad() {
+			ad.book.RemoveAddress(ka.Addr)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Import adds the addresses of an export, and returns how many were
+// added. The other fields of the infos are ignored.
+func (ad *AddrBookAdmin) Import(infos []AddressInfo) (int, error) {
+	n := 0
+	for _, info := range infos {
+		if err := ad.Add(info.Addr, info.Src); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
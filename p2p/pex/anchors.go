@@ -0,0 +1,61 @@
+package pex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/p2p"
+)
+
+/* Anchors */
+
+// Anchors are a few of our outbound peers, saved on shutdown and dialed
+// first on startup. An attacker filling our address book while we are
+// down can't eclipse us, as long as one of them is honest and still up.
+
+// max anchors saved on shutdown
+const maxAnchors = 2
+
+func saveAnchors(filePath string, addrs []*p2p.NetAddress) error {
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = addr.String()
+	}
+	jsonBytes, err := json.MarshalIndent(strs, "", "\t")
+	if err != nil {
+		return err
+	}
+	return cmn.WriteFileAtomic(filePath, jsonBytes, 0644)
+}
+
+// loadAnchors reads the anchors and deletes the file, so that anchors
+// making us crash aren't dialed again on the next start.
+// Returns nil if the file does not exist, and the valid anchors along with
+// the first error if some are invalid.
+func loadAnchors(filePath string) ([]*p2p.NetAddress, error) {
+	jsonBytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	if err := json.Unmarshal(jsonBytes, &strs); err != nil {
+		return nil, err
+	}
+	if len(strs) > maxAnchors {
+		strs = strs[:maxAnchors]
+	}
+	netAddrs, errs := p2p.NewNetAddressStrings(strs)
+	if len(errs) > 0 {
+		return netAddrs, errs[0]
+	}
+	return netAddrs, nil
+}
@@ -0,0 +1,98 @@
+package pex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-fusion/p2p"
+)
+
+func TestAnchorsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anchors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "anchors.json")
+
+	if anchors, err := loadAnchors(filePath); anchors != nil || err != nil {
+		t.Fatalf("expected no anchors without a file, got %v, %v", anchors, err)
+	}
+
+	addrs := testAddrs(t, maxAnchors+1)
+	if err := saveAnchors(filePath, addrs); err != nil {
+		t.Fatal(err)
+	}
+	anchors, err := loadAnchors(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != maxAnchors {
+		t.Fatalf("expected %d anchors, got %d", maxAnchors, len(anchors))
+	}
+	for i, addr := range anchors {
+		if !addr.Equals(addrs[i]) {
+			t.Errorf("anchor %d: got %v, want %v", i, addr, addrs[i])
+		}
+	}
+	// the file is read once
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatal("expected the file deleted once loaded")
+	}
+
+	if err := ioutil.WriteFile(filePath, []byte(`["`+addrs[0].String()+`", "nonsense"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if anchors, err := loadAnchors(filePath); err == nil || len(anchors) != 1 || !anchors[0].Equals(addrs[0]) {
+		t.Fatalf("expected the valid anchor and an error, got %v, %v", anchors, err)
+	}
+}
+
+func TestPEXReactorAnchors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anchors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, _ := newTestReactor()
+	r.config.AnchorsFile = filepath.Join(dir, "anchors.json")
+	addrs := []*p2p.NetAddress{
+		mustNetAddress(t, "00000000000000000000000000000000000000a1@1.1.0.1:12345"),
+		mustNetAddress(t, "00000000000000000000000000000000000000a2@1.1.0.2:12345"),
+		mustNetAddress(t, "00000000000000000000000000000000000000a3@2.2.0.1:12345"),
+		mustNetAddress(t, "00000000000000000000000000000000000000a4@3.3.0.1:12345"),
+	}
+	// from the longest connected
+	since := time.Now().Add(-time.Hour)
+	for i, addr := range addrs {
+		r.outbound.Set(string(addr.ID), outboundPeer{addr, since.Add(time.Duration(i) * time.Minute)})
+	}
+	r.saveAnchors()
+
+	// the longest connected peers, one per network group
+	r, _ = newTestReactor()
+	r.config.AnchorsFile = filepath.Join(dir, "anchors.json")
+	r.loadAnchors()
+	want := []*p2p.NetAddress{addrs[0], addrs[2]}
+	if len(r.anchors) != len(want) {
+		t.Fatalf("expected %d anchors, got %v", len(want), r.anchors)
+	}
+	for i, addr := range want {
+		if !r.anchors[i].Equals(addr) {
+			t.Errorf("anchor %d: got %v, want %v", i, r.anchors[i], addr)
+		}
+		if _, ok := r.anchorIDs[addr.ID]; !ok {
+			t.Errorf("expected %v among the anchor IDs", addr.ID)
+		}
+	}
+
+	// nothing to save without outbound peers
+	r.saveAnchors()
+	if _, err := os.Stat(r.config.AnchorsFile); !os.IsNotExist(err) {
+		t.Fatal("expected no anchors saved without outbound peers")
+	}
+}
@@ -0,0 +1,202 @@
+package pex
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/p2p"
+)
+
+const (
+	// crawled nodes neither seen nor tried for this long are dropped
+	crawlReportMaxAge = numMissingDays * 24 * time.Hour
+
+	// nodes that connected to us are only added while the report holds
+	// fewer nodes than the book can. Dialed nodes come from the book
+	crawlReportMaxNodes = newBucketCount*newBucketSize + oldBucketCount*oldBucketSize
+)
+
+// CrawledNode is what a seed knows about a node of the network.
+type CrawledNode struct {
+	ID          p2p.ID    `json:"id"`
+	Addr        string    `json:"addr"`      // address we last dialed, or the listen address of an inbound node
+	Reachable   bool      `json:"reachable"` // whether the last dial succeeded
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"` // last time we dialed it
+	LastSeen    time.Time `json:"last_seen"`    // last time we were connected to it
+
+	// From the handshake of the last connection
+	Network      string           `json:"network,omitempty"`
+	Version      string           `json:"version,omitempty"`
+	Moniker      string           `json:"moniker,omitempty"`
+	Channels     cmn.HexBytes     `json:"channels,omitempty"`
+	Capabilities []p2p.Capability `json:"capabilities,omitempty"`
+	HeadHeight   uint64           `json:"head_height"`
+}
+
+// CrawlReportSummary aggregates a report, to follow the health of the
+// network and version rollouts.
+type CrawlReportSummary struct {
+	Nodes     int            `json:"nodes"`
+	Reachable int            `json:"reachable"`
+	Versions  map[string]int `json:"versions"` // nodes seen per version
+	MaxHeight uint64         `json:"max_height"`
+}
+
+type crawlReportJSON struct {
+	Time    time.Time          `json:"time"`
+	Summary CrawlReportSummary `json:"summary"`
+	Nodes   []CrawledNode      `json:"nodes"`
+}
+
+// CrawlReport records the nodes a seed crawls. It serves them as JSON
+// over HTTP.
+type CrawlReport struct {
+	mtx   sync.Mutex
+	nodes map[p2p.ID]*CrawledNode
+}
+
+// NewCrawlReport returns an empty report.
+func NewCrawlReport() *CrawlReport {
+	return &CrawlReport{nodes: make(map[p2p.ID]*CrawledNode)}
+}
+
+// node returns the entry of id, created if needed.
+// NOTE: must be called with c.mtx held.
+func (c *CrawlReport) node(id p2p.ID) *CrawledNode {
+	n := c.nodes[id]
+	if n == nil {
+		n = &CrawledNode{ID: id}
+		c.nodes[id] = n
+	}
+	return n
+}
+
+// markDialed records the outcome of a dial of addr.
+func (c *CrawlReport) markDialed(addr *p2p.NetAddress, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	n := c.node(addr.ID)
+	n.Addr = addr.String()
+	n.LastAttempt = time.Now()
+	n.Reachable = err == nil
+	if err != nil {
+		n.LastError = err.Error()
+	} else {
+		n.LastError = ""
+	}
+}
+
+// markSeen records the info of a connected peer. An unknown peer is left
+// out if the report is full.
+func (c *CrawlReport) markSeen(peer Peer) {
+	info := peer.NodeInfo()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.nodes[peer.ID()]; !ok && len(c.nodes) >= crawlReportMaxNodes {
+		return
+	}
+	n := c.node(peer.ID())
+	if n.Addr == "" {
+		if addr := info.NetAddress(); addr != nil {
+			n.Addr = addr.String()
+		}
+	}
+	n.LastSeen = time.Now()
+	n.Network = info.Network
+	n.Version = info.Version
+	n.Moniker = info.Moniker
+	n.Channels = info.Channels
+	n.Capabilities = info.Capabilities
+	n.HeadHeight = info.HeadHeight
+}
+
+// prune drops the nodes neither seen nor tried for crawlReportMaxAge.
+func (c *CrawlReport) prune() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for id, n := range c.nodes {
+		if time.Since(n.LastSeen) > crawlReportMaxAge && time.Since(n.LastAttempt) > crawlReportMaxAge {
+			delete(c.nodes, id)
+		}
+	}
+}
+
+// Nodes returns the crawled nodes, sorted by ID.
+func (c *CrawlReport) Nodes() []CrawledNode {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	nodes := make([]CrawledNode, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, *n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// Summary aggregates the crawled nodes.
+func (c *CrawlReport) Summary() CrawlReportSummary {
+	return summarize(c.Nodes())
+}
+
+func summarize(nodes []CrawledNode) CrawlReportSummary {
+	s := CrawlReportSummary{
+		Nodes:    len(nodes),
+		Versions: make(map[string]int),
+	}
+	for _, n := range nodes {
+		if n.Reachable {
+			s.Reachable++
+		}
+		if n.Version != "" {
+			s.Versions[n.Version]++
+		}
+		if n.HeadHeight > s.MaxHeight {
+			s.MaxHeight = n.HeadHeight
+		}
+	}
+	return s
+}
+
+// MarshalJSON implements json.Marshaler. The report holds the summary
+// and the nodes.
+func (c *CrawlReport) MarshalJSON() ([]byte, error) {
+	nodes := c.Nodes()
+	return json.Marshal(crawlReportJSON{
+		Time:    time.Now(),
+		Summary: summarize(nodes),
+		Nodes:   nodes,
+	})
+}
+
+// Save writes the report to filePath.
+func (c *CrawlReport) Save(filePath string) error {
+	jsonBytes, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return cmn.WriteFileAtomic(filePath, jsonBytes, 0644)
+}
+
+// ServeHTTP implements http.Handler by serving the report as JSON.
+func (c *CrawlReport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET the report", http.StatusMethodNotAllowed)
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes) // nolint: errcheck
+}
@@ -0,0 +1,67 @@
+package pex
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	cfg "github.com/go-fusion/config"
+	"github.com/go-fusion/p2p"
+)
+
+func TestCrawlReportMaxNodes(t *testing.T) {
+	c := NewCrawlReport()
+	peer := func(i int) *mockPeer {
+		return newMockPeer(p2p.NodeInfo{ID: p2p.ID(fmt.Sprintf("%040x", i)), ListenAddr: "8.8.8.8:12345", Version: "0.0.0.1"}, false)
+	}
+	for i := 0; i < crawlReportMaxNodes; i++ {
+		c.markSeen(peer(i))
+	}
+
+	// the report is full of nodes that connected to us
+	c.markSeen(peer(crawlReportMaxNodes))
+	if len(c.nodes) != crawlReportMaxNodes {
+		t.Fatalf("got %d nodes, want %d", len(c.nodes), crawlReportMaxNodes)
+	}
+	// known ones are still updated
+	p := peer(0)
+	p.nodeInfo.Version = "0.0.0.2"
+	c.markSeen(p)
+	if n := c.nodes[p.ID()]; n.Version != "0.0.0.2" {
+		t.Errorf("got version %v, want 0.0.0.2", n.Version)
+	}
+	// and dialed nodes recorded
+	addr := mustNetAddress(t, testID+"@8.8.8.8:12345")
+	c.markDialed(addr, nil)
+	if n := c.nodes[addr.ID]; n == nil || !n.Reachable {
+		t.Errorf("dialed node not recorded: %v", n)
+	}
+}
+
+func TestPEXReactorCrawlLocalError(t *testing.T) {
+	for _, maxOutbound := range []int{0, 1} {
+		config := cfg.DefaultP2PConfig()
+		config.MaxOutbound = maxOutbound
+		r, book := newTestReactorWithConfig(config)
+		addr := mustNetAddress(t, testID+"@127.0.0.1:1")
+		if err := book.AddAddress(addr, addr); err != nil {
+			t.Fatal(err)
+		}
+		// due for a crawl
+		book.addrLookup[addr.ID].LastAttempt = time.Time{}
+		r.crawlPeers()
+
+		nodes := r.CrawlReport().Nodes()
+		if maxOutbound == 0 {
+			// our outbound slots are full, the node is not unreachable
+			if len(nodes) != 0 {
+				t.Errorf("full slots: got %v in the report", nodes)
+			}
+			continue
+		}
+		// nothing listens on the port
+		if len(nodes) != 1 || nodes[0].Reachable || nodes[0].LastError == "" {
+			t.Errorf("closed port: got %v in the report", nodes)
+		}
+	}
+}
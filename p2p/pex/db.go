@@ -0,0 +1,104 @@
+package pex
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/go-fusion/p2p"
+)
+
+/* Loading & Saving to a DB */
+
+// A DB backed book stores its key, the checksum of its asmap and each of
+// its addresses under their own key, as JSON.
+var (
+	addrBookKeyKey     = []byte("addrbook/key")
+	addrBookASMapKey   = []byte("addrbook/asmap")
+	addrBookAddrPrefix = "addrbook/addr/"
+)
+
+func addrBookAddrKey(id p2p.ID) []byte {
+	return []byte(addrBookAddrPrefix + string(id))
+}
+
+// changed writes ka to the DB, or deletes it if it left the book.
+// File backed books are saved every dumpAddressInterval instead.
+// NOTE: must be called with a.mtx held, or before the book is started.
+func (a *addrBook) changed(ka *knownAddress) {
+	if a.db == nil {
+		return
+	}
+	id := ka.ID()
+	switch a.addrLookup[id] {
+	case nil:
+		a.db.Delete(addrBookAddrKey(id))
+	case ka:
+		bz, err := json.Marshal(ka)
+		if err != nil {
+			a.Logger.Error("Failed to encode address", "addr", ka.Addr, "err", err)
+			return
+		}
+		a.db.Set(addrBookAddrKey(id), bz)
+	default:
+		// replaced by another address of the peer, written already
+	}
+}
+
+// loadFromDB loads the book, migrating the JSON file to the DB on the
+// first start.
+func (a *addrBook) loadFromDB() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	key := a.db.Get(addrBookKeyKey)
+	if key == nil {
+		a.migrateFromFile()
+		return nil
+	}
+
+	var addrs []*knownAddress
+	iter := a.db.Iterator([]byte(addrBookAddrPrefix), prefixEnd(addrBookAddrPrefix))
+	for ; iter.Valid(); iter.Next() {
+		ka := new(knownAddress)
+		if err := json.Unmarshal(iter.Value(), ka); err != nil {
+			iter.Close()
+			return ErrAddrBookCorruptDB{string(iter.Key()), err}
+		}
+		addrs = append(addrs, ka)
+	}
+	iter.Close()
+
+	asmapChecksum := string(a.db.Get(addrBookASMapKey))
+	a.restore(string(key), asmapChecksum, addrs)
+	a.db.Set(addrBookASMapKey, []byte(a.asmapChecksum()))
+	a.Logger.Info("Loaded AddrBook from DB", "size", a.size())
+	return nil
+}
+
+// migrateFromFile moves the addresses of the JSON file to the DB, and
+// renames the file once done.
+func (a *addrBook) migrateFromFile() {
+	migrated := a.filePath != "" && a.loadFromFile(a.filePath)
+	if migrated {
+		a.Logger.Info("Migrating AddrBook from file to DB", "file", a.filePath, "size", a.size())
+		for _, ka := range a.addrLookup {
+			a.changed(ka)
+		}
+	}
+	a.db.Set(addrBookASMapKey, []byte(a.asmapChecksum()))
+	// written last: a migration interrupted before is done again
+	a.db.SetSync(addrBookKeyKey, []byte(a.key))
+
+	if migrated {
+		if err := os.Rename(a.filePath, a.filePath+".migrated"); err != nil {
+			a.Logger.Error("Failed to rename migrated AddrBook", "file", a.filePath, "err", err)
+		}
+	}
+}
+
+// prefixEnd returns the end of the range of the keys starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return end
+}
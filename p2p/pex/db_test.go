@@ -0,0 +1,184 @@
+package pex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/p2p"
+)
+
+// testAddrs returns n routable addresses of distinct peers.
+func testAddrs(t *testing.T, n int) []*p2p.NetAddress {
+	addrs := make([]*p2p.NetAddress, n)
+	for i := range addrs {
+		addrs[i] = mustNetAddress(t, fmt.Sprintf("%040x@8.8.%d.%d:12345", i+1, i/256, i%256))
+	}
+	return addrs
+}
+
+func startDBAddrBook(t *testing.T, db dbm.DB, filePath string) *addrBook {
+	book := NewDBAddrBook(db, filePath, true)
+	if err := book.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// storedAddr returns the address of id written to db, or nil.
+func storedAddr(t *testing.T, db dbm.DB, id p2p.ID) *knownAddress {
+	bz := db.Get(addrBookAddrKey(id))
+	if bz == nil {
+		return nil
+	}
+	ka := new(knownAddress)
+	if err := json.Unmarshal(bz, ka); err != nil {
+		t.Fatal(err)
+	}
+	return ka
+}
+
+func TestDBAddrBookWritesChanges(t *testing.T) {
+	db := dbm.NewMemDB()
+	book := startDBAddrBook(t, db, "")
+	addrs := testAddrs(t, 3)
+	for _, addr := range addrs {
+		if err := book.AddAddress(addr, addrs[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each change is written as it happens
+	for _, addr := range addrs {
+		if storedAddr(t, db, addr.ID) == nil {
+			t.Fatalf("expected %v written on add", addr)
+		}
+	}
+	book.MarkGood(addrs[0])
+	if ka := storedAddr(t, db, addrs[0].ID); ka == nil || !ka.isOld() || ka.LastSuccess.IsZero() {
+		t.Fatalf("expected %v written as good, got %+v", addrs[0], ka)
+	}
+	book.RemoveAddress(addrs[1])
+	if storedAddr(t, db, addrs[1].ID) != nil {
+		t.Fatalf("expected %v deleted on removal", addrs[1])
+	}
+	key := book.key
+	book.Stop()
+
+	// and loaded on the next start, with the key of the buckets
+	book = startDBAddrBook(t, db, "")
+	defer book.Stop()
+	if book.key != key || book.Size() != 2 {
+		t.Fatalf("expected the 2 addresses under the same key, got %d", book.Size())
+	}
+	if !book.IsGood(addrs[0]) || book.HasAddress(addrs[1]) || !book.HasAddress(addrs[2]) {
+		t.Fatal("expected the book as it was before the restart")
+	}
+}
+
+func TestDBAddrBookCorrupt(t *testing.T) {
+	db := dbm.NewMemDB()
+	startDBAddrBook(t, db, "").Stop()
+	db.Set(addrBookAddrKey("broken"), []byte("{"))
+
+	book := NewDBAddrBook(db, "", true)
+	if err := book.Start(); err == nil {
+		t.Fatal("expected an error for a corrupt address")
+	} else if _, ok := err.(ErrAddrBookCorruptDB); !ok {
+		t.Fatalf("expected ErrAddrBookCorruptDB, got %v", err)
+	}
+}
+
+func TestDBAddrBookMigratesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addrbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "addrbook.json")
+
+	fileBook := NewAddrBook(filePath, true)
+	addrs := testAddrs(t, 10)
+	for _, addr := range addrs {
+		if err := fileBook.AddAddress(addr, addrs[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileBook.MarkGood(addrs[0])
+	fileBook.saveToFile(filePath)
+
+	checkMigrated := func(db dbm.DB) {
+		book := startDBAddrBook(t, db, filePath)
+		defer book.Stop()
+		if book.key != fileBook.key || book.Size() != len(addrs) || !book.IsGood(addrs[0]) {
+			t.Fatalf("expected the %d addresses of the file, got %d", len(addrs), book.Size())
+		}
+		for _, addr := range addrs {
+			if storedAddr(t, db, addr.ID) == nil {
+				t.Fatalf("expected %v moved to the DB", addr)
+			}
+		}
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Fatal("expected the file renamed once migrated")
+		}
+		if _, err := os.Stat(filePath + ".migrated"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := dbm.NewMemDB()
+	checkMigrated(db)
+
+	// the next start reads the DB only
+	book := startDBAddrBook(t, db, filePath)
+	if book.Size() != len(addrs) {
+		t.Fatalf("expected the migrated addresses, got %d", book.Size())
+	}
+	book.Stop()
+
+	// a migration that crashed before the key was written is done again,
+	// over the addresses it wrote already
+	if err := os.Rename(filePath+".migrated", filePath); err != nil {
+		t.Fatal(err)
+	}
+	db.Delete(addrBookKeyKey)
+	for _, addr := range addrs[5:] {
+		db.Delete(addrBookAddrKey(addr.ID))
+	}
+	checkMigrated(db)
+}
+
+func TestAddrBookPickSkipsBanned(t *testing.T) {
+	book := NewAddrBook("", true)
+	banList := p2p.NewBanList(dbm.NewMemDB())
+	book.SetBanList(banList)
+	addrs := testAddrs(t, 100)
+	for _, addr := range addrs {
+		if err := book.AddAddress(addr, addrs[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowed := addrs[len(addrs)-1]
+	for _, addr := range addrs[:len(addrs)-1] {
+		if err := banList.Ban(string(addr.ID), 0, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a banned pick is not taken for an empty book
+	for i := 0; i < 100; i++ {
+		if addr := book.PickAddress(50); addr == nil || !addr.Equals(allowed) {
+			t.Fatalf("expected the only address not banned, got %v", addr)
+		}
+	}
+	if err := banList.Ban(string(allowed.ID), 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if addr := book.PickAddress(50); addr != nil {
+		t.Fatalf("expected no address once all are banned, got %v", addr)
+	}
+}
@@ -0,0 +1,197 @@
+package pex
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+
+	"github.com/go-fusion/p2p"
+)
+
+const (
+	// TXT records of DNS seeds look like
+	// "fusion-seed pub=<base64> sig=<base64> ts=<unix time> addrs=ID@host:port,ID@host:port"
+	// where pub is the seeder's node key and sig its signature of the
+	// timestamp and the addrs for the seed host name. Other TXT records are
+	// ignored.
+	dnsSeedRecordPrefix = "fusion-seed"
+
+	// records signed longer ago are rejected, so old records can't be
+	// replayed. Seeders sign their records again every
+	// dnsSeederRefreshPeriod, and resolvers cache them for dnsSeederTTL
+	dnsSeedRecordMaxAge = time.Hour
+
+	// records signed this much in the future are accepted, for clock skew
+	dnsSeedRecordMaxSkew = 10 * time.Minute
+
+	// how often DNS seeds are resolved again when the book has enough addresses
+	dnsSeedResolveInterval = 30 * time.Minute
+
+	// how long resolving a DNS seed may take
+	dnsSeedResolveTimeout = 10 * time.Second
+)
+
+// DNSSeed is a host name whose TXT records list peer addresses, signed by
+// the seeder node with the given ID.
+// The host names of the listed addresses are resolved through their
+// A/AAAA records.
+type DNSSeed struct {
+	ID   p2p.ID
+	Host string // lower case, without trailing dot
+}
+
+// ParseDNSSeed parses a DNS seed of the form "ID@hostname".
+func ParseDNSSeed(s string) (DNSSeed, error) {
+	spl := strings.Split(s, "@")
+	if len(spl) != 2 {
+		return DNSSeed{}, ErrDNSSeedInvalid{s, fmt.Errorf("missing ID")}
+	}
+	idBytes, err := hex.DecodeString(spl[0])
+	if err != nil {
+		return DNSSeed{}, ErrDNSSeedInvalid{s, err}
+	}
+	if len(idBytes) != p2p.IDByteLength {
+		return DNSSeed{}, ErrDNSSeedInvalid{s,
+			fmt.Errorf("invalid hex length - got %d, expected %d", len(idBytes), p2p.IDByteLength)}
+	}
+	host := strings.TrimSuffix(strings.ToLower(spl[1]), ".")
+	if host == "" {
+		return DNSSeed{}, ErrDNSSeedInvalid{s, fmt.Errorf("missing hostname")}
+	}
+	return DNSSeed{p2p.ID(strings.ToLower(spl[0])), host}, nil
+}
+
+func (seed DNSSeed) String() string {
+	return p2p.IDAddressString(seed.ID, seed.Host)
+}
+
+// NetAddress returns the address standing for the seed as the source of the
+// addresses it lists, so they are grouped by seed in the book.
+func (seed DNSSeed) NetAddress() *p2p.NetAddress {
+	return &p2p.NetAddress{ID: seed.ID, Host: seed.Host}
+}
+
+// SignDNSSeedRecord returns a TXT record for the DNS seed host listing
+// addrs, signed at timestamp with the seeder's node key.
+func SignDNSSeedRecord(host string, addrs []*p2p.NetAddress, timestamp time.Time, privKey crypto.PrivKey) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	addrStrs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addrStrs[i] = addr.String()
+	}
+	addrList := strings.Join(addrStrs, ",")
+
+	pubKeyBytes, err := cdc.MarshalBinaryBare(privKey.PubKey())
+	if err != nil {
+		return "", err
+	}
+	ts := timestamp.Unix()
+	sigBytes, err := cdc.MarshalBinaryBare(privKey.Sign(dnsSeedSignBytes(host, ts, addrList)))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s pub=%s sig=%s ts=%d addrs=%s", dnsSeedRecordPrefix,
+		base64.StdEncoding.EncodeToString(pubKeyBytes),
+		base64.StdEncoding.EncodeToString(sigBytes),
+		ts, addrList), nil
+}
+
+// parseDNSSeedRecord returns the addresses listed by a TXT record of the
+// seed, once checked they were signed by the seed's node key less than
+// dnsSeedRecordMaxAge before now.
+func parseDNSSeedRecord(seed DNSSeed, txt string, now time.Time) ([]string, error) {
+	fields := strings.Fields(txt)
+	if len(fields) == 0 || fields[0] != dnsSeedRecordPrefix {
+		return nil, ErrDNSSeedRecordInvalid{seed, "not a seed record"}
+	}
+	values := make(map[string]string)
+	for _, field := range fields[1:] {
+		spl := strings.SplitN(field, "=", 2)
+		if len(spl) != 2 {
+			return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("malformed field %q", field)}
+		}
+		values[spl[0]] = spl[1]
+	}
+
+	pubKeyBytes, err := base64.StdEncoding.DecodeString(values["pub"])
+	if err != nil {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("malformed pub: %v", err)}
+	}
+	var pubKey crypto.PubKey
+	if err := cdc.UnmarshalBinaryBare(pubKeyBytes, &pubKey); err != nil {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("malformed pub: %v", err)}
+	}
+	if p2p.PubKeyToID(pubKey) != seed.ID {
+		return nil, ErrDNSSeedRecordInvalid{seed, "pub does not match the seed ID"}
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(values["sig"])
+	if err != nil {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("malformed sig: %v", err)}
+	}
+	var sig crypto.Signature
+	if err := cdc.UnmarshalBinaryBare(sigBytes, &sig); err != nil {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("malformed sig: %v", err)}
+	}
+	ts, err := strconv.ParseInt(values["ts"], 10, 64)
+	if err != nil {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("malformed ts: %v", err)}
+	}
+	addrList := values["addrs"]
+	if !pubKey.VerifyBytes(dnsSeedSignBytes(seed.Host, ts, addrList), sig) {
+		return nil, ErrDNSSeedRecordInvalid{seed, "wrong signature"}
+	}
+	signed := time.Unix(ts, 0)
+	if now.Sub(signed) > dnsSeedRecordMaxAge {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("signed too long ago, at %v", signed)}
+	}
+	if signed.Sub(now) > dnsSeedRecordMaxSkew {
+		return nil, ErrDNSSeedRecordInvalid{seed, fmt.Sprintf("signed in the future, at %v", signed)}
+	}
+
+	if addrList == "" {
+		return nil, nil
+	}
+	return strings.Split(addrList, ","), nil
+}
+
+func dnsSeedSignBytes(host string, ts int64, addrList string) []byte {
+	return []byte(dnsSeedRecordPrefix + ":" + host + ":" + strconv.FormatInt(ts, 10) + ":" + addrList)
+}
+
+// resolveDNSSeed returns the addresses listed by the TXT records of seed.
+// Records that are not signed by the seed are skipped and reported in the
+// errors, as are the addresses that fail to parse or resolve.
+func resolveDNSSeed(resolver *net.Resolver, seed DNSSeed) ([]*p2p.NetAddress, []error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsSeedResolveTimeout)
+	defer cancel()
+	txts, err := resolver.LookupTXT(ctx, seed.Host)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var addrStrs []string
+	var errs []error
+	now := time.Now()
+	for _, txt := range txts {
+		if !strings.HasPrefix(txt, dnsSeedRecordPrefix+" ") {
+			continue
+		}
+		strs, err := parseDNSSeedRecord(seed, txt, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addrStrs = append(addrStrs, strs...)
+	}
+
+	netAddrs, addrErrs := p2p.NewNetAddressStrings(addrStrs)
+	return netAddrs, append(errs, addrErrs...)
+}
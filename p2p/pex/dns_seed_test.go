@@ -0,0 +1,346 @@
+package pex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/go-fusion/p2p"
+)
+
+const testSeedHost = "seed.example.org"
+
+func newTestSeed(t *testing.T) (DNSSeed, crypto.PrivKey) {
+	privKey := crypto.GenPrivKeyEd25519()
+	seed, err := ParseDNSSeed(string(p2p.PubKeyToID(privKey.PubKey())) + "@" + strings.ToUpper(testSeedHost) + ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seed, privKey
+}
+
+// newSeedBook returns a book of n routable addresses.
+func newSeedBook(t *testing.T, n int) *addrBook {
+	book := NewAddrBook("", true)
+	for i := 0; i < n; i++ {
+		addr := mustNetAddress(t, fmt.Sprintf("%040x@8.8.%d.%d:12345", i+1, i/250, i%250+1))
+		if err := book.AddAddress(addr, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return book
+}
+
+func TestDNSSeedRecord(t *testing.T) {
+	seed, privKey := newTestSeed(t)
+	addrs := []*p2p.NetAddress{mustNetAddress(t, testID+"@8.8.8.8:12345")}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		host    string
+		signed  time.Time
+		privKey crypto.PrivKey
+		valid   bool
+	}{
+		{"fresh", testSeedHost, now.Add(-time.Minute), privKey, true},
+		{"old", testSeedHost, now.Add(-dnsSeedRecordMaxAge - time.Minute), privKey, false},
+		{"future", testSeedHost, now.Add(dnsSeedRecordMaxSkew + time.Minute), privKey, false},
+		{"other host", "other.example.org", now, privKey, false},
+		{"other key", testSeedHost, now, crypto.GenPrivKeyEd25519(), false},
+	}
+	for _, tt := range tests {
+		txt, err := SignDNSSeedRecord(tt.host, addrs, tt.signed, tt.privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		strs, err := parseDNSSeedRecord(seed, txt, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid = %v", tt.name, err, tt.valid)
+		}
+		if err == nil && (len(strs) != 1 || strs[0] != addrs[0].String()) {
+			t.Errorf("%s: got addresses %v", tt.name, strs)
+		}
+	}
+
+	// the timestamp is signed
+	txt, err := SignDNSSeedRecord(testSeedHost, addrs, now.Add(-2*dnsSeedRecordMaxAge), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(txt)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "ts=") {
+			fields[i] = fmt.Sprintf("ts=%d", now.Unix())
+		}
+	}
+	if _, err := parseDNSSeedRecord(seed, strings.Join(fields, " "), now); err == nil {
+		t.Error("a record with a replaced timestamp was accepted")
+	}
+}
+
+func startTestSeeder(t *testing.T, numAddrs int) (*DNSSeeder, DNSSeed) {
+	seed, privKey := newTestSeed(t)
+	s := NewDNSSeeder(newSeedBook(t, numAddrs), testSeedHost, "127.0.0.1:0", privKey)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return s, seed
+}
+
+// query returns the parsed answer of the seeder to a query of name and
+// type, with an EDNS0 OPT record for udpSize if it is not 0.
+func query(t *testing.T, s *DNSSeeder, name string, typ dnsmessage.Type, udpSize int) (dnsmessage.Header, []dnsmessage.Resource, int) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1})
+	b.StartQuestions()                                                                                          // nolint: errcheck
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}) // nolint: errcheck
+	if udpSize > 0 {
+		b.StartAdditionals() // nolint: errcheck
+		var rh dnsmessage.ResourceHeader
+		rh.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false) // nolint: errcheck
+		b.OPTResource(rh, dnsmessage.OPTResource{})          // nolint: errcheck
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := s.answer(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p dnsmessage.Parser
+	hdr, err := p.Start(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hdr, answers, len(resp)
+}
+
+func TestDNSSeederAnswer(t *testing.T) {
+	s, _ := startTestSeeder(t, dnsSeederMaxAddrs)
+	defer s.Stop() // nolint: errcheck
+	numRecords := dnsSeederMaxAddrs / dnsSeederAddrsPerRecord
+
+	// without EDNS0 the response is cut to 512 bytes
+	hdr, answers, size := query(t, s, testSeedHost+".", dnsmessage.TypeTXT, 0)
+	if hdr.RCode != dnsmessage.RCodeSuccess || !hdr.Authoritative || hdr.Truncated {
+		t.Errorf("got header %+v", hdr)
+	}
+	if size > dnsMinUDPSize || len(answers) >= numRecords {
+		t.Errorf("got %d TXT records in %d bytes, want less than %d in at most %d", len(answers), size, numRecords, dnsMinUDPSize)
+	}
+	numSmall := len(answers)
+
+	// with EDNS0 more of them fit
+	_, answers, size = query(t, s, testSeedHost+".", dnsmessage.TypeTXT, dnsMaxUDPSize)
+	if len(answers) <= numSmall || len(answers) > numRecords || size > dnsMaxUDPSize {
+		t.Errorf("got %d TXT records in %d bytes with EDNS0, %d without", len(answers), size, numSmall)
+	}
+	// and a larger size is capped
+	if _, _, size = query(t, s, testSeedHost+".", dnsmessage.TypeTXT, 2*dnsMaxUDPSize); size > dnsMaxUDPSize {
+		t.Errorf("got %d bytes, want at most %d", size, dnsMaxUDPSize)
+	}
+	for _, answer := range answers {
+		txt := strings.Join(answer.Body.(*dnsmessage.TXTResource).TXT, "")
+		if !strings.HasPrefix(txt, dnsSeedRecordPrefix+" ") {
+			t.Errorf("got TXT record %q", txt)
+		}
+	}
+
+	_, answers, _ = query(t, s, testSeedHost+".", dnsmessage.TypeA, dnsMaxUDPSize)
+	if len(answers) != dnsSeederMaxAddrs {
+		t.Errorf("got %d A records, want %d", len(answers), dnsSeederMaxAddrs)
+	}
+
+	// no answers to ANY queries
+	if _, answers, _ = query(t, s, testSeedHost+".", dnsmessage.TypeALL, dnsMaxUDPSize); len(answers) != 0 {
+		t.Errorf("got %d answers to ANY", len(answers))
+	}
+
+	// other names don't exist
+	if hdr, _, _ = query(t, s, "other.example.org.", dnsmessage.TypeTXT, 0); hdr.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("got rcode %v for another name", hdr.RCode)
+	}
+
+	// responses and garbage are dropped
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, Response: true})
+	resp, _ := b.Finish()
+	if _, err := s.answer(resp); err == nil {
+		t.Error("answered a response")
+	}
+	if _, err := s.answer([]byte{1, 2, 3}); err == nil {
+		t.Error("answered garbage")
+	}
+}
+
+func TestDNSSeederRateLimit(t *testing.T) {
+	s := NewDNSSeeder(newSeedBook(t, 0), testSeedHost, "127.0.0.1:0", crypto.GenPrivKeyEd25519())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ip, other := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+
+	// a burst, then queries at the rate
+	for i := 0; i < dnsSeederQueryBurst; i++ {
+		if !s.allow(ip) {
+			t.Fatalf("query %d of the burst refused", i)
+		}
+	}
+	if s.allow(ip) {
+		t.Fatal("query over the burst allowed")
+	}
+	if !s.allow(other) {
+		t.Fatal("query of another IP refused")
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < dnsSeederQueryRate; i++ {
+		if !s.allow(ip) {
+			t.Fatalf("query %d of the next second refused", i)
+		}
+	}
+	if s.allow(ip) {
+		t.Fatal("query over the rate allowed")
+	}
+
+	// the clients are bounded, and forgotten once their allowance is back
+	for i := len(s.clients); i < dnsSeederMaxClients; i++ {
+		s.allow(net.IPv4(11, 0, byte(i>>8), byte(i)))
+	}
+	if s.allow(net.ParseIP("12.0.0.1")) {
+		t.Fatal("new IP allowed with the clients full")
+	}
+	now = now.Add(time.Duration(dnsSeederQueryBurst/dnsSeederQueryRate) * time.Second)
+	if !s.allow(net.ParseIP("12.0.0.1")) || len(s.clients) != 1 {
+		t.Fatalf("expected the clients pruned, got %d", len(s.clients))
+	}
+}
+
+// seederResolver returns a resolver asking s, whatever the server.
+func seederResolver(s *DNSSeeder) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.Addr().String())
+		},
+	}
+}
+
+func TestResolveDNSSeed(t *testing.T) {
+	s, seed := startTestSeeder(t, 10)
+	defer s.Stop() // nolint: errcheck
+
+	addrs, errs := resolveDNSSeed(seederResolver(s), seed)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// the resolver gets the records that fit in its UDP size
+	if len(addrs) == 0 || len(addrs) > 10 {
+		t.Fatalf("got %d addresses, want 1 to 10", len(addrs))
+	}
+	for _, addr := range addrs {
+		if !s.book.HasAddress(addr) {
+			t.Errorf("got %v, not in the book of the seeder", addr)
+		}
+	}
+
+	// a seed expecting another key gets nothing
+	other, _ := newTestSeed(t)
+	if addrs, errs := resolveDNSSeed(seederResolver(s), other); len(addrs) != 0 || len(errs) == 0 {
+		t.Errorf("got %v, %v from a seed with another key", addrs, errs)
+	}
+}
+
+func TestPEXReactorResolveDNSSeeds(t *testing.T) {
+	s, seed := startTestSeeder(t, 10)
+	defer s.Stop() // nolint: errcheck
+
+	r, book := newTestReactor()
+	r.config.DNSSeeds = []string{seed.String()}
+	if err := r.checkSeeds(); err != nil {
+		t.Fatal(err)
+	}
+	r.SetDNSResolver(seederResolver(s))
+	r.resolveDNSSeeds()
+
+	if book.Size() == 0 {
+		t.Fatal("no addresses from the seed")
+	}
+	// the seed is the source of all of them
+	for _, ka := range book.ListOfKnownAddresses() {
+		if ka.Src.String() != seed.NetAddress().String() {
+			t.Errorf("%v: source %v, want %v", ka.Addr, ka.Src, seed.NetAddress())
+		}
+	}
+}
+
+func TestPEXReactorResolveDNSSeedsAsync(t *testing.T) {
+	seed, _ := newTestSeed(t)
+	r, _ := newTestReactor()
+	r.config.DNSSeeds = []string{seed.String()}
+	if err := r.checkSeeds(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a resolver hanging until release is closed
+	dials, release := make(chan struct{}, 10), make(chan struct{})
+	r.SetDNSResolver(&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials <- struct{}{}
+			<-release
+			return nil, errors.New("unreachable")
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		r.maybeResolveDNSSeeds()
+		r.maybeResolveDNSSeeds()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("maybeResolveDNSSeeds blocked on the resolver")
+	}
+	<-dials
+	resolving, last := dnsResolveState(r)
+	if !resolving {
+		t.Fatal("not resolving")
+	}
+	// a resolution is running, so this returns without starting another
+	r.maybeResolveDNSSeeds()
+	if _, last2 := dnsResolveState(r); last2 != last {
+		t.Error("started a second resolution")
+	}
+	close(release)
+
+	for i := 0; i < 100; i++ {
+		if resolving, _ = dnsResolveState(r); !resolving {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("the resolution did not finish")
+}
+
+func dnsResolveState(r *PEXReactor) (bool, time.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.resolvingDNS, r.lastDNSResolve
+}
@@ -0,0 +1,405 @@
+package pex
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/go-fusion/p2p"
+)
+
+const (
+	// how often the served records are rebuilt from the addrbook
+	dnsSeederRefreshPeriod = 1 * time.Minute
+
+	// TTL of the served records, in seconds
+	dnsSeederTTL = 300
+
+	// addresses served in total, and per TXT record
+	dnsSeederMaxAddrs       = 24
+	dnsSeederAddrsPerRecord = 3
+
+	// size of a UDP response when the query has no EDNS0 OPT record,
+	// and the largest size we honour when it has one: the size of the DNS
+	// flag day 2020, which keeps the amplification of spoofed queries low
+	// and avoids IP fragmentation
+	dnsMinUDPSize = 512
+	dnsMaxUDPSize = 1232
+
+	// queries answered per client IP: a burst, refilled at a rate per
+	// second, so the seeder can't flood a spoofed IP with responses
+	dnsSeederQueryBurst = 20
+	dnsSeederQueryRate  = 5
+
+	// client IPs whose queries are counted
+	dnsSeederMaxClients = 10000
+
+	// max length of a string in a TXT record
+	dnsMaxTXTStringLen = 255
+)
+
+var errDNSNotAQuery = errors.New("not a DNS query")
+
+// DNSSeeder serves the addresses of the addrbook under host as the DNS
+// records resolved by DNS seeds: TXT records of addresses signed with the
+// node key, and A/AAAA records of their IPs.
+// It is meant to run alongside a node in seed mode, so the addrbook is
+// filled by crawling the network, and to be delegated the host name
+// by an NS record.
+type DNSSeeder struct {
+	cmn.BaseService
+
+	book    AddrBook
+	host    string // lower case, without trailing dot
+	laddr   string
+	privKey crypto.PrivKey
+
+	conn net.PacketConn
+
+	mtx     sync.RWMutex
+	txts    [][]string // TXT records, split into strings
+	ipv4s   []net.IP
+	ipv6s   []net.IP
+	refresh *time.Ticker
+
+	// only used by serveRoutine
+	clients map[string]*dnsClient // IP->queries allowed
+	now     func() time.Time
+}
+
+// dnsClient is the number of queries of a client IP we answer, as of
+// updated.
+type dnsClient struct {
+	allowance float64
+	updated   time.Time
+}
+
+// NewDNSSeeder returns a DNSSeeder answering queries for host on the UDP
+// address laddr, e.g. "0.0.0.0:53", with records signed by privKey.
+func NewDNSSeeder(book AddrBook, host, laddr string, privKey crypto.PrivKey) *DNSSeeder {
+	s := &DNSSeeder{
+		book:    book,
+		host:    strings.TrimSuffix(strings.ToLower(host), "."),
+		laddr:   laddr,
+		privKey: privKey,
+		clients: make(map[string]*dnsClient),
+		now:     time.Now,
+	}
+	s.BaseService = *cmn.NewBaseService(nil, "DNSSeeder", s)
+	return s
+}
+
+// OnStart implements BaseService
+func (s *DNSSeeder) OnStart() error {
+	if err := s.BaseService.OnStart(); err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", s.laddr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.Logger.Info("DNS seeder listening", "host", s.host, "laddr", conn.LocalAddr())
+
+	s.refreshRecords()
+	s.refresh = time.NewTicker(dnsSeederRefreshPeriod)
+	go s.refreshRoutine()
+	go s.serveRoutine()
+	return nil
+}
+
+// OnStop implements BaseService
+func (s *DNSSeeder) OnStop() {
+	s.BaseService.OnStop()
+	s.refresh.Stop()
+	s.conn.Close() // nolint: errcheck
+}
+
+// Addr returns the address the seeder listens on.
+func (s *DNSSeeder) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *DNSSeeder) refreshRoutine() {
+	for {
+		select {
+		case <-s.refresh.C:
+			s.refreshRecords()
+		case <-s.Quit():
+			return
+		}
+	}
+}
+
+// refreshRecords rebuilds the served records from a random selection of
+// routable TCP addresses of the book, vetted ones first.
+func (s *DNSSeeder) refreshRecords() {
+	var vetted, unvetted []*p2p.NetAddress
+	kas := s.book.ListOfKnownAddresses()
+	for _, i := range cmn.RandPerm(len(kas)) {
+		ka := kas[i]
+		if ka.Local || ka.Addr.Scheme != "" || !ka.Addr.Routable() || ka.isBad() {
+			continue
+		}
+		if ka.isOld() {
+			vetted = append(vetted, ka.Addr)
+		} else {
+			unvetted = append(unvetted, ka.Addr)
+		}
+	}
+	addrs := append(vetted, unvetted...)
+	if len(addrs) > dnsSeederMaxAddrs {
+		addrs = addrs[:dnsSeederMaxAddrs]
+	}
+
+	var txts [][]string
+	var ipv4s, ipv6s []net.IP
+	now := time.Now()
+	for i := 0; i < len(addrs); i += dnsSeederAddrsPerRecord {
+		end := cmn.MinInt(i+dnsSeederAddrsPerRecord, len(addrs))
+		txt, err := SignDNSSeedRecord(s.host, addrs[i:end], now, s.privKey)
+		if err != nil {
+			s.Logger.Error("Failed to sign DNS seed record", "err", err)
+			return
+		}
+		txts = append(txts, splitTXT(txt))
+	}
+	for _, addr := range addrs {
+		if addr.Onion() {
+			continue
+		}
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			ipv4s = append(ipv4s, ip4)
+		} else {
+			ipv6s = append(ipv6s, addr.IP)
+		}
+	}
+
+	s.mtx.Lock()
+	s.txts, s.ipv4s, s.ipv6s = txts, ipv4s, ipv6s
+	s.mtx.Unlock()
+	s.Logger.Debug("Refreshed DNS seed records", "numAddrs", len(addrs))
+}
+
+func (s *DNSSeeder) serveRoutine() {
+	buf := make([]byte, dnsMaxUDPSize)
+	for {
+		n, raddr, err := s.conn.ReadFrom(buf)
+		if !s.IsRunning() {
+			return
+		}
+		if err != nil {
+			s.Logger.Error("Failed to read DNS query", "err", err)
+			continue
+		}
+		if udpAddr, ok := raddr.(*net.UDPAddr); ok && !s.allow(udpAddr.IP) {
+			s.Logger.Debug("Dropping DNS query over the rate limit", "from", raddr)
+			continue
+		}
+
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			s.Logger.Debug("Dropping DNS query", "from", raddr, "err", err)
+			continue
+		}
+		if _, err := s.conn.WriteTo(resp, raddr); err != nil {
+			s.Logger.Debug("Failed to write DNS response", "to", raddr, "err", err)
+		}
+	}
+}
+
+// allow returns true if a query from ip is to be answered, and counts it.
+// New IPs are not answered while dnsSeederMaxClients IPs are still
+// counted.
+func (s *DNSSeeder) allow(ip net.IP) bool {
+	now := s.now()
+	key := ip.String()
+	c := s.clients[key]
+	if c == nil {
+		if len(s.clients) >= dnsSeederMaxClients {
+			s.pruneClients(now)
+			if len(s.clients) >= dnsSeederMaxClients {
+				return false
+			}
+		}
+		c = &dnsClient{dnsSeederQueryBurst, now}
+		s.clients[key] = c
+	}
+	c.allowance = math.Min(dnsSeederQueryBurst, c.allowance+now.Sub(c.updated).Seconds()*dnsSeederQueryRate)
+	c.updated = now
+	if c.allowance < 1 {
+		return false
+	}
+	c.allowance--
+	return true
+}
+
+// pruneClients forgets the clients whose allowance is back to the burst.
+func (s *DNSSeeder) pruneClients(now time.Time) {
+	refill := time.Duration(dnsSeederQueryBurst * float64(time.Second) / dnsSeederQueryRate)
+	for key, c := range s.clients {
+		if now.Sub(c.updated) >= refill {
+			delete(s.clients, key)
+		}
+	}
+}
+
+// answer returns the response to the query msg.
+// Only the first question is answered. ANY queries get no answers, as
+// they are mostly used for amplification attacks.
+func (s *DNSSeeder) answer(msg []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Response || hdr.OpCode != 0 {
+		return nil, errDNSNotAQuery
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	// the size the client takes, from its EDNS0 OPT record
+	maxSize, edns := dnsMinUDPSize, false
+	if err := p.SkipAllQuestions(); err == nil {
+		if err := p.SkipAllAnswers(); err == nil {
+			if err := p.SkipAllAuthorities(); err == nil {
+				for {
+					rh, err := p.AdditionalHeader()
+					if err != nil {
+						break
+					}
+					if rh.Type == dnsmessage.TypeOPT {
+						edns = true
+						maxSize = cmn.MaxInt(dnsMinUDPSize, cmn.MinInt(int(rh.Class), dnsMaxUDPSize))
+						break
+					}
+					if err := p.SkipAdditional(); err != nil {
+						break
+					}
+				}
+			}
+		}
+	}
+
+	respHdr := dnsmessage.Header{
+		ID:               hdr.ID,
+		Response:         true,
+		Authoritative:    true,
+		RecursionDesired: hdr.RecursionDesired,
+	}
+	name := strings.TrimSuffix(strings.ToLower(q.Name.String()), ".")
+	if name != s.host {
+		respHdr.RCode = dnsmessage.RCodeNameError
+	}
+
+	var answers []dnsmessage.Resource
+	if name == s.host && q.Class == dnsmessage.ClassINET {
+		answers = s.answers(q)
+	}
+
+	// drop answers until the response fits. Not marked truncated, as we
+	// don't serve TCP: clients get a random subset of the records instead
+	for n := len(answers); n >= 0; n-- {
+		resp, err := buildDNSResponse(respHdr, q, answers[:n], edns, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) <= maxSize {
+			return resp, nil
+		}
+	}
+	return nil, errors.New("DNS response does not fit")
+}
+
+func (s *DNSSeeder) answers(q dnsmessage.Question) []dnsmessage.Resource {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: dnsSeederTTL}
+	var answers []dnsmessage.Resource
+	switch q.Type {
+	case dnsmessage.TypeTXT:
+		for _, txt := range s.txts {
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.TXTResource{TXT: txt}})
+		}
+	case dnsmessage.TypeA:
+		for _, ip := range s.ipv4s {
+			var a [4]byte
+			copy(a[:], ip)
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{A: a}})
+		}
+	case dnsmessage.TypeAAAA:
+		for _, ip := range s.ipv6s {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+		}
+	}
+	for i, j := range cmn.RandPerm(len(answers)) {
+		answers[i], answers[j] = answers[j], answers[i]
+	}
+	return answers
+}
+
+func buildDNSResponse(hdr dnsmessage.Header, q dnsmessage.Question, answers []dnsmessage.Resource,
+	edns bool, maxSize int) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, maxSize), hdr)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, answer := range answers {
+		var err error
+		switch body := answer.Body.(type) {
+		case *dnsmessage.TXTResource:
+			err = b.TXTResource(answer.Header, *body)
+		case *dnsmessage.AResource:
+			err = b.AResource(answer.Header, *body)
+		case *dnsmessage.AAAAResource:
+			err = b.AAAAResource(answer.Header, *body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if edns {
+		if err := b.StartAdditionals(); err != nil {
+			return nil, err
+		}
+		var rh dnsmessage.ResourceHeader
+		if err := rh.SetEDNS0(maxSize, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil, err
+		}
+		if err := b.OPTResource(rh, dnsmessage.OPTResource{}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// splitTXT splits txt into the strings of a TXT record.
+// Resolvers join them back together.
+func splitTXT(txt string) []string {
+	var strs []string
+	for len(txt) > dnsMaxTXTStringLen {
+		strs = append(strs, txt[:dnsMaxTXTStringLen])
+		txt = txt[dnsMaxTXTStringLen:]
+	}
+	return append(strs, txt)
+}
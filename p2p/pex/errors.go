@@ -30,3 +30,40 @@ type ErrAddrBookNilAddr struct {
 func (err ErrAddrBookNilAddr) Error() string {
 	return fmt.Sprintf("Cannot add a nil address. Got (addr, src) = (%v, %v)", err.Addr, err.Src)
 }
+
+type ErrDNSSeedInvalid struct {
+	Seed string
+	Err  error
+}
+
+func (err ErrDNSSeedInvalid) Error() string {
+	return fmt.Sprintf("Invalid DNS seed %v, expected ID@hostname: %v", err.Seed, err.Err)
+}
+
+type ErrDNSSeedRecordInvalid struct {
+	Seed   DNSSeed
+	Reason string
+}
+
+func (err ErrDNSSeedRecordInvalid) Error() string {
+	return fmt.Sprintf("Invalid TXT record from DNS seed %v: %v", err.Seed, err.Reason)
+}
+
+type ErrAddrBookStaleRecord struct {
+	ID     p2p.ID
+	Seq    uint64
+	OurSeq uint64
+}
+
+func (err ErrAddrBookStaleRecord) Error() string {
+	return fmt.Sprintf("Stale record of %v: seq %d, we have %d", err.ID, err.Seq, err.OurSeq)
+}
+
+type ErrAddrBookCorruptDB struct {
+	Key string
+	Err error
+}
+
+func (err ErrAddrBookCorruptDB) Error() string {
+	return fmt.Sprintf("Corrupt address %s in the AddrBook DB: %v", err.Key, err.Err)
+}
@@ -11,6 +11,7 @@ import (
 
 type addrBookJSON struct {
 	Key   string          `json:"key"`
+	ASMap string          `json:"asmap,omitempty"` // checksum of the asmap the buckets were computed with
 	Addrs []*knownAddress `json:"addrs"`
 }
 
@@ -27,6 +28,7 @@ func (a *addrBook) saveToFile(filePath string) {
 
 	aJSON := &addrBookJSON{
 		Key:   a.key,
+		ASMap: a.asmapChecksum(),
 		Addrs: addrs,
 	}
 
@@ -63,11 +65,24 @@ func (a *addrBook) loadFromFile(filePath string) bool {
 		cmn.PanicCrisis(cmn.Fmt("Error reading file %s: %v", filePath, err))
 	}
 
+	a.restore(aJSON.Key, aJSON.ASMap, aJSON.Addrs)
+	return true
+}
+
+// restore sets the key and the addresses of a saved book, computed with
+// the asmap of the given checksum.
+func (a *addrBook) restore(key, asmapChecksum string, addrs []*knownAddress) {
 	// Restore all the fields...
 	// Restore the key
-	a.key = aJSON.Key
+	a.key = key
+	// The groups changed with the asmap, place the addresses again
+	if asmapChecksum != a.asmapChecksum() {
+		a.Logger.Info("asmap changed, placing addresses in their buckets again")
+		a.rebucket(addrs)
+		return
+	}
 	// Restore .bucketsNew & .bucketsOld
-	for _, ka := range aJSON.Addrs {
+	for _, ka := range addrs {
 		for _, bucketIndex := range ka.Buckets {
 			bucket := a.getBucket(ka.BucketType, bucketIndex)
 			bucket[ka.Addr.String()] = ka
@@ -79,5 +94,24 @@ func (a *addrBook) loadFromFile(filePath string) bool {
 			a.nOld++
 		}
 	}
-	return true
+}
+
+// rebucket adds the addresses to the buckets computed for the current
+// groups. Old addresses whose old bucket is full become new again.
+func (a *addrBook) rebucket(addrs []*knownAddress) {
+	for _, ka := range addrs {
+		ka.Buckets = nil
+		if ka.isOld() && a.addToOldBucket(ka, a.calcOldBucket(ka.Addr)) {
+			continue
+		}
+		ka.BucketType = bucketTypeNew
+		a.addToNewBucket(ka, a.calcNewBucket(ka.Addr, ka.Src))
+	}
+}
+
+func (a *addrBook) asmapChecksum() string {
+	if a.asmap == nil {
+		return ""
+	}
+	return a.asmap.Checksum()
 }
@@ -9,13 +9,20 @@ import (
 // knownAddress tracks information about a known network address
 // that is used to determine how viable an address is.
 type knownAddress struct {
-	Addr        *p2p.NetAddress `json:"addr"`
-	Src         *p2p.NetAddress `json:"src"`
-	Attempts    int32           `json:"attempts"`
-	LastAttempt time.Time       `json:"last_attempt"`
-	LastSuccess time.Time       `json:"last_success"`
-	BucketType  byte            `json:"bucket_type"`
-	Buckets     []int           `json:"buckets"`
+	Addr        *p2p.NetAddress   `json:"addr"`
+	AltAddrs    []*p2p.NetAddress `json:"alt_addrs"` // other listen addresses of the peer
+	Src         *p2p.NetAddress   `json:"src"`
+	Attempts    int32             `json:"attempts"`
+	LastAttempt time.Time         `json:"last_attempt"`
+	LastSuccess time.Time         `json:"last_success"`
+	BucketType  byte              `json:"bucket_type"`
+	Buckets     []int             `json:"buckets"`
+	Local       bool              `json:"local"`            // heard on the local network, not gossiped
+	Record      *p2p.NodeRecord   `json:"record,omitempty"` // latest signed record of the node
+
+	Latency      time.Duration    `json:"latency,omitempty"`      // handshake round trip when we last connected
+	Capabilities []p2p.Capability `json:"capabilities,omitempty"` // protocols the peer announced when we last connected
+	LastError    string           `json:"last_error,omitempty"`   // why we last failed to dial or dropped the peer
 }
 
 func newKnownAddress(addr *p2p.NetAddress, src *p2p.NetAddress) *knownAddress {
@@ -36,13 +43,40 @@ func (ka *knownAddress) ID() p2p.ID {
 func (ka *knownAddress) copy() *knownAddress {
 	return &knownAddress{
 		Addr:        ka.Addr,
+		AltAddrs:    ka.AltAddrs,
 		Src:         ka.Src,
 		Attempts:    ka.Attempts,
 		LastAttempt: ka.LastAttempt,
 		LastSuccess: ka.LastSuccess,
 		BucketType:  ka.BucketType,
 		Buckets:     ka.Buckets,
+		Local:       ka.Local,
+		Record:      ka.Record,
+
+		Latency:      ka.Latency,
+		Capabilities: ka.Capabilities,
+		LastError:    ka.LastError,
+	}
+}
+
+// addrs returns the main address followed by the alternative ones.
+func (ka *knownAddress) addrs() []*p2p.NetAddress {
+	return append([]*p2p.NetAddress{ka.Addr}, ka.AltAddrs...)
+}
+
+// addAltAddr records another listen address of the peer.
+// It returns false if the address is already known or there are too many.
+func (ka *knownAddress) addAltAddr(addr *p2p.NetAddress) bool {
+	for _, known := range ka.addrs() {
+		if known.Equals(addr) {
+			return false
+		}
+	}
+	if len(ka.AltAddrs) >= maxAltAddrsPerAddress {
+		return false
 	}
+	ka.AltAddrs = append(ka.AltAddrs, addr)
+	return true
 }
 
 func (ka *knownAddress) isOld() bool {
@@ -30,6 +30,9 @@ const (
 	// buckets a frequently seen new address may end up in.
 	maxNewBucketsPerAddress = 4
 
+	// other listen addresses kept for a peer besides its main one.
+	maxAltAddrsPerAddress = 7
+
 	// days before which we assume an address has vanished
 	// if we have not seen it announced in that long.
 	numMissingDays = 7
@@ -52,4 +55,8 @@ const (
 	// max addresses returned by GetSelection
 	// NOTE: this must match "maxMsgSize"
 	maxGetSelection = 250
+
+	// random picks of PickAddress before it looks for the addresses that
+	// aren't banned
+	maxPickAttempts = 10
 )
@@ -2,6 +2,7 @@ package pex
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"sort"
 	"sync"
@@ -20,18 +21,41 @@ const (
 	// PexChannel is a channel for PEX messages
 	PexChannel = byte(0x00)
 
+	// PexProtocolVersion is the version of the PEX protocol advertised to peers.
+	// Version 2 relays signed node records instead of bare addresses.
+	PexProtocolVersion = 2
+
+	// name of the PEX reactor in the switch, which peers advertise
+	// PexProtocolVersion under
+	pexCapability = "PEX"
+
+	// the PEX version relaying node records
+	pexRecordsVersion = 2
+
 	// over-estimate of max NetAddress size
 	// hexID (40) + IP (16) + Port (2) + Name (100) ...
 	// NOTE: dont use massive DNS name ..
 	maxAddressSize = 256
 
+	// over-estimate of max NodeRecord size
+	// PubKey (37) + Signature (69) + 8 addresses + Seq and Timestamp
+	maxRecordSize = 1024
+
 	// NOTE: amplificaiton factor!
 	// small request results in up to maxMsgSize response
-	maxMsgSize = maxAddressSize * maxGetSelection
+	maxMsgSize = maxRecordSize * maxGetSelection
 
 	// ensure we have enough peers
-	defaultEnsurePeersPeriod   = 30 * time.Second
-	defaultMinNumOutboundPeers = p2p.DefaultMinNumOutboundPeers
+	defaultEnsurePeersPeriod = 30 * time.Second
+
+	// dial a new address to test it this often, once we have enough peers
+	defaultFeelerPeriod = 2 * time.Minute
+
+	// replace one of our outbound peers with a feeler this often
+	defaultRotatePeriod = 30 * time.Minute
+
+	// addresses a feeler picks from the book before giving up
+	maxFeelerAttempts = 10
 
 	// Seed/Crawler constants
 
@@ -63,6 +87,17 @@ const (
 //
 // Only accept pexAddrsMsg from peers we sent a corresponding pexRequestMsg too.
 // Only accept one pexRequestMsg every ~defaultEnsurePeersPeriod.
+//
+// Peers speaking PEX version 2 exchange node records, the addresses of a
+// node signed by the node itself, so they can't make up addresses for
+// others. Each peer sends its own record when it connects.
+//
+// ## Eclipse attacks
+//
+// We dial at most one outbound peer per network group, and a few anchor
+// peers saved on shutdown are dialed first on startup. Once we have enough
+// peers, feeler connections test new addresses, and periodically one of
+// them replaces an outbound peer, so attackers can't keep our slots.
 type PEXReactor struct {
 	p2p.BaseReactor
 
@@ -73,8 +108,24 @@ type PEXReactor struct {
 	// maps to prevent abuse
 	requestsSent         *cmn.CMap // ID->struct{}: unanswered send requests
 	lastReceivedRequests *cmn.CMap // ID->time.Time: last time peer requested from us
+	ownRecordsReceived   *cmn.CMap // ID->struct{}: peer sent us its own record
+
+	outbound *cmn.CMap // ID->outboundPeer: outbound peers we dialed from the book
+	feelers  *cmn.CMap // ID->struct{}: feeler connections being made
+
+	crawlReport *CrawlReport // nodes crawled in seed mode
+
+	anchors      []*p2p.NetAddress // loaded on start
+	anchorIDs    map[p2p.ID]struct{}
+	lastRotation time.Time // only used by feelerRoutine
 
 	attemptsToDial sync.Map // address (string) -> {number of attempts (int), last time dialed (time.Time)}
+
+	dnsSeeds       []DNSSeed
+	resolver       *net.Resolver
+	mtx            sync.Mutex // guards lastDNSResolve and resolvingDNS
+	lastDNSResolve time.Time
+	resolvingDNS   bool
 }
 
 func (pexR *PEXReactor) minReceiveRequestInterval() time.Duration {
@@ -95,6 +146,24 @@ type PEXReactorConfig struct {
 	// PrivatePeerIDs is a list of peer IDs, which must not be gossiped to other
 	// peers.
 	PrivatePeerIDs []string
+
+	// DNSSeeds is a list of "ID@hostname" DNS seeds, whose TXT records
+	// signed by ID list addresses to add to the addrbook.
+	DNSSeeds []string
+
+	// AnchorsFile is where the anchor peers are saved on shutdown.
+	// Empty to not use anchors.
+	AnchorsFile string
+
+	// CrawlReportFile is where a seed saves its crawl report after each
+	// crawl. Empty to not save it.
+	CrawlReportFile string
+}
+
+// outboundPeer is an outbound peer we dialed from the book.
+type outboundPeer struct {
+	addr  *p2p.NetAddress
+	since time.Time
 }
 
 type _attemptsToDial struct {
@@ -103,6 +172,8 @@ type _attemptsToDial struct {
 }
 
 // NewPEXReactor creates new PEX reactor.
+// The book is started before the reactor and stopped after it by its
+// owner, the node, as other services use it too.
 func NewPEXReactor(b AddrBook, config *PEXReactorConfig) *PEXReactor {
 	r := &PEXReactor{
 		book:                 b,
@@ -110,6 +181,12 @@ func NewPEXReactor(b AddrBook, config *PEXReactorConfig) *PEXReactor {
 		ensurePeersPeriod:    defaultEnsurePeersPeriod,
 		requestsSent:         cmn.NewCMap(),
 		lastReceivedRequests: cmn.NewCMap(),
+		ownRecordsReceived:   cmn.NewCMap(),
+		outbound:             cmn.NewCMap(),
+		crawlReport:          NewCrawlReport(),
+		feelers:              cmn.NewCMap(),
+		anchorIDs:            make(map[p2p.ID]struct{}),
+		resolver:             net.DefaultResolver,
 	}
 	r.BaseReactor = *p2p.NewBaseReactor("PEXReactor", r)
 	return r
@@ -120,11 +197,6 @@ func (r *PEXReactor) OnStart() error {
 	if err := r.BaseReactor.OnStart(); err != nil {
 		return err
 	}
-	err := r.book.Start()
-	if err != nil && err != cmn.ErrAlreadyStarted {
-		return err
-	}
-
 	// return err if user provided a bad seed address
 	// or a host name that we cant resolve
 	if err := r.checkSeeds(); err != nil {
@@ -136,15 +208,35 @@ func (r *PEXReactor) OnStart() error {
 	if r.config.SeedMode {
 		go r.crawlPeersRoutine()
 	} else {
+		r.loadAnchors()
 		go r.ensurePeersRoutine()
+		go r.feelerRoutine()
 	}
 	return nil
 }
 
+// CrawlReport returns the nodes crawled in seed mode.
+func (r *PEXReactor) CrawlReport() *CrawlReport {
+	return r.crawlReport
+}
+
+// SetDNSResolver sets the resolver used to look up the DNS seeds.
+// Must be called before the reactor is started.
+func (r *PEXReactor) SetDNSResolver(resolver *net.Resolver) {
+	r.resolver = resolver
+}
+
 // OnStop implements BaseService
 func (r *PEXReactor) OnStop() {
 	r.BaseReactor.OnStop()
-	r.book.Stop()
+	if !r.config.SeedMode {
+		r.saveAnchors()
+	}
+}
+
+// ProtocolVersion implements p2p.VersionedReactor
+func (r *PEXReactor) ProtocolVersion() uint64 {
+	return PexProtocolVersion
 }
 
 // GetChannels implements Reactor
@@ -161,26 +253,37 @@ func (r *PEXReactor) GetChannels() []*conn.ChannelDescriptor {
 // AddPeer implements Reactor by adding peer to the address book (if inbound)
 // or by requesting more addresses (if outbound).
 func (r *PEXReactor) AddPeer(p Peer) {
+	if r.config.SeedMode {
+		r.crawlReport.markSeen(p)
+	}
+
+	if supportsRecords(p) {
+		r.sendOwnRecord(p)
+	}
+
 	if p.IsOutbound() {
 		// For outbound peers, the address is already in the books -
 		// either via DialPeersAsync or r.Receive.
-		// Ask it for more peers if we need.
-		if r.book.NeedMoreAddrs() {
+		// Ask it for more peers if we need, unless it is a feeler.
+		if r.book.NeedMoreAddrs() && !r.feelers.Has(string(p.ID())) {
 			r.RequestAddrs(p)
 		}
 	} else {
 		// inbound peer is its own source
-		addr := p.NodeInfo().NetAddress()
-		src := addr
+		addrs := p.NodeInfo().NetAddresses()
+		if len(addrs) == 0 {
+			return
+		}
+		src := addrs[0]
 
 		// ignore private addrs
-		if isAddrPrivate(addr, r.config.PrivatePeerIDs) {
+		if isAddrPrivate(src, r.config.PrivatePeerIDs) {
 			return
 		}
 
-		// add to book. dont RequestAddrs right away because
-		// we don't trust inbound as much - let ensurePeersRoutine handle it.
-		err := r.book.AddAddress(addr, src)
+		// add all its listen addresses to book. dont RequestAddrs right away
+		// because we don't trust inbound as much - let ensurePeersRoutine handle it.
+		err := r.book.AddListenAddrs(addrs)
 		r.logErrAddrBook(err)
 	}
 }
@@ -202,6 +305,8 @@ func (r *PEXReactor) RemovePeer(p Peer, reason interface{}) {
 	id := string(p.ID())
 	r.requestsSent.Delete(id)
 	r.lastReceivedRequests.Delete(id)
+	r.ownRecordsReceived.Delete(id)
+	r.outbound.Delete(id)
 }
 
 // Receive implements Reactor by handling incoming PEX messages.
@@ -209,7 +314,7 @@ func (r *PEXReactor) Receive(chID byte, src Peer, msgBytes []byte) {
 	msg, err := DecodeMessage(msgBytes)
 	if err != nil {
 		r.Logger.Error("Error decoding message", "src", src, "chId", chID, "msg", msg, "err", err, "bytes", msgBytes)
-		r.Switch.StopPeerForError(src, err)
+		r.Switch.ReportBehaviour(src, p2p.BadMessage(err))
 		return
 	}
 	r.Logger.Debug("Received message", "src", src, "chId", chID, "msg", msg)
@@ -218,7 +323,7 @@ func (r *PEXReactor) Receive(chID byte, src Peer, msgBytes []byte) {
 	case *pexRequestMessage:
 		// Check we're not receiving too many requests
 		if err := r.receiveRequest(src); err != nil {
-			r.Switch.StopPeerForError(src, err)
+			r.Switch.ReportBehaviour(src, p2p.Spam(err))
 			return
 		}
 
@@ -228,20 +333,32 @@ func (r *PEXReactor) Receive(chID byte, src Peer, msgBytes []byte) {
 		// 1) restrict how frequently peers can request
 		// 2) limit the output size
 		if r.config.SeedMode {
-			r.SendAddrs(src, r.book.GetSelectionWithBias(biasToSelectNewPeers))
+			r.sendSelection(src, r.book.GetSelectionWithBias(biasToSelectNewPeers))
 			r.Switch.StopPeerGracefully(src)
 		} else {
-			r.SendAddrs(src, r.book.GetSelection())
+			r.sendSelection(src, r.book.GetSelection())
 		}
 
 	case *pexAddrsMessage:
 		// If we asked for addresses, add them to the book
 		if err := r.ReceiveAddrs(msg.Addrs, src); err != nil {
-			r.Switch.StopPeerForError(src, err)
+			r.Switch.ReportBehaviour(src, p2p.BadMessage(err))
+			return
+		}
+
+	case *pexRecordsMessage:
+		// If we asked for records, or it is the peer's own, add them to the book
+		if err := r.ReceiveRecords(msg.Records, src); err != nil {
+			if _, ok := err.(p2p.ErrNodeRecordBadSignature); ok {
+				r.Switch.ReportBehaviour(src, p2p.InvalidRecord(err))
+			} else {
+				r.Switch.ReportBehaviour(src, p2p.BadMessage(err))
+			}
 			return
 		}
 	default:
 		r.Logger.Error(fmt.Sprintf("Unknown message type %v", reflect.TypeOf(msg)))
+		r.Switch.ReportBehaviour(src, p2p.BadMessage(fmt.Errorf("unknown message type %v", reflect.TypeOf(msg))))
 	}
 }
 
@@ -291,8 +408,13 @@ func (r *PEXReactor) RequestAddrs(p Peer) {
 
 // ReceiveAddrs adds the given addrs to the addrbook if theres an open
 // request for this peer and deletes the open request.
-// If there's no open request for the src peer, it returns an error.
+// If there's no open request for the src peer, or the peer relays records,
+// it returns an error.
 func (r *PEXReactor) ReceiveAddrs(addrs []*p2p.NetAddress, src Peer) error {
+	// peers relaying records must not fall back to unsigned addresses
+	if supportsRecords(src) {
+		return cmn.NewError("Received pexAddrsMessage from a peer relaying records")
+	}
 
 	id := string(src.ID())
 	if !r.requestsSent.Has(id) {
@@ -301,12 +423,23 @@ func (r *PEXReactor) ReceiveAddrs(addrs []*p2p.NetAddress, src Peer) error {
 	r.requestsSent.Delete(id)
 
 	srcAddr := src.NodeInfo().NetAddress()
+	supportsOnion := r.Switch.NodeInfo().SupportsOnion()
 	for _, netAddr := range addrs {
 		// NOTE: GetSelection methods should never return nil addrs
 		if netAddr == nil {
 			return cmn.NewError("received nil addr")
 		}
 
+		// we can't dial onion addrs without a proxy
+		if netAddr.Onion() && !supportsOnion {
+			continue
+		}
+
+		// don't let peers point us at local sockets or HTTP paths
+		if netAddr.LocalConfigOnly() {
+			continue
+		}
+
 		// ignore private peers
 		// TODO: give private peers to AddrBook so it can enforce this on AddAddress.
 		// We'd then have to check for ErrPrivatePeer on AddAddress here, which is
@@ -321,8 +454,109 @@ func (r *PEXReactor) ReceiveAddrs(addrs []*p2p.NetAddress, src Peer) error {
 	return nil
 }
 
+// ReceiveRecords adds the given node records to the addrbook if theres an
+// open request for this peer, and deletes the open request. Without one,
+// it only accepts the first record of the peer itself.
+// It returns an ErrNodeRecordBadSignature for the first forged record.
+func (r *PEXReactor) ReceiveRecords(records []*p2p.NodeRecord, src Peer) error {
+	id := string(src.ID())
+	if r.requestsSent.Has(id) {
+		r.requestsSent.Delete(id)
+	} else {
+		if len(records) != 1 || records[0] == nil || records[0].PubKey == nil ||
+			records[0].ID() != src.ID() || r.ownRecordsReceived.Has(id) {
+			return cmn.NewError("Received unsolicited pexRecordsMessage")
+		}
+		r.ownRecordsReceived.Set(id, struct{}{})
+	}
+
+	srcAddr := src.NodeInfo().NetAddress()
+	supportsOnion := r.Switch.NodeInfo().SupportsOnion()
+	for _, record := range records {
+		if record == nil {
+			return cmn.NewError("received nil record")
+		}
+		if err := record.Verify(); err != nil {
+			if _, ok := err.(p2p.ErrNodeRecordBadSignature); ok {
+				return err
+			}
+			// e.g. expired, or our clock is off
+			r.Logger.Debug("Ignoring invalid record", "src", src, "err", err)
+			continue
+		}
+		netAddr := record.NetAddresses()[0]
+
+		// we can't dial onion addrs without a proxy
+		if netAddr.Onion() && !supportsOnion {
+			continue
+		}
+		// ignore private peers
+		if isAddrPrivate(netAddr, r.config.PrivatePeerIDs) {
+			continue
+		}
+
+		err := r.book.AddRecord(record, srcAddr)
+		r.logErrAddrBook(err)
+	}
+	return nil
+}
+
+// sendSelection sends the records of the selected addresses to the peers
+// that relay records, and the bare addresses to the others.
+func (r *PEXReactor) sendSelection(p Peer, netAddrs []*p2p.NetAddress) {
+	if supportsRecords(p) {
+		r.SendRecords(p, r.book.Records(netAddrs))
+	} else {
+		r.SendAddrs(p, netAddrs)
+	}
+}
+
+// SendRecords sends node records to the peer.
+// Records of onion addrs are only sent to peers that can dial them.
+func (r *PEXReactor) SendRecords(p Peer, records []*p2p.NodeRecord) {
+	if !p.NodeInfo().SupportsOnion() {
+		filtered := make([]*p2p.NodeRecord, 0, len(records))
+		for _, record := range records {
+			if netAddrs := record.NetAddresses(); len(netAddrs) > 0 && !netAddrs[0].Onion() {
+				filtered = append(filtered, record)
+			}
+		}
+		records = filtered
+	}
+	p.Send(PexChannel, cdc.MustMarshalBinary(&pexRecordsMessage{Records: records}))
+}
+
+// sendOwnRecord sends our own record to the peer.
+func (r *PEXReactor) sendOwnRecord(p Peer) {
+	record, err := r.Switch.NodeRecord()
+	if err != nil {
+		r.Logger.Error("Failed to sign our node record", "err", err)
+		return
+	}
+	if record == nil {
+		return
+	}
+	p.Send(PexChannel, cdc.MustMarshalBinary(&pexRecordsMessage{Records: []*p2p.NodeRecord{record}}))
+}
+
+// supportsRecords returns true if the peer relays node records.
+func supportsRecords(p Peer) bool {
+	version, ok := p.NodeInfo().Capability(pexCapability)
+	return ok && version >= pexRecordsVersion
+}
+
 // SendAddrs sends addrs to the peer.
+// Onion addrs are only sent to peers that can dial them.
 func (r *PEXReactor) SendAddrs(p Peer, netAddrs []*p2p.NetAddress) {
+	if !p.NodeInfo().SupportsOnion() {
+		filtered := make([]*p2p.NetAddress, 0, len(netAddrs))
+		for _, netAddr := range netAddrs {
+			if !netAddr.Onion() {
+				filtered = append(filtered, netAddr)
+			}
+		}
+		netAddrs = filtered
+	}
 	p.Send(PexChannel, cdc.MustMarshalBinary(&pexAddrsMessage{Addrs: netAddrs}))
 }
 
@@ -338,6 +572,9 @@ func (r *PEXReactor) ensurePeersRoutine() {
 		jitter = seed.Int63n(r.ensurePeersPeriod.Nanoseconds())
 	)
 
+	// Reconnect to our anchors before anybody else
+	r.dialAnchors()
+
 	// Randomize first round of communication to avoid thundering herd.
 	// If no potential peers are present directly start connecting so we guarantee
 	// swift setup with the help of configured seeds.
@@ -370,7 +607,7 @@ func (r *PEXReactor) ensurePeersRoutine() {
 func (r *PEXReactor) ensurePeers() {
 	var (
 		out, in, dial = r.Switch.NumPeers()
-		numToDial     = defaultMinNumOutboundPeers - (out + dial)
+		numToDial     = r.Switch.MinNumOutboundPeers() - (out + dial)
 	)
 	r.Logger.Info(
 		"Ensure peers",
@@ -380,6 +617,8 @@ func (r *PEXReactor) ensurePeers() {
 		"numToDial", numToDial,
 	)
 
+	r.maybeResolveDNSSeeds()
+
 	if numToDial <= 0 {
 		return
 	}
@@ -393,6 +632,10 @@ func (r *PEXReactor) ensurePeers() {
 	// Try maxAttempts times to pick numToDial addresses to dial
 	maxAttempts := numToDial * 3
 
+	// Dial at most one routable address per network group (the ASN with
+	// an asmap), so that a single operator can't own all our outbound peers
+	groups := r.outboundGroups()
+
 	for i := 0; i < maxAttempts && len(toDial) < numToDial; i++ {
 		try := r.book.PickAddress(newBias)
 		if try == nil {
@@ -407,6 +650,13 @@ func (r *PEXReactor) ensurePeers() {
 		if connected := r.Switch.Peers().Has(try.ID); connected {
 			continue
 		}
+		if try.Routable() {
+			group := r.book.GroupKey(try)
+			if _, taken := groups[group]; taken {
+				continue
+			}
+			groups[group] = struct{}{}
+		}
 		// TODO: consider moving some checks from toDial into here
 		// so we don't even consider dialing peers that we want to wait
 		// before dialling again, or have dialed too many times already
@@ -437,6 +687,24 @@ func (r *PEXReactor) ensurePeers() {
 	}
 }
 
+// outboundGroups returns the network groups of our routable outbound peers.
+func (r *PEXReactor) outboundGroups() map[string]struct{} {
+	groups := make(map[string]struct{})
+	for _, peer := range r.Switch.Peers().List() {
+		if !peer.IsOutbound() {
+			continue
+		}
+		ip := peer.RemoteIP()
+		if ip == nil {
+			continue
+		}
+		if addr := p2p.NewNetAddressIPPort(ip, 0); addr.Routable() {
+			groups[r.book.GroupKey(addr)] = struct{}{}
+		}
+	}
+	return groups
+}
+
 func (r *PEXReactor) dialAttemptsInfo(addr *p2p.NetAddress) (attempts int, lastDialed time.Time) {
 	_attempts, ok := r.attemptsToDial.Load(addr.DialString())
 	if !ok {
@@ -447,6 +715,12 @@ func (r *PEXReactor) dialAttemptsInfo(addr *p2p.NetAddress) (attempts int, lastD
 }
 
 func (r *PEXReactor) dialPeer(addr *p2p.NetAddress) {
+	if r.Switch.IsBanned(addr) {
+		r.Logger.Debug("Not dialing banned peer", "addr", addr)
+		r.attemptsToDial.Delete(addr.DialString())
+		return
+	}
+
 	attempts, lastDialed := r.dialAttemptsInfo(addr)
 
 	if attempts > maxAttemptsToDial {
@@ -467,6 +741,11 @@ func (r *PEXReactor) dialPeer(addr *p2p.NetAddress) {
 	}
 
 	err := r.Switch.DialPeerWithAddress(addr, false)
+	if p2p.IsLocalDialError(err) {
+		// not the peer's fault, try again later
+		r.Logger.Debug("Not dialing peer", "addr", addr, "err", err)
+		return
+	}
 	if err != nil {
 		r.Logger.Error("Dialing failed", "addr", addr, "err", err, "attempts", attempts)
 		// TODO: detect more "bad peer" scenarios
@@ -484,11 +763,189 @@ func (r *PEXReactor) dialPeer(addr *p2p.NetAddress) {
 	} else {
 		// cleanup any history
 		r.attemptsToDial.Delete(addr.DialString())
+		// the peer may be gone already
+		if r.Switch.Peers().Has(addr.ID) {
+			r.outbound.Set(string(addr.ID), outboundPeer{addr, time.Now()})
+		}
+	}
+}
+
+//----------------------------------------------------------
+// Anchors, feelers and rotation
+
+// loadAnchors reads the anchors saved on our last shutdown.
+func (r *PEXReactor) loadAnchors() {
+	if r.config.AnchorsFile == "" {
+		return
+	}
+	anchors, err := loadAnchors(r.config.AnchorsFile)
+	if err != nil {
+		r.Logger.Error("Failed to load anchors", "file", r.config.AnchorsFile, "err", err)
+	}
+	r.anchors = anchors
+	for _, addr := range anchors {
+		r.anchorIDs[addr.ID] = struct{}{}
+	}
+}
+
+// saveAnchors saves our longest connected outbound peers, from different
+// network groups, as the anchors for our next start.
+func (r *PEXReactor) saveAnchors() {
+	if r.config.AnchorsFile == "" {
+		return
+	}
+	peers := make([]outboundPeer, 0, r.outbound.Size())
+	for _, v := range r.outbound.Values() {
+		peers = append(peers, v.(outboundPeer))
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].since.Before(peers[j].since)
+	})
+
+	anchors := make([]*p2p.NetAddress, 0, maxAnchors)
+	groups := make(map[string]struct{})
+	for _, p := range peers {
+		if len(anchors) == maxAnchors {
+			break
+		}
+		group := r.book.GroupKey(p.addr)
+		if _, taken := groups[group]; taken {
+			continue
+		}
+		groups[group] = struct{}{}
+		anchors = append(anchors, p.addr)
+	}
+	if len(anchors) == 0 {
+		return
+	}
+
+	r.Logger.Info("Saving anchors", "anchors", anchors)
+	if err := saveAnchors(r.config.AnchorsFile, anchors); err != nil {
+		r.Logger.Error("Failed to save anchors", "file", r.config.AnchorsFile, "err", err)
+	}
+}
+
+// dialAnchors dials the anchors loaded on start.
+func (r *PEXReactor) dialAnchors() {
+	for _, addr := range r.anchors {
+		if r.Switch.Peers().Has(addr.ID) || r.Switch.IsDialing(addr.ID) {
+			continue
+		}
+		r.Logger.Info("Dialing anchor", "addr", addr)
+		err := r.book.AddAddress(addr, addr)
+		r.logErrAddrBook(err)
+		go r.dialPeer(addr)
+	}
+}
+
+func (r *PEXReactor) feelerRoutine() {
+	r.lastRotation = time.Now()
+	ticker := time.NewTicker(defaultFeelerPeriod)
+	for {
+		select {
+		case <-ticker.C:
+			r.feeler()
+		case <-r.Quit():
+			ticker.Stop()
+			return
+		}
 	}
 }
 
-// check seed addresses are well formed
+// feeler dials a new address from a network group we have no outbound
+// peer in, to check it is reachable. If so, it moves to an old bucket,
+// and is disconnected, unless it is time to rotate our outbound peers:
+// then it replaces one of them, picked at random.
+// Anchors and persistent peers are never replaced.
+// The feeler has an outbound slot of its own, see
+// Switch.DialFeelerWithAddress.
+func (r *PEXReactor) feeler() {
+	out, _, dial := r.Switch.NumPeers()
+	if out+dial < r.Switch.MinNumOutboundPeers() {
+		// ensurePeers is still filling our slots
+		return
+	}
+
+	groups := r.outboundGroups()
+	var addr *p2p.NetAddress
+	for i := 0; i < maxFeelerAttempts && addr == nil; i++ {
+		try := r.book.PickAddress(100)
+		if try == nil {
+			return
+		}
+		if r.Switch.Peers().Has(try.ID) || r.Switch.IsDialing(try.ID) || r.Switch.IsBanned(try) {
+			continue
+		}
+		if _, taken := groups[r.book.GroupKey(try)]; taken && try.Routable() {
+			continue
+		}
+		addr = try
+	}
+	if addr == nil {
+		return
+	}
+
+	id := string(addr.ID)
+	r.feelers.Set(id, struct{}{})
+	defer r.feelers.Delete(id)
+
+	r.Logger.Debug("Dialing feeler", "addr", addr)
+	if err := r.Switch.DialFeelerWithAddress(addr); err != nil {
+		r.Logger.Debug("Feeler failed", "addr", addr, "err", err)
+		if !p2p.IsLocalDialError(err) {
+			r.book.MarkAttempt(addr)
+		}
+		return
+	}
+	r.book.MarkGood(addr)
+
+	peer := r.Switch.Peers().Get(addr.ID)
+	if peer == nil {
+		return
+	}
+	if time.Since(r.lastRotation) >= defaultRotatePeriod {
+		if victim := r.rotationCandidate(); victim != nil {
+			r.Logger.Info("Rotating outbound peer", "old", victim, "new", peer)
+			r.lastRotation = time.Now()
+			r.outbound.Set(id, outboundPeer{addr, time.Now()})
+			r.Switch.StopPeerGracefully(victim)
+			return
+		}
+	}
+	r.Switch.StopPeerGracefully(peer)
+}
+
+// rotationCandidate returns a random outbound peer we dialed from the book
+// that isn't an anchor, nil if there is none.
+func (r *PEXReactor) rotationCandidate() Peer {
+	candidates := make([]Peer, 0, r.outbound.Size())
+	for _, id := range r.outbound.Keys() {
+		if _, anchor := r.anchorIDs[p2p.ID(id)]; anchor {
+			continue
+		}
+		peer := r.Switch.Peers().Get(p2p.ID(id))
+		if peer == nil || peer.IsPersistent() {
+			continue
+		}
+		candidates = append(candidates, peer)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[cmn.RandIntn(len(candidates))]
+}
+
+// check seed addresses and DNS seeds are well formed
 func (r *PEXReactor) checkSeeds() error {
+	r.dnsSeeds = r.dnsSeeds[:0]
+	for _, s := range r.config.DNSSeeds {
+		seed, err := ParseDNSSeed(s)
+		if err != nil {
+			return err
+		}
+		r.dnsSeeds = append(r.dnsSeeds, seed)
+	}
+
 	lSeeds := len(r.config.Seeds)
 	if lSeeds == 0 {
 		return nil
@@ -502,6 +959,56 @@ func (r *PEXReactor) checkSeeds() error {
 	return nil
 }
 
+// resolve the DNS seeds in the background if we need more addresses or
+// haven't done so for dnsSeedResolveInterval, unless they are being
+// resolved already
+func (r *PEXReactor) maybeResolveDNSSeeds() {
+	if len(r.dnsSeeds) == 0 {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.resolvingDNS {
+		return
+	}
+	if time.Since(r.lastDNSResolve) < dnsSeedResolveInterval && !r.book.NeedMoreAddrs() {
+		return
+	}
+	r.resolvingDNS = true
+	r.lastDNSResolve = time.Now()
+	go func() {
+		r.resolveDNSSeeds()
+		r.mtx.Lock()
+		r.resolvingDNS = false
+		r.mtx.Unlock()
+	}()
+}
+
+// resolveDNSSeeds adds the addresses listed by the DNS seeds to the book.
+// The addresses of a seed all have the seed as their source, so a seed
+// can only fill a few buckets of the book.
+func (r *PEXReactor) resolveDNSSeeds() {
+	supportsOnion := r.Switch.NodeInfo().SupportsOnion()
+	for _, seed := range r.dnsSeeds {
+		addrs, errs := resolveDNSSeed(r.resolver, seed)
+		for _, err := range errs {
+			r.Logger.Error("Error resolving DNS seed", "seed", seed, "err", err)
+		}
+		r.Logger.Info("Resolved DNS seed", "seed", seed, "numAddrs", len(addrs))
+		src := seed.NetAddress()
+		for _, addr := range addrs {
+			if addr.Onion() && !supportsOnion {
+				continue
+			}
+			if addr.LocalConfigOnly() || isAddrPrivate(addr, r.config.PrivatePeerIDs) {
+				continue
+			}
+			err := r.book.AddAddress(addr, src)
+			r.logErrAddrBook(err)
+		}
+	}
+}
+
 // randomly dial seeds until we connect to one or exhaust them
 func (r *PEXReactor) dialSeeds() {
 	lSeeds := len(r.config.Seeds)
@@ -551,6 +1058,7 @@ func (r *PEXReactor) crawlPeersRoutine() {
 		case <-ticker.C:
 			r.attemptDisconnects()
 			r.crawlPeers()
+			r.saveCrawlReport()
 		case <-r.Quit():
 			return
 		}
@@ -610,6 +1118,7 @@ func (r *PEXReactor) getPeersToCrawl() []crawlPeerInfo {
 
 // crawlPeers will crawl the network looking for new peer addresses. (once)
 func (r *PEXReactor) crawlPeers() {
+	r.maybeResolveDNSSeeds()
 	peerInfos := r.getPeersToCrawl()
 
 	now := time.Now()
@@ -621,6 +1130,11 @@ func (r *PEXReactor) crawlPeers() {
 		}
 		// Otherwise, attempt to connect with the known address
 		err := r.Switch.DialPeerWithAddress(pi.Addr, false)
+		if p2p.IsLocalDialError(err) {
+			// says nothing about the node
+			continue
+		}
+		r.crawlReport.markDialed(pi.Addr, err)
 		if err != nil {
 			r.book.MarkAttempt(pi.Addr)
 			continue
@@ -631,9 +1145,23 @@ func (r *PEXReactor) crawlPeers() {
 	}
 }
 
+// saveCrawlReport drops the nodes gone for long from the crawl report,
+// and saves it if configured.
+func (r *PEXReactor) saveCrawlReport() {
+	r.crawlReport.prune()
+	if r.config.CrawlReportFile == "" {
+		return
+	}
+	if err := r.crawlReport.Save(r.config.CrawlReportFile); err != nil {
+		r.Logger.Error("Failed to save crawl report", "file", r.config.CrawlReportFile, "err", err)
+	}
+}
+
 // attemptDisconnects checks if we've been with each peer long enough to disconnect
 func (r *PEXReactor) attemptDisconnects() {
 	for _, peer := range r.Switch.Peers().List() {
+		// still there
+		r.crawlReport.markSeen(peer)
 		if peer.Status().Duration < defaultSeedDisconnectWaitPeriod {
 			continue
 		}
@@ -658,13 +1186,14 @@ func isAddrPrivate(addr *p2p.NetAddress, privatePeerIDs []string) bool {
 // Messages
 
 // PexMessage is a primary type for PEX messages. Underneath, it could contain
-// either pexRequestMessage, or pexAddrsMessage messages.
+// either pexRequestMessage, pexAddrsMessage or pexRecordsMessage messages.
 type PexMessage interface{}
 
 func RegisterPexMessage(cdc *amino.Codec) {
 	cdc.RegisterInterface((*PexMessage)(nil), nil)
 	cdc.RegisterConcrete(&pexRequestMessage{}, "tendermint/p2p/PexRequestMessage", nil)
 	cdc.RegisterConcrete(&pexAddrsMessage{}, "tendermint/p2p/PexAddrsMessage", nil)
+	cdc.RegisterConcrete(&pexRecordsMessage{}, "tendermint/p2p/PexRecordsMessage", nil)
 }
 
 // DecodeMessage implements interface registered above.
@@ -697,3 +1226,14 @@ type pexAddrsMessage struct {
 func (m *pexAddrsMessage) String() string {
 	return fmt.Sprintf("[pexAddrs %v]", m.Addrs)
 }
+
+/*
+A message with announced node records.
+*/
+type pexRecordsMessage struct {
+	Records []*p2p.NodeRecord
+}
+
+func (m *pexRecordsMessage) String() string {
+	return fmt.Sprintf("[pexRecords %v]", m.Records)
+}
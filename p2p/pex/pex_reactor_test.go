@@ -0,0 +1,175 @@
+package pex
+
+import (
+	"net"
+	"testing"
+
+	cfg "github.com/go-fusion/config"
+	"github.com/go-fusion/p2p"
+	tmconn "github.com/go-fusion/p2p/conn"
+	"github.com/go-fusion/p2p/trust"
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+)
+
+type mockPeer struct {
+	cmn.BaseService
+	nodeInfo p2p.NodeInfo
+	outbound bool
+}
+
+func newMockPeer(nodeInfo p2p.NodeInfo, outbound bool) *mockPeer {
+	p := &mockPeer{nodeInfo: nodeInfo, outbound: outbound}
+	p.BaseService = *cmn.NewBaseService(nil, "MockPeer", p)
+	return p
+}
+
+func (p *mockPeer) ID() p2p.ID                      { return p.nodeInfo.ID }
+func (p *mockPeer) IsOutbound() bool                { return p.outbound }
+func (p *mockPeer) IsPersistent() bool              { return false }
+func (p *mockPeer) NodeInfo() p2p.NodeInfo          { return p.nodeInfo }
+func (p *mockPeer) RemoteIP() net.IP                { return nil }
+func (p *mockPeer) Status() tmconn.ConnectionStatus { return tmconn.ConnectionStatus{} }
+func (p *mockPeer) Send(byte, []byte) bool          { return true }
+func (p *mockPeer) TrySend(byte, []byte) bool       { return true }
+func (p *mockPeer) Set(string, interface{})         {}
+func (p *mockPeer) Get(string) interface{}          { return nil }
+
+// newTestReactor returns a reactor over a book that is not strict about
+// routability, so only the checks of the reactor apply.
+func newTestReactor() (*PEXReactor, *addrBook) {
+	return newTestReactorWithConfig(cfg.DefaultP2PConfig())
+}
+
+func newTestReactorWithConfig(config *cfg.P2PConfig) (*PEXReactor, *addrBook) {
+	book := NewAddrBook("", false)
+	r := NewPEXReactor(book, &PEXReactorConfig{})
+	sw := p2p.NewSwitch(config)
+	sw.SetNodeKey(&p2p.NodeKey{PrivKey: crypto.GenPrivKeyEd25519()})
+	sw.SetNodeInfo(p2p.NodeInfo{ID: p2p.ID(testID), ListenAddr: "8.8.8.8:12345"})
+	r.SetSwitch(sw)
+	return r, book
+}
+
+func TestPEXReactorReceiveAddrsLocalConfigOnly(t *testing.T) {
+	r, book := newTestReactor()
+	src := newMockPeer(p2p.NodeInfo{ID: "89abcdef0123456789abcdef0123456789abcdef", ListenAddr: "1.1.1.1:12345"}, true)
+
+	tcp := mustNetAddress(t, "00000000000000000000000000000000000000a1@2.2.2.2:12345")
+	addrs := []*p2p.NetAddress{
+		tcp,
+		mustNetAddress(t, "unix://00000000000000000000000000000000000000a2@/var/run/docker.sock"),
+		mustNetAddress(t, "memory://00000000000000000000000000000000000000a3@node"),
+		mustNetAddress(t, "ws://00000000000000000000000000000000000000a4@3.3.3.3:80/admin"),
+	}
+
+	r.RequestAddrs(src)
+	if err := r.ReceiveAddrs(addrs, src); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addrs {
+		if book.HasAddress(addr) != (addr == tcp) {
+			t.Errorf("%v: added = %v", addr, book.HasAddress(addr))
+		}
+	}
+}
+
+func TestAddrBookLocalConfigOnly(t *testing.T) {
+	book := NewAddrBook("", false)
+	unix := mustNetAddress(t, "unix://"+testID+"@/var/run/fusiond.sock")
+
+	// from the local config, e.g. a persistent peer
+	if err := book.AddAddress(unix, unix); err != nil {
+		t.Fatal(err)
+	}
+	if !book.HasAddress(unix) {
+		t.Fatal("address of the local config was not added")
+	}
+	// but never gossiped
+	if selection := book.GetSelection(); len(selection) != 0 {
+		t.Errorf("gossiped %v", selection)
+	}
+
+	// nor taken from a handshake
+	addr := mustNetAddress(t, "89abcdef0123456789abcdef0123456789abcdef@8.8.8.8:12345")
+	ws := mustNetAddress(t, "ws://89abcdef0123456789abcdef0123456789abcdef@8.8.8.8:80/p2p")
+	if err := book.AddListenAddrs([]*p2p.NetAddress{addr, ws}); err != nil {
+		t.Fatal(err)
+	}
+	if ka := book.addrLookup[addr.ID]; len(ka.AltAddrs) != 0 {
+		t.Errorf("got alternative addresses %v", ka.AltAddrs)
+	}
+	if err := book.AddListenAddrs([]*p2p.NetAddress{mustNetAddress(t, "memory://00000000000000000000000000000000000000a3@node")}); err == nil {
+		t.Error("expected a memory listen address to be refused")
+	}
+}
+
+func TestPEXReactorReceiveAddrsFromRecordPeer(t *testing.T) {
+	r, book := newTestReactor()
+	src := newMockPeer(p2p.NodeInfo{
+		ID:           "89abcdef0123456789abcdef0123456789abcdef",
+		ListenAddr:   "1.1.1.1:12345",
+		Capabilities: []p2p.Capability{{Name: pexCapability, Version: pexRecordsVersion}},
+	}, true)
+
+	// the peer must answer with records, even when asked
+	r.RequestAddrs(src)
+	addr := mustNetAddress(t, "00000000000000000000000000000000000000a1@2.2.2.2:12345")
+	if err := r.ReceiveAddrs([]*p2p.NetAddress{addr}, src); err == nil {
+		t.Error("expected an error")
+	}
+	if book.HasAddress(addr) {
+		t.Error("an unsigned address was added")
+	}
+}
+
+func TestPEXReactorFeelerAttempts(t *testing.T) {
+	tests := []struct {
+		name       string
+		distrusted bool
+		attempts   int32
+	}{
+		// we don't trust the peer enough, the address is not to blame
+		{"local error", true, 0},
+		// nothing listens on the port
+		{"dial error", false, 1},
+	}
+	for _, tt := range tests {
+		config := cfg.DefaultP2PConfig()
+		// our outbound slots are full, as with max_num_peers: the
+		// feeler has a slot of its own
+		config.MinOutbound = 0
+		config.MaxOutbound = 0
+		r, book := newTestReactorWithConfig(config)
+		addr := mustNetAddress(t, "00000000000000000000000000000000000000a1@127.0.0.1:1")
+		if err := book.AddAddress(addr, addr); err != nil {
+			t.Fatal(err)
+		}
+		if tt.distrusted {
+			store := trust.NewTrustMetricStore(dbm.NewMemDB(), trust.DefaultConfig())
+			store.GetPeerTrustMetric(string(addr.ID)).BadEvents(10)
+			r.Switch.SetTrustMetricStore(store)
+		}
+
+		r.feeler()
+		if ka := book.addrLookup[addr.ID]; ka.Attempts != tt.attempts {
+			t.Errorf("%s: got %d attempts, want %d", tt.name, ka.Attempts, tt.attempts)
+		}
+	}
+}
+
+func TestPEXReactorLeavesBookRunning(t *testing.T) {
+	r, book := newTestReactor()
+	if err := book.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer book.Stop()
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.Stop()
+	if !book.IsRunning() {
+		t.Fatal("expected the book kept running for its owner")
+	}
+}
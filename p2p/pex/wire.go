@@ -2,10 +2,12 @@ package pex
 
 import (
 	"github.com/tendermint/go-amino"
+	"github.com/tendermint/go-crypto"
 )
 
 var cdc = amino.NewCodec()
 
 func init() {
 	RegisterPexMessage(cdc)
+	crypto.RegisterAmino(cdc)
 }
@@ -0,0 +1,303 @@
+package p2p
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestTimeout       = 10 * time.Second
+	defaultMaxResponseSize      = 1024 * 1024 // 1MB
+	defaultMaxPendingPerPeer    = 16
+	defaultMaxServingPerPeer    = 4
+	maxRequestErrorReasonLength = 256
+)
+
+// RequestConfig is the config of a Requester.
+type RequestConfig struct {
+	// Timeout of a request, unless its context has an earlier deadline.
+	Timeout time.Duration
+
+	// Maximum size of the payload of a response. Bigger ones fail the
+	// request.
+	MaxResponseSize int
+
+	// Maximum number of requests waiting for a response from a peer.
+	MaxPendingPerPeer int
+
+	// Maximum number of requests of a peer being handled at once. More are
+	// answered with an error.
+	MaxServingPerPeer int
+}
+
+// DefaultRequestConfig returns the default config.
+func DefaultRequestConfig() RequestConfig {
+	return RequestConfig{
+		Timeout:           defaultRequestTimeout,
+		MaxResponseSize:   defaultMaxResponseSize,
+		MaxPendingPerPeer: defaultMaxPendingPerPeer,
+		MaxServingPerPeer: defaultMaxServingPerPeer,
+	}
+}
+
+// RequestHandler answers a request received from peer on chID. The error,
+// if any, is sent back to the peer instead of a response.
+type RequestHandler func(peer Peer, chID byte, req []byte) ([]byte, error)
+
+// requestMessage is the envelope of requests and responses. The ID is
+// chosen by the requesting side, and is unique per peer.
+type requestMessage struct {
+	ID       uint64
+	Response bool
+	Error    string // reason a request failed, on responses only
+	Payload  []byte
+}
+
+type requestResult struct {
+	resp []byte
+	err  error
+}
+
+type pendingRequest struct {
+	chID   byte
+	result chan requestResult // buffered, receives one result
+}
+
+// requestPeer is the state of the requests to and from a peer.
+type requestPeer struct {
+	nextID  uint64 // ID of the next request sent
+	pending map[uint64]pendingRequest
+	serving int // requests of the peer being handled
+}
+
+// Requester implements request/response messaging on top of peer
+// channels: it correlates responses with requests, and enforces timeouts,
+// response sizes and concurrency limits per peer.
+//
+// A reactor using it dedicates channels to requests, passes what it
+// receives on them to Receive, calls RemovePeer when a peer is removed, and
+// sends requests with Request.
+type Requester struct {
+	config  RequestConfig
+	handler RequestHandler
+
+	mtx   sync.Mutex
+	peers map[ID]*requestPeer
+}
+
+// NewRequester returns a Requester answering requests with handler, which
+// may be nil if the reactor sends requests only.
+func NewRequester(config RequestConfig, handler RequestHandler) *Requester {
+	return &Requester{
+		config:  config,
+		handler: handler,
+		peers:   make(map[ID]*requestPeer),
+	}
+}
+
+// peer returns the state of the requests with peer, created if needed, or
+// nil if peer was stopped. The switch stops a peer before calling
+// RemovePeer, so an entry created here is always deleted by RemovePeer.
+// NOTE: must be called with r.mtx held.
+func (r *Requester) peer(peer Peer) *requestPeer {
+	if !peer.IsRunning() {
+		// RemovePeer was called, or is about to be
+		return nil
+	}
+	rp := r.peers[peer.ID()]
+	if rp == nil {
+		rp = &requestPeer{
+			nextID:  1,
+			pending: make(map[uint64]pendingRequest),
+		}
+		r.peers[peer.ID()] = rp
+	}
+	return rp
+}
+
+// Request sends msg to peer on chID and waits for the response. It fails
+// if the context is done, or after the timeout of the config, including
+// while the request is being sent.
+func (r *Requester) Request(ctx context.Context, peer Peer, chID byte, msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	r.mtx.Lock()
+	rp := r.peer(peer)
+	if rp == nil {
+		r.mtx.Unlock()
+		return nil, ErrRequestSendFailed{peer.ID()}
+	}
+	if len(rp.pending) >= r.config.MaxPendingPerPeer {
+		r.mtx.Unlock()
+		return nil, ErrRequestTooMany{peer.ID(), r.config.MaxPendingPerPeer}
+	}
+	reqID := rp.nextID
+	rp.nextID++
+	result := make(chan requestResult, 1)
+	rp.pending[reqID] = pendingRequest{chID, result}
+	r.mtx.Unlock()
+
+	defer r.cancel(peer.ID(), reqID)
+
+	msgBytes, err := cdc.MarshalBinaryBare(requestMessage{ID: reqID, Payload: msg})
+	if err != nil {
+		return nil, err
+	}
+
+	// Send blocks while the send queue of the peer is full, so it must not
+	// delay the timeout. The response may be received before it returns.
+	sent := make(chan bool, 1)
+	go func() {
+		sent <- peer.Send(chID, msgBytes)
+	}()
+
+	for {
+		select {
+		case ok := <-sent:
+			if !ok {
+				return nil, ErrRequestSendFailed{peer.ID()}
+			}
+			sent = nil
+		case res := <-result:
+			return res.resp, res.err
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrRequestTimeout{peer.ID()}
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cancel forgets the request reqID to id. A late response to it is ignored.
+func (r *Requester) cancel(id ID, reqID uint64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if rp, ok := r.peers[id]; ok {
+		delete(rp.pending, reqID)
+	}
+}
+
+// Receive handles msgBytes received from peer on chID, a request or a
+// response. Requests are handled in their own goroutine. It returns an
+// error if peer misbehaved, which the reactor should report as a bad
+// message.
+func (r *Requester) Receive(chID byte, peer Peer, msgBytes []byte) error {
+	var msg requestMessage
+	if err := cdc.UnmarshalBinaryBare(msgBytes, &msg); err != nil {
+		return err
+	}
+	if msg.Response {
+		return r.receiveResponse(chID, peer, msg)
+	}
+	r.receiveRequest(chID, peer, msg)
+	return nil
+}
+
+func (r *Requester) receiveResponse(chID byte, peer Peer, msg requestMessage) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rp, ok := r.peers[peer.ID()]
+	if !ok {
+		// no request was sent since the peer was added
+		return ErrResponseUnexpected{peer.ID(), msg.ID}
+	}
+	req, ok := rp.pending[msg.ID]
+	if !ok {
+		if msg.ID >= rp.nextID {
+			return ErrResponseUnexpected{peer.ID(), msg.ID}
+		}
+		// the request timed out or was canceled
+		return nil
+	}
+	if req.chID != chID {
+		return ErrResponseUnexpected{peer.ID(), msg.ID}
+	}
+	delete(rp.pending, msg.ID)
+
+	switch {
+	case len(msg.Payload) > r.config.MaxResponseSize:
+		err := ErrResponseTooLarge{peer.ID(), len(msg.Payload), r.config.MaxResponseSize}
+		req.result <- requestResult{err: err}
+		return err
+	case msg.Error != "":
+		req.result <- requestResult{err: ErrRequestFailed{peer.ID(), msg.Error}}
+	default:
+		req.result <- requestResult{resp: msg.Payload}
+	}
+	return nil
+}
+
+func (r *Requester) receiveRequest(chID byte, peer Peer, msg requestMessage) {
+	if r.handler == nil {
+		r.reject(chID, peer, msg.ID, "requests are not served")
+		return
+	}
+
+	r.mtx.Lock()
+	rp := r.peer(peer)
+	if rp == nil {
+		// the peer was stopped, and can't be answered
+		r.mtx.Unlock()
+		return
+	}
+	if rp.serving >= r.config.MaxServingPerPeer {
+		r.mtx.Unlock()
+		r.reject(chID, peer, msg.ID, "too many requests")
+		return
+	}
+	rp.serving++
+	r.mtx.Unlock()
+
+	go func() {
+		defer func() {
+			r.mtx.Lock()
+			rp.serving--
+			r.mtx.Unlock()
+		}()
+		payload, err := r.handler(peer, chID, msg.Payload)
+		resp := requestMessage{ID: msg.ID, Response: true, Payload: payload}
+		if err != nil {
+			resp.Error, resp.Payload = err.Error(), nil
+		}
+		if msgBytes, ok := responseBytes(resp); ok {
+			peer.Send(chID, msgBytes)
+		}
+	}()
+}
+
+// reject answers the request reqID of peer with reason. It is called from
+// Receive, so it doesn't wait for room in the send queue of the peer: when
+// there is none, the request times out on the other side.
+func (r *Requester) reject(chID byte, peer Peer, reqID uint64, reason string) {
+	if msgBytes, ok := responseBytes(requestMessage{ID: reqID, Response: true, Error: reason}); ok {
+		peer.TrySend(chID, msgBytes)
+	}
+}
+
+func responseBytes(msg requestMessage) ([]byte, bool) {
+	if len(msg.Error) > maxRequestErrorReasonLength {
+		msg.Error = msg.Error[:maxRequestErrorReasonLength]
+	}
+	msgBytes, err := cdc.MarshalBinaryBare(msg)
+	return msgBytes, err == nil
+}
+
+// RemovePeer fails the requests to peer. Reactors must call it from their
+// RemovePeer.
+func (r *Requester) RemovePeer(peer Peer) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rp, ok := r.peers[peer.ID()]
+	if !ok {
+		return
+	}
+	for _, req := range rp.pending {
+		req.result <- requestResult{err: ErrRequestPeerRemoved{peer.ID()}}
+	}
+	delete(r.peers, peer.ID())
+}
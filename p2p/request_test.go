@@ -0,0 +1,301 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+const testRequestChannel = byte(0x50)
+
+// requestTestPeer is a mockPeer whose Send is handled by send, and
+// TrySend by trySend if it is set.
+type requestTestPeer struct {
+	*mockPeer
+	send    func(chID byte, msgBytes []byte) bool
+	trySend func(chID byte, msgBytes []byte) bool
+}
+
+func (p *requestTestPeer) Send(chID byte, msgBytes []byte) bool {
+	return p.send(chID, msgBytes)
+}
+
+func (p *requestTestPeer) TrySend(chID byte, msgBytes []byte) bool {
+	if p.trySend == nil {
+		return p.mockPeer.TrySend(chID, msgBytes)
+	}
+	return p.trySend(chID, msgBytes)
+}
+
+// newRequestTestPeer returns a started peer, which drops what is sent to it
+// unless send is set.
+func newRequestTestPeer(t *testing.T, i int) *requestTestPeer {
+	p := &requestTestPeer{
+		mockPeer: newMockPeer(mockID(i), "10.0.0.1", true),
+		send:     func(byte, []byte) bool { return true },
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// connectRequesters makes what a sends to peerB be received by b from peerA,
+// and the other way around.
+func connectRequesters(a, b *Requester, peerA, peerB *requestTestPeer) {
+	peerB.send = func(chID byte, msgBytes []byte) bool {
+		go b.Receive(chID, peerA, msgBytes)
+		return true
+	}
+	peerA.send = func(chID byte, msgBytes []byte) bool {
+		go a.Receive(chID, peerB, msgBytes)
+		return true
+	}
+}
+
+func testRequestConfig() RequestConfig {
+	config := DefaultRequestConfig()
+	config.Timeout = 100 * time.Millisecond
+	return config
+}
+
+func pendingRequests(r *Requester, id ID) (int, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	rp, ok := r.peers[id]
+	if !ok {
+		return 0, false
+	}
+	return len(rp.pending), true
+}
+
+func TestRequesterRequest(t *testing.T) {
+	echo := func(peer Peer, chID byte, req []byte) ([]byte, error) {
+		return append([]byte("echo "), req...), nil
+	}
+	a := NewRequester(testRequestConfig(), nil)
+	b := NewRequester(testRequestConfig(), echo)
+	peerA, peerB := newRequestTestPeer(t, 1), newRequestTestPeer(t, 2)
+	connectRequesters(a, b, peerA, peerB)
+
+	resp, err := a.Request(context.Background(), peerB, testRequestChannel, []byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, []byte("echo ping")) {
+		t.Errorf("expected the echoed request, got %q", resp)
+	}
+	if n, _ := pendingRequests(a, peerB.ID()); n != 0 {
+		t.Errorf("expected no pending request, got %d", n)
+	}
+}
+
+func TestRequesterTimeout(t *testing.T) {
+	r := NewRequester(testRequestConfig(), nil)
+	peer := newRequestTestPeer(t, 1)
+
+	_, err := r.Request(context.Background(), peer, testRequestChannel, []byte("ping"))
+	if _, ok := err.(ErrRequestTimeout); !ok {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	if n, _ := pendingRequests(r, peer.ID()); n != 0 {
+		t.Errorf("expected no pending request, got %d", n)
+	}
+
+	// a blocked send counts in the timeout
+	unblock := make(chan struct{})
+	defer close(unblock)
+	peer.send = func(byte, []byte) bool {
+		<-unblock
+		return true
+	}
+	start := time.Now()
+	_, err = r.Request(context.Background(), peer, testRequestChannel, []byte("ping"))
+	if _, ok := err.(ErrRequestTimeout); !ok {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to time out during the send, took %v", elapsed)
+	}
+
+	// the context ends requests before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Request(ctx, peer, testRequestChannel, []byte("ping")); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRequesterMaxResponseSize(t *testing.T) {
+	big := func(peer Peer, chID byte, req []byte) ([]byte, error) {
+		return make([]byte, 101), nil
+	}
+	configA := testRequestConfig()
+	configA.MaxResponseSize = 100
+	a := NewRequester(configA, nil)
+	b := NewRequester(testRequestConfig(), big)
+	peerA, peerB := newRequestTestPeer(t, 1), newRequestTestPeer(t, 2)
+	connectRequesters(a, b, peerA, peerB)
+
+	_, err := a.Request(context.Background(), peerB, testRequestChannel, []byte("ping"))
+	if _, ok := err.(ErrResponseTooLarge); !ok {
+		t.Errorf("expected ErrResponseTooLarge, got %v", err)
+	}
+}
+
+func TestRequesterMaxPending(t *testing.T) {
+	config := testRequestConfig()
+	config.Timeout = time.Minute
+	config.MaxPendingPerPeer = 2
+	r := NewRequester(config, nil)
+	peer := newRequestTestPeer(t, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, config.MaxPendingPerPeer)
+	for i := 0; i < config.MaxPendingPerPeer; i++ {
+		go func() {
+			_, err := r.Request(ctx, peer, testRequestChannel, []byte("ping"))
+			done <- err
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := pendingRequests(r, peer.ID()); n == config.MaxPendingPerPeer {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the requests never got pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err := r.Request(context.Background(), peer, testRequestChannel, []byte("ping"))
+	if _, ok := err.(ErrRequestTooMany); !ok {
+		t.Errorf("expected ErrRequestTooMany, got %v", err)
+	}
+
+	cancel()
+	for i := 0; i < config.MaxPendingPerPeer; i++ {
+		if err := <-done; err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	}
+	if n, _ := pendingRequests(r, peer.ID()); n != 0 {
+		t.Errorf("expected no pending request, got %d", n)
+	}
+}
+
+func TestRequesterRemovePeer(t *testing.T) {
+	config := testRequestConfig()
+	config.Timeout = time.Minute
+	r := NewRequester(config, nil)
+	peer := newRequestTestPeer(t, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Request(context.Background(), peer, testRequestChannel, []byte("ping"))
+		done <- err
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := pendingRequests(r, peer.ID()); n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the request never got pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// as the switch does
+	peer.Stop()
+	r.RemovePeer(peer)
+
+	select {
+	case err := <-done:
+		if _, ok := err.(ErrRequestPeerRemoved); !ok {
+			t.Errorf("expected ErrRequestPeerRemoved, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request wasn't failed")
+	}
+
+	// a stopped peer gets no entry anymore, by requests to or from it
+	_, err := r.Request(context.Background(), peer, testRequestChannel, []byte("ping"))
+	if _, ok := err.(ErrRequestSendFailed); !ok {
+		t.Errorf("expected ErrRequestSendFailed, got %v", err)
+	}
+	server := NewRequester(config, func(Peer, byte, []byte) ([]byte, error) { return nil, nil })
+	msgBytes, err := cdc.MarshalBinaryBare(requestMessage{ID: 1, Payload: []byte("ping")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Receive(testRequestChannel, peer, msgBytes); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pendingRequests(r, peer.ID()); ok {
+		t.Error("expected the requester to forget the peer")
+	}
+	if _, ok := pendingRequests(server, peer.ID()); ok {
+		t.Error("expected the server to ignore the stopped peer")
+	}
+}
+
+func TestRequesterRejectWithoutBlocking(t *testing.T) {
+	config := testRequestConfig()
+	config.MaxServingPerPeer = 1
+	handling, unblock := make(chan struct{}, 1), make(chan struct{})
+	defer close(unblock)
+	slow := func(Peer, byte, []byte) ([]byte, error) {
+		handling <- struct{}{}
+		<-unblock
+		return nil, nil
+	}
+	peer := newRequestTestPeer(t, 1)
+	// the send queue of the peer is full
+	peer.send = func(byte, []byte) bool {
+		<-unblock
+		return true
+	}
+	rejected := make(chan string, 2)
+	peer.trySend = func(chID byte, msgBytes []byte) bool {
+		var msg requestMessage
+		if err := cdc.UnmarshalBinaryBare(msgBytes, &msg); err != nil {
+			t.Error(err)
+		}
+		rejected <- msg.Error
+		return false
+	}
+	receive := func(r *Requester, id uint64) {
+		msgBytes, err := cdc.MarshalBinaryBare(requestMessage{ID: id, Payload: []byte("ping")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- r.Receive(testRequestChannel, peer, msgBytes) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Receive blocked on the send queue")
+		}
+	}
+
+	// requests that are not served
+	receive(NewRequester(config, nil), 1)
+	if reason := <-rejected; reason != "requests are not served" {
+		t.Errorf("got rejection %q", reason)
+	}
+
+	// and requests over the serving limit
+	r := NewRequester(config, slow)
+	receive(r, 1)
+	<-handling
+	receive(r, 2)
+	if reason := <-rejected; reason != "too many requests" {
+		t.Errorf("got rejection %q", reason)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cfg "github.com/go-fusion/config"
 	"github.com/go-fusion/p2p/conn"
+	"github.com/go-fusion/p2p/trust"
 	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
 )
 
 const (
@@ -27,9 +31,19 @@ const (
 	reconnectBackOffAttempts    = 10
 	reconnectBackOffBaseSeconds = 3
 
-	// keep at least this many outbound peers
-	// TODO: move to config
-	DefaultMinNumOutboundPeers = 10
+	// inbound peers are capped per subnet of these sizes
+	subnetBitsIPv4 = 24
+	subnetBitsIPv6 = 48
+
+	// at most this many inbound handshakes run at the same time
+	maxInboundHandshakes = 32
+
+	// an inbound peer only evicts one whose trust score is lower by this much
+	evictionScoreMargin = 10
+	maxTrustScore       = 100
+
+	// drop the expired bans from the DB this often
+	banPruneInterval = time.Minute
 )
 
 //-----------------------------------------------------------------------------
@@ -41,6 +55,8 @@ type AddrBook interface {
 	AddOurAddress(*NetAddress)
 	OurAddress(*NetAddress) bool
 	MarkGood(*NetAddress)
+	MarkConnected(addr *NetAddress, latency time.Duration, caps []Capability)
+	MarkError(addr *NetAddress, err error)
 	RemoveAddress(*NetAddress)
 	HasAddress(*NetAddress) bool
 	Save()
@@ -58,15 +74,25 @@ type Switch struct {
 	config       *cfg.P2PConfig
 	peerConfig   *PeerConfig
 	listeners    []Listener
+	transports   map[string]Transport
 	reactors     map[string]Reactor
 	chDescs      []*conn.ChannelDescriptor
 	reactorsByCh map[byte]Reactor
 	peers        *PeerSet
 	dialing      *cmn.CMap
 	reconnecting *cmn.CMap
+	nodeInfoMtx  sync.RWMutex
 	nodeInfo     NodeInfo // our node info
 	nodeKey      *NodeKey // our node privkey
+	recordMtx    sync.Mutex
+	record       *NodeRecord // our listen addresses signed with nodeKey
 	addrBook     AddrBook
+	trustStore   *trust.TrustMetricStore
+	scorer       BehaviourScorer
+	banList      *BanList
+
+	unconditionalPeerIDs map[ID]struct{} // exempt from the peer limits
+	inboundHandshakes    int32           // in progress, accessed atomically
 
 	filterConnByAddr func(net.Addr) error
 	filterConnByID   func(ID) error
@@ -85,6 +111,13 @@ func NewSwitch(config *cfg.P2PConfig) *Switch {
 		peers:        NewPeerSet(),
 		dialing:      cmn.NewCMap(),
 		reconnecting: cmn.NewCMap(),
+		transports:   make(map[string]Transport),
+		scorer:       NewBehaviourScorer(config),
+		banList:      NewBanList(dbm.NewMemDB()),
+	}
+
+	for _, t := range DefaultTransports() {
+		sw.transports[t.Scheme()] = t
 	}
 
 	// Ensure we have a completely undeterministic PRNG.
@@ -97,6 +130,11 @@ func NewSwitch(config *cfg.P2PConfig) *Switch {
 	sw.peerConfig.MConfig.MaxPacketMsgPayloadSize = config.MaxPacketMsgPayloadSize
 	sw.peerConfig.AuthEnc = config.AuthEnc
 
+	sw.unconditionalPeerIDs = make(map[ID]struct{})
+	for _, id := range cmn.SplitAndTrim(config.UnconditionalPeerIDs, ",", " ") {
+		sw.unconditionalPeerIDs[ID(id)] = struct{}{}
+	}
+
 	sw.BaseService = *cmn.NewBaseService(nil, "P2P Switch", sw)
 	return sw
 }
@@ -147,6 +185,22 @@ func (sw *Switch) Listeners() []Listener {
 	return sw.listeners
 }
 
+// AddTransport adds a transport to the switch, replacing the one of the
+// same scheme. The switch starts with DefaultTransports.
+// NOTE: Not goroutine safe.
+func (sw *Switch) AddTransport(t Transport) {
+	sw.transports[t.Scheme()] = t
+}
+
+// Transport returns the transport of the address scheme, or nil if there
+// is none. The empty scheme is TCP.
+func (sw *Switch) Transport(scheme string) Transport {
+	if scheme == "" {
+		scheme = SchemeTCP
+	}
+	return sw.transports[scheme]
+}
+
 // IsListening returns true if the switch has at least one listener.
 // NOTE: Not goroutine safe.
 func (sw *Switch) IsListening() bool {
@@ -154,17 +208,99 @@ func (sw *Switch) IsListening() bool {
 }
 
 // SetNodeInfo sets the switch's NodeInfo for checking compatibility and handshaking with other nodes.
-// NOTE: Not goroutine safe.
 func (sw *Switch) SetNodeInfo(nodeInfo NodeInfo) {
+	sw.nodeInfoMtx.Lock()
+	defer sw.nodeInfoMtx.Unlock()
 	sw.nodeInfo = nodeInfo
 }
 
 // NodeInfo returns the switch's NodeInfo.
-// NOTE: Not goroutine safe.
 func (sw *Switch) NodeInfo() NodeInfo {
+	sw.nodeInfoMtx.RLock()
+	defer sw.nodeInfoMtx.RUnlock()
 	return sw.nodeInfo
 }
 
+// Capabilities returns the protocol versions of the VersionedReactors,
+// sorted by reactor name, to be advertised in the NodeInfo.
+// NOTE: Not goroutine safe.
+func (sw *Switch) Capabilities() []Capability {
+	capabilities := make([]Capability, 0, len(sw.reactors))
+	for name, reactor := range sw.reactors {
+		if vr, ok := reactor.(VersionedReactor); ok {
+			capabilities = append(capabilities, Capability{name, vr.ProtocolVersion()})
+		}
+	}
+	sort.Slice(capabilities, func(i, j int) bool { return capabilities[i].Name < capabilities[j].Name })
+	return capabilities
+}
+
+// SetHead updates the head of the chain advertised in the switch's NodeInfo.
+// Peers that are already connected keep the old one.
+func (sw *Switch) SetHead(height uint64, hash []byte) {
+	sw.nodeInfoMtx.Lock()
+	defer sw.nodeInfoMtx.Unlock()
+	sw.nodeInfo.HeadHeight = height
+	sw.nodeInfo.HeadHash = hash
+}
+
+// ReplaceListenAddr replaces oldAddr with newAddr in the listen addresses
+// advertised in the switch's NodeInfo, e.g. when the external IP of the NAT
+// changes. It does nothing if oldAddr is not advertised.
+// Peers that are already connected keep the old one.
+func (sw *Switch) ReplaceListenAddr(oldAddr, newAddr *NetAddress) {
+	sw.nodeInfoMtx.Lock()
+	defer sw.nodeInfoMtx.Unlock()
+	if sw.nodeInfo.ListenAddr == oldAddr.AddressString() {
+		sw.nodeInfo.ListenAddr = newAddr.AddressString()
+		return
+	}
+	for i, addr := range sw.nodeInfo.ListenAddrs {
+		if addr == oldAddr.AddressString() {
+			// copy, NodeInfo() callers share the slice
+			listenAddrs := append([]string(nil), sw.nodeInfo.ListenAddrs...)
+			listenAddrs[i] = newAddr.AddressString()
+			sw.nodeInfo.ListenAddrs = listenAddrs
+			return
+		}
+	}
+}
+
+// NodeRecord returns the listen addresses of our NodeInfo signed with our
+// node key, for PEX to relay. A new record is signed when they changed or
+// the last one is older than a day. Addresses with a host name and paths
+// are left out, and the record is nil if we have no other address to advertise.
+func (sw *Switch) NodeRecord() (*NodeRecord, error) {
+	nodeInfo := sw.NodeInfo()
+	addrs := make([]string, 0, 1+len(nodeInfo.ListenAddrs))
+	for _, addr := range append([]string{nodeInfo.ListenAddr}, nodeInfo.ListenAddrs...) {
+		if recordAddr(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	sw.recordMtx.Lock()
+	defer sw.recordMtx.Unlock()
+	if sw.record != nil && sameStrings(sw.record.Addrs, addrs) &&
+		time.Since(sw.record.SignedAt()) < nodeRecordRefreshInterval {
+		return sw.record, nil
+	}
+	// the time keeps Seq increasing across restarts
+	seq := uint64(time.Now().Unix())
+	if sw.record != nil && seq <= sw.record.Seq {
+		seq = sw.record.Seq + 1
+	}
+	record, err := NewNodeRecord(sw.nodeKey.PrivKey, addrs, seq)
+	if err != nil {
+		return nil, err
+	}
+	sw.record = record
+	return record, nil
+}
+
 // SetNodeKey sets the switch's private key for authenticated encryption.
 // NOTE: Not goroutine safe.
 func (sw *Switch) SetNodeKey(nodeKey *NodeKey) {
@@ -187,6 +323,7 @@ func (sw *Switch) OnStart() error {
 	for _, listener := range sw.listeners {
 		go sw.listenerRoutine(listener)
 	}
+	go sw.banListRoutine()
 	return nil
 }
 
@@ -251,24 +388,50 @@ func (sw *Switch) NumPeers() (outbound, inbound, dialing int) {
 	return
 }
 
+// MinNumOutboundPeers returns the number of outbound peers the switch
+// should be kept connected to.
+func (sw *Switch) MinNumOutboundPeers() int {
+	return sw.config.MinOutbound
+}
+
+// IsUnconditional returns true if the peer with the given ID is exempt
+// from the peer limits.
+func (sw *Switch) IsUnconditional(id ID) bool {
+	_, ok := sw.unconditionalPeerIDs[id]
+	return ok
+}
+
 // Peers returns the set of peers that are connected to the switch.
 func (sw *Switch) Peers() IPeerSet {
 	return sw.peers
 }
 
 // StopPeerForError disconnects from a peer due to external error.
+// The error is recorded as a bad event in the peer's trust metric.
 // If the peer is persistent, it will attempt to reconnect.
 // TODO: make record depending on reason.
 func (sw *Switch) StopPeerForError(peer Peer, reason interface{}) {
 	sw.Logger.Error("Stopping peer for error", "peer", peer, "err", reason)
+	if tm := sw.PeerTrustMetric(peer.ID()); tm != nil {
+		tm.BadEvents(1)
+	}
+	if sw.addrBook != nil {
+		sw.addrBook.MarkError(peer.NodeInfo().NetAddress(), fmt.Errorf("%v", reason))
+	}
 	sw.stopAndRemovePeer(peer, reason)
 
 	if peer.IsPersistent() {
 		// NOTE: this is the self-reported addr, not the original we dialed
-		go sw.reconnectToPeer(peer.NodeInfo().NetAddress())
+		go sw.reconnectToPeer(sw.BestPeerAddress(peer.NodeInfo()))
 	}
 }
 
+// BestPeerAddress returns the listen address of the peer described by info
+// that is the most reachable from our own listen addresses.
+func (sw *Switch) BestPeerAddress(info NodeInfo) *NetAddress {
+	return BestNetAddress(sw.NodeInfo().NetAddresses(), info.NetAddresses())
+}
+
 // StopPeerGracefully disconnects from a peer gracefully.
 // TODO: handle graceful disconnects.
 func (sw *Switch) StopPeerGracefully(peer Peer) {
@@ -282,6 +445,9 @@ func (sw *Switch) stopAndRemovePeer(peer Peer, reason interface{}) {
 	for _, reactor := range sw.reactors {
 		reactor.RemovePeer(peer, reason)
 	}
+	if sw.trustStore != nil {
+		sw.trustStore.PeerDisconnected(string(peer.ID()))
+	}
 }
 
 // reconnectToPeer tries to reconnect to the addr, first repeatedly
@@ -347,6 +513,250 @@ func (sw *Switch) MarkPeerAsGood(peer Peer) {
 	if sw.addrBook != nil {
 		sw.addrBook.MarkGood(peer.NodeInfo().NetAddress())
 	}
+	if tm := sw.PeerTrustMetric(peer.ID()); tm != nil {
+		tm.GoodEvents(1)
+	}
+}
+
+//---------------------------------------------------------------------
+// Peer reputation
+
+// ReportBehaviour lets reactors report what a peer did. The behaviour
+// scorer decides whether the switch ignores it, logs it, disconnects the peer
+// or bans it. Useful behaviours also mark the peer as good.
+func (sw *Switch) ReportBehaviour(peer Peer, b PeerBehaviour) {
+	switch sw.scorer.Score(peer.ID(), b) {
+	case ActionIgnore:
+		if b.Kind.useful() {
+			sw.MarkPeerAsGood(peer)
+		}
+	case ActionWarn:
+		sw.Logger.Info("Peer misbehaved", "peer", peer, "behaviour", b)
+		if tm := sw.PeerTrustMetric(peer.ID()); tm != nil {
+			tm.BadEvents(1)
+		}
+	case ActionDisconnect:
+		sw.StopPeerForError(peer, b)
+	case ActionBan:
+		sw.banPeer(peer, b)
+	}
+}
+
+// SetBehaviourScorer replaces the scoring backend of ReportBehaviour.
+// NOTE: Not goroutine safe.
+func (sw *Switch) SetBehaviourScorer(scorer BehaviourScorer) {
+	sw.scorer = scorer
+}
+
+// SetBanList sets the list of banned peers. By default the switch keeps its
+// bans in memory only.
+// NOTE: Not goroutine safe.
+func (sw *Switch) SetBanList(banList *BanList) {
+	sw.banList = banList
+}
+
+// BanList returns the list of banned peers.
+func (sw *Switch) BanList() *BanList {
+	return sw.banList
+}
+
+// IsBanned returns true if the ID or the IP of the given address is banned.
+func (sw *Switch) IsBanned(addr *NetAddress) bool {
+	return sw.banList.IsBannedAddr(addr)
+}
+
+// Ban bans target, a peer ID, an IP or a CIDR range, for duration, or
+// forever if it is 0, and disconnects the peers it covers.
+func (sw *Switch) Ban(target string, duration time.Duration, reason string) error {
+	if err := sw.banList.Ban(target, duration, reason); err != nil {
+		return err
+	}
+	for _, peer := range sw.peers.List() {
+		if sw.banList.IsBanned(peer.ID(), peer.RemoteIP()) {
+			sw.Logger.Info("Disconnecting banned peer", "peer", peer, "reason", reason)
+			sw.stopAndRemovePeer(peer, reason)
+		}
+	}
+	return nil
+}
+
+// banListRoutine drops the expired bans every banPruneInterval, off the
+// path of IsBanned.
+func (sw *Switch) banListRoutine() {
+	ticker := time.NewTicker(banPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sw.banList.PruneExpired()
+		case <-sw.Quit():
+			return
+		}
+	}
+}
+
+// banPeer disconnects the peer, bans its ID and refuses to
+// connect to it again for config.BanTime, even if it is persistent.
+func (sw *Switch) banPeer(peer Peer, reason interface{}) {
+	sw.Logger.Error("Banning peer", "peer", peer, "err", reason)
+	banTime := time.Duration(sw.config.BanTime) * time.Second
+	if err := sw.banList.Ban(string(peer.ID()), banTime, fmt.Sprint(reason)); err != nil {
+		sw.Logger.Error("Failed to ban peer", "peer", peer, "err", err)
+	}
+	if tm := sw.PeerTrustMetric(peer.ID()); tm != nil {
+		tm.BadEvents(1)
+	}
+	sw.stopAndRemovePeer(peer, reason)
+}
+
+// SetTrustMetricStore sets the store used to keep a trust metric per peer ID.
+// The caller is responsible for starting and stopping the store.
+// NOTE: Not goroutine safe.
+func (sw *Switch) SetTrustMetricStore(trustStore *trust.TrustMetricStore) {
+	sw.trustStore = trustStore
+}
+
+// PeerTrustMetric returns the trust metric of the peer with the given ID,
+// creating it if needed. It returns nil if no trust metric store is set.
+// Metrics are only created when an event is recorded, so that peers that
+// merely connect don't fill the store.
+func (sw *Switch) PeerTrustMetric(id ID) *trust.TrustMetric {
+	if sw.trustStore == nil {
+		return nil
+	}
+	return sw.trustStore.GetPeerTrustMetric(string(id))
+}
+
+// peerTrustScore returns the trust score of the peer with the given ID.
+// Peers we have no history for are given the maximum score.
+func (sw *Switch) peerTrustScore(id ID) int {
+	if !sw.hasTrustHistory(id) {
+		return maxTrustScore
+	}
+	return sw.trustStore.GetPeerTrustMetric(string(id)).TrustScore()
+}
+
+// hasTrustHistory returns true if we have a trust metric for the peer with
+// the given ID.
+func (sw *Switch) hasTrustHistory(id ID) bool {
+	return sw.trustStore != nil && sw.trustStore.HasPeerTrustMetric(string(id))
+}
+
+// worstInboundPeer returns the inbound peer with the lowest trust score
+// that may be evicted, and its score. Persistent and unconditional peers
+// are never evicted. It returns nil if there is none.
+func (sw *Switch) worstInboundPeer() (Peer, int) {
+	var (
+		worst      Peer
+		worstScore int
+	)
+	for _, peer := range sw.peers.List() {
+		if peer.IsOutbound() || peer.IsPersistent() || sw.IsUnconditional(peer.ID()) {
+			continue
+		}
+		if score := sw.peerTrustScore(peer.ID()); worst == nil || score < worstScore {
+			worst, worstScore = peer, score
+		}
+	}
+	return worst, worstScore
+}
+
+// evictInboundPeer makes room for the new inbound peer with the given ID by
+// stopping the worst inbound peer, provided the new peer's trust score is
+// higher by at least evictionScoreMargin. A peer we have no history for
+// never evicts another, so fresh IDs cannot push out established peers.
+// It returns true if a peer was evicted.
+func (sw *Switch) evictInboundPeer(id ID) bool {
+	if !sw.hasTrustHistory(id) {
+		return false
+	}
+	worst, worstScore := sw.worstInboundPeer()
+	if worst == nil || worstScore+evictionScoreMargin > sw.peerTrustScore(id) {
+		return false
+	}
+
+	sw.Logger.Info("Evicting inbound peer to make room", "peer", worst, "score", worstScore, "for", id)
+	sw.stopAndRemovePeer(worst, ErrSwitchLowTrustPeer{worst.ID(), worstScore})
+	return true
+}
+
+// numInboundInSubnet returns the number of inbound peers, unconditional
+// ones excluded, in subnet.
+func (sw *Switch) numInboundInSubnet(subnet string) int {
+	n := 0
+	for _, peer := range sw.peers.List() {
+		if peer.IsOutbound() || sw.IsUnconditional(peer.ID()) {
+			continue
+		}
+		if peerIP := peer.RemoteIP(); peerIP != nil && subnetOf(peerIP) == subnet {
+			n++
+		}
+	}
+	return n
+}
+
+// checkInboundConn returns an error if the inbound connection from addr
+// can be refused before the handshake: its address is filtered or banned,
+// too many handshakes are in progress, or the inbound slots or the slots of
+// its subnet are full and no peer could be evicted. As the peer ID is not
+// known yet, the slots are given headroom for the unconditional peers;
+// checkInboundLimits enforces the exact limits after the handshake.
+func (sw *Switch) checkInboundConn(addr net.Addr) error {
+	if err := sw.FilterConnByAddr(addr); err != nil {
+		return err
+	}
+	ip := addrIP(addr)
+	if sw.banList.IsBanned("", ip) {
+		return ErrSwitchBannedPeer
+	}
+
+	pending := int(atomic.LoadInt32(&sw.inboundHandshakes))
+	if pending >= maxInboundHandshakes {
+		return ErrSwitchTooManyPeers
+	}
+	headroom := len(sw.unconditionalPeerIDs)
+	if ip != nil && sw.config.MaxInboundPerSubnet > 0 {
+		if subnet := subnetOf(ip); sw.numInboundInSubnet(subnet) >= sw.config.MaxInboundPerSubnet+headroom {
+			return ErrSwitchSubnetFull{subnet}
+		}
+	}
+	_, inbound, _ := sw.NumPeers()
+	if inbound+pending >= sw.config.MaxInbound+headroom {
+		// only a peer with the maximum score could evict the worst one
+		if worst, worstScore := sw.worstInboundPeer(); worst == nil || worstScore+evictionScoreMargin > maxTrustScore {
+			return ErrSwitchTooManyPeers
+		}
+	}
+	return nil
+}
+
+// checkInboundLimits returns an error if the inbound peer with the given ID
+// and IP must be refused because of the per subnet cap or because the
+// inbound slots are full and no worse peer can be evicted.
+func (sw *Switch) checkInboundLimits(id ID, ip net.IP) error {
+	if sw.IsUnconditional(id) {
+		return nil
+	}
+
+	if ip != nil && sw.config.MaxInboundPerSubnet > 0 {
+		if subnet := subnetOf(ip); sw.numInboundInSubnet(subnet) >= sw.config.MaxInboundPerSubnet {
+			return ErrSwitchSubnetFull{subnet}
+		}
+	}
+
+	_, inbound, _ := sw.NumPeers()
+	if inbound >= sw.config.MaxInbound && !sw.evictInboundPeer(id) {
+		return ErrSwitchTooManyPeers
+	}
+	return nil
+}
+
+// subnetOf returns the /24 of an IPv4 address or the /48 of an IPv6 one.
+func subnetOf(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(subnetBitsIPv4, 32)), Mask: net.CIDRMask(subnetBitsIPv4, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(subnetBitsIPv6, 128)), Mask: net.CIDRMask(subnetBitsIPv6, 128)}).String()
 }
 
 //---------------------------------------------------------------------
@@ -367,7 +777,7 @@ func (sw *Switch) DialPeersAsync(addrBook AddrBook, peers []string, persistent b
 		sw.Logger.Error("Error in peer's address", "err", err)
 	}
 
-	ourAddr := sw.nodeInfo.NetAddress()
+	ourAddr := sw.NodeInfo().NetAddress()
 
 	// TODO: this code feels like it's in the wrong place.
 	// The integration tests depend on the addrBook being saved
@@ -417,7 +827,33 @@ func (sw *Switch) DialPeersAsync(addrBook AddrBook, peers []string, persistent b
 
 // DialPeerWithAddress dials the given peer and runs sw.addPeer if it connects and authenticates successfully.
 // If `persistent == true`, the switch will always try to reconnect to this peer if the connection ever fails.
+// Non-persistent peers whose trust score is below config.MinTrustScore are not dialed.
 func (sw *Switch) DialPeerWithAddress(addr *NetAddress, persistent bool) error {
+	return sw.dialPeerWithAddress(addr, persistent, sw.config.MaxOutbound)
+}
+
+// DialFeelerWithAddress dials the given peer like DialPeerWithAddress, for
+// a feeler connection. Feelers have an outbound slot of their own on top of
+// config.MaxOutbound, so addresses are still tested once the outbound
+// peers are all connected. The caller disconnects the peer when done.
+func (sw *Switch) DialFeelerWithAddress(addr *NetAddress) error {
+	return sw.dialPeerWithAddress(addr, false, sw.config.MaxOutbound+1)
+}
+
+func (sw *Switch) dialPeerWithAddress(addr *NetAddress, persistent bool, maxOutbound int) error {
+	if sw.IsBanned(addr) {
+		return ErrSwitchBannedPeer
+	}
+	if !sw.IsUnconditional(addr.ID) {
+		if out, _, dialing := sw.NumPeers(); out+dialing >= maxOutbound {
+			return ErrSwitchTooManyPeers
+		}
+	}
+	if !persistent {
+		if score := sw.peerTrustScore(addr.ID); score < sw.config.MinTrustScore {
+			return ErrSwitchLowTrustPeer{addr.ID, score}
+		}
+	}
 	sw.dialing.Set(string(addr.ID), addr)
 	defer sw.dialing.Delete(string(addr.ID))
 	return sw.addOutboundPeerWithConfig(addr, sw.peerConfig, persistent)
@@ -468,20 +904,24 @@ func (sw *Switch) listenerRoutine(l Listener) {
 			break
 		}
 
-		// ignore connection if we already have enough
-		// leave room for MinNumOutboundPeers
-		maxPeers := sw.config.MaxNumPeers - DefaultMinNumOutboundPeers
-		if maxPeers <= sw.peers.Size() {
-			sw.Logger.Info("Ignoring inbound connection: already have enough peers", "address", inConn.RemoteAddr().String(), "numPeers", sw.peers.Size(), "max", maxPeers)
+		// Refuse what we can before the handshake, which is costly
+		if err := sw.checkInboundConn(inConn.RemoteAddr()); err != nil {
+			sw.Logger.Info("Ignoring inbound connection", "address", inConn.RemoteAddr().String(), "err", err)
+			inConn.Close() // nolint: errcheck
 			continue
 		}
 
-		// New inbound connection!
-		err := sw.addInboundPeerWithConfig(inConn, sw.peerConfig)
-		if err != nil {
-			sw.Logger.Info("Ignoring inbound connection: error while adding peer", "address", inConn.RemoteAddr().String(), "err", err)
-			continue
-		}
+		// New inbound connection! The handshakes run concurrently, and the
+		// inbound limits are checked again by addPeer, once we know the
+		// peer ID
+		atomic.AddInt32(&sw.inboundHandshakes, 1)
+		go func(inConn net.Conn) {
+			defer atomic.AddInt32(&sw.inboundHandshakes, -1)
+			err := sw.addInboundPeerWithConfig(inConn, sw.peerConfig)
+			if err != nil {
+				sw.Logger.Info("Ignoring inbound connection: error while adding peer", "address", inConn.RemoteAddr().String(), "err", err)
+			}
+		}(inConn)
 	}
 
 	// cleanup
@@ -507,8 +947,15 @@ func (sw *Switch) addInboundPeerWithConfig(conn net.Conn, config *PeerConfig) er
 // If peer is started succesffuly, reconnectLoop will start when StopPeerForError is called
 func (sw *Switch) addOutboundPeerWithConfig(addr *NetAddress, config *PeerConfig, persistent bool) error {
 	sw.Logger.Info("Dialing peer", "address", addr)
-	peerConn, err := newOutboundPeerConn(addr, config, persistent, sw.nodeKey.PrivKey)
+	transport := sw.Transport(addr.Scheme)
+	if transport == nil {
+		return ErrTransportNotFound{addr.Scheme}
+	}
+	peerConn, err := newOutboundPeerConn(addr, transport, config, persistent, sw.nodeKey.PrivKey)
 	if err != nil {
+		if sw.addrBook != nil {
+			sw.addrBook.MarkError(addr, err)
+		}
 		if persistent {
 			go sw.reconnectToPeer(addr)
 		}
@@ -516,6 +963,9 @@ func (sw *Switch) addOutboundPeerWithConfig(addr *NetAddress, config *PeerConfig
 	}
 
 	if err := sw.addPeer(peerConn); err != nil {
+		if sw.addrBook != nil {
+			sw.addrBook.MarkError(addr, err)
+		}
 		peerConn.CloseConn()
 		return err
 	}
@@ -534,15 +984,25 @@ func (sw *Switch) addPeer(pc peerConn) error {
 		return err
 	}
 
+	// Refuse banned IPs before the handshake
+	remoteIP := addrIP(addr)
+	if sw.banList.IsBanned("", remoteIP) {
+		return ErrSwitchBannedPeer
+	}
+
 	// NOTE: if AuthEnc==false, we don't have a peerID until after the handshake.
 	// If AuthEnc==true then we already know the ID and could do the checks first before the handshake,
 	// but it's simple to just deal with both cases the same after the handshake.
 
 	// Exchange NodeInfo on the conn
-	peerNodeInfo, err := pc.HandshakeTimeout(sw.nodeInfo, time.Duration(sw.peerConfig.HandshakeTimeout*time.Second))
+	ourNodeInfo := sw.NodeInfo()
+	handshakeStart := time.Now()
+	peerNodeInfo, err := pc.HandshakeTimeout(ourNodeInfo, time.Duration(sw.peerConfig.HandshakeTimeout*time.Second))
 	if err != nil {
 		return err
 	}
+	// the handshake is a round trip
+	latency := time.Since(handshakeStart)
 
 	peerID := peerNodeInfo.ID
 
@@ -580,16 +1040,28 @@ func (sw *Switch) addPeer(pc peerConn) error {
 		return ErrSwitchDuplicatePeer
 	}
 
+	// Refuse banned peers
+	if sw.banList.IsBanned(peerID, remoteIP) {
+		return ErrSwitchBannedPeer
+	}
+
 	// Filter peer against ID white list
 	if err := sw.FilterConnByID(peerID); err != nil {
 		return err
 	}
 
 	// Check version, chain id
-	if err := sw.nodeInfo.CompatibleWith(peerNodeInfo); err != nil {
+	if err := ourNodeInfo.CompatibleWith(peerNodeInfo); err != nil {
 		return err
 	}
 
+	// Check the inbound limits, making room for the peer if needed
+	if !pc.outbound {
+		if err := sw.checkInboundLimits(peerID, remoteIP); err != nil {
+			return err
+		}
+	}
+
 	peer := newPeer(pc, peerNodeInfo, sw.reactorsByCh, sw.chDescs, sw.StopPeerForError)
 	peer.SetLogger(sw.Logger.With("peer", addr))
 
@@ -609,10 +1081,26 @@ func (sw *Switch) addPeer(pc peerConn) error {
 		return err
 	}
 
+	if sw.addrBook != nil {
+		sw.addrBook.MarkConnected(peerNodeInfo.NetAddress(), latency, peerNodeInfo.Capabilities)
+	}
+
 	sw.Logger.Info("Added peer", "peer", peer)
 	return nil
 }
 
+// addrIP returns the IP of addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
 func (sw *Switch) startInitPeer(peer *peer) error {
 	err := peer.Start() // spawn send/recv routines
 	if err != nil {
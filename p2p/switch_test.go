@@ -0,0 +1,175 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	cfg "github.com/go-fusion/config"
+	tmconn "github.com/go-fusion/p2p/conn"
+	"github.com/go-fusion/p2p/trust"
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+)
+
+type mockPeer struct {
+	cmn.BaseService
+	id       ID
+	ip       net.IP
+	outbound bool
+}
+
+func newMockPeer(id ID, ip string, outbound bool) *mockPeer {
+	p := &mockPeer{id: id, ip: net.ParseIP(ip), outbound: outbound}
+	p.BaseService = *cmn.NewBaseService(nil, "MockPeer", p)
+	return p
+}
+
+func (p *mockPeer) ID() ID                          { return p.id }
+func (p *mockPeer) IsOutbound() bool                { return p.outbound }
+func (p *mockPeer) IsPersistent() bool              { return false }
+func (p *mockPeer) NodeInfo() NodeInfo              { return NodeInfo{ID: p.id} }
+func (p *mockPeer) RemoteIP() net.IP                { return p.ip }
+func (p *mockPeer) Status() tmconn.ConnectionStatus { return tmconn.ConnectionStatus{} }
+func (p *mockPeer) Send(byte, []byte) bool          { return true }
+func (p *mockPeer) TrySend(byte, []byte) bool       { return true }
+func (p *mockPeer) Set(string, interface{})         {}
+func (p *mockPeer) Get(string) interface{}          { return nil }
+
+func mockID(i int) ID {
+	return ID(fmt.Sprintf("%040x", i))
+}
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 46656}
+}
+
+// newLimitedSwitch returns a switch with maxInbound inbound peers, each in
+// its own subnet, and a trust store.
+func newLimitedSwitch(t *testing.T, maxInbound int) (*Switch, []*mockPeer) {
+	config := cfg.DefaultP2PConfig()
+	config.MaxInbound = maxInbound
+	config.MaxInboundPerSubnet = 2
+	sw := NewSwitch(config)
+	sw.SetTrustMetricStore(trust.NewTrustMetricStore(dbm.NewMemDB(), trust.DefaultConfig()))
+
+	var peers []*mockPeer
+	for i := 0; i < maxInbound; i++ {
+		peer := newMockPeer(mockID(i), fmt.Sprintf("10.0.%d.1", i), false)
+		if err := sw.peers.Add(peer); err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+	return sw, peers
+}
+
+func TestSwitchCheckInboundConn(t *testing.T) {
+	sw, peers := newLimitedSwitch(t, 3)
+
+	// the inbound slots are full of peers with the maximum score
+	if err := sw.checkInboundConn(tcpAddr("10.1.0.1")); err != ErrSwitchTooManyPeers {
+		t.Errorf("full slots: got %v, want %v", err, ErrSwitchTooManyPeers)
+	}
+
+	// a peer that could be evicted leaves room for the handshake
+	sw.PeerTrustMetric(peers[0].ID()).BadEvents(10)
+	if err := sw.checkInboundConn(tcpAddr("10.1.0.1")); err != nil {
+		t.Errorf("evictable peer: got %v", err)
+	}
+
+	// the subnet cap is checked before the handshake
+	sw.peers.Remove(peers[1])
+	peer := newMockPeer(mockID(10), "10.0.2.2", false)
+	if err := sw.peers.Add(peer); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.checkInboundConn(tcpAddr("10.0.2.3")); err == nil {
+		t.Error("full subnet: expected an error")
+	}
+
+	// banned IPs are refused
+	if err := sw.banList.Ban("10.2.0.1", 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.checkInboundConn(tcpAddr("10.2.0.1")); err != ErrSwitchBannedPeer {
+		t.Errorf("banned IP: got %v, want %v", err, ErrSwitchBannedPeer)
+	}
+}
+
+func TestSwitchCheckInboundConnHandshakes(t *testing.T) {
+	sw, _ := newLimitedSwitch(t, 0)
+	sw.config.MaxInbound = 2 * maxInboundHandshakes
+
+	sw.inboundHandshakes = maxInboundHandshakes - 1
+	if err := sw.checkInboundConn(tcpAddr("10.1.0.1")); err != nil {
+		t.Errorf("got %v", err)
+	}
+	sw.inboundHandshakes = maxInboundHandshakes
+	if err := sw.checkInboundConn(tcpAddr("10.1.0.1")); err != ErrSwitchTooManyPeers {
+		t.Errorf("too many handshakes: got %v, want %v", err, ErrSwitchTooManyPeers)
+	}
+}
+
+func TestSwitchEvictInboundPeer(t *testing.T) {
+	sw, peers := newLimitedSwitch(t, 2)
+	sw.PeerTrustMetric(peers[0].ID()).BadEvents(10)
+	worstScore := sw.peerTrustScore(peers[0].ID())
+	if worstScore+evictionScoreMargin > maxTrustScore {
+		t.Fatalf("score %d is too high for the test", worstScore)
+	}
+
+	// a peer we have no history for never evicts another
+	if err := sw.checkInboundLimits(mockID(10), net.ParseIP("10.1.0.1")); err != ErrSwitchTooManyPeers {
+		t.Errorf("unknown ID: got %v, want %v", err, ErrSwitchTooManyPeers)
+	}
+	if !sw.peers.Has(peers[0].ID()) {
+		t.Fatal("unknown ID evicted a peer")
+	}
+
+	// nor does a known peer without the margin
+	sw.PeerTrustMetric(mockID(11)).BadEvents(10)
+	if err := sw.checkInboundLimits(mockID(11), net.ParseIP("10.1.0.1")); err != ErrSwitchTooManyPeers {
+		t.Errorf("no margin: got %v, want %v", err, ErrSwitchTooManyPeers)
+	}
+
+	// a known good peer evicts the worst one
+	sw.PeerTrustMetric(mockID(12)).GoodEvents(10)
+	if err := sw.checkInboundLimits(mockID(12), net.ParseIP("10.1.0.1")); err != nil {
+		t.Fatalf("good peer: got %v", err)
+	}
+	if sw.peers.Has(peers[0].ID()) {
+		t.Error("the worst peer was not evicted")
+	}
+	if !sw.peers.Has(peers[1].ID()) {
+		t.Error("the wrong peer was evicted")
+	}
+}
+
+func TestSwitchDialFeelerSlot(t *testing.T) {
+	sw, _ := newLimitedSwitch(t, 0)
+	sw.config.MaxOutbound = 1
+	sw.SetNodeKey(&NodeKey{PrivKey: crypto.GenPrivKeyEd25519()})
+	if err := sw.peers.Add(newMockPeer(mockID(0), "10.0.0.1", true)); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := NewNetAddressString(fmt.Sprintf("%v@127.0.0.1:1", mockID(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the outbound slots are full, but for the feeler
+	if err := sw.DialPeerWithAddress(addr, false); err != ErrSwitchTooManyPeers {
+		t.Errorf("outbound slots full: got %v, want %v", err, ErrSwitchTooManyPeers)
+	}
+	if err := sw.DialFeelerWithAddress(addr); err == nil || IsLocalDialError(err) {
+		t.Errorf("expected the feeler dialed, got %v", err)
+	}
+
+	// which there is one of
+	sw.dialing.Set(string(mockID(2)), addr)
+	if err := sw.DialFeelerWithAddress(addr); err != ErrSwitchTooManyPeers {
+		t.Errorf("feeler slot taken: got %v, want %v", err, ErrSwitchTooManyPeers)
+	}
+}
@@ -0,0 +1,194 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	tmconn "github.com/go-fusion/p2p/conn"
+)
+
+// Address schemes of the transports shipped with the switch.
+const (
+	SchemeTCP       = "tcp"
+	SchemeTCP4      = "tcp4"
+	SchemeTCP6      = "tcp6"
+	SchemeUnix      = "unix"
+	SchemeMemory    = "memory"
+	SchemeWebSocket = "ws"
+)
+
+// Transport dials and listens for the raw connections of one address
+// scheme. The switch runs the secret connection handshake on top.
+type Transport interface {
+	// Scheme returns the address scheme served by the transport.
+	Scheme() string
+
+	// Dial connects to addr, giving up after timeout.
+	Dial(addr *NetAddress, timeout time.Duration) (net.Conn, error)
+
+	// Listen listens on laddr, the address without its scheme,
+	// e.g. "0.0.0.0:12345" or "/var/run/fusiond.sock".
+	Listen(laddr string) (net.Listener, error)
+}
+
+// DefaultTransports returns the transports a new switch starts with.
+func DefaultTransports() []Transport {
+	return []Transport{
+		NewTCPTransport(SchemeTCP),
+		NewTCPTransport(SchemeTCP4),
+		NewTCPTransport(SchemeTCP6),
+		NewUnixTransport(),
+		NewMemoryTransport(),
+		NewWebSocketTransport(),
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+var errOnionWithoutProxy = errors.New("onion addresses can only be dialed through a proxy")
+
+type tcpTransport struct {
+	network string
+}
+
+// NewTCPTransport returns a transport over the TCP network,
+// which is one of "tcp", "tcp4" or "tcp6".
+func NewTCPTransport(network string) Transport {
+	return tcpTransport{network}
+}
+
+func (t tcpTransport) Scheme() string {
+	return t.network
+}
+
+func (t tcpTransport) Dial(addr *NetAddress, timeout time.Duration) (net.Conn, error) {
+	if addr.Onion() {
+		return nil, errOnionWithoutProxy
+	}
+	return net.DialTimeout(t.network, addr.DialString(), timeout)
+}
+
+func (t tcpTransport) Listen(laddr string) (net.Listener, error) {
+	return net.Listen(t.network, laddr)
+}
+
+//-----------------------------------------------------------------------------
+
+type unixTransport struct{}
+
+// NewUnixTransport returns a transport over Unix domain sockets,
+// for sidecars running on the same host.
+func NewUnixTransport() Transport {
+	return unixTransport{}
+}
+
+func (unixTransport) Scheme() string {
+	return SchemeUnix
+}
+
+func (unixTransport) Dial(addr *NetAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", addr.Path, timeout)
+}
+
+func (unixTransport) Listen(laddr string) (net.Listener, error) {
+	return net.Listen("unix", laddr)
+}
+
+//-----------------------------------------------------------------------------
+
+var (
+	errMemoryListenerClosed = errors.New("memory listener closed")
+	errMemoryAddrInUse      = errors.New("memory address already in use")
+	errMemoryConnRefused    = errors.New("memory connection refused")
+	errMemoryDialTimeout    = errors.New("memory dial timed out")
+)
+
+// memory listeners by name, shared by all the switches of the process
+var memoryListeners = struct {
+	mtx sync.Mutex
+	ls  map[string]*memoryListener
+}{ls: make(map[string]*memoryListener)}
+
+type memoryTransport struct{}
+
+// NewMemoryTransport returns a transport over in-process pipes made by
+// conn.NetPipe. Its addresses are arbitrary names, e.g. "memory://node0",
+// shared by the whole process, which makes it handy for hermetic tests.
+func NewMemoryTransport() Transport {
+	return memoryTransport{}
+}
+
+func (memoryTransport) Scheme() string {
+	return SchemeMemory
+}
+
+func (memoryTransport) Dial(addr *NetAddress, timeout time.Duration) (net.Conn, error) {
+	memoryListeners.mtx.Lock()
+	l, ok := memoryListeners.ls[addr.Path]
+	memoryListeners.mtx.Unlock()
+	if !ok {
+		return nil, errMemoryConnRefused
+	}
+
+	ours, theirs := tmconn.NetPipe()
+	select {
+	case l.conns <- theirs:
+		return ours, nil
+	case <-l.closed:
+		return nil, errMemoryConnRefused
+	case <-time.After(timeout):
+		return nil, errMemoryDialTimeout
+	}
+}
+
+func (memoryTransport) Listen(laddr string) (net.Listener, error) {
+	memoryListeners.mtx.Lock()
+	defer memoryListeners.mtx.Unlock()
+	if _, ok := memoryListeners.ls[laddr]; ok {
+		return nil, errMemoryAddrInUse
+	}
+	l := &memoryListener{
+		name:   laddr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	memoryListeners.ls[laddr] = l
+	return l, nil
+}
+
+type memoryListener struct {
+	name      string
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errMemoryListenerClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		memoryListeners.mtx.Lock()
+		delete(memoryListeners.ls, l.name)
+		memoryListeners.mtx.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr(l.name)
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return SchemeMemory }
+func (a memoryAddr) String() string  { return string(a) }
@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type socks5Transport struct {
+	network        string
+	proxyAddr      string
+	isolateStreams bool
+}
+
+// NewSOCKS5Transport returns a TCP transport for the network ("tcp",
+// "tcp4" or "tcp6") that dials through the SOCKS5 proxy at proxyAddr, e.g.
+// Tor at "127.0.0.1:9050". Host names, .onion ones included, are resolved
+// by the proxy.
+// isolateStreams: use different proxy credentials for each peer, so Tor
+// builds a separate circuit for each of them.
+// Listening is not proxied.
+func NewSOCKS5Transport(network, proxyAddr string, isolateStreams bool) Transport {
+	return socks5Transport{network, proxyAddr, isolateStreams}
+}
+
+func (t socks5Transport) Scheme() string {
+	return t.network
+}
+
+func (t socks5Transport) Dial(addr *NetAddress, timeout time.Duration) (net.Conn, error) {
+	var auth *proxy.Auth
+	if t.isolateStreams {
+		auth = &proxy.Auth{User: addr.String(), Password: "fusiond"}
+	}
+	dialer, err := proxy.SOCKS5("tcp", t.proxyAddr, auth, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	// the SOCKS handshake must not hang either
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, t.network, addr.DialString())
+	if err != nil {
+		return nil, err
+	}
+	return &proxiedConn{conn, proxiedAddr{addr}}, nil
+}
+
+func (t socks5Transport) Listen(laddr string) (net.Listener, error) {
+	return net.Listen(t.network, laddr)
+}
+
+// proxiedConn reports the address of the peer as its remote address instead
+// of the one of the proxy, so the switch filters and bans the peer and not
+// the proxy.
+type proxiedConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+type proxiedAddr struct {
+	addr *NetAddress
+}
+
+func (a proxiedAddr) Network() string { return "tcp" }
+func (a proxiedAddr) String() string  { return a.addr.DialString() }
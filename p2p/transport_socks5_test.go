@@ -0,0 +1,195 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// socks5Request is what the stub proxy got from a client.
+type socks5Request struct {
+	user   string // empty without authentication
+	target string // host:port
+}
+
+// serveSOCKS5 serves a single connection as a SOCKS5 proxy connecting to
+// nothing: it answers the CONNECT request and then echoes what it reads.
+func serveSOCKS5(conn net.Conn) (req socks5Request, err error) {
+	defer conn.Close() // nolint: errcheck
+
+	// greeting: VER NMETHODS METHODS
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	methods := make([]byte, buf[1])
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(0x00)
+	if bytes.IndexByte(methods, 0x02) >= 0 {
+		method = 0x02
+	}
+	if _, err = conn.Write([]byte{0x05, method}); err != nil {
+		return
+	}
+
+	// username/password: VER ULEN UNAME PLEN PASSWD
+	if method == 0x02 {
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		if _, err = io.ReadFull(conn, user); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(conn, make([]byte, buf[0])); err != nil {
+			return
+		}
+		req.user = string(user)
+		if _, err = conn.Write([]byte{0x01, 0x00}); err != nil {
+			return
+		}
+	}
+
+	// request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if head[1] != 0x01 {
+		return req, errors.New("not a CONNECT request")
+	}
+	var host string
+	switch head[3] {
+	case 0x01, 0x04:
+		ip := make(net.IP, 4)
+		if head[3] == 0x04 {
+			ip = make(net.IP, 16)
+		}
+		if _, err = io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case 0x03:
+		if _, err = io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		name := make([]byte, buf[0])
+		if _, err = io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return req, errors.New("unknown address type")
+	}
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	req.target = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf))))
+	if _, err = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	_, err = io.Copy(conn, conn)
+	return req, err
+}
+
+// startSOCKS5 runs the stub proxy and returns its address and the requests
+// it serves.
+func startSOCKS5(t *testing.T) (string, <-chan socks5Request) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := make(chan socks5Request, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := serveSOCKS5(conn)
+				if err != nil {
+					t.Error(err)
+				}
+				reqs <- req
+			}()
+		}
+	}()
+	return ln.Addr().String(), reqs
+}
+
+func dialSOCKS5(t *testing.T, transport Transport, addr string) *NetAddress {
+	netAddr, err := NewNetAddressStringWithOptionalID(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := transport.Dial(netAddr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the peer, not the proxy, is the remote address
+	if conn.RemoteAddr().String() != netAddr.DialString() {
+		t.Errorf("remote address %v, want %v", conn.RemoteAddr(), netAddr.DialString())
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("got %q through the proxy", buf)
+	}
+	conn.Close() // nolint: errcheck
+	return netAddr
+}
+
+func TestSOCKS5Transport(t *testing.T) {
+	proxyAddr, reqs := startSOCKS5(t)
+	transport := NewSOCKS5Transport(SchemeTCP, proxyAddr, false)
+
+	const onion = "expyuzz4wqqyqhjn.onion:12345"
+	for _, target := range []string{"8.8.8.8:12345", onion} {
+		dialSOCKS5(t, transport, target)
+		req := <-reqs
+		if req.target != target {
+			t.Errorf("proxy connected to %v, want %v", req.target, target)
+		}
+		if req.user != "" {
+			t.Errorf("%v: authenticated as %q without isolation", target, req.user)
+		}
+	}
+}
+
+func TestSOCKS5TransportIsolateStreams(t *testing.T) {
+	proxyAddr, reqs := startSOCKS5(t)
+	transport := NewSOCKS5Transport(SchemeTCP, proxyAddr, true)
+
+	users := make(map[string]bool)
+	for _, target := range []string{"8.8.8.8:12345", "9.9.9.9:12345"} {
+		netAddr := dialSOCKS5(t, transport, target)
+		req := <-reqs
+		if req.target != target {
+			t.Errorf("proxy connected to %v, want %v", req.target, target)
+		}
+		if req.user != netAddr.String() {
+			t.Errorf("authenticated as %q, want %q", req.user, netAddr.String())
+		}
+		users[req.user] = true
+	}
+	if len(users) != 2 {
+		t.Error("the peers share proxy credentials")
+	}
+}
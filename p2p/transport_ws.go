@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+var errWebSocketListenerClosed = errors.New("websocket listener closed")
+
+const (
+	// time allowed to read and answer the WebSocket handshake, and to keep
+	// an idle HTTP connection without one
+	webSocketHandshakeTimeout = 10 * time.Second
+)
+
+type webSocketTransport struct{}
+
+// NewWebSocketTransport returns a transport carrying the connection in
+// binary WebSocket frames, e.g. to "ws://1.2.3.4:8080/p2p", so browser
+// based light nodes can connect.
+func NewWebSocketTransport() Transport {
+	return webSocketTransport{}
+}
+
+func (webSocketTransport) Scheme() string {
+	return SchemeWebSocket
+}
+
+func (webSocketTransport) Dial(addr *NetAddress, timeout time.Duration) (net.Conn, error) {
+	hostPort, path := splitPath(addr.DialString())
+	config, err := websocket.NewConfig("ws://"+hostPort+path, "http://"+hostPort+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	rawConn, err := net.DialTimeout("tcp", hostPort, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// the WebSocket handshake must not hang either
+	rawConn.SetDeadline(time.Now().Add(timeout)) // nolint: errcheck
+	ws, err := websocket.NewClient(config, rawConn)
+	if err != nil {
+		rawConn.Close() // nolint: errcheck
+		return nil, err
+	}
+	rawConn.SetDeadline(time.Time{}) // nolint: errcheck
+
+	ws.PayloadType = websocket.BinaryFrame
+	return &webSocketConn{
+		Conn:       ws,
+		localAddr:  rawConn.LocalAddr(),
+		remoteAddr: rawConn.RemoteAddr(),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Listen serves WebSocket connections on the HTTP path of laddr,
+// "/" if it has none.
+func (webSocketTransport) Listen(laddr string) (net.Listener, error) {
+	hostPort, path := splitPath(laddr)
+	if path == "" {
+		path = "/"
+	}
+	ln, err := net.Listen("tcp", hostPort)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &webSocketListener{
+		ln:     ln,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	// no Handshake, non-browser peers do not send an Origin
+	mux.Handle(path, websocket.Server{Handler: l.handle})
+	l.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: webSocketHandshakeTimeout,
+		ReadTimeout:       webSocketHandshakeTimeout,
+		WriteTimeout:      webSocketHandshakeTimeout,
+		IdleTimeout:       webSocketHandshakeTimeout,
+	}
+	go l.srv.Serve(ln) // nolint: errcheck
+	return l, nil
+}
+
+type webSocketListener struct {
+	ln        net.Listener
+	srv       *http.Server
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Runs for as long as the connection is open, as the http server closes
+// it when the handler returns.
+func (l *webSocketListener) handle(ws *websocket.Conn) {
+	// the deadlines of the http server only bound the handshake
+	ws.SetDeadline(time.Time{}) // nolint: errcheck
+	ws.PayloadType = websocket.BinaryFrame
+	remoteAddr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr)
+	if err != nil {
+		return
+	}
+	conn := &webSocketConn{
+		Conn:       ws,
+		localAddr:  l.ln.Addr(),
+		remoteAddr: remoteAddr,
+		done:       make(chan struct{}),
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		return
+	}
+	<-conn.done
+}
+
+func (l *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errWebSocketListenerClosed
+	}
+}
+
+// Close stops accepting connections. Accepted connections stay open.
+func (l *webSocketListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.srv.Close()
+	})
+	return err
+}
+
+func (l *webSocketListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// webSocketConn reports the addresses of the underlying TCP connection,
+// so the switch can filter and ban the peer by IP.
+type webSocketConn struct {
+	*websocket.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func (c *webSocketConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *webSocketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// splitPath splits "host:port/path" into "host:port" and "/path".
+func splitPath(addr string) (string, string) {
+	if i := strings.Index(addr, "/"); i >= 0 {
+		return addr[:i], addr[i:]
+	}
+	return addr, ""
+}
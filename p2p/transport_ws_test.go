@@ -0,0 +1,58 @@
+package p2p
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	transport := NewWebSocketTransport()
+	ln, err := transport.Listen("127.0.0.1:0/p2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close() // nolint: errcheck
+
+	addr, err := NewNetAddressStringWithOptionalID("ws://" + ln.Addr().String() + "/p2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		defer conn.Close() // nolint: errcheck
+		// echo
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			accepted <- err
+			return
+		}
+		_, err = conn.Write(buf)
+		accepted <- err
+	}()
+
+	conn, err := transport.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() // nolint: errcheck
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte("hello")) {
+		t.Errorf("got %q", buf)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+}
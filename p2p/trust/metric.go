@@ -172,6 +172,14 @@ func (tm *TrustMetric) Pause() {
 	tm.paused = true
 }
 
+// IsPaused returns true if the metric is paused, until the next event
+func (tm *TrustMetric) IsPaused() bool {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+
+	return tm.paused
+}
+
 // BadEvents indicates that an undesirable event(s) took place
 func (tm *TrustMetric) BadEvents(num int) {
 	tm.mtx.Lock()
@@ -12,7 +12,17 @@ import (
 	dbm "github.com/tendermint/tmlibs/db"
 )
 
-const defaultStorePeriodicSaveInterval = 1 * time.Minute
+const (
+	defaultStorePeriodicSaveInterval = 1 * time.Minute
+
+	// defaultStoreMaxSize bounds the number of trust metrics in the store.
+	// Past it, paused metrics are evicted to make room for new ones
+	defaultStoreMaxSize = 1000
+
+	// neutralTrustScore is the score of a metric with nothing held against
+	// its peer, which is evicted first
+	neutralTrustScore = 100
+)
 
 var trustMetricKey = []byte("trustMetricStore")
 
@@ -23,6 +33,12 @@ type TrustMetricStore struct {
 	// Maps a Peer.Key to that peer's TrustMetric
 	peerMetrics map[string]*TrustMetric
 
+	// Maps a Peer.Key to the last time its TrustMetric was used
+	lastUsed map[string]time.Time
+
+	// The number of trust metrics past which paused ones are evicted
+	maxSize int
+
 	// Mutex that protects the map and history data file
 	mtx sync.Mutex
 
@@ -39,6 +55,8 @@ type TrustMetricStore struct {
 func NewTrustMetricStore(db dbm.DB, tmc TrustMetricConfig) *TrustMetricStore {
 	tms := &TrustMetricStore{
 		peerMetrics: make(map[string]*TrustMetric),
+		lastUsed:    make(map[string]time.Time),
+		maxSize:     defaultStoreMaxSize,
 		db:          db,
 		config:      tmc,
 	}
@@ -94,10 +112,13 @@ func (tms *TrustMetricStore) AddPeerTrustMetric(key string, tm *TrustMetric) {
 	if key == "" || tm == nil {
 		return
 	}
-	tms.peerMetrics[key] = tm
+	tms.add(key, tm)
 }
 
-// GetPeerTrustMetric returns a trust metric by peer key
+// GetPeerTrustMetric returns a trust metric by peer key.
+// The metric is created if needed, so callers should only get one when
+// recording an event, and use HasPeerTrustMetric otherwise. Creating a
+// metric may evict a paused one to keep the store within its size
 func (tms *TrustMetricStore) GetPeerTrustMetric(key string) *TrustMetric {
 	tms.mtx.Lock()
 	defer tms.mtx.Unlock()
@@ -108,11 +129,22 @@ func (tms *TrustMetricStore) GetPeerTrustMetric(key string) *TrustMetric {
 		tm = NewMetricWithConfig(tms.config)
 		tm.Start()
 		// The metric needs to be in the map
-		tms.peerMetrics[key] = tm
+		tms.add(key, tm)
+		return tm
 	}
+	tms.lastUsed[key] = time.Now()
 	return tm
 }
 
+// HasPeerTrustMetric returns true if the store holds a trust metric for the peer key
+func (tms *TrustMetricStore) HasPeerTrustMetric(key string) bool {
+	tms.mtx.Lock()
+	defer tms.mtx.Unlock()
+
+	_, ok := tms.peerMetrics[key]
+	return ok
+}
+
 // PeerDisconnected pauses the trust metric associated with the peer identified by the key
 func (tms *TrustMetricStore) PeerDisconnected(key string) {
 	tms.mtx.Lock()
@@ -121,6 +153,7 @@ func (tms *TrustMetricStore) PeerDisconnected(key string) {
 	// If the Peer that disconnected has a metric, pause it
 	if tm, ok := tms.peerMetrics[key]; ok {
 		tm.Pause()
+		tms.lastUsed[key] = time.Now()
 	}
 }
 
@@ -140,6 +173,53 @@ func (tms *TrustMetricStore) size() int {
 	return len(tms.peerMetrics)
 }
 
+// add associates tm with key, evicting paused metrics first if the store
+// is full
+func (tms *TrustMetricStore) add(key string, tm *TrustMetric) {
+	if old, ok := tms.peerMetrics[key]; ok && old != tm {
+		old.Stop()
+	}
+	tms.peerMetrics[key] = tm
+	tms.lastUsed[key] = time.Now()
+	for tms.size() > tms.maxSize {
+		if !tms.evict(key) {
+			break
+		}
+	}
+}
+
+// evict stops and removes the least recently used paused metric, other
+// than the one of keep. Metrics with a neutral score, which hold nothing
+// against their peer, go before the others, so that churning through peer
+// keys does not make us forget the peers that misbehaved.
+// Returns false if no metric is paused: metrics of connected peers, whose
+// number is bounded by the switch, are never evicted
+func (tms *TrustMetricStore) evict(keep string) bool {
+	var (
+		oldest        string
+		oldestNeutral bool
+	)
+	for key, tm := range tms.peerMetrics {
+		if key == keep || !tm.IsPaused() {
+			continue
+		}
+		neutral := tm.TrustScore() >= neutralTrustScore
+		switch {
+		case oldest == "",
+			neutral && !oldestNeutral,
+			neutral == oldestNeutral && tms.lastUsed[key].Before(tms.lastUsed[oldest]):
+			oldest, oldestNeutral = key, neutral
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	tms.peerMetrics[oldest].Stop()
+	delete(tms.peerMetrics, oldest)
+	delete(tms.lastUsed, oldest)
+	return true
+}
+
 /* Loading & Saving */
 /* Both loadFromDB and savetoDB assume the mutex has been acquired */
 
@@ -165,8 +245,10 @@ func (tms *TrustMetricStore) loadFromDB() bool {
 
 		tm.Start()
 		tm.Init(p)
+		// No peer is connected yet
+		tm.Pause()
 		// Load the peer trust metric into the store
-		tms.peerMetrics[key] = tm
+		tms.add(key, tm)
 	}
 	return true
 }
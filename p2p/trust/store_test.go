@@ -0,0 +1,79 @@
+// Copyright 2017 Tendermint. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package trust
+
+import (
+	"fmt"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
+)
+
+func newTestStore(t *testing.T, db dbm.DB, maxSize int) *TrustMetricStore {
+	store := NewTrustMetricStore(db, DefaultConfig())
+	store.SetLogger(log.NewNopLogger())
+	store.maxSize = maxSize
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestTrustMetricStoreEvictsPausedMetrics(t *testing.T) {
+	store := newTestStore(t, dbm.NewMemDB(), 3)
+	defer store.Stop()
+
+	// a misbehaving peer and two neutral ones, all gone
+	store.GetPeerTrustMetric("bad").BadEvents(10)
+	store.GetPeerTrustMetric("old").GoodEvents(1)
+	store.GetPeerTrustMetric("new").GoodEvents(1)
+	for _, key := range []string{"bad", "old", "new"} {
+		store.PeerDisconnected(key)
+	}
+
+	// the least recently used neutral metric makes room
+	store.GetPeerTrustMetric("next").GoodEvents(1)
+	if store.Size() != 3 || store.HasPeerTrustMetric("old") {
+		t.Fatalf("expected old evicted, got size %d", store.Size())
+	}
+	if !store.HasPeerTrustMetric("bad") || !store.HasPeerTrustMetric("new") {
+		t.Fatal("expected bad and new kept")
+	}
+
+	// misbehaving peers go once no neutral metric is paused
+	store.GetPeerTrustMetric("new").GoodEvents(1)
+	store.GetPeerTrustMetric("last").GoodEvents(1)
+	if store.HasPeerTrustMetric("bad") {
+		t.Fatal("expected bad evicted once only connected peers were left")
+	}
+
+	// metrics of connected peers are never evicted
+	for i := 0; i < 3; i++ {
+		store.GetPeerTrustMetric(fmt.Sprintf("peer%d", i)).GoodEvents(1)
+	}
+	if store.Size() != 6 {
+		t.Fatalf("expected the connected peers kept past the size, got %d", store.Size())
+	}
+}
+
+func TestTrustMetricStoreLoadsPaused(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := newTestStore(t, db, 2)
+	for i := 0; i < 3; i++ {
+		store.GetPeerTrustMetric(fmt.Sprintf("peer%d", i)).BadEvents(1)
+	}
+	store.Stop()
+
+	store = newTestStore(t, db, 2)
+	defer store.Stop()
+	if store.Size() != 2 {
+		t.Fatalf("expected the saved metrics pruned to the size, got %d", store.Size())
+	}
+	for key, tm := range store.peerMetrics {
+		if !tm.IsPaused() {
+			t.Errorf("expected the metric of %v paused until its peer connects", key)
+		}
+	}
+}
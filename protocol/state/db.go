@@ -0,0 +1,175 @@
+package state
+
+import (
+	"encoding/binary"
+	"strings"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+/* Loading & Saving to a DB */
+
+// The state is stored under its own prefixes, so that it can share a DB
+// with the block store and be saved in the same batch as the blocks.
+var (
+	genesisHashKey    = []byte("state/genesis")
+	stateHeightKey    = []byte("state/height")
+	unspentSizeKey    = []byte("state/utxo-size")
+	balancePrefix     = "state/balance/"
+	noncePrefix       = "state/nonce/"
+	codePrefix        = "state/code/"
+	storagePrefix     = "state/storage/"
+	unspentPrefix     = "state/utxo/"
+	spentPrefix       = "state/spent/"
+	unspentUndoPrefix = "state/utxo-undo/"
+	stateUndoPrefix   = "state/undo/"
+)
+
+// accountPrefixes are the prefixes of the state changed by transactions,
+// which the undo data of a block restores along with the unspent set.
+var accountPrefixes = []string{balancePrefix, noncePrefix, codePrefix, storagePrefix}
+
+func isAccountKey(key string) bool {
+	for _, prefix := range accountPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func balanceKey(addr types.Address, asset types.AssetID) []byte {
+	return concatKey(balancePrefix, addr[:], asset[:])
+}
+
+func nonceKey(addr types.Address) []byte {
+	return concatKey(noncePrefix, addr[:])
+}
+
+func codeKey(addr types.Address) []byte {
+	return concatKey(codePrefix, addr[:])
+}
+
+func storageKey(addr types.Address, key types.Hash) []byte {
+	return concatKey(storagePrefix, addr[:], key[:])
+}
+
+// storageAddrPrefix is the prefix of every slot of the contract at addr.
+func storageAddrPrefix(addr types.Address) string {
+	return string(concatKey(storagePrefix, addr[:]))
+}
+
+func unspentKey(in types.TxInput) []byte {
+	return concatKey(unspentPrefix, in.Source[:], uint64Bytes(in.SourceID))
+}
+
+func spentKey(in types.TxInput) []byte {
+	return concatKey(spentPrefix, in.Source[:], uint64Bytes(in.SourceID))
+}
+
+func unspentUndoKey(height uint64) []byte {
+	return concatKey(unspentUndoPrefix, uint64Bytes(height))
+}
+
+func stateUndoKey(height uint64) []byte {
+	return concatKey(stateUndoPrefix, uint64Bytes(height))
+}
+
+func concatKey(prefix string, parts ...[]byte) []byte {
+	key := []byte(prefix)
+	for _, part := range parts {
+		key = append(key, part...)
+	}
+	return key
+}
+
+// uint64Bytes encodes v big endian, so that keys sort by it.
+func uint64Bytes(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+func bytesUint64(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// prefixEnd returns the end of the range of the keys starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// kvStore is a DB with the changes not written to it yet.
+// NOTE: Not goroutine safe.
+type kvStore struct {
+	db    dbm.DB
+	dirty map[string][]byte // nil values are deleted keys
+}
+
+func newKVStore(db dbm.DB) *kvStore {
+	return &kvStore{
+		db:    db,
+		dirty: make(map[string][]byte),
+	}
+}
+
+// get returns the value of key, or nil.
+func (kv *kvStore) get(key []byte) []byte {
+	if value, ok := kv.dirty[string(key)]; ok {
+		return value
+	}
+	return kv.db.Get(key)
+}
+
+// set sets key to value. An empty value deletes key.
+func (kv *kvStore) set(key, value []byte) {
+	if len(value) == 0 {
+		value = nil
+	}
+	kv.dirty[string(key)] = value
+}
+
+// keys returns the keys starting with prefix.
+func (kv *kvStore) keys(prefix string) [][]byte {
+	var keys [][]byte
+	iter := kv.db.Iterator([]byte(prefix), prefixEnd(prefix))
+	for ; iter.Valid(); iter.Next() {
+		key := append([]byte(nil), iter.Key()...)
+		if value, ok := kv.dirty[string(key)]; !ok || value != nil {
+			keys = append(keys, key)
+		}
+	}
+	iter.Close()
+	for key, value := range kv.dirty {
+		if value != nil && len(key) >= len(prefix) && key[:len(prefix)] == prefix && !kv.db.Has([]byte(key)) {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys
+}
+
+// write adds the changes to batch, and forgets them.
+func (kv *kvStore) write(batch dbm.Batch) {
+	for key, value := range kv.dirty {
+		if value == nil {
+			batch.Delete([]byte(key))
+		} else {
+			batch.Set([]byte(key), value)
+		}
+	}
+	kv.dirty = make(map[string][]byte)
+}
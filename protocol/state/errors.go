@@ -0,0 +1,175 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+var (
+	ErrNonceTooLow  = errors.New("nonce too low")
+	ErrNonceTooHigh = errors.New("nonce too high")
+)
+
+type ErrInsufficientBalance struct {
+	Addr  types.Address
+	Asset types.AssetID
+}
+
+func (e ErrInsufficientBalance) Error() string {
+	return fmt.Sprintf("Insufficient balance of asset %v for %v", types.Hash(e.Asset), e.Addr)
+}
+
+//-------------------------------------------------------------------
+
+var (
+	ErrSendersMismatch      = errors.New("number of senders does not match number of transactions")
+	ErrCreationSpendsInputs = errors.New("contract creation must not spend inputs")
+)
+
+type ErrUnexpectedHeight struct {
+	Expected uint64
+	Got      uint64
+}
+
+func (e ErrUnexpectedHeight) Error() string {
+	return fmt.Sprintf("Unexpected block height. Expected %v, got %v", e.Expected, e.Got)
+}
+
+var ErrStateNotCommitted = errors.New("state has changes not committed")
+
+type ErrStateHeightMismatch struct {
+	State uint64
+	Store uint64
+}
+
+func (e ErrStateHeightMismatch) Error() string {
+	return fmt.Sprintf("State is at block %v but the block store at block %v", e.State, e.Store)
+}
+
+type ErrGenesisMismatch struct {
+	Stored types.Hash
+	Given  types.Hash
+}
+
+func (e ErrGenesisMismatch) Error() string {
+	return fmt.Sprintf("State was started from genesis %v, not from genesis %v", e.Stored, e.Given)
+}
+
+type ErrNoUndoData struct {
+	Height uint64
+}
+
+func (e ErrNoUndoData) Error() string {
+	return fmt.Sprintf("No undo data for block %v", e.Height)
+}
+
+type ErrOutputNotFound struct {
+	Input types.TxInput
+}
+
+func (e ErrOutputNotFound) Error() string {
+	return fmt.Sprintf("Output %v:%v does not exist", e.Input.Source, e.Input.SourceID)
+}
+
+type ErrDoubleSpend struct {
+	Input types.TxInput
+}
+
+func (e ErrDoubleSpend) Error() string {
+	return fmt.Sprintf("Output %v:%v is already spent", e.Input.Source, e.Input.SourceID)
+}
+
+type ErrOutputNotOwned struct {
+	Input types.TxInput
+	Addr  types.Address
+}
+
+func (e ErrOutputNotOwned) Error() string {
+	return fmt.Sprintf("Output %v:%v is not owned by %v", e.Input.Source, e.Input.SourceID, e.Addr)
+}
+
+type ErrInsufficientInputs struct {
+	TxHash types.Hash
+	Asset  types.AssetID
+}
+
+func (e ErrInsufficientInputs) Error() string {
+	return fmt.Sprintf("Inputs of %v do not cover outputs of asset %v", e.TxHash, types.Hash(e.Asset))
+}
+
+//-------------------------------------------------------------------
+
+var (
+	ErrGasUintOverflow = errors.New("gas uint64 overflow")
+	ErrGasLimitReached = errors.New("block gas limit reached")
+	ErrIntrinsicGas    = errors.New("intrinsic gas too low")
+	ErrFeeCapTooLow    = errors.New("gas price below block base fee")
+)
+
+type ErrGasUsedExceedsLimit struct {
+	Used  uint64
+	Limit uint64
+}
+
+func (e ErrGasUsedExceedsLimit) Error() string {
+	return fmt.Sprintf("Block gas used %v exceeds gas limit %v", e.Used, e.Limit)
+}
+
+type ErrInvalidGasLimit struct {
+	Got    uint64
+	Parent uint64
+}
+
+func (e ErrInvalidGasLimit) Error() string {
+	return fmt.Sprintf("Invalid block gas limit %v (parent %v)", e.Got, e.Parent)
+}
+
+type ErrInvalidBaseFee struct {
+	Got      *big.Int
+	Expected *big.Int
+}
+
+func (e ErrInvalidBaseFee) Error() string {
+	return fmt.Sprintf("Invalid block base fee. Expected %v, got %v", e.Expected, e.Got)
+}
+
+type ErrGasUsedMismatch struct {
+	Header uint64
+	Actual uint64
+}
+
+func (e ErrGasUsedMismatch) Error() string {
+	return fmt.Sprintf("Block gas used mismatch. Header %v, actual %v", e.Header, e.Actual)
+}
+
+var ErrLogsBloomMismatch = errors.New("block logs bloom mismatch")
+
+type ErrPreviousHashMismatch struct {
+	Expected types.Hash
+	Got      types.Hash
+}
+
+func (e ErrPreviousHashMismatch) Error() string {
+	return fmt.Sprintf("Block does not follow the last one. Expected previous hash %v, got %v", e.Expected, e.Got)
+}
+
+type ErrReceiptsHashMismatch struct {
+	Header types.Hash
+	Actual types.Hash
+}
+
+func (e ErrReceiptsHashMismatch) Error() string {
+	return fmt.Sprintf("Block receipts hash mismatch. Header %v, actual %v", e.Header, e.Actual)
+}
+
+type ErrStateRootMismatch struct {
+	Header types.Hash
+	Actual types.Hash
+}
+
+func (e ErrStateRootMismatch) Error() string {
+	return fmt.Sprintf("Block state root mismatch. Header %v, actual %v", e.Header, e.Actual)
+}
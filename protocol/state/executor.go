@@ -0,0 +1,149 @@
+package state
+
+import (
+	"sync"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/store"
+	"github.com/go-fusion/protocol/types"
+)
+
+// BlockExecutor applies blocks in order on top of the block store: each
+// block is checked against its parent, applied to the state, and its header
+// and receipts are saved so that logs can be queried.
+//
+// The state and the block store share a DB, and every block is saved in
+// one write with the state after it, so that they agree after a restart.
+type BlockExecutor struct {
+	mtx      sync.Mutex
+	db       dbm.DB
+	state    *StateDB
+	store    *store.BlockStore
+	genesis  *types.BlockHeader
+	onCommit []func(header *types.BlockHeader)
+}
+
+// NewBlockExecutor returns an executor over the state and the block store
+// saved in db, for the chain started by genesis. The genesis balances are
+// saved to an empty db. It fails if db holds another chain, or if the state
+// and the block store don't agree on the last block.
+func NewBlockExecutor(db dbm.DB, genesis *Genesis) (*BlockExecutor, error) {
+	if err := genesis.Validate(); err != nil {
+		return nil, err
+	}
+	s := NewStateDB(db)
+	bs := store.NewBlockStore(db)
+
+	hash := genesis.Hash()
+	if stored := db.Get(genesisHashKey); stored == nil && bs.Height() == 0 && s.Unspent().Height() == 0 {
+		batch := db.NewBatch()
+		genesis.apply(s)
+		s.commit(batch)
+		batch.Set(genesisHashKey, hash[:])
+		batch.Write()
+	} else if storedHash := types.BytesToHash(stored); storedHash != hash {
+		return nil, ErrGenesisMismatch{Stored: storedHash, Given: hash}
+	}
+
+	if s.Unspent().Height() != bs.Height() {
+		return nil, ErrStateHeightMismatch{State: s.Unspent().Height(), Store: bs.Height()}
+	}
+	return &BlockExecutor{
+		db:      db,
+		state:   s,
+		store:   bs,
+		genesis: genesis.Header(),
+	}, nil
+}
+
+// Store returns the block store blocks are saved to.
+func (be *BlockExecutor) Store() *store.BlockStore {
+	return be.store
+}
+
+// OnCommit registers fn to be called with the header of every block saved,
+// and with the header of the new last block after a revert, which is the
+// genesis header once every block is reverted. Must be called before any block is applied.
+func (be *BlockExecutor) OnCommit(fn func(header *types.BlockHeader)) {
+	be.onCommit = append(be.onCommit, fn)
+}
+
+// ApplyBlock applies block, which must follow the last block of the store,
+// saves it with its receipts and returns them. senders[i] must be the
+// sender of block.Transactions[i]. On error nothing is changed.
+func (be *BlockExecutor) ApplyBlock(block *types.Block, senders []types.Address) ([]*types.Receipt, error) {
+	be.mtx.Lock()
+	defer be.mtx.Unlock()
+
+	height := be.store.Height()
+	if block.Height != height+1 {
+		return nil, ErrUnexpectedHeight{Expected: height + 1, Got: block.Height}
+	}
+	parent := be.loadHeader(height)
+	if hash := parent.Hash(); block.PreviousBlockHash != hash {
+		return nil, ErrPreviousHashMismatch{hash, block.PreviousBlockHash}
+	}
+
+	receipts, err := applyBlock(be.state, be.getHash, parent, block, senders)
+	if err != nil {
+		return nil, err
+	}
+	batch := be.db.NewBatch()
+	be.state.commitBlock(batch, block.Height)
+	be.store.SaveBlock(batch, &block.BlockHeader, receipts)
+
+	for _, fn := range be.onCommit {
+		fn(&block.BlockHeader)
+	}
+	return receipts, nil
+}
+
+// RevertBlock removes the last block, at height, from the store, and rolls
+// the state back to the end of the block before, in one write. Blocks
+// further than the undo data kept for the last 256 blocks can't be
+// reverted.
+func (be *BlockExecutor) RevertBlock(height uint64) error {
+	be.mtx.Lock()
+	defer be.mtx.Unlock()
+
+	if last := be.store.Height(); height != last || height == 0 {
+		return ErrUnexpectedHeight{Expected: last, Got: height}
+	}
+	if err := be.state.RevertBlock(height); err != nil {
+		return err
+	}
+	batch := be.db.NewBatch()
+	be.state.commit(batch)
+	be.store.RemoveBlock(batch, height)
+
+	head := be.loadHeader(height - 1)
+	for _, fn := range be.onCommit {
+		fn(head)
+	}
+	return nil
+}
+
+// Genesis returns the header of the genesis block, the parent of the
+// first block.
+func (be *BlockExecutor) Genesis() *types.BlockHeader {
+	return be.genesis
+}
+
+// loadHeader returns the header of the saved block at height, or the
+// genesis header at height 0.
+func (be *BlockExecutor) loadHeader(height uint64) *types.BlockHeader {
+	if height == 0 {
+		return be.genesis
+	}
+	return be.store.LoadBlockHeader(height)
+}
+
+// getHash returns the hash of the saved block at height.
+func (be *BlockExecutor) getHash(height uint64) types.Hash {
+	header := be.loadHeader(height)
+	if header == nil {
+		return types.Hash{}
+	}
+	return header.Hash()
+}
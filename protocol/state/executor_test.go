@@ -0,0 +1,299 @@
+package state
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/store"
+	"github.com/go-fusion/protocol/types"
+)
+
+func TestBlockExecutorSavesReceipts(t *testing.T) {
+	db := dbm.NewMemDB()
+	be, err := NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := be.Store()
+	var committed []uint64
+	be.OnCommit(func(header *types.BlockHeader) {
+		committed = append(committed, header.Height)
+	})
+
+	senders := []types.Address{alice}
+	block := sealBlock(t, be.state, be.Genesis(), childBlock(be.Genesis(), transferTx(0, bob, 10)), senders)
+	if _, err := be.ApplyBlock(block, senders); err != nil {
+		t.Fatal(err)
+	}
+	if bs.Height() != 1 || len(committed) != 1 {
+		t.Fatalf("expected block 1 saved and committed, got height %d, commits %v", bs.Height(), committed)
+	}
+
+	logs, err := bs.GetLogs(&store.FilterQuery{
+		Topics: [][]types.Hash{{types.TransferEventTopic}, {}, {addressTopic(bob)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].BlockHeight != 1 || logs[0].TxHash != block.Transactions[0].Hash() {
+		t.Fatalf("expected the transfer log of block 1, got %+v", logs)
+	}
+
+	// a block must follow the last one saved
+	if _, err := be.ApplyBlock(newBlock(3), nil); err == nil {
+		t.Fatal("expected an error for a height gap")
+	}
+	orphan := childBlock(&block.BlockHeader)
+	orphan.PreviousBlockHash = types.Hash{1}
+	if _, err := be.ApplyBlock(orphan, nil); err == nil {
+		t.Fatal("expected an error for a wrong previous hash")
+	} else if _, ok := err.(ErrPreviousHashMismatch); !ok {
+		t.Fatalf("expected ErrPreviousHashMismatch, got %v", err)
+	}
+	if bs.Height() != 1 {
+		t.Fatalf("failed blocks were saved, height %d", bs.Height())
+	}
+}
+
+func TestBlockExecutorRestart(t *testing.T) {
+	db := dbm.NewMemDB()
+	be, err := NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pay := transferTx(0, bob, 10)
+	block := sealBlock(t, be.state, be.Genesis(), childBlock(be.Genesis(), pay), []types.Address{alice})
+	if _, err := be.ApplyBlock(block, []types.Address{alice}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new executor over the same DB continues from block 1
+	be, err = NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if be.state.GetBalance(bob, asset).Int64() != 1010 || be.state.GetNonce(alice) != 1 {
+		t.Fatalf("expected the balances and nonces of block 1, got %v and %d",
+			be.state.GetBalance(bob, asset), be.state.GetNonce(alice))
+	}
+	sent := types.TxInput{Source: pay.Hash(), SourceID: types.TxOutputSend}
+	spend := transferTx(0, carol, 4, &sent)
+	block = sealBlock(t, be.state, &block.BlockHeader, childBlock(&block.BlockHeader, spend), []types.Address{bob})
+	if _, err := be.ApplyBlock(block, []types.Address{bob}); err != nil {
+		t.Fatal(err)
+	}
+
+	be, err = NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := be.state.Unspent()
+	if us.Height() != 2 || us.Has(sent) || us.Size() != 2 {
+		t.Fatalf("expected the unspent set of block 2, got height %d, size %d", us.Height(), us.Size())
+	}
+	// with the undo data of the blocks
+	if err := us.RevertBlock(2); err != nil {
+		t.Fatal(err)
+	}
+	if !us.Has(sent) {
+		t.Fatal("revert did not restore the spent output")
+	}
+}
+
+func TestBlockExecutorStateMismatch(t *testing.T) {
+	db := dbm.NewMemDB()
+	if _, err := NewBlockExecutor(db, testGenesis()); err != nil {
+		t.Fatal(err)
+	}
+	store.NewBlockStore(db).SaveBlock(nil, &newBlock(1).BlockHeader, nil)
+	if _, err := NewBlockExecutor(db, testGenesis()); err == nil {
+		t.Fatal("expected an error for a store ahead of the state")
+	} else if _, ok := err.(ErrStateHeightMismatch); !ok {
+		t.Fatalf("expected ErrStateHeightMismatch, got %v", err)
+	}
+}
+
+func TestBlockExecutorGenesis(t *testing.T) {
+	db := dbm.NewMemDB()
+	genesis := testGenesis()
+	be, err := NewBlockExecutor(db, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if be.state.GetBalance(alice, asset).Int64() != 1000 || be.state.Root() != genesis.Header().StateRoot {
+		t.Fatal("expected the genesis balances saved, with the root of the genesis header")
+	}
+	if hash := be.getHash(0); hash != genesis.Header().Hash() {
+		t.Fatalf("expected block 0 to be the genesis, got %v", hash)
+	}
+
+	// the first block follows the genesis header
+	block := newBlock(1)
+	block.TransactionsStatusHash = types.ReceiptsHash(nil)
+	block.StateRoot = genesis.Header().StateRoot
+	if _, err := be.ApplyBlock(block, nil); err == nil {
+		t.Fatal("expected an error for a first block not following the genesis")
+	} else if _, ok := err.(ErrPreviousHashMismatch); !ok {
+		t.Fatalf("expected ErrPreviousHashMismatch, got %v", err)
+	}
+
+	// the hash covers the chain ID, the balances, the validator and the gas limit
+	changes := []func(g *Genesis){
+		func(g *Genesis) { g.ChainID = "other" },
+		func(g *Genesis) { g.Alloc[0].Balance = big.NewInt(1) },
+		func(g *Genesis) { g.Alloc = g.Alloc[1:] },
+		func(g *Genesis) { g.Validator = carol },
+		func(g *Genesis) { g.GasLimit++ },
+		func(g *Genesis) { g.Timestamp++ },
+	}
+	for i, change := range changes {
+		other := testGenesis()
+		change(other)
+		if other.Hash() == genesis.Hash() {
+			t.Errorf("change %d kept the genesis hash", i)
+		}
+		if _, err := NewBlockExecutor(db, other); err == nil {
+			t.Errorf("change %d: expected an error for a DB of another chain", i)
+		} else if _, ok := err.(ErrGenesisMismatch); !ok {
+			t.Errorf("change %d: expected ErrGenesisMismatch, got %v", i, err)
+		}
+	}
+	if _, err := NewBlockExecutor(db, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []func(g *Genesis){
+		func(g *Genesis) { g.ChainID = "" },
+		func(g *Genesis) { g.GasLimit = MinBlockGasLimit - 1 },
+		func(g *Genesis) { g.Alloc[0].Balance = big.NewInt(-1) },
+		func(g *Genesis) { g.Alloc[0].Balance = nil },
+		func(g *Genesis) { g.Alloc = append(g.Alloc, g.Alloc[0]) },
+	}
+	for i, change := range invalid {
+		other := testGenesis()
+		change(other)
+		if err := other.Validate(); err == nil {
+			t.Errorf("invalid genesis %d was accepted", i)
+		}
+	}
+}
+
+func TestGenesisFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "genesis.json")
+
+	genesis := testGenesis()
+	genesis.Validator = carol
+	if err := genesis.SaveAs(filePath); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGenesis(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Hash() != genesis.Hash() {
+		t.Fatalf("expected the saved genesis, got %+v", loaded)
+	}
+
+	invalid := DefaultGenesis("")
+	if err := invalid.SaveAs(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGenesis(filePath); err == nil {
+		t.Fatal("expected an error for an invalid genesis")
+	}
+}
+
+func TestBlockExecutorRevertBlock(t *testing.T) {
+	db := dbm.NewMemDB()
+	be, err := NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heads []*types.BlockHeader
+	be.OnCommit(func(header *types.BlockHeader) {
+		heads = append(heads, header)
+	})
+	genesis := newTestState()
+
+	pay := transferTx(0, bob, 10)
+	block1 := sealBlock(t, be.state, be.Genesis(), childBlock(be.Genesis(), pay), []types.Address{alice})
+	if _, err := be.ApplyBlock(block1, []types.Address{alice}); err != nil {
+		t.Fatal(err)
+	}
+	sent := types.TxInput{Source: pay.Hash(), SourceID: types.TxOutputSend}
+	spend := transferTx(0, carol, 4, &sent)
+	block2 := childBlock(&block1.BlockHeader, spend)
+	block2.Validator = carol
+	block2 = sealBlock(t, be.state, &block1.BlockHeader, block2, []types.Address{bob})
+	if _, err := be.ApplyBlock(block2, []types.Address{bob}); err != nil {
+		t.Fatal(err)
+	}
+	afterBlock1 := map[types.Address]int64{alice: 990, bob: 1010, carol: 0}
+
+	if err := be.RevertBlock(1); err == nil {
+		t.Fatal("expected an error for a block below the last one")
+	}
+	if err := be.RevertBlock(2); err != nil {
+		t.Fatal(err)
+	}
+	if be.store.Height() != 1 || be.store.LoadBlockHeader(2) != nil {
+		t.Fatalf("expected block 2 removed from the store, height %d", be.store.Height())
+	}
+	if head := heads[len(heads)-1]; head == nil || head.Height != 1 {
+		t.Fatalf("expected the head to be block 1, got %+v", head)
+	}
+	for addr, balance := range afterBlock1 {
+		if got := be.state.GetBalance(addr, asset).Int64(); got != balance {
+			t.Errorf("expected %v to hold %d after the revert, got %d", addr, balance, got)
+		}
+	}
+	if be.state.GetBalance(carol, types.NativeAssetID).Sign() != 0 || be.state.GetNonce(bob) != 0 {
+		t.Error("expected the fees and nonce of block 2 reverted")
+	}
+	if !be.state.Unspent().Has(sent) {
+		t.Error("expected the output spent by block 2 restored")
+	}
+
+	// the revert was saved, and block 2 can be applied again
+	be, err = NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	be.OnCommit(func(header *types.BlockHeader) {
+		heads = append(heads, header)
+	})
+	if _, err := be.ApplyBlock(block2, []types.Address{bob}); err != nil {
+		t.Fatal(err)
+	}
+	for _, height := range []uint64{2, 1} {
+		if err := be.RevertBlock(height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, addr := range []types.Address{alice, bob, carol} {
+		for _, a := range []types.AssetID{asset, types.NativeAssetID} {
+			if be.state.GetBalance(addr, a).Cmp(genesis.GetBalance(addr, a)) != 0 {
+				t.Errorf("expected the balances of %v back to genesis", addr)
+			}
+		}
+	}
+	if us := be.state.Unspent(); us.Height() != 0 || us.Size() != 0 || be.store.Height() != 0 {
+		t.Fatalf("expected an empty chain, got unspent height %d, size %d", us.Height(), us.Size())
+	}
+	if head := heads[len(heads)-1]; head.Hash() != be.Genesis().Hash() {
+		t.Error("expected the genesis as head once every block is reverted")
+	}
+	if err := be.RevertBlock(0); err == nil {
+		t.Fatal("expected an error for reverting past genesis")
+	}
+}
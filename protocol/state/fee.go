@@ -0,0 +1,135 @@
+package state
+
+import (
+	"math/big"
+
+	"github.com/go-fusion/common/math"
+	"github.com/go-fusion/protocol/types"
+	"github.com/go-fusion/protocol/vm"
+)
+
+// Fee market parameters
+const (
+	// DefaultBlockGasLimit is the gas limit of the genesis block
+	DefaultBlockGasLimit uint64 = 10000000
+	// MinBlockGasLimit is the lowest gas limit a block may set
+	MinBlockGasLimit uint64 = 5000
+	// GasLimitBoundDivisor bounds how much the gas limit may change per block
+	GasLimitBoundDivisor uint64 = 1024
+
+	// InitialBaseFee is the base fee of the genesis block
+	InitialBaseFee = 1000000000
+	// ElasticityMultiplier sets the gas target to GasLimit / ElasticityMultiplier
+	ElasticityMultiplier = 2
+	// BaseFeeChangeDenominator bounds the base fee change to 1/8 per block
+	BaseFeeChangeDenominator = 8
+
+	// BaseFeeBurnPercent is the share of the base fee that is burned.
+	// The rest goes to the block's Validator together with the tip.
+	BaseFeeBurnPercent = 50
+)
+
+// BlockContext carries the block level values a transaction is applied against.
+type BlockContext struct {
+	Height    uint64
+	Timestamp uint64
+	Validator types.Address
+	GasLimit  uint64
+	BaseFee   *big.Int
+	GetHash   vm.GetHashFunc // hashes of the previous blocks, may be nil
+}
+
+// NewBlockContext returns the context of header. getHash returns the
+// hashes of the blocks before it.
+func NewBlockContext(header *types.BlockHeader, getHash vm.GetHashFunc) *BlockContext {
+	return &BlockContext{
+		Height:    header.Height,
+		Timestamp: header.Timestamp,
+		Validator: header.Validator,
+		GasLimit:  header.GasLimit,
+		BaseFee:   header.BaseFee,
+		GetHash:   getHash,
+	}
+}
+
+// CalcBaseFee returns the base fee of the block following parent. The fee
+// rises when parent used more than its gas target and falls when it used
+// less, by at most 1/BaseFeeChangeDenominator per block, so wallets can
+// predict the fee of the next block from the current head.
+func CalcBaseFee(parent *types.BlockHeader) *big.Int {
+	if parent.BaseFee == nil {
+		return big.NewInt(InitialBaseFee)
+	}
+	target := parent.GasLimit / ElasticityMultiplier
+	if target == 0 || parent.GasUsed == target {
+		return new(big.Int).Set(parent.BaseFee)
+	}
+
+	var (
+		num   = new(big.Int)
+		denom = new(big.Int).SetUint64(target * BaseFeeChangeDenominator)
+	)
+	if parent.GasUsed > target {
+		// baseFee + max(1, baseFee * (used - target) / target / 8)
+		num.SetUint64(parent.GasUsed - target)
+		num.Mul(num, parent.BaseFee)
+		num.Div(num, denom)
+		return num.Add(parent.BaseFee, math.BigMax(num, big.NewInt(1)))
+	}
+	// max(0, baseFee - baseFee * (target - used) / target / 8)
+	num.SetUint64(target - parent.GasUsed)
+	num.Mul(num, parent.BaseFee)
+	num.Div(num, denom)
+	return math.BigMax(num.Sub(parent.BaseFee, num), new(big.Int))
+}
+
+// genesisParent is the parent the first block is checked against when no
+// genesis is given: it sets the default gas limit and, having no base fee,
+// the initial base fee.
+var genesisParent = types.BlockHeader{GasLimit: DefaultBlockGasLimit}
+
+// VerifyHeader checks the gas limit and base fee of header against parent,
+// or against the genesis values if parent is nil.
+func VerifyHeader(parent, header *types.BlockHeader) error {
+	if parent == nil {
+		parent = &genesisParent
+	}
+	if err := VerifyGasLimit(parent, header); err != nil {
+		return err
+	}
+	return VerifyBaseFee(parent, header)
+}
+
+// VerifyGasLimit checks that the gas limit of header stays within the bounds
+// allowed relative to parent and that the gas used does not exceed it.
+func VerifyGasLimit(parent, header *types.BlockHeader) error {
+	if header.GasUsed > header.GasLimit {
+		return ErrGasUsedExceedsLimit{header.GasUsed, header.GasLimit}
+	}
+	diff := int64(header.GasLimit) - int64(parent.GasLimit)
+	if diff < 0 {
+		diff = -diff
+	}
+	limit := parent.GasLimit / GasLimitBoundDivisor
+	if uint64(diff) >= limit || header.GasLimit < MinBlockGasLimit {
+		return ErrInvalidGasLimit{header.GasLimit, parent.GasLimit}
+	}
+	return nil
+}
+
+// VerifyBaseFee checks that header carries the base fee derived from parent.
+func VerifyBaseFee(parent, header *types.BlockHeader) error {
+	expected := CalcBaseFee(parent)
+	if header.BaseFee == nil || header.BaseFee.Cmp(expected) != 0 {
+		return ErrInvalidBaseFee{header.BaseFee, expected}
+	}
+	return nil
+}
+
+// splitBaseFee returns the part of fee that is burned and the part paid to the validator.
+func splitBaseFee(fee *big.Int) (burned, paid *big.Int) {
+	burned = new(big.Int).Mul(fee, big.NewInt(BaseFeeBurnPercent))
+	burned.Div(burned, big.NewInt(100))
+	paid = new(big.Int).Sub(fee, burned)
+	return burned, paid
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+func TestCalcBaseFee(t *testing.T) {
+	parent := &types.BlockHeader{GasLimit: 2000000, BaseFee: big.NewInt(InitialBaseFee)}
+	cases := []struct {
+		used     uint64
+		expected int64
+	}{
+		{1000000, InitialBaseFee},                     // at target
+		{2000000, InitialBaseFee * 9 / 8},             // full block: +1/8
+		{0, InitialBaseFee * 7 / 8},                   // empty block: -1/8
+		{1500000, InitialBaseFee + InitialBaseFee/16}, // half way above target
+	}
+	for _, c := range cases {
+		parent.GasUsed = c.used
+		if fee := CalcBaseFee(parent); fee.Int64() != c.expected {
+			t.Errorf("used %d: expected base fee %d, got %v", c.used, c.expected, fee)
+		}
+	}
+}
+
+func TestApplyBlockVerifiesHeader(t *testing.T) {
+	// first block, checked against the genesis values
+	block := newBlock(1)
+	block.BaseFee = big.NewInt(InitialBaseFee + 1)
+	if _, err := ApplyBlock(New(), nil, block, nil); err == nil {
+		t.Fatal("expected an error for a wrong base fee")
+	} else if _, ok := err.(ErrInvalidBaseFee); !ok {
+		t.Fatalf("expected ErrInvalidBaseFee, got %v", err)
+	}
+
+	s := New()
+	block = sealBlock(t, s, nil, newBlock(1), nil)
+	if _, err := ApplyBlock(s, nil, block, nil); err != nil {
+		t.Fatal(err)
+	}
+	parent := &block.BlockHeader
+
+	// the gas limit may move by less than 1/1024 of the parent's
+	block = childBlock(parent)
+	block.GasLimit += parent.GasLimit / GasLimitBoundDivisor
+	if _, err := ApplyBlock(s, parent, block, nil); err == nil {
+		t.Fatal("expected an error for a gas limit out of bounds")
+	} else if _, ok := err.(ErrInvalidGasLimit); !ok {
+		t.Fatalf("expected ErrInvalidGasLimit, got %v", err)
+	}
+
+	// the empty parent lowers the base fee of its child
+	block = childBlock(parent)
+	block.BaseFee = parent.BaseFee
+	if _, err := ApplyBlock(s, parent, block, nil); err == nil {
+		t.Fatal("expected an error for a base fee not derived from the parent")
+	}
+
+	block = childBlock(parent)
+	block.GasLimit++
+	block = sealBlock(t, s, parent, block, nil)
+	if _, err := ApplyBlock(s, parent, block, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+package state
+
+import (
+	"github.com/go-fusion/common/math"
+	"github.com/go-fusion/protocol/types"
+)
+
+// Gas schedule
+const (
+	TxGas              uint64 = 21000 // base cost of every transaction
+	TxBatchOutputGas   uint64 = 9000  // per transfer of a batch transaction beyond the first
+	TxInputGas         uint64 = 3000  // per spent TxInput
+	TxDataZeroGas      uint64 = 4     // per zero byte of Payload
+	TxDataNonZeroGas   uint64 = 16    // per non-zero byte of Payload
+	TxContractCreation uint64 = 32000 // extra cost of deploying a contract
+)
+
+// IntrinsicGas returns the gas a transaction costs before any execution.
+func IntrinsicGas(tx *types.Transaction) (uint64, error) {
+	gas := TxGas
+	if isContractCreation(tx) {
+		gas += TxContractCreation
+	}
+
+	if n := uint64(len(tx.Transfers())); n > 1 {
+		gas += (n - 1) * TxBatchOutputGas
+	}
+	gas += uint64(len(tx.Inputs)) * TxInputGas
+
+	var nz uint64
+	for _, b := range tx.Payload {
+		if b != 0 {
+			nz++
+		}
+	}
+	z := uint64(len(tx.Payload)) - nz
+
+	if (math.MaxUint64-gas)/TxDataNonZeroGas < nz {
+		return 0, ErrGasUintOverflow
+	}
+	gas += nz * TxDataNonZeroGas
+	if (math.MaxUint64-gas)/TxDataZeroGas < z {
+		return 0, ErrGasUintOverflow
+	}
+	gas += z * TxDataZeroGas
+	return gas, nil
+}
+
+// GasPool tracks the gas left in a block while its transactions are applied.
+type GasPool uint64
+
+// AddGas makes gas available for execution.
+func (gp *GasPool) AddGas(amount uint64) *GasPool {
+	if uint64(*gp) > math.MaxUint64-amount {
+		panic("gas pool pushed above uint64")
+	}
+	*(*uint64)(gp) += amount
+	return gp
+}
+
+// SubGas deducts the given amount from the pool if enough gas is
+// available and returns an error otherwise.
+func (gp *GasPool) SubGas(amount uint64) error {
+	if uint64(*gp) < amount {
+		return ErrGasLimitReached
+	}
+	*(*uint64)(gp) -= amount
+	return nil
+}
+
+// Gas returns the amount of gas remaining in the pool.
+func (gp *GasPool) Gas() uint64 {
+	return uint64(*gp)
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"golang.org/x/crypto/blake2b"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+// GenesisAlloc is a balance the chain starts with.
+type GenesisAlloc struct {
+	Address types.Address `json:"address"`
+	Asset   types.Hash    `json:"asset"`
+	Balance *big.Int      `json:"balance"`
+}
+
+// Genesis defines the start of a chain: the values its first block is
+// checked against and the balances it starts with.
+type Genesis struct {
+	ChainID   string         `json:"chain_id"`
+	Timestamp uint64         `json:"timestamp"`
+	Validator types.Address  `json:"validator"`
+	GasLimit  uint64         `json:"gas_limit"`
+	Alloc     []GenesisAlloc `json:"alloc"`
+}
+
+// DefaultGenesis returns the genesis of the chain chainID with no balance,
+// and the default gas limit.
+func DefaultGenesis(chainID string) *Genesis {
+	return &Genesis{
+		ChainID:  chainID,
+		GasLimit: DefaultBlockGasLimit,
+	}
+}
+
+// LoadGenesis reads the genesis in the JSON file at filePath and validates it.
+func LoadGenesis(filePath string) (*Genesis, error) {
+	jsonBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	genesis := new(Genesis)
+	if err := json.Unmarshal(jsonBytes, genesis); err != nil {
+		return nil, fmt.Errorf("Error reading genesis from %v: %v", filePath, err)
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid genesis in %v: %v", filePath, err)
+	}
+	return genesis, nil
+}
+
+// SaveAs writes the genesis as JSON to filePath.
+func (g *Genesis) SaveAs(filePath string) error {
+	jsonBytes, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return cmn.WriteFileAtomic(filePath, jsonBytes, 0644)
+}
+
+// Validate checks that the genesis has a chain ID and a valid gas limit,
+// and that every balance is allocated once and not negative.
+func (g *Genesis) Validate() error {
+	if g.ChainID == "" {
+		return fmt.Errorf("chain_id is empty")
+	}
+	if g.GasLimit < MinBlockGasLimit {
+		return fmt.Errorf("gas_limit %v is below the minimum of %v", g.GasLimit, MinBlockGasLimit)
+	}
+	seen := make(map[string]bool, len(g.Alloc))
+	for i, alloc := range g.Alloc {
+		if alloc.Balance == nil || alloc.Balance.Sign() < 0 {
+			return fmt.Errorf("alloc %d has no or a negative balance", i)
+		}
+		key := string(balanceKey(alloc.Address, types.AssetID(alloc.Asset)))
+		if seen[key] {
+			return fmt.Errorf("alloc %d allocates asset %v to %v again", i, alloc.Asset, alloc.Address)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// apply sets the balances of the genesis in s.
+func (g *Genesis) apply(s *StateDB) {
+	for _, alloc := range g.Alloc {
+		s.SetBalance(alloc.Address, types.AssetID(alloc.Asset), alloc.Balance)
+	}
+}
+
+// Header returns the header of the genesis block, at height 0. It is the
+// parent of the first block, and commits to the genesis balances with its
+// state root.
+func (g *Genesis) Header() *types.BlockHeader {
+	s := New()
+	g.apply(s)
+	return &types.BlockHeader{
+		Timestamp: g.Timestamp,
+		StateRoot: s.Root(),
+		Validator: g.Validator,
+		GasLimit:  g.GasLimit,
+	}
+}
+
+// Hash identifies the chain from its start: the blake2b hash of the chain
+// ID and of the genesis header. Nodes exchange it in the handshake, so
+// that nodes with different genesis balances, validator or gas limit
+// don't connect, even before any block.
+func (g *Genesis) Hash() types.Hash {
+	header := g.Header().Hash()
+	return types.Hash(blake2b.Sum256(append([]byte(g.ChainID), header[:]...)))
+}
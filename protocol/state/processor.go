@@ -0,0 +1,105 @@
+package state
+
+import (
+	"github.com/go-fusion/protocol/types"
+	"github.com/go-fusion/protocol/vm"
+)
+
+// ApplyBlock applies the transactions of block in order and returns their
+// receipts. senders[i] must be the sender of block.Transactions[i]. The block
+// is applied atomically and the gas used, logs bloom, receipts hash and
+// state root must match the header. The gas limit and base fee of the header are checked
+// against parent, which is nil for the first block.
+//
+// The outputs paid by the block are then recorded in the unspent set of s
+// and the inputs it spends removed from it. Failed transactions moved no
+// value, so they neither spend nor create outputs. Nor do contract
+// creations, which pay an address only known after execution and may not
+// spend inputs.
+//
+// BLOCKHASH only sees the hash of parent; BlockExecutor provides the
+// previous 256 blocks.
+func ApplyBlock(s *StateDB, parent *types.BlockHeader, block *types.Block, senders []types.Address) ([]*types.Receipt, error) {
+	getHash := func(height uint64) types.Hash {
+		if parent != nil && parent.Height == height {
+			return parent.Hash()
+		}
+		return types.Hash{}
+	}
+	return applyBlock(s, getHash, parent, block, senders)
+}
+
+func applyBlock(s *StateDB, getHash vm.GetHashFunc, parent *types.BlockHeader, block *types.Block, senders []types.Address) ([]*types.Receipt, error) {
+	if len(senders) != len(block.Transactions) {
+		return nil, ErrSendersMismatch
+	}
+	if err := VerifyHeader(parent, &block.BlockHeader); err != nil {
+		return nil, err
+	}
+
+	var (
+		ctx      = NewBlockContext(&block.BlockHeader, getHash)
+		gp       = new(GasPool).AddGas(block.GasLimit)
+		gasUsed  uint64
+		receipts = make([]*types.Receipt, 0, len(block.Transactions))
+		snapshot = s.Snapshot()
+
+		// successful transactions, for the unspent set
+		spending []*types.Transaction
+		spenders []types.Address
+	)
+	for i, tx := range block.Transactions {
+		// the hash of a malformed transaction is meaningless
+		if err := tx.ValidateBasic(); err != nil {
+			s.RevertToSnapshot(snapshot)
+			return nil, err
+		}
+		txHash := tx.Hash()
+		s.Prepare(txHash, uint64(i))
+		result, err := ApplyTransaction(s, ctx, gp, tx, senders[i])
+		if err != nil {
+			s.RevertToSnapshot(snapshot)
+			return nil, err
+		}
+		gasUsed += result.GasUsed
+
+		logs := s.GetLogs(txHash)
+		for _, log := range logs {
+			log.BlockHeight = block.Height
+		}
+		status := types.ReceiptStatusSuccessful
+		if result.Failed() {
+			status = types.ReceiptStatusFailed
+		}
+		receipt := types.NewReceipt(txHash, status, result.GasUsed, gasUsed, logs)
+		receipt.ContractAddress = result.ContractAddress
+		receipts = append(receipts, receipt)
+
+		if !result.Failed() && !isContractCreation(tx) {
+			spending = append(spending, tx)
+			spenders = append(spenders, senders[i])
+		}
+	}
+
+	if gasUsed != block.GasUsed {
+		s.RevertToSnapshot(snapshot)
+		return nil, ErrGasUsedMismatch{block.GasUsed, gasUsed}
+	}
+	if bloom := types.CreateBloom(receipts); bloom != block.LogsBloom {
+		s.RevertToSnapshot(snapshot)
+		return nil, ErrLogsBloomMismatch
+	}
+	if hash := types.ReceiptsHash(receipts); hash != block.TransactionsStatusHash {
+		s.RevertToSnapshot(snapshot)
+		return nil, ErrReceiptsHashMismatch{block.TransactionsStatusHash, hash}
+	}
+	if err := s.unspent.apply(block.Height, spending, spenders); err != nil {
+		s.RevertToSnapshot(snapshot)
+		return nil, err
+	}
+	if root := s.Root(); root != block.StateRoot {
+		s.RevertToSnapshot(snapshot)
+		return nil, ErrStateRootMismatch{block.StateRoot, root}
+	}
+	return receipts, nil
+}
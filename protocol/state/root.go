@@ -0,0 +1,93 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+// The state root commits to every balance, nonce, contract code, storage
+// slot and unspent output with a multiplicative set hash (MuHash): every
+// key and value is hashed to an integer modulo a 3072-bit prime, and the
+// accumulator of the state is the product of those of its entries. A
+// change divides the old entry out and multiplies the new one in, so the
+// root of a block costs in proportion to what the block changed, and two
+// states with the same entries have the same root whatever the order they
+// were written in.
+
+// muHashBytes is the size of the elements of the set hash
+const muHashBytes = 384
+
+// muHashPrime is 2^3072 - 1103717, the largest 3072-bit safe prime
+var muHashPrime = func() *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), muHashBytes*8)
+	return p.Sub(p, big.NewInt(1103717))
+}()
+
+var stateRootAccKey = []byte("state/root-acc")
+
+// isRootKey returns true if key is committed to by the state root
+func isRootKey(key string) bool {
+	return isAccountKey(key) || strings.HasPrefix(key, unspentPrefix)
+}
+
+// muHashElement returns the element of the set hash of key set to value
+func muHashElement(key, value []byte) *big.Int {
+	xof, err := blake2b.NewXOF(muHashBytes, nil)
+	if err != nil {
+		panic(err)
+	}
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(key)))
+	xof.Write(n[:])
+	xof.Write(key)
+	xof.Write(value)
+	buf := make([]byte, muHashBytes)
+	if _, err := xof.Read(buf); err != nil {
+		panic(err)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(buf), muHashPrime)
+}
+
+// accumulator returns the set hash of the state with its pending changes
+func (s *StateDB) accumulator() *big.Int {
+	acc := big.NewInt(1)
+	if bz := s.kv.db.Get(stateRootAccKey); bz != nil {
+		acc.SetBytes(bz)
+	}
+	num, den := big.NewInt(1), big.NewInt(1)
+	for key, value := range s.kv.dirty {
+		if !isRootKey(key) {
+			continue
+		}
+		prev := s.kv.db.Get([]byte(key))
+		if bytes.Equal(prev, value) {
+			continue
+		}
+		if prev != nil {
+			den.Mul(den, muHashElement([]byte(key), prev))
+			den.Mod(den, muHashPrime)
+		}
+		if value != nil {
+			num.Mul(num, muHashElement([]byte(key), value))
+			num.Mod(num, muHashPrime)
+		}
+	}
+	acc.Mul(acc, num)
+	acc.Mul(acc, den.ModInverse(den, muHashPrime))
+	return acc.Mod(acc, muHashPrime)
+}
+
+// Root returns the commitment to the state, with its pending changes.
+// Blocks carry the root of the state after them in their header.
+func (s *StateDB) Root() types.Hash {
+	var buf [muHashBytes]byte
+	acc := s.accumulator().Bytes()
+	copy(buf[muHashBytes-len(acc):], acc)
+	return types.Hash(blake2b.Sum256(buf[:]))
+}
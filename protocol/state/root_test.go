@@ -0,0 +1,104 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/types"
+	"github.com/go-fusion/protocol/vm"
+)
+
+func TestStateRoot(t *testing.T) {
+	empty := New().Root()
+
+	// the root depends on the entries only, not on how they were written
+	a := New()
+	a.SetBalance(alice, asset, big.NewInt(1))
+	a.Commit()
+	a.SetState(carol, types.Hash{1}, types.Hash{2})
+	b := New()
+	b.SetState(carol, types.Hash{1}, types.Hash{3})
+	b.SetState(carol, types.Hash{1}, types.Hash{2})
+	b.SetBalance(alice, asset, big.NewInt(1))
+	if a.Root() != b.Root() || a.Root() == empty {
+		t.Fatal("expected states with the same entries to have the same root")
+	}
+
+	// every change moves it
+	root := a.Root()
+	snapshot := a.Snapshot()
+	a.SetNonce(alice, 1)
+	if a.Root() == root {
+		t.Fatal("expected a nonce change to change the root")
+	}
+	a.RevertToSnapshot(snapshot)
+	if a.Root() != root {
+		t.Fatal("expected the revert to restore the root")
+	}
+	a.Unspent().AddOutput(types.TxInput{Source: types.Hash{1}}, &UnspentOutput{Owner: bob, AssetID: asset, Amount: big.NewInt(1)})
+	if a.Root() == root {
+		t.Fatal("expected an unspent output to change the root")
+	}
+
+	// and it is kept by commits
+	root = a.Root()
+	a.Commit()
+	if a.Root() != root || NewStateDB(a.kv.db).Root() != root {
+		t.Fatal("expected the root to be kept by the commit")
+	}
+
+	// deleting every entry brings it back to the empty root
+	a.SetBalance(alice, asset, new(big.Int))
+	a.SetState(carol, types.Hash{1}, types.Hash{})
+	a.Unspent().spend(types.TxInput{Source: types.Hash{1}}, nil, new(blockUndo))
+	if a.Root() != empty {
+		t.Fatal("expected an emptied state to have the empty root")
+	}
+}
+
+func TestApplyBlockVerifiesStateRoot(t *testing.T) {
+	s := newTestState()
+	block := sealBlock(t, s, nil, newBlock(1, transferTx(0, bob, 10)), []types.Address{alice})
+	root := block.StateRoot
+	block.StateRoot = types.Hash{1}
+	if _, err := ApplyBlock(s, nil, block, []types.Address{alice}); err == nil {
+		t.Fatal("expected an error for a wrong state root")
+	} else if e, ok := err.(ErrStateRootMismatch); !ok || e.Actual != root {
+		t.Fatalf("expected ErrStateRootMismatch, got %v", err)
+	}
+	if s.Unspent().Height() != 0 || s.GetNonce(alice) != 0 || s.Root() != newTestState().Root() {
+		t.Fatal("the rejected block changed the state")
+	}
+}
+
+func TestBlockExecutorKeepsContractStorage(t *testing.T) {
+	db := dbm.NewMemDB()
+	be, err := NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the constructor stores 1 in slot 0
+	create := transferTx(0, types.Address{}, 0)
+	create.Payload = []byte{byte(vm.PUSH1), 1, byte(vm.PUSH0), byte(vm.SSTORE), byte(vm.STOP)}
+	create.GasLimit = 100000
+	block := sealBlock(t, be.state, be.Genesis(), childBlock(be.Genesis(), create), []types.Address{alice})
+	receipts, err := be.ApplyBlock(block, []types.Address{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := receipts[0].ContractAddress
+
+	be, err = NewBlockExecutor(db, testGenesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := be.state.GetState(contract, types.Hash{}); got != types.BytesToHash([]byte{1}) {
+		t.Fatalf("expected the slot to survive the restart, got %v", got)
+	}
+	if be.state.Root() != block.StateRoot {
+		t.Fatal("expected the state after a restart to have the root of the last block")
+	}
+}
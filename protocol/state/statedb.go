@@ -0,0 +1,272 @@
+package state
+
+import (
+	"encoding/json"
+	"math/big"
+
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+// StateDB keeps account balances, nonces, contract code and contract
+// storage and collects the logs emitted by the transactions of a block. Every change is journaled so a failing
+// transaction can be rolled back with RevertToSnapshot.
+//
+// It also keeps the unspent outputs of the applied blocks, which ApplyBlock
+// updates once per block, after the transactions.
+//
+// The state is read from its DB, and changes are kept in memory until they
+// are committed to it.
+type StateDB struct {
+	kv      *kvStore
+	unspent *UnspentSet
+
+	// logs of the block being applied
+	thash   types.Hash
+	txIndex uint64
+	logs    map[types.Hash][]*types.Log
+	logSize uint64
+
+	journal []journalEntry
+}
+
+// New returns an empty StateDB kept in memory
+func New() *StateDB {
+	return NewStateDB(dbm.NewMemDB())
+}
+
+// NewStateDB returns the StateDB saved in db, which is empty if nothing was
+// committed to it.
+func NewStateDB(db dbm.DB) *StateDB {
+	s := &StateDB{
+		kv:   newKVStore(db),
+		logs: make(map[types.Hash][]*types.Log),
+	}
+	s.unspent = newUnspentSet(s.kv, s.set)
+	return s
+}
+
+// Unspent returns the unspent outputs of the applied blocks
+func (s *StateDB) Unspent() *UnspentSet {
+	return s.unspent
+}
+
+// set sets key to value, journaling the change
+func (s *StateDB) set(key, value []byte) {
+	prev, ok := s.kv.dirty[string(key)]
+	s.journal = append(s.journal, kvChange{key: string(key), prev: prev, existed: ok})
+	s.kv.set(key, value)
+}
+
+// GetBalance returns the balance of asset held by addr
+func (s *StateDB) GetBalance(addr types.Address, asset types.AssetID) *big.Int {
+	return new(big.Int).SetBytes(s.kv.get(balanceKey(addr, asset)))
+}
+
+// SetBalance sets the balance of asset held by addr
+func (s *StateDB) SetBalance(addr types.Address, asset types.AssetID, amount *big.Int) {
+	if amount.Sign() < 0 {
+		cmn.PanicSanity(cmn.Fmt("Negative balance of asset %v for %v", types.Hash(asset), addr))
+	}
+	s.set(balanceKey(addr, asset), amount.Bytes())
+}
+
+// AddBalance adds amount to the balance of asset held by addr
+func (s *StateDB) AddBalance(addr types.Address, asset types.AssetID, amount *big.Int) {
+	s.SetBalance(addr, asset, new(big.Int).Add(s.GetBalance(addr, asset), amount))
+}
+
+// SubBalance subtracts amount from the balance of asset held by addr
+func (s *StateDB) SubBalance(addr types.Address, asset types.AssetID, amount *big.Int) {
+	s.SetBalance(addr, asset, new(big.Int).Sub(s.GetBalance(addr, asset), amount))
+}
+
+// GetNonce returns the next nonce expected from addr
+func (s *StateDB) GetNonce(addr types.Address) uint64 {
+	return bytesUint64(s.kv.get(nonceKey(addr)))
+}
+
+// SetNonce sets the next nonce expected from addr
+func (s *StateDB) SetNonce(addr types.Address, nonce uint64) {
+	var value []byte
+	if nonce != 0 {
+		value = uint64Bytes(nonce)
+	}
+	s.set(nonceKey(addr), value)
+}
+
+// GetCode returns the contract code deployed at addr
+func (s *StateDB) GetCode(addr types.Address) []byte {
+	return s.kv.get(codeKey(addr))
+}
+
+// SetCode deploys code at addr
+func (s *StateDB) SetCode(addr types.Address, code []byte) {
+	s.set(codeKey(addr), code)
+}
+
+// GetState returns the value stored under key by the contract at addr
+func (s *StateDB) GetState(addr types.Address, key types.Hash) types.Hash {
+	return types.BytesToHash(s.kv.get(storageKey(addr, key)))
+}
+
+// SetState stores value under key for the contract at addr
+func (s *StateDB) SetState(addr types.Address, key, value types.Hash) {
+	if value == (types.Hash{}) {
+		s.set(storageKey(addr, key), nil)
+	} else {
+		s.set(storageKey(addr, key), value[:])
+	}
+}
+
+// ClearStorage deletes every value stored by the contract at addr
+func (s *StateDB) ClearStorage(addr types.Address) {
+	for _, key := range s.kv.keys(storageAddrPrefix(addr)) {
+		s.set(key, nil)
+	}
+}
+
+// Prepare sets the transaction that subsequent logs are attributed to
+func (s *StateDB) Prepare(txHash types.Hash, txIndex uint64) {
+	s.thash = txHash
+	s.txIndex = txIndex
+}
+
+// AddLog records a log emitted by the current transaction
+func (s *StateDB) AddLog(log *types.Log) {
+	s.journal = append(s.journal, addLogChange{txHash: s.thash})
+	log.TxHash = s.thash
+	log.TxIndex = s.txIndex
+	log.Index = s.logSize
+	s.logs[s.thash] = append(s.logs[s.thash], log)
+	s.logSize++
+}
+
+// GetLogs returns the logs emitted by the transaction txHash
+func (s *StateDB) GetLogs(txHash types.Hash) []*types.Log {
+	return s.logs[txHash]
+}
+
+// Snapshot returns an identifier for the current state
+func (s *StateDB) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot undoes every change made after the snapshot was taken
+func (s *StateDB) RevertToSnapshot(id int) {
+	for i := len(s.journal) - 1; i >= id; i-- {
+		s.journal[i].revert(s)
+	}
+	s.journal = s.journal[:id]
+}
+
+// Commit writes the changes to the DB, and drops the journal and the
+// collected logs; changes before this point can no longer be reverted
+func (s *StateDB) Commit() {
+	batch := s.kv.db.NewBatch()
+	s.commit(batch)
+	batch.Write()
+}
+
+// commit adds the changes to batch, to be written with the block they
+// belong to, and drops the journal and the collected logs
+func (s *StateDB) commit(batch dbm.Batch) {
+	s.kv.set(stateRootAccKey, s.accumulator().Bytes())
+	s.kv.write(batch)
+	s.journal = nil
+	s.logs = make(map[types.Hash][]*types.Log)
+	s.logSize = 0
+}
+
+// kvPair is the value of a key, nil if the key is deleted
+type kvPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// commitBlock is commit for the block at height: it also adds the values
+// the block changed to batch, so that RevertBlock can restore them.
+func (s *StateDB) commitBlock(batch dbm.Batch, height uint64) {
+	var undo []kvPair
+	for key := range s.kv.dirty {
+		if isAccountKey(key) {
+			undo = append(undo, kvPair{[]byte(key), s.kv.db.Get([]byte(key))})
+		}
+	}
+	bz, err := json.Marshal(undo)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+	s.kv.set(stateUndoKey(height), bz)
+	if height > maxUndoBlocks {
+		s.kv.set(stateUndoKey(height-maxUndoBlocks), nil)
+	}
+	s.commit(batch)
+}
+
+// RevertBlock rolls back the block at height, which must be the last one
+// committed, to the state before it. Like other changes, the rollback is
+// written by the next commit. It fails if there are changes not committed
+// yet.
+func (s *StateDB) RevertBlock(height uint64) error {
+	if len(s.kv.dirty) > 0 {
+		return ErrStateNotCommitted
+	}
+	if last := s.unspent.Height(); height != last || height == 0 {
+		return ErrUnexpectedHeight{Expected: last, Got: height}
+	}
+	bz := s.kv.get(stateUndoKey(height))
+	if bz == nil {
+		return ErrNoUndoData{height}
+	}
+	var undo []kvPair
+	if err := json.Unmarshal(bz, &undo); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Error reading undo data: %v", err))
+	}
+
+	if err := s.unspent.RevertBlock(height); err != nil {
+		return err
+	}
+	for _, pair := range undo {
+		s.kv.set(pair.Key, pair.Value)
+	}
+	s.kv.set(stateUndoKey(height), nil)
+	return nil
+}
+
+//----------------------------------------------------------
+// journal
+
+type journalEntry interface {
+	revert(*StateDB)
+}
+
+type kvChange struct {
+	key     string
+	prev    []byte
+	existed bool // whether key was changed before
+}
+
+func (ch kvChange) revert(s *StateDB) {
+	if ch.existed {
+		s.kv.dirty[ch.key] = ch.prev
+	} else {
+		delete(s.kv.dirty, ch.key)
+	}
+}
+
+type addLogChange struct {
+	txHash types.Hash
+}
+
+func (ch addLogChange) revert(s *StateDB) {
+	logs := s.logs[ch.txHash]
+	if len(logs) == 1 {
+		delete(s.logs, ch.txHash)
+	} else {
+		s.logs[ch.txHash] = logs[:len(logs)-1]
+	}
+	s.logSize--
+}
@@ -0,0 +1,42 @@
+package state
+
+import (
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+func TestStateDBCommit(t *testing.T) {
+	db := dbm.NewMemDB()
+	s := NewStateDB(db)
+	one, two := types.Hash{1}, types.Hash{2}
+	s.SetCode(carol, []byte{1})
+	s.SetState(carol, one, one)
+	s.SetNonce(carol, 1)
+	s.Commit()
+
+	// pending changes are only seen by s
+	s.SetState(carol, two, two)
+	snapshot := s.Snapshot()
+	s.SetState(carol, one, two)
+	s.ClearStorage(carol)
+	if s.GetState(carol, one) != (types.Hash{}) || s.GetState(carol, two) != (types.Hash{}) {
+		t.Fatal("expected ClearStorage to clear committed and pending slots")
+	}
+	s.RevertToSnapshot(snapshot)
+	if s.GetState(carol, one) != one || s.GetState(carol, two) != two {
+		t.Fatal("expected the revert to restore the slots")
+	}
+	if NewStateDB(db).GetState(carol, two) != (types.Hash{}) {
+		t.Fatal("expected pending changes not to be written")
+	}
+
+	s.Commit()
+	s = NewStateDB(db)
+	if s.GetState(carol, one) != one || s.GetState(carol, two) != two ||
+		len(s.GetCode(carol)) != 1 || s.GetNonce(carol) != 1 {
+		t.Fatal("expected the committed state to be read back")
+	}
+}
@@ -0,0 +1,166 @@
+package state
+
+import (
+	"math/big"
+
+	"github.com/go-fusion/protocol/types"
+	"github.com/go-fusion/protocol/vm"
+)
+
+// ExecutionResult describes the outcome of an applied transaction.
+type ExecutionResult struct {
+	GasUsed uint64
+	Burned  *big.Int // part of the base fee removed from circulation
+
+	// contract execution only
+	Err             error // set if the contract failed; its changes were reverted but gas is charged
+	ReturnData      []byte
+	ContractAddress types.Address // address of the deployed contract
+}
+
+// Failed returns true if contract execution failed.
+func (r *ExecutionResult) Failed() bool {
+	return r.Err != nil
+}
+
+// isContractCreation returns true if tx deploys its Payload as a contract.
+func isContractCreation(tx *types.Transaction) bool {
+	return !tx.IsBatch() && tx.TO == (types.Address{})
+}
+
+// ApplyTransaction applies tx sent by from in the block described by ctx.
+// The sender buys GasLimit gas at GasPrice up front and is refunded for
+// what is left. A transaction with an empty TO deploys its Payload as a
+// contract and one sent to a contract calls it with Payload as input.
+// Either every output is paid or, on error, the state and the gas pool are
+// left untouched. A failing contract is not an error: its changes are
+// reverted, the gas is charged and ExecutionResult.Err is set.
+func ApplyTransaction(s *StateDB, ctx *BlockContext, gp *GasPool, tx *types.Transaction, from types.Address) (*ExecutionResult, error) {
+	if err := tx.ValidateBasic(); err != nil {
+		return nil, err
+	}
+	if isContractCreation(tx) && len(tx.Inputs) > 0 {
+		return nil, ErrCreationSpendsInputs
+	}
+
+	nonce := s.GetNonce(from)
+	if tx.Nonce < nonce {
+		return nil, ErrNonceTooLow
+	} else if tx.Nonce > nonce {
+		return nil, ErrNonceTooHigh
+	}
+
+	gasUsed, err := IntrinsicGas(tx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.GasLimit < gasUsed {
+		return nil, ErrIntrinsicGas
+	}
+
+	gasPrice := tx.GasPrice
+	if gasPrice == nil {
+		gasPrice = new(big.Int)
+	}
+	baseFee := ctx.BaseFee
+	if baseFee == nil {
+		baseFee = new(big.Int)
+	}
+	if gasPrice.Cmp(baseFee) < 0 {
+		return nil, ErrFeeCapTooLow
+	}
+
+	if err := gp.SubGas(tx.GasLimit); err != nil {
+		return nil, err
+	}
+
+	snapshot := s.Snapshot()
+	result, err := applyMessage(s, ctx, tx, from, gasUsed, gasPrice, baseFee)
+	if err != nil {
+		s.RevertToSnapshot(snapshot)
+		gp.AddGas(tx.GasLimit)
+		return nil, err
+	}
+	gp.AddGas(tx.GasLimit - result.GasUsed)
+	s.SetNonce(from, nonce+1)
+	return result, nil
+}
+
+func applyMessage(s *StateDB, ctx *BlockContext, tx *types.Transaction, from types.Address,
+	gasUsed uint64, gasPrice, baseFee *big.Int) (*ExecutionResult, error) {
+
+	// buy gas
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(tx.GasLimit), gasPrice)
+	if s.GetBalance(from, types.NativeAssetID).Cmp(cost) < 0 {
+		return nil, ErrInsufficientBalance{from, types.NativeAssetID}
+	}
+	s.SubBalance(from, types.NativeAssetID, cost)
+
+	result := new(ExecutionResult)
+	if isContractCreation(tx) || (!tx.IsBatch() && len(s.GetCode(tx.TO)) > 0) {
+		evm := vm.New(vm.Context{
+			Origin:    from,
+			GasPrice:  gasPrice,
+			Coinbase:  ctx.Validator,
+			Height:    ctx.Height,
+			Timestamp: ctx.Timestamp,
+			GasLimit:  ctx.GasLimit,
+			BaseFee:   baseFee,
+			GetHash:   ctx.GetHash,
+		}, s)
+		gasLeft := tx.GasLimit - gasUsed
+		if isContractCreation(tx) {
+			result.ReturnData, result.ContractAddress, gasLeft, result.Err = evm.Create(from, tx.Payload, gasLeft, tx.AssetID, tx.Amount)
+		} else {
+			result.ReturnData, gasLeft, result.Err = evm.Call(from, tx.TO, tx.Payload, gasLeft, tx.AssetID, tx.Amount)
+		}
+		gasUsed = tx.GasLimit - gasLeft
+		if refund := evm.Refund(); refund > gasUsed/vm.RefundQuotient {
+			gasUsed -= gasUsed / vm.RefundQuotient
+		} else {
+			gasUsed -= refund
+		}
+	} else if err := applyTransfers(s, tx, from); err != nil {
+		return nil, err
+	}
+
+	// refund unused gas
+	refund := new(big.Int).SetUint64(tx.GasLimit - gasUsed)
+	s.AddBalance(from, types.NativeAssetID, refund.Mul(refund, gasPrice))
+
+	// pay the validator its share of the base fee and the tip
+	used := new(big.Int).SetUint64(gasUsed)
+	burned, paid := splitBaseFee(new(big.Int).Mul(used, baseFee))
+	tip := new(big.Int).Sub(gasPrice, baseFee)
+	paid.Add(paid, tip.Mul(tip, used))
+	s.AddBalance(ctx.Validator, types.NativeAssetID, paid)
+
+	result.GasUsed = gasUsed
+	result.Burned = burned
+	return result, nil
+}
+
+func applyTransfers(s *StateDB, tx *types.Transaction, from types.Address) error {
+	for _, out := range tx.Transfers() {
+		amount := out.Amount
+		if amount == nil {
+			amount = new(big.Int)
+		}
+		if s.GetBalance(from, out.AssetID).Cmp(amount) < 0 {
+			return ErrInsufficientBalance{from, out.AssetID}
+		}
+		s.SubBalance(from, out.AssetID, amount)
+		s.AddBalance(out.TO, out.AssetID, amount)
+		s.AddLog(&types.Log{
+			Address: from,
+			Topics:  []types.Hash{types.TransferEventTopic, addressTopic(from), addressTopic(out.TO), types.Hash(out.AssetID)},
+			Data:    amount.Bytes(),
+		})
+	}
+	return nil
+}
+
+// addressTopic left pads addr to a log topic
+func addressTopic(addr types.Address) types.Hash {
+	return types.BytesToHash(addr[:])
+}
@@ -0,0 +1,45 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/go-fusion/protocol/types"
+	"github.com/go-fusion/protocol/vm"
+)
+
+func TestApplyTransactionRefund(t *testing.T) {
+	clear := []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.SSTORE)}
+	clearTwo := append([]byte{byte(vm.PUSH0), byte(vm.PUSH1), 1, byte(vm.SSTORE)}, clear...)
+	usedTwo := TxGas + 2 + 3 + 2 + 2 + 2*vm.SstoreResetGas
+	tests := []struct {
+		name string
+		code []byte
+		want uint64
+	}{
+		// 4800 is below a fifth of the gas used
+		{"uncapped", clear, TxGas + 2 + 2 + vm.SstoreResetGas - vm.SstoreClearRefund},
+		// 9600 is capped to a fifth of the gas used
+		{"capped", clearTwo, usedTwo - usedTwo/vm.RefundQuotient},
+	}
+	for _, tt := range tests {
+		s := newTestState()
+		s.SetCode(carol, tt.code)
+		s.SetState(carol, types.Hash{}, types.Hash{1})
+		s.SetState(carol, types.BytesToHash([]byte{1}), types.Hash{1})
+
+		tx := transferTx(0, carol, 0)
+		tx.GasLimit = 100000
+		ctx := NewBlockContext(&types.BlockHeader{Height: 1, GasLimit: DefaultBlockGasLimit, BaseFee: big.NewInt(InitialBaseFee)}, nil)
+		result, err := ApplyTransaction(s, ctx, new(GasPool).AddGas(DefaultBlockGasLimit), tx, alice)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if result.Failed() {
+			t.Fatalf("%s: %v", tt.name, result.Err)
+		}
+		if result.GasUsed != tt.want {
+			t.Errorf("%s: used %d gas, want %d", tt.name, result.GasUsed, tt.want)
+		}
+	}
+}
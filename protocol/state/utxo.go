@@ -0,0 +1,290 @@
+package state
+
+import (
+	"encoding/json"
+	"math/big"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+// maxUndoBlocks bounds how deep a reorg the unspent set can roll back.
+const maxUndoBlocks = 256
+
+// UnspentOutput is an output that has been created by an applied
+// transaction and not yet spent by a TxInput.
+type UnspentOutput struct {
+	Owner   types.Address
+	AssetID types.AssetID
+	Amount  *big.Int
+	Output  types.TxOutput
+	Height  uint64 // height of the block that created it
+}
+
+// blockUndo holds what is needed to roll back one applied block.
+type blockUndo struct {
+	Spent   []spentOutput
+	Created []types.TxInput
+}
+
+type spentOutput struct {
+	Input  types.TxInput
+	Output *UnspentOutput
+}
+
+// UnspentSet indexes unspent transaction outputs by (Source, SourceID).
+// Blocks are applied in order and can be reverted from the tip down.
+// It is stored with the rest of the state, and saved when the state is
+// committed.
+// NOTE: Not goroutine safe.
+type UnspentSet struct {
+	kv  *kvStore
+	set func(key, value []byte) // journaled by the StateDB
+}
+
+func newUnspentSet(kv *kvStore, set func(key, value []byte)) *UnspentSet {
+	return &UnspentSet{kv: kv, set: set}
+}
+
+// Height returns the height of the last applied block.
+func (us *UnspentSet) Height() uint64 {
+	return bytesUint64(us.kv.get(stateHeightKey))
+}
+
+func (us *UnspentSet) setHeight(height uint64) {
+	us.set(stateHeightKey, uint64Bytes(height))
+}
+
+// Size returns the number of unspent outputs.
+func (us *UnspentSet) Size() int {
+	return int(bytesUint64(us.kv.get(unspentSizeKey)))
+}
+
+func (us *UnspentSet) addSize(delta int) {
+	us.set(unspentSizeKey, uint64Bytes(uint64(us.Size()+delta)))
+}
+
+// Get returns the unspent output referenced by in, or nil.
+func (us *UnspentSet) Get(in types.TxInput) *UnspentOutput {
+	bz := us.kv.get(unspentKey(in))
+	if bz == nil {
+		return nil
+	}
+	out := new(UnspentOutput)
+	if err := json.Unmarshal(bz, out); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Error reading unspent output: %v", err))
+	}
+	return out
+}
+
+// Has returns true if in references an unspent output.
+func (us *UnspentSet) Has(in types.TxInput) bool {
+	return us.kv.get(unspentKey(in)) != nil
+}
+
+// AddOutput inserts an output outside of block processing, e.g. for genesis allocations.
+func (us *UnspentSet) AddOutput(in types.TxInput, out *UnspentOutput) {
+	if !us.Has(in) {
+		us.addSize(1)
+	}
+	us.put(in, out)
+}
+
+func (us *UnspentSet) put(in types.TxInput, out *UnspentOutput) {
+	bz, err := json.Marshal(out)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+	us.set(unspentKey(in), bz)
+}
+
+// ApplyBlock spends the inputs and records the outputs of every transaction
+// in block. senders[i] must be the sender of block.Transactions[i].
+// The block is applied atomically: on error the set is unchanged.
+func (us *UnspentSet) ApplyBlock(block *types.Block, senders []types.Address) error {
+	return us.apply(block.Height, block.Transactions, senders)
+}
+
+// apply applies txs as the block at height.
+func (us *UnspentSet) apply(height uint64, txs []*types.Transaction, senders []types.Address) error {
+	if last := us.Height(); height != last+1 {
+		return ErrUnexpectedHeight{Expected: last + 1, Got: height}
+	}
+	if len(senders) != len(txs) {
+		return ErrSendersMismatch
+	}
+
+	undo := new(blockUndo)
+	for i, tx := range txs {
+		if err := us.applyTx(tx, senders[i], height, undo); err != nil {
+			us.rollback(undo)
+			return err
+		}
+	}
+
+	bz, err := json.Marshal(undo)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+	us.set(unspentUndoKey(height), bz)
+	us.setHeight(height)
+	if height > maxUndoBlocks {
+		us.pruneUndo(height - maxUndoBlocks)
+	}
+	return nil
+}
+
+// RevertBlock rolls back the block at the tip of the set.
+func (us *UnspentSet) RevertBlock(height uint64) error {
+	if last := us.Height(); height != last || height == 0 {
+		return ErrUnexpectedHeight{Expected: last, Got: height}
+	}
+	undo := us.loadUndo(height)
+	if undo == nil {
+		return ErrNoUndoData{height}
+	}
+	us.rollback(undo)
+	us.set(unspentUndoKey(height), nil)
+	us.setHeight(height - 1)
+	return nil
+}
+
+func (us *UnspentSet) loadUndo(height uint64) *blockUndo {
+	bz := us.kv.get(unspentUndoKey(height))
+	if bz == nil {
+		return nil
+	}
+	undo := new(blockUndo)
+	if err := json.Unmarshal(bz, undo); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Error reading undo data: %v", err))
+	}
+	return undo
+}
+
+// pruneUndo drops the undo data of the block at height, which can no
+// longer be reverted, and forgets which outputs it spent.
+func (us *UnspentSet) pruneUndo(height uint64) {
+	undo := us.loadUndo(height)
+	if undo == nil {
+		return
+	}
+	for _, spent := range undo.Spent {
+		us.set(spentKey(spent.Input), nil)
+	}
+	us.set(unspentUndoKey(height), nil)
+}
+
+// applyTx spends the inputs of tx and records its outputs. The value of
+// outputs is paid from balances, and outputs earmark it. A transaction
+// spending inputs must therefore pay every transfer from them: transferring
+// an asset none of them hold would create an output from nothing.
+func (us *UnspentSet) applyTx(tx *types.Transaction, from types.Address, height uint64, undo *blockUndo) error {
+	if err := tx.ValidateBasic(); err != nil {
+		return err
+	}
+	txHash := tx.Hash()
+
+	// spend inputs, summing their value per asset
+	var (
+		assets []types.AssetID
+		totals = make(map[types.AssetID]*big.Int)
+	)
+	for _, in := range tx.Inputs {
+		out := us.Get(*in)
+		if out == nil {
+			if us.wasSpent(*in) {
+				return ErrDoubleSpend{*in}
+			}
+			return ErrOutputNotFound{*in}
+		}
+		if out.Owner != from {
+			return ErrOutputNotOwned{*in, from}
+		}
+		us.spend(*in, out, undo)
+
+		if _, ok := totals[out.AssetID]; !ok {
+			assets = append(assets, out.AssetID)
+			totals[out.AssetID] = new(big.Int)
+		}
+		totals[out.AssetID].Add(totals[out.AssetID], out.Amount)
+	}
+
+	// record transfer outputs
+	transfers := tx.Transfers()
+	for i, tr := range transfers {
+		amount := tr.Amount
+		if amount == nil {
+			amount = new(big.Int)
+		}
+		if _, ok := totals[tr.AssetID]; !ok && len(tx.Inputs) > 0 {
+			return ErrInsufficientInputs{txHash, tr.AssetID}
+		}
+		us.addCreated(types.TxInput{Source: txHash, SourceID: uint64(i)}, &UnspentOutput{
+			Owner:   tr.TO,
+			AssetID: tr.AssetID,
+			Amount:  new(big.Int).Set(amount),
+			Output:  tr.Output,
+			Height:  height,
+		}, undo)
+		if total, ok := totals[tr.AssetID]; ok {
+			total.Sub(total, amount)
+		}
+	}
+
+	// whatever the inputs did not pay out comes back to the sender as odd
+	id := uint64(len(transfers))
+	for _, asset := range assets {
+		odd := totals[asset]
+		if odd.Sign() < 0 {
+			return ErrInsufficientInputs{txHash, asset}
+		}
+		if odd.Sign() == 0 {
+			continue
+		}
+		us.addCreated(types.TxInput{Source: txHash, SourceID: id}, &UnspentOutput{
+			Owner:   from,
+			AssetID: asset,
+			Amount:  odd,
+			Output:  tx.Output,
+			Height:  height,
+		}, undo)
+		id++
+	}
+	return nil
+}
+
+func (us *UnspentSet) addCreated(in types.TxInput, out *UnspentOutput, undo *blockUndo) {
+	us.AddOutput(in, out)
+	undo.Created = append(undo.Created, in)
+}
+
+// spend removes the output in, remembering it was spent for as long as the
+// block spending it can be reverted.
+func (us *UnspentSet) spend(in types.TxInput, out *UnspentOutput, undo *blockUndo) {
+	us.set(unspentKey(in), nil)
+	us.set(spentKey(in), []byte{1})
+	us.addSize(-1)
+	undo.Spent = append(undo.Spent, spentOutput{in, out})
+}
+
+// wasSpent returns true if in was spent by the pending block or by one of
+// the blocks still covered by undo data.
+func (us *UnspentSet) wasSpent(in types.TxInput) bool {
+	return us.kv.get(spentKey(in)) != nil
+}
+
+// rollback restores spent outputs and removes created ones. Outputs created
+// and spent within the same block end up removed.
+func (us *UnspentSet) rollback(undo *blockUndo) {
+	for _, spent := range undo.Spent {
+		us.set(spentKey(spent.Input), nil)
+		us.AddOutput(spent.Input, spent.Output)
+	}
+	for _, in := range undo.Created {
+		if us.Has(in) {
+			us.set(unspentKey(in), nil)
+			us.addSize(-1)
+		}
+	}
+}
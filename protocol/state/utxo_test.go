@@ -0,0 +1,271 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+var (
+	alice = types.Address{1}
+	bob   = types.Address{2}
+	carol = types.Address{3}
+	asset = types.AssetID{7}
+)
+
+func transferTx(nonce uint64, to types.Address, amount int64, inputs ...*types.TxInput) *types.Transaction {
+	return &types.Transaction{
+		AssetID:  asset,
+		Nonce:    nonce,
+		Amount:   big.NewInt(amount),
+		GasPrice: big.NewInt(InitialBaseFee),
+		GasLimit: TxGas + uint64(len(inputs))*TxInputGas,
+		TO:       to,
+		Inputs:   inputs,
+	}
+}
+
+// testGenesis allocates to alice and bob enough to pay for gas, and some
+// of asset.
+func testGenesis() *Genesis {
+	genesis := DefaultGenesis("test")
+	for _, addr := range []types.Address{alice, bob} {
+		genesis.Alloc = append(genesis.Alloc,
+			GenesisAlloc{addr, types.Hash(types.NativeAssetID), new(big.Int).Mul(big.NewInt(InitialBaseFee), big.NewInt(1000000))},
+			GenesisAlloc{addr, types.Hash(asset), big.NewInt(1000)})
+	}
+	return genesis
+}
+
+// newTestState returns the committed state of testGenesis.
+func newTestState() *StateDB {
+	s := New()
+	testGenesis().apply(s)
+	s.Commit()
+	return s
+}
+
+// copyState returns a copy of s, pending changes included.
+func copyState(s *StateDB) *StateDB {
+	db := dbm.NewMemDB()
+	iter := s.kv.db.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		db.Set(iter.Key(), iter.Value())
+	}
+	iter.Close()
+	c := NewStateDB(db)
+	for key, value := range s.kv.dirty {
+		c.kv.dirty[key] = value
+	}
+	return c
+}
+
+// sealBlock fills in the header fields ApplyBlock checks, by applying the
+// block following parent to copies of pre. Blocks that fail for another
+// reason than their state root are left without one.
+func sealBlock(t *testing.T, pre *StateDB, parent *types.BlockHeader, block *types.Block, senders []types.Address) *types.Block {
+	s := copyState(pre)
+	ctx := NewBlockContext(&block.BlockHeader, nil)
+	gp := new(GasPool).AddGas(block.GasLimit)
+	var (
+		gasUsed  uint64
+		receipts []*types.Receipt
+	)
+	for i, tx := range block.Transactions {
+		s.Prepare(tx.Hash(), uint64(i))
+		result, err := ApplyTransaction(s, ctx, gp, tx, senders[i])
+		if err != nil {
+			t.Fatalf("sealing tx %d: %v", i, err)
+		}
+		gasUsed += result.GasUsed
+		status := types.ReceiptStatusSuccessful
+		if result.Failed() {
+			status = types.ReceiptStatusFailed
+		}
+		receipts = append(receipts, types.NewReceipt(tx.Hash(), status, result.GasUsed, gasUsed, s.GetLogs(tx.Hash())))
+	}
+	block.GasUsed = gasUsed
+	block.LogsBloom = types.CreateBloom(receipts)
+	block.TransactionsStatusHash = types.ReceiptsHash(receipts)
+
+	// the root is only known once the whole block is applied
+	_, err := ApplyBlock(copyState(pre), parent, block, senders)
+	if e, ok := err.(ErrStateRootMismatch); ok {
+		block.StateRoot = e.Actual
+	}
+	return block
+}
+
+func newBlock(height uint64, txs ...*types.Transaction) *types.Block {
+	return &types.Block{
+		BlockHeader: types.BlockHeader{
+			Height:   height,
+			GasLimit: DefaultBlockGasLimit,
+			BaseFee:  big.NewInt(InitialBaseFee),
+		},
+		Transactions: txs,
+	}
+}
+
+// childBlock returns a block of txs following parent.
+func childBlock(parent *types.BlockHeader, txs ...*types.Transaction) *types.Block {
+	return &types.Block{
+		BlockHeader: types.BlockHeader{
+			Height:            parent.Height + 1,
+			PreviousBlockHash: parent.Hash(),
+			GasLimit:          parent.GasLimit,
+			BaseFee:           CalcBaseFee(parent),
+		},
+		Transactions: txs,
+	}
+}
+
+func TestUnspentSetApplyAndRevert(t *testing.T) {
+	us := New().Unspent()
+
+	pay := transferTx(0, bob, 10)
+	if err := us.ApplyBlock(newBlock(1, pay), []types.Address{alice}); err != nil {
+		t.Fatal(err)
+	}
+	sent := types.TxInput{Source: pay.Hash(), SourceID: types.TxOutputSend}
+	if out := us.Get(sent); out == nil || out.Owner != bob || out.Amount.Int64() != 10 {
+		t.Fatalf("expected an output of 10 to bob, got %+v", out)
+	}
+
+	// bob spends it, paying 4 to carol and getting 6 back as odd
+	spend := transferTx(0, carol, 4, &sent)
+	if err := us.ApplyBlock(newBlock(2, spend), []types.Address{bob}); err != nil {
+		t.Fatal(err)
+	}
+	if us.Has(sent) {
+		t.Fatal("spent output still unspent")
+	}
+	odd := types.TxInput{Source: spend.Hash(), SourceID: types.TxOutputOdd}
+	if out := us.Get(odd); out == nil || out.Owner != bob || out.Amount.Int64() != 6 {
+		t.Fatalf("expected an odd of 6 to bob, got %+v", out)
+	}
+
+	// spending it again is a double spend
+	again := transferTx(1, carol, 1, &sent)
+	if err := us.ApplyBlock(newBlock(3, again), []types.Address{bob}); err == nil {
+		t.Fatal("expected a double spend error")
+	} else if _, ok := err.(ErrDoubleSpend); !ok {
+		t.Fatalf("expected ErrDoubleSpend, got %v", err)
+	}
+
+	// so is spending it twice in a block
+	us2 := New().Unspent()
+	us2.AddOutput(sent, &UnspentOutput{Owner: bob, AssetID: asset, Amount: big.NewInt(10)})
+	twice := newBlock(1, transferTx(0, carol, 1, &sent), transferTx(1, carol, 1, &sent))
+	if err := us2.ApplyBlock(twice, []types.Address{bob, bob}); err == nil {
+		t.Fatal("expected a double spend error")
+	}
+	if !us2.Has(sent) || us2.Size() != 1 {
+		t.Fatal("failed block was not rolled back")
+	}
+
+	if err := us.RevertBlock(2); err != nil {
+		t.Fatal(err)
+	}
+	if !us.Has(sent) || us.Has(odd) {
+		t.Fatal("revert did not restore the spent output")
+	}
+}
+
+func TestUnspentSetNilEntries(t *testing.T) {
+	us := New().Unspent()
+	nilOutput := &types.Transaction{Version: types.TxVersionBatch, Outputs: []*types.TransferOutput{nil}}
+	if err := us.ApplyBlock(newBlock(1, nilOutput), []types.Address{alice}); err != types.ErrTxNilOutput {
+		t.Fatalf("expected ErrTxNilOutput, got %v", err)
+	}
+	nilInput := transferTx(0, bob, 1, nil)
+	if err := us.ApplyBlock(newBlock(1, nilInput), []types.Address{alice}); err != types.ErrTxNilInput {
+		t.Fatalf("expected ErrTxNilInput, got %v", err)
+	}
+}
+
+func TestApplyBlockTracksUnspent(t *testing.T) {
+	s := newTestState()
+	pay := transferTx(0, bob, 10)
+	block := sealBlock(t, s, nil, newBlock(1, pay), []types.Address{alice})
+	if _, err := ApplyBlock(s, nil, block, []types.Address{alice}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Unspent().Height() != 1 {
+		t.Fatalf("expected the unspent set at height 1, got %d", s.Unspent().Height())
+	}
+	sent := types.TxInput{Source: pay.Hash(), SourceID: types.TxOutputSend}
+	if out := s.Unspent().Get(sent); out == nil || out.Owner != bob {
+		t.Fatalf("expected an output to bob, got %+v", out)
+	}
+
+	// an input that does not exist fails the whole block
+	bad := transferTx(0, carol, 1, &types.TxInput{Source: types.Hash{9}})
+	parent := &block.BlockHeader
+	block = sealBlock(t, s, parent, childBlock(parent, bad), []types.Address{bob})
+	balance := s.GetBalance(bob, asset)
+	if _, err := ApplyBlock(s, parent, block, []types.Address{bob}); err == nil {
+		t.Fatal("expected an error for an unknown input")
+	} else if _, ok := err.(ErrOutputNotFound); !ok {
+		t.Fatalf("expected ErrOutputNotFound, got %v", err)
+	}
+	if s.GetBalance(bob, asset).Cmp(balance) != 0 {
+		t.Fatal("failed block changed balances")
+	}
+}
+
+func TestApplyBlockNilOutput(t *testing.T) {
+	s := newTestState()
+	tx := &types.Transaction{Version: types.TxVersionBatch, Outputs: []*types.TransferOutput{nil}}
+	block := newBlock(1, tx)
+	if _, err := ApplyBlock(s, nil, block, []types.Address{alice}); err != types.ErrTxNilOutput {
+		t.Fatalf("expected ErrTxNilOutput, got %v", err)
+	}
+}
+
+func TestUnspentSetInputsCoverTransfers(t *testing.T) {
+	s := New()
+	us := s.Unspent()
+	owned := types.TxInput{Source: types.Hash{1}}
+	us.AddOutput(owned, &UnspentOutput{Owner: bob, AssetID: asset, Amount: big.NewInt(10)})
+
+	// an input of asset can't pay a transfer of another asset
+	other := transferTx(0, carol, 5, &owned)
+	other.AssetID = types.NativeAssetID
+	if err := us.ApplyBlock(newBlock(1, other), []types.Address{bob}); err == nil {
+		t.Fatal("expected an error for a transfer not covered by the inputs")
+	} else if e, ok := err.(ErrInsufficientInputs); !ok || e.Asset != types.NativeAssetID {
+		t.Fatalf("expected ErrInsufficientInputs, got %v", err)
+	}
+
+	// nor can it cover more than its amount
+	if err := us.ApplyBlock(newBlock(1, transferTx(0, carol, 11, &owned)), []types.Address{bob}); err == nil {
+		t.Fatal("expected an error for a transfer above the inputs")
+	} else if _, ok := err.(ErrInsufficientInputs); !ok {
+		t.Fatalf("expected ErrInsufficientInputs, got %v", err)
+	}
+	if !us.Has(owned) || us.Size() != 1 || us.Height() != 0 {
+		t.Fatal("failed blocks changed the set")
+	}
+
+	// a batch paying two assets needs inputs of both
+	batch := func(outputs ...*types.TransferOutput) *types.Transaction {
+		return &types.Transaction{
+			Version:  types.TxVersionBatch,
+			GasPrice: big.NewInt(InitialBaseFee),
+			Inputs:   []*types.TxInput{&owned},
+			Outputs:  outputs,
+		}
+	}
+	paid := &types.TransferOutput{AssetID: asset, TO: carol, Amount: big.NewInt(4)}
+	unpaid := &types.TransferOutput{AssetID: types.NativeAssetID, TO: carol, Amount: big.NewInt(1)}
+	if err := us.ApplyBlock(newBlock(1, batch(paid, unpaid)), []types.Address{bob}); err == nil {
+		t.Fatal("expected an error for a batch transfer not covered by the inputs")
+	}
+	if err := us.ApplyBlock(newBlock(1, batch(paid)), []types.Address{bob}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,15 @@
+package store
+
+import (
+	"fmt"
+)
+
+type ErrFilterRangeTooLarge struct {
+	From  uint64
+	To    uint64
+	Limit uint64
+}
+
+func (e ErrFilterRangeTooLarge) Error() string {
+	return fmt.Sprintf("Filter block range [%v, %v] exceeds limit of %v blocks", e.From, e.To, e.Limit)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"github.com/go-fusion/protocol/types"
+)
+
+// maxFilterBlockRange bounds the number of blocks a single query may scan.
+const maxFilterBlockRange = 10000
+
+// FilterQuery selects logs by block range, emitting address and topics.
+//
+// Topics restricts matches by position:
+//
+//	{}                  matches any topics
+//	{{A}}               matches A in the first position
+//	{{}, {B}}           matches anything in the first and B in the second position
+//	{{A, B}, {C, D}}    matches (A or B) in the first and (C or D) in the second position
+type FilterQuery struct {
+	FromBlock uint64          `json:"fromBlock"` // 0 means the first block
+	ToBlock   uint64          `json:"toBlock"`   // 0 means the latest block
+	Addresses []types.Address `json:"addresses"`
+	Topics    [][]types.Hash  `json:"topics"`
+}
+
+// GetLogs returns the logs matching q. Blocks whose LogsBloom cannot match
+// are skipped without loading their receipts.
+func (bs *BlockStore) GetLogs(q *FilterQuery) ([]*types.Log, error) {
+	from, to := q.FromBlock, q.ToBlock
+	if from == 0 {
+		from = 1
+	}
+	if head := bs.Height(); to == 0 || to > head {
+		to = head
+	}
+	if from > to {
+		return nil, nil
+	}
+	if to-from >= maxFilterBlockRange {
+		return nil, ErrFilterRangeTooLarge{from, to, maxFilterBlockRange}
+	}
+
+	var logs []*types.Log
+	for height := from; height <= to; height++ {
+		header := bs.LoadBlockHeader(height)
+		if header == nil || !bloomFilter(header.LogsBloom, q.Addresses, q.Topics) {
+			continue
+		}
+		for _, receipt := range bs.LoadReceipts(height) {
+			logs = append(logs, filterLogs(receipt.Logs, q.Addresses, q.Topics)...)
+		}
+	}
+	return logs, nil
+}
+
+// bloomFilter returns false if bloom cannot contain a log matching addresses and topics.
+func bloomFilter(bloom types.Bloom, addresses []types.Address, topics [][]types.Hash) bool {
+	if len(addresses) > 0 {
+		var included bool
+		for _, addr := range addresses {
+			if bloom.Test(addr[:]) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, sub := range topics {
+		included := len(sub) == 0 // empty rule set == wildcard
+		for _, topic := range sub {
+			if bloom.Test(topic[:]) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	return true
+}
+
+// filterLogs returns the logs matching addresses and topics.
+func filterLogs(logs []*types.Log, addresses []types.Address, topics [][]types.Hash) []*types.Log {
+	var ret []*types.Log
+LOGS_LOOP:
+	for _, log := range logs {
+		if len(addresses) > 0 && !includesAddress(addresses, log.Address) {
+			continue
+		}
+		// If the to filtered topics is greater than the amount of topics in logs, skip.
+		if len(topics) > len(log.Topics) {
+			continue
+		}
+		for i, sub := range topics {
+			match := len(sub) == 0 // empty rule set == wildcard
+			for _, topic := range sub {
+				if log.Topics[i] == topic {
+					match = true
+					break
+				}
+			}
+			if !match {
+				continue LOGS_LOOP
+			}
+		}
+		ret = append(ret, log)
+	}
+	return ret
+}
+
+func includesAddress(addresses []types.Address, a types.Address) bool {
+	for _, addr := range addresses {
+		if addr == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+var blockStoreKey = []byte("blockStore")
+
+/*
+BlockStore is a simple low level store for block headers and the receipts
+of their transactions.
+
+The store can be assumed to contain all contiguous blocks between 1 and
+Height(). Headers are kept apart from receipts so that log queries can
+skip a block by testing its LogsBloom without loading the receipts.
+*/
+type BlockStore struct {
+	db dbm.DB
+
+	mtx    sync.RWMutex
+	height uint64
+}
+
+// NewBlockStore returns a new BlockStore with the given DB,
+// initialized to the last height that was committed to the DB.
+func NewBlockStore(db dbm.DB) *BlockStore {
+	bsj := loadBlockStoreStateJSON(db)
+	return &BlockStore{
+		height: bsj.Height,
+		db:     db,
+	}
+}
+
+// Height returns the last known contiguous block height.
+func (bs *BlockStore) Height() uint64 {
+	bs.mtx.RLock()
+	defer bs.mtx.RUnlock()
+	return bs.height
+}
+
+// LoadBlockHeader returns the header with the given height.
+// If no header is found for that height, it returns nil.
+func (bs *BlockStore) LoadBlockHeader(height uint64) *types.BlockHeader {
+	bz := bs.db.Get(calcHeaderKey(height))
+	if len(bz) == 0 {
+		return nil
+	}
+	var header = new(types.BlockHeader)
+	if err := json.Unmarshal(bz, header); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Error reading block header: %v", err))
+	}
+	return header
+}
+
+// LoadReceipts returns the receipts of the block with the given height.
+// If no receipts are found for that height, it returns nil.
+func (bs *BlockStore) LoadReceipts(height uint64) []*types.Receipt {
+	bz := bs.db.Get(calcReceiptsKey(height))
+	if len(bz) == 0 {
+		return nil
+	}
+	var receipts []*types.Receipt
+	if err := json.Unmarshal(bz, &receipts); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Error reading receipts: %v", err))
+	}
+	return receipts
+}
+
+// SaveBlock persists the header of the given block and its receipts, in
+// one write with the changes of batch, such as the state after the block.
+// batch may be nil. The block must be the one following Height().
+func (bs *BlockStore) SaveBlock(batch dbm.Batch, header *types.BlockHeader, receipts []*types.Receipt) {
+	if header == nil {
+		cmn.PanicSanity("BlockStore can only save a non-nil block header")
+	}
+	height := header.Height
+	if g, w := height, bs.Height()+1; g != w {
+		cmn.PanicSanity(cmn.Fmt("BlockStore can only save contiguous blocks. Wanted %v, got %v", w, g))
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+	receiptsBytes, err := json.Marshal(receipts)
+	if err != nil {
+		cmn.PanicSanity(err.Error())
+	}
+
+	if batch == nil {
+		batch = bs.db.NewBatch()
+	}
+	batch.Set(calcHeaderKey(height), headerBytes)
+	batch.Set(calcReceiptsKey(height), receiptsBytes)
+	batch.Set(blockStoreKey, BlockStoreStateJSON{Height: height}.Bytes())
+	batch.Write()
+
+	bs.mtx.Lock()
+	bs.height = height
+	bs.mtx.Unlock()
+}
+
+// RemoveBlock deletes the block at height, which must be Height(), in one
+// write with the changes of batch, such as the state before the block.
+// batch may be nil.
+func (bs *BlockStore) RemoveBlock(batch dbm.Batch, height uint64) {
+	if g, w := height, bs.Height(); g != w || g == 0 {
+		cmn.PanicSanity(cmn.Fmt("BlockStore can only remove the last block. Wanted %v, got %v", w, g))
+	}
+
+	if batch == nil {
+		batch = bs.db.NewBatch()
+	}
+	batch.Delete(calcHeaderKey(height))
+	batch.Delete(calcReceiptsKey(height))
+	batch.Set(blockStoreKey, BlockStoreStateJSON{Height: height - 1}.Bytes())
+	batch.Write()
+
+	bs.mtx.Lock()
+	bs.height = height - 1
+	bs.mtx.Unlock()
+}
+
+//-----------------------------------------------------------------------------
+
+func calcHeaderKey(height uint64) []byte {
+	return []byte(fmt.Sprintf("H:%v", height))
+}
+
+func calcReceiptsKey(height uint64) []byte {
+	return []byte(fmt.Sprintf("R:%v", height))
+}
+
+//-----------------------------------------------------------------------------
+
+// BlockStoreStateJSON is the block store state JSON structure.
+type BlockStoreStateJSON struct {
+	Height uint64 `json:"height"`
+}
+
+// Bytes returns the JSON encoding of BlockStoreStateJSON.
+func (bsj BlockStoreStateJSON) Bytes() []byte {
+	bytes, err := json.Marshal(bsj)
+	if err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Could not marshal state bytes: %v", err))
+	}
+	return bytes
+}
+
+// loadBlockStoreStateJSON returns the BlockStoreStateJSON as loaded from disk.
+// If no BlockStoreStateJSON was previously persisted, it returns the zero value.
+func loadBlockStoreStateJSON(db dbm.DB) BlockStoreStateJSON {
+	bytes := db.Get(blockStoreKey)
+	if len(bytes) == 0 {
+		return BlockStoreStateJSON{}
+	}
+	bsj := BlockStoreStateJSON{}
+	if err := json.Unmarshal(bytes, &bsj); err != nil {
+		cmn.PanicCrisis(cmn.Fmt("Could not unmarshal bytes: %X", bytes))
+	}
+	return bsj
+}
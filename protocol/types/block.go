@@ -1,5 +1,11 @@
 package types
 
+import (
+	"math/big"
+
+	"golang.org/x/crypto/blake2b"
+)
+
 // BlockHeader ss
 type BlockHeader struct {
 	Version                uint64
@@ -7,8 +13,32 @@ type BlockHeader struct {
 	Timestamp              uint64
 	PreviousBlockHash      Hash
 	TransactionsMerkleRoot Hash
-	TransactionsStatusHash Hash
+	TransactionsStatusHash Hash // see ReceiptsHash
+	StateRoot              Hash // commitment to the state after the block
 	Validator              Address
+	LogsBloom              Bloom // union of the blooms of all receipts
+
+	GasLimit uint64   // max gas all transactions in the block may use
+	GasUsed  uint64   // gas used by all transactions in the block
+	BaseFee  *big.Int // per gas price every transaction pays at least
+}
+
+// Hash returns the blake2b hash of the header
+func (h *BlockHeader) Hash() Hash {
+	w := &hashWriter{}
+	w.writeUint64(h.Version)
+	w.writeUint64(h.Height)
+	w.writeUint64(h.Timestamp)
+	w.writeFixed(h.PreviousBlockHash[:])
+	w.writeFixed(h.TransactionsMerkleRoot[:])
+	w.writeFixed(h.TransactionsStatusHash[:])
+	w.writeFixed(h.StateRoot[:])
+	w.writeFixed(h.Validator[:])
+	w.writeFixed(h.LogsBloom[:])
+	w.writeUint64(h.GasLimit)
+	w.writeUint64(h.GasUsed)
+	w.writeBig(h.BaseFee)
+	return Hash(blake2b.Sum256(w.buf))
 }
 
 // Block ss
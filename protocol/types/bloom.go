@@ -0,0 +1,69 @@
+package types
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// BloomByteLength is the number of bytes of a logs bloom
+	BloomByteLength = 256
+	// BloomBitLength is the number of bits of a logs bloom
+	BloomBitLength = 8 * BloomByteLength
+)
+
+// Bloom is a 2048 bit bloom filter over log addresses and topics
+type Bloom [BloomByteLength]byte
+
+// Add sets the three bits selected by the hash of b
+func (b *Bloom) Add(data []byte) {
+	for _, i := range bloomIndexes(data) {
+		b[BloomByteLength-1-i/8] |= 1 << (i % 8)
+	}
+}
+
+// Test returns false if data was definitely never added
+func (b Bloom) Test(data []byte) bool {
+	for _, i := range bloomIndexes(data) {
+		if b[BloomByteLength-1-i/8]&(1<<(i%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Or merges other into b
+func (b *Bloom) Or(other Bloom) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+func bloomIndexes(data []byte) [3]uint {
+	h := blake2b.Sum256(data)
+	var idx [3]uint
+	for i := range idx {
+		idx[i] = (uint(h[2*i])<<8 | uint(h[2*i+1])) % BloomBitLength
+	}
+	return idx
+}
+
+// LogsBloom returns the bloom of the addresses and topics of logs
+func LogsBloom(logs []*Log) Bloom {
+	var b Bloom
+	for _, log := range logs {
+		b.Add(log.Address[:])
+		for _, topic := range log.Topics {
+			b.Add(topic[:])
+		}
+	}
+	return b
+}
+
+// CreateBloom returns the union of the blooms of receipts
+func CreateBloom(receipts []*Receipt) Bloom {
+	var b Bloom
+	for _, r := range receipts {
+		b.Or(r.Bloom)
+	}
+	return b
+}
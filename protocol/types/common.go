@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/hex"
+	"fmt"
 
 	"golang.org/x/crypto/blake2b"
 )
@@ -19,6 +20,14 @@ type Hash [HashBytesNumber]byte
 // AssetID ss
 type AssetID Hash
 
+// NativeAssetID is the asset gas is paid in
+var NativeAssetID = AssetID{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
 //Address ss
 type Address [AddressBytesNumber]byte
 
@@ -38,6 +47,16 @@ func (h Hash) String() string {
 	return "0x" + h.Hex()
 }
 
+// MarshalText encodes the hash as 0x prefixed hex, for JSON.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText decodes a 0x prefixed hex hash.
+func (h *Hash) UnmarshalText(input []byte) error {
+	return unmarshalHex(input, h[:])
+}
+
 // SetBytes Sets the hash to the value of b. If b is larger than len(h), 'b' will be cropped (from the left).
 func (h *Hash) SetBytes(b []byte) {
 	if len(b) > len(h) {
@@ -85,3 +104,26 @@ func (a Address) Hex() string {
 func (a Address) String() string {
 	return "0x" + a.Hex()
 }
+
+// MarshalText encodes the address as 0x prefixed hex, for JSON.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes a 0x prefixed hex address, checksummed or not.
+func (a *Address) UnmarshalText(input []byte) error {
+	return unmarshalHex(input, a[:])
+}
+
+// unmarshalHex decodes the 0x prefixed hex input into out, which it must fill.
+func unmarshalHex(input []byte, out []byte) error {
+	if len(input) < 2 || input[0] != '0' || (input[1] != 'x' && input[1] != 'X') {
+		return fmt.Errorf("hex string without 0x prefix")
+	}
+	input = input[2:]
+	if len(input) != 2*len(out) {
+		return fmt.Errorf("hex string has length %d, want %d", len(input), 2*len(out))
+	}
+	_, err := hex.Decode(out, input)
+	return err
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"encoding/binary"
+	"math/big"
+)
+
+// hashWriter collects a deterministic byte encoding of a structure
+// before it is hashed. Variable sized fields are length prefixed.
+type hashWriter struct {
+	buf []byte
+}
+
+func (w *hashWriter) writeUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *hashWriter) writeBytes(b []byte) {
+	w.writeUint64(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *hashWriter) writeFixed(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func (w *hashWriter) writeBig(v *big.Int) {
+	if v == nil {
+		w.writeBytes(nil)
+		return
+	}
+	w.writeBytes(v.Bytes())
+}
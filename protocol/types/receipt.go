@@ -0,0 +1,77 @@
+package types
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// ReceiptStatusFailed is the status of a transaction whose execution failed
+	ReceiptStatusFailed uint64 = 0
+	// ReceiptStatusSuccessful is the status of a transaction that applied cleanly
+	ReceiptStatusSuccessful uint64 = 1
+)
+
+// Event topics emitted by built-in operations
+var (
+	// TransferEventTopic is emitted for every output paid by a transaction.
+	// Topics: [TransferEventTopic, from, to, asset], Data: amount
+	TransferEventTopic = Hash(blake2b.Sum256([]byte("Transfer(address,address,asset,uint256)")))
+)
+
+// Log is a structured event emitted while a transaction is applied
+type Log struct {
+	Address Address // account that emitted the event
+	Topics  []Hash
+	Data    []byte
+
+	// derived fields, filled in when the block is applied
+	BlockHeight uint64
+	TxHash      Hash
+	TxIndex     uint64
+	Index       uint64 // position of the log in the block
+}
+
+// Receipt records the outcome of a transaction
+type Receipt struct {
+	Status            uint64
+	CumulativeGasUsed uint64
+	GasUsed           uint64
+	TxHash            Hash
+	Logs              []*Log
+	Bloom             Bloom
+	ContractAddress   Address // set when the transaction deployed a contract
+}
+
+// NewReceipt returns a receipt for txHash with its bloom computed from logs
+func NewReceipt(txHash Hash, status, gasUsed, cumulativeGasUsed uint64, logs []*Log) *Receipt {
+	return &Receipt{
+		Status:            status,
+		CumulativeGasUsed: cumulativeGasUsed,
+		GasUsed:           gasUsed,
+		TxHash:            txHash,
+		Logs:              logs,
+		Bloom:             LogsBloom(logs),
+	}
+}
+
+// ReceiptsHash commits to the status and gas of every receipt. It is stored
+// as BlockHeader.TransactionsStatusHash.
+func ReceiptsHash(receipts []*Receipt) Hash {
+	w := &hashWriter{}
+	for _, r := range receipts {
+		w.writeUint64(r.Status)
+		w.writeUint64(r.CumulativeGasUsed)
+		w.writeFixed(r.TxHash[:])
+		w.writeFixed(r.Bloom[:])
+		w.writeUint64(uint64(len(r.Logs)))
+		for _, log := range r.Logs {
+			w.writeFixed(log.Address[:])
+			w.writeUint64(uint64(len(log.Topics)))
+			for _, topic := range log.Topics {
+				w.writeFixed(topic[:])
+			}
+			w.writeBytes(log.Data)
+		}
+	}
+	return Hash(blake2b.Sum256(w.buf))
+}
@@ -1,11 +1,43 @@
 package types
 
 import (
+	"errors"
 	"math/big"
 	"sync/atomic"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// TxVersionSingle is the original format: one TO, one Amount, one Output
+	TxVersionSingle uint64 = 0
+	// TxVersionBatch pays every entry of Outputs under one nonce and one fee
+	TxVersionBatch uint64 = 1
+
+	// MaxBatchOutputs limits the number of transfers in a batch transaction
+	MaxBatchOutputs = 256
+
+	// TxOutputSend is the SourceID of the first output paid by a transaction
+	TxOutputSend uint64 = 0
+	// TxOutputOdd is the SourceID of the change output of a single transaction
+	TxOutputOdd uint64 = 1
+)
+
+// transaction validation errors
+var (
+	ErrTxUnknownVersion  = errors.New("unknown transaction version")
+	ErrTxNoOutputs       = errors.New("batch transaction has no outputs")
+	ErrTxTooManyOutputs  = errors.New("batch transaction has too many outputs")
+	ErrTxNegativeAmount  = errors.New("negative transfer amount")
+	ErrTxNilOutput       = errors.New("batch transaction has a nil output")
+	ErrTxNilInput        = errors.New("transaction has a nil input")
+	ErrTxUnexpectedBatch = errors.New("single transaction must not carry batch outputs")
 )
 
 // TxInput ss
+// Source is the hash of an earlier transaction and SourceID the index of
+// the output being spent. A batch transaction numbers its outputs in order
+// and places change outputs after the last transfer.
 type TxInput struct {
 	Source   Hash
 	SourceID uint64 // 0 Send 1 odd
@@ -18,6 +50,14 @@ type TxOutput struct {
 	EndTime   uint64
 }
 
+// TransferOutput is one recipient of a batch transaction
+type TransferOutput struct {
+	AssetID AssetID
+	TO      Address
+	Amount  *big.Int
+	Output  TxOutput
+}
+
 // Transaction ss
 type Transaction struct {
 	Version  uint64
@@ -31,6 +71,9 @@ type Transaction struct {
 	Inputs   []*TxInput
 	Output   TxOutput // just support one output auto gen a odd
 
+	// Outputs replaces AssetID, Amount, TO and Output when Version is TxVersionBatch
+	Outputs []*TransferOutput
+
 	Sign []byte
 
 	// caches
@@ -38,3 +81,110 @@ type Transaction struct {
 	size atomic.Value
 	from atomic.Value
 }
+
+// IsBatch reports whether the transaction uses the multi-output format
+func (tx *Transaction) IsBatch() bool {
+	return tx.Version == TxVersionBatch
+}
+
+// Transfers returns the payments made by the transaction. A single
+// transaction is returned as a batch of one so callers handle both
+// formats the same way.
+func (tx *Transaction) Transfers() []*TransferOutput {
+	if tx.IsBatch() {
+		return tx.Outputs
+	}
+	return []*TransferOutput{{
+		AssetID: tx.AssetID,
+		TO:      tx.TO,
+		Amount:  tx.Amount,
+		Output:  tx.Output,
+	}}
+}
+
+// ValidateBasic performs stateless checks on the transaction format
+func (tx *Transaction) ValidateBasic() error {
+	for _, in := range tx.Inputs {
+		if in == nil {
+			return ErrTxNilInput
+		}
+	}
+	switch tx.Version {
+	case TxVersionSingle:
+		if len(tx.Outputs) != 0 {
+			return ErrTxUnexpectedBatch
+		}
+		if tx.Amount != nil && tx.Amount.Sign() < 0 {
+			return ErrTxNegativeAmount
+		}
+	case TxVersionBatch:
+		if len(tx.Outputs) == 0 {
+			return ErrTxNoOutputs
+		}
+		if len(tx.Outputs) > MaxBatchOutputs {
+			return ErrTxTooManyOutputs
+		}
+		for _, out := range tx.Outputs {
+			if out == nil {
+				return ErrTxNilOutput
+			}
+			if out.Amount == nil || out.Amount.Sign() < 0 {
+				return ErrTxNegativeAmount
+			}
+		}
+	default:
+		return ErrTxUnknownVersion
+	}
+	return nil
+}
+
+// Hash returns the blake2b hash of the transaction, signature included.
+// The hash of a transaction with a nil entry in Inputs or Outputs is the
+// zero Hash: such a transaction is rejected by ValidateBasic, which must be
+// called before relying on the hash.
+func (tx *Transaction) Hash() Hash {
+	if hash := tx.hash.Load(); hash != nil {
+		return hash.(Hash)
+	}
+	w := &hashWriter{}
+	if err := tx.encode(w); err != nil {
+		return Hash{}
+	}
+	w.writeBytes(tx.Sign)
+	h := Hash(blake2b.Sum256(w.buf))
+	tx.hash.Store(h)
+	return h
+}
+
+func (tx *Transaction) encode(w *hashWriter) error {
+	w.writeUint64(tx.Version)
+	w.writeFixed(tx.AssetID[:])
+	w.writeUint64(tx.Nonce)
+	w.writeBig(tx.Amount)
+	w.writeBig(tx.GasPrice)
+	w.writeUint64(tx.GasLimit)
+	w.writeFixed(tx.TO[:])
+	w.writeBytes(tx.Payload)
+	w.writeUint64(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		if in == nil {
+			return ErrTxNilInput
+		}
+		w.writeFixed(in.Source[:])
+		w.writeUint64(in.SourceID)
+	}
+	w.writeUint64(tx.Output.StartTime)
+	w.writeUint64(tx.Output.EndTime)
+	w.writeUint64(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		if out == nil {
+			return ErrTxNilOutput
+		}
+		w.writeFixed(out.AssetID[:])
+		w.writeFixed(out.TO[:])
+		w.writeBig(out.Amount)
+		w.writeUint64(out.Output.StartTime)
+		w.writeUint64(out.Output.EndTime)
+	}
+	return nil
+}
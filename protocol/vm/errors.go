@@ -0,0 +1,46 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrOutOfGas             = errors.New("out of gas")
+	ErrGasUintOverflow      = errors.New("gas uint64 overflow")
+	ErrExecutionReverted    = errors.New("execution reverted")
+	ErrInvalidJump          = errors.New("invalid jump destination")
+	ErrMaxCodeSizeExceeded  = errors.New("max code size exceeded")
+	ErrContractAddressTaken = errors.New("contract address already has code")
+	ErrInsufficientBalance  = errors.New("insufficient balance for transfer")
+	ErrDepth                = errors.New("max call depth exceeded")
+	ErrWriteProtection      = errors.New("write protection")
+	ErrReturnDataOutOfRange = errors.New("return data out of bounds")
+	ErrInvalidCode          = errors.New("invalid code: must not begin with 0xef")
+)
+
+type ErrStackUnderflow struct {
+	Len      int
+	Required int
+}
+
+func (e ErrStackUnderflow) Error() string {
+	return fmt.Sprintf("Stack underflow (%v <=> %v)", e.Len, e.Required)
+}
+
+type ErrStackOverflow struct {
+	Len   int
+	Limit int
+}
+
+func (e ErrStackOverflow) Error() string {
+	return fmt.Sprintf("Stack limit reached %v (%v)", e.Len, e.Limit)
+}
+
+type ErrInvalidOpCode struct {
+	OpCode OpCode
+}
+
+func (e ErrInvalidOpCode) Error() string {
+	return fmt.Sprintf("Invalid opcode: %v", e.OpCode)
+}
@@ -0,0 +1,139 @@
+package vm
+
+import (
+	"math/big"
+
+	"github.com/go-fusion/common/math"
+)
+
+// Gas costs of instructions
+const (
+	GasQuickStep   uint64 = 2
+	GasFastestStep uint64 = 3
+	GasFastStep    uint64 = 5
+	GasMidStep     uint64 = 8
+	GasSlowStep    uint64 = 10
+
+	ExpGas            uint64 = 10 // base cost of EXP
+	ExpByteGas        uint64 = 50 // per byte of the EXP exponent
+	Sha3Gas           uint64 = 30 // base cost of SHA3
+	Sha3WordGas       uint64 = 6  // per word hashed by SHA3, and by CREATE2 for the init code
+	CopyGas           uint64 = 3  // per word copied by the *COPY instructions
+	MemoryGas         uint64 = 3  // per word of memory expansion
+	QuadCoeffDiv      uint64 = 512
+	BalanceGas        uint64 = 700
+	ExtcodeGas        uint64 = 700 // base cost of EXTCODESIZE, EXTCODECOPY and EXTCODEHASH
+	BlockhashGas      uint64 = 20
+	JumpdestGas       uint64 = 1
+	SloadGas          uint64 = 800
+	SstoreSetGas      uint64 = 20000 // storing a non-zero value into an empty slot
+	SstoreResetGas    uint64 = 5000  // any other store
+	SstoreClearRefund uint64 = 4800  // refunded for clearing a slot
+	LogGas            uint64 = 375
+	LogTopicGas       uint64 = 375
+	LogDataGas        uint64 = 8
+	CreateGas         uint64 = 32000
+	CreateDataGas     uint64 = 200 // per byte of deployed code
+	CallGas           uint64 = 700
+	CallValueGas      uint64 = 9000  // extra cost of a call that moves value
+	CallNewAccountGas uint64 = 25000 // extra cost of moving value to an empty account
+	CallStipend       uint64 = 2300  // free gas given to the callee of a call that moves value
+	SelfdestructGas   uint64 = 5000
+
+	// RefundQuotient caps the refund of a transaction to 1/RefundQuotient
+	// of the gas it used
+	RefundQuotient uint64 = 5
+
+	// MaxCodeSize is the largest contract code that can be deployed
+	MaxCodeSize = 24576
+
+	// callDepthLimit is the maximum depth of nested calls and creations
+	callDepthLimit = 1024
+)
+
+// constGas holds the static cost of every supported instruction.
+// Instructions not listed are invalid.
+var constGas = map[OpCode]uint64{
+	STOP: 0, ADD: GasFastestStep, MUL: GasFastStep, SUB: GasFastestStep,
+	DIV: GasFastStep, SDIV: GasFastStep, MOD: GasFastStep, SMOD: GasFastStep,
+	ADDMOD: GasMidStep, MULMOD: GasMidStep, EXP: ExpGas, SIGNEXTEND: GasFastStep,
+
+	LT: GasFastestStep, GT: GasFastestStep, SLT: GasFastestStep, SGT: GasFastestStep,
+	EQ: GasFastestStep, ISZERO: GasFastestStep, AND: GasFastestStep, OR: GasFastestStep,
+	XOR: GasFastestStep, NOT: GasFastestStep, BYTE: GasFastestStep,
+	SHL: GasFastestStep, SHR: GasFastestStep, SAR: GasFastestStep, SHA3: Sha3Gas,
+
+	ADDRESS: GasQuickStep, BALANCE: BalanceGas, ORIGIN: GasQuickStep, CALLER: GasQuickStep,
+	CALLVALUE: GasQuickStep, CALLDATALOAD: GasFastestStep, CALLDATASIZE: GasQuickStep,
+	CALLDATACOPY: GasFastestStep, CODESIZE: GasQuickStep, CODECOPY: GasFastestStep,
+	GASPRICE: GasQuickStep, EXTCODESIZE: ExtcodeGas, EXTCODECOPY: ExtcodeGas,
+	RETURNDATASIZE: GasQuickStep, RETURNDATACOPY: GasFastestStep, EXTCODEHASH: ExtcodeGas,
+
+	BLOCKHASH: BlockhashGas, COINBASE: GasQuickStep, TIMESTAMP: GasQuickStep,
+	NUMBER: GasQuickStep, GASLIMIT: GasQuickStep, SELFBALANCE: GasFastStep,
+	BASEFEE: GasQuickStep,
+
+	POP: GasQuickStep, MLOAD: GasFastestStep, MSTORE: GasFastestStep, MSTORE8: GasFastestStep,
+	SLOAD: SloadGas, SSTORE: 0, JUMP: GasMidStep, JUMPI: GasSlowStep, PC: GasQuickStep,
+	MSIZE: GasQuickStep, GAS: GasQuickStep, JUMPDEST: JumpdestGas,
+	MCOPY: GasFastestStep, PUSH0: GasQuickStep,
+
+	LOG0: LogGas, LOG1: LogGas + LogTopicGas, LOG2: LogGas + 2*LogTopicGas,
+	LOG3: LogGas + 3*LogTopicGas, LOG4: LogGas + 4*LogTopicGas,
+
+	ASSETBALANCE: BalanceGas,
+
+	CREATE: CreateGas, CALL: CallGas, CALLCODE: CallGas, RETURN: 0,
+	DELEGATECALL: CallGas, CREATE2: CreateGas, STATICCALL: CallGas, REVERT: 0,
+	SELFDESTRUCT: SelfdestructGas,
+}
+
+func init() {
+	for op := PUSH1; op <= PUSH32; op++ {
+		constGas[op] = GasFastestStep
+	}
+	for op := DUP1; op <= DUP16; op++ {
+		constGas[op] = GasFastestStep
+	}
+	for op := SWAP1; op <= SWAP16; op++ {
+		constGas[op] = GasFastestStep
+	}
+}
+
+// toWordSize returns the number of 32 byte words needed to hold size bytes.
+func toWordSize(size uint64) uint64 {
+	if size > math.MaxUint64-31 {
+		return math.MaxUint64/32 + 1
+	}
+	return (size + 31) / 32
+}
+
+// callGas returns the gas given to a call or creation: what was requested,
+// but at most all but one 64th of the gas available.
+func callGas(available uint64, requested *big.Int) uint64 {
+	gas := available - available/64
+	if requested != nil && requested.IsUint64() && requested.Uint64() < gas {
+		return requested.Uint64()
+	}
+	return gas
+}
+
+// memoryGasCost returns the gas needed to grow mem to newSize bytes.
+func memoryGasCost(mem *memory, newSize uint64) (uint64, error) {
+	if newSize == 0 {
+		return 0, nil
+	}
+	// 0x1FFFFFFFE0 is the largest size whose word count squared fits into uint64
+	if newSize > 0x1FFFFFFFE0 {
+		return 0, ErrGasUintOverflow
+	}
+	newWords := toWordSize(newSize)
+	oldWords := toWordSize(uint64(mem.len()))
+	if newWords <= oldWords {
+		return 0, nil
+	}
+	cost := func(words uint64) uint64 {
+		return words*MemoryGas + words*words/QuadCoeffDiv
+	}
+	return cost(newWords) - cost(oldWords), nil
+}
@@ -0,0 +1,698 @@
+package vm
+
+import (
+	"math/big"
+
+	"github.com/go-fusion/common/math"
+	"github.com/go-fusion/protocol/types"
+)
+
+var (
+	big1   = big.NewInt(1)
+	big32  = big.NewInt(32)
+	big256 = big.NewInt(256)
+)
+
+// interpreter runs the code of one contract frame.
+type interpreter struct {
+	vm    *VM
+	c     *contract
+	stack *stack
+	mem   *memory
+	pc    uint64
+
+	returnData []byte // output of the last call or creation
+}
+
+func newInterpreter(vm *VM, c *contract) *interpreter {
+	return &interpreter{
+		vm:    vm,
+		c:     c,
+		stack: newStack(),
+		mem:   newMemory(),
+	}
+}
+
+// stackReq lists how many items an instruction pops and pushes.
+var stackReq = map[OpCode][2]int{
+	STOP: {0, 0}, ADD: {2, 1}, MUL: {2, 1}, SUB: {2, 1}, DIV: {2, 1}, SDIV: {2, 1},
+	MOD: {2, 1}, SMOD: {2, 1}, ADDMOD: {3, 1}, MULMOD: {3, 1}, EXP: {2, 1}, SIGNEXTEND: {2, 1},
+
+	LT: {2, 1}, GT: {2, 1}, SLT: {2, 1}, SGT: {2, 1}, EQ: {2, 1}, ISZERO: {1, 1},
+	AND: {2, 1}, OR: {2, 1}, XOR: {2, 1}, NOT: {1, 1}, BYTE: {2, 1},
+	SHL: {2, 1}, SHR: {2, 1}, SAR: {2, 1}, SHA3: {2, 1},
+
+	ADDRESS: {0, 1}, BALANCE: {1, 1}, ORIGIN: {0, 1}, CALLER: {0, 1}, CALLVALUE: {0, 1},
+	CALLDATALOAD: {1, 1}, CALLDATASIZE: {0, 1}, CALLDATACOPY: {3, 0},
+	CODESIZE: {0, 1}, CODECOPY: {3, 0}, GASPRICE: {0, 1}, EXTCODESIZE: {1, 1}, EXTCODECOPY: {4, 0},
+	RETURNDATASIZE: {0, 1}, RETURNDATACOPY: {3, 0}, EXTCODEHASH: {1, 1},
+
+	BLOCKHASH: {1, 1}, COINBASE: {0, 1}, TIMESTAMP: {0, 1}, NUMBER: {0, 1}, GASLIMIT: {0, 1},
+	SELFBALANCE: {0, 1}, BASEFEE: {0, 1},
+
+	POP: {1, 0}, MLOAD: {1, 1}, MSTORE: {2, 0}, MSTORE8: {2, 0}, SLOAD: {1, 1}, SSTORE: {2, 0},
+	JUMP: {1, 0}, JUMPI: {2, 0}, PC: {0, 1}, MSIZE: {0, 1}, GAS: {0, 1}, JUMPDEST: {0, 0},
+	MCOPY: {3, 0}, PUSH0: {0, 1},
+
+	LOG0: {2, 0}, LOG1: {3, 0}, LOG2: {4, 0}, LOG3: {5, 0}, LOG4: {6, 0},
+
+	ASSETBALANCE: {2, 1},
+
+	CREATE: {3, 1}, CALL: {7, 1}, CALLCODE: {7, 1}, RETURN: {2, 0}, DELEGATECALL: {6, 1},
+	CREATE2: {4, 1}, STATICCALL: {6, 1}, REVERT: {2, 0}, SELFDESTRUCT: {1, 0},
+}
+
+func (in *interpreter) run() ([]byte, error) {
+	var (
+		c  = in.c
+		st = in.stack
+	)
+	for {
+		op := c.getOp(in.pc)
+		cost, ok := constGas[op]
+		if !ok {
+			return nil, ErrInvalidOpCode{op}
+		}
+
+		var req [2]int
+		switch {
+		case op.IsPush():
+			req = [2]int{0, 1}
+		case op >= DUP1 && op <= DUP16:
+			req = [2]int{int(op-DUP1) + 1, int(op-DUP1) + 2}
+		case op >= SWAP1 && op <= SWAP16:
+			req = [2]int{int(op-SWAP1) + 2, int(op-SWAP1) + 2}
+		default:
+			req = stackReq[op]
+		}
+		if err := st.require(req[0], req[1]); err != nil {
+			return nil, err
+		}
+		if !c.useGas(cost) {
+			return nil, ErrOutOfGas
+		}
+
+		switch {
+		case op.IsPush():
+			n := uint64(op-PUSH1) + 1
+			start := in.pc + 1
+			data := make([]byte, n)
+			if start < uint64(len(c.code)) {
+				copy(data, c.code[start:])
+			}
+			st.push(new(big.Int).SetBytes(data))
+			in.pc += n + 1
+			continue
+		case op >= DUP1 && op <= DUP16:
+			st.dup(int(op-DUP1) + 1)
+			in.pc++
+			continue
+		case op >= SWAP1 && op <= SWAP16:
+			st.swap(int(op-SWAP1) + 1)
+			in.pc++
+			continue
+		}
+
+		switch op {
+		case STOP:
+			return nil, nil
+
+		// arithmetic
+		case ADD:
+			x, y := st.pop(), st.peek()
+			math.U256(y.Add(x, y))
+		case MUL:
+			x, y := st.pop(), st.peek()
+			math.U256(y.Mul(x, y))
+		case SUB:
+			x, y := st.pop(), st.peek()
+			math.U256(y.Sub(x, y))
+		case DIV:
+			x, y := st.pop(), st.peek()
+			if y.Sign() != 0 {
+				math.U256(y.Div(x, y))
+			} else {
+				y.SetUint64(0)
+			}
+		case SDIV:
+			x, y := math.S256(st.pop()), math.S256(st.pop())
+			res := new(big.Int)
+			if y.Sign() != 0 {
+				res.Quo(x, y)
+			}
+			st.push(math.U256(res))
+		case MOD:
+			x, y := st.pop(), st.peek()
+			if y.Sign() != 0 {
+				y.Mod(x, y)
+			} else {
+				y.SetUint64(0)
+			}
+		case SMOD:
+			x, y := math.S256(st.pop()), math.S256(st.pop())
+			res := new(big.Int)
+			if y.Sign() != 0 {
+				res.Rem(x, y)
+			}
+			st.push(math.U256(res))
+		case ADDMOD:
+			x, y, z := st.pop(), st.pop(), st.peek()
+			if z.Sign() != 0 {
+				z.Mod(x.Add(x, y), z)
+			} else {
+				z.SetUint64(0)
+			}
+		case MULMOD:
+			x, y, z := st.pop(), st.pop(), st.peek()
+			if z.Sign() != 0 {
+				z.Mod(x.Mul(x, y), z)
+			} else {
+				z.SetUint64(0)
+			}
+		case EXP:
+			base, exponent := st.pop(), st.pop()
+			expBytes := uint64((exponent.BitLen() + 7) / 8)
+			if !c.useGas(expBytes * ExpByteGas) {
+				return nil, ErrOutOfGas
+			}
+			st.push(math.Exp(base, exponent))
+		case SIGNEXTEND:
+			back, num := st.pop(), st.peek()
+			if back.Cmp(big.NewInt(31)) < 0 {
+				bit := uint(back.Uint64()*8 + 7)
+				mask := new(big.Int).Sub(new(big.Int).Lsh(big1, bit), big1)
+				if num.Bit(int(bit)) > 0 {
+					num.Or(num, new(big.Int).Not(mask))
+				} else {
+					num.And(num, mask)
+				}
+				math.U256(num)
+			}
+
+		// comparison and bitwise
+		case LT:
+			x, y := st.pop(), st.peek()
+			setBool(y, x.Cmp(y) < 0)
+		case GT:
+			x, y := st.pop(), st.peek()
+			setBool(y, x.Cmp(y) > 0)
+		case SLT:
+			x, y := math.S256(st.pop()), st.peek()
+			setBool(y, x.Cmp(math.S256(y)) < 0)
+		case SGT:
+			x, y := math.S256(st.pop()), st.peek()
+			setBool(y, x.Cmp(math.S256(y)) > 0)
+		case EQ:
+			x, y := st.pop(), st.peek()
+			setBool(y, x.Cmp(y) == 0)
+		case ISZERO:
+			x := st.peek()
+			setBool(x, x.Sign() == 0)
+		case AND:
+			x, y := st.pop(), st.peek()
+			y.And(x, y)
+		case OR:
+			x, y := st.pop(), st.peek()
+			y.Or(x, y)
+		case XOR:
+			x, y := st.pop(), st.peek()
+			y.Xor(x, y)
+		case NOT:
+			x := st.peek()
+			math.U256(x.Not(x))
+		case BYTE:
+			th, val := st.pop(), st.peek()
+			if th.Cmp(big32) < 0 {
+				val.SetUint64(uint64(math.Byte(val, 32, int(th.Int64()))))
+			} else {
+				val.SetUint64(0)
+			}
+		case SHL:
+			shift, value := st.pop(), st.peek()
+			if shift.Cmp(big256) >= 0 {
+				value.SetUint64(0)
+			} else {
+				math.U256(value.Lsh(value, uint(shift.Uint64())))
+			}
+		case SHR:
+			shift, value := st.pop(), st.peek()
+			if shift.Cmp(big256) >= 0 {
+				value.SetUint64(0)
+			} else {
+				value.Rsh(value, uint(shift.Uint64()))
+			}
+		case SAR:
+			shift, value := st.pop(), math.S256(st.pop())
+			if shift.Cmp(big256) >= 0 {
+				if value.Sign() >= 0 {
+					value = new(big.Int)
+				} else {
+					value = big.NewInt(-1)
+				}
+			} else {
+				value = new(big.Int).Rsh(value, uint(shift.Uint64()))
+			}
+			st.push(math.U256(value))
+		case SHA3:
+			offset, size, err := in.memArgs(st.pop(), st.pop())
+			if err != nil {
+				return nil, err
+			}
+			if !c.useGas(toWordSize(size) * Sha3WordGas) {
+				return nil, ErrOutOfGas
+			}
+			st.push(new(big.Int).SetBytes(keccak256(in.mem.get(offset, size))))
+
+		// closure state
+		case ADDRESS:
+			st.push(addressToBig(c.address))
+		case BALANCE:
+			addr := bigToAddress(st.pop())
+			st.push(in.vm.state.GetBalance(addr, types.NativeAssetID))
+		case ORIGIN:
+			st.push(addressToBig(in.vm.Origin))
+		case CALLER:
+			st.push(addressToBig(c.caller))
+		case CALLVALUE:
+			st.push(new(big.Int).Set(c.value))
+		case CALLDATALOAD:
+			offset := st.pop()
+			st.push(new(big.Int).SetBytes(getData(c.input, offset, 32)))
+		case CALLDATASIZE:
+			st.push(new(big.Int).SetUint64(uint64(len(c.input))))
+		case CALLDATACOPY, CODECOPY:
+			memOffset, dataOffset, length := st.pop(), st.pop(), st.pop()
+			offset, size, err := in.memArgs(memOffset, length)
+			if err != nil {
+				return nil, err
+			}
+			if !c.useGas(toWordSize(size) * CopyGas) {
+				return nil, ErrOutOfGas
+			}
+			src := c.input
+			if op == CODECOPY {
+				src = c.code
+			}
+			in.mem.set(offset, size, getData(src, dataOffset, size))
+		case CODESIZE:
+			st.push(new(big.Int).SetUint64(uint64(len(c.code))))
+		case GASPRICE:
+			st.push(bigOrZero(in.vm.GasPrice))
+		case EXTCODESIZE:
+			addr := bigToAddress(st.peek())
+			st.peek().SetUint64(uint64(len(in.vm.state.GetCode(addr))))
+		case EXTCODECOPY:
+			addr := bigToAddress(st.pop())
+			memOffset, codeOffset, length := st.pop(), st.pop(), st.pop()
+			offset, size, err := in.memArgs(memOffset, length)
+			if err != nil {
+				return nil, err
+			}
+			if !c.useGas(toWordSize(size) * CopyGas) {
+				return nil, ErrOutOfGas
+			}
+			in.mem.set(offset, size, getData(in.vm.state.GetCode(addr), codeOffset, size))
+		case RETURNDATASIZE:
+			st.push(new(big.Int).SetUint64(uint64(len(in.returnData))))
+		case RETURNDATACOPY:
+			memOffset, dataOffset, length := st.pop(), st.pop(), st.pop()
+			end := new(big.Int).Add(dataOffset, length)
+			if !end.IsUint64() || end.Uint64() > uint64(len(in.returnData)) {
+				return nil, ErrReturnDataOutOfRange
+			}
+			offset, size, err := in.memArgs(memOffset, length)
+			if err != nil {
+				return nil, err
+			}
+			if !c.useGas(toWordSize(size) * CopyGas) {
+				return nil, ErrOutOfGas
+			}
+			in.mem.set(offset, size, in.returnData[dataOffset.Uint64():end.Uint64()])
+		case EXTCODEHASH:
+			addr := bigToAddress(st.peek())
+			if in.vm.empty(addr) {
+				st.peek().SetUint64(0)
+			} else {
+				st.peek().SetBytes(keccak256(in.vm.state.GetCode(addr)))
+			}
+
+		// block
+		case BLOCKHASH:
+			num := st.peek()
+			var hash types.Hash
+			if num.IsUint64() && num.Uint64() < in.vm.Height && num.Uint64()+256 >= in.vm.Height && in.vm.GetHash != nil {
+				hash = in.vm.GetHash(num.Uint64())
+			}
+			num.SetBytes(hash[:])
+		case COINBASE:
+			st.push(addressToBig(in.vm.Coinbase))
+		case TIMESTAMP:
+			st.push(new(big.Int).SetUint64(in.vm.Timestamp))
+		case NUMBER:
+			st.push(new(big.Int).SetUint64(in.vm.Height))
+		case GASLIMIT:
+			st.push(new(big.Int).SetUint64(in.vm.GasLimit))
+		case SELFBALANCE:
+			st.push(in.vm.state.GetBalance(c.address, types.NativeAssetID))
+		case BASEFEE:
+			st.push(bigOrZero(in.vm.BaseFee))
+		case ASSETBALANCE:
+			addr, asset := bigToAddress(st.pop()), types.AssetID(types.BytesToHash(st.pop().Bytes()))
+			st.push(in.vm.state.GetBalance(addr, asset))
+
+		// storage and execution
+		case POP:
+			st.pop()
+		case MLOAD:
+			offset, _, err := in.memArgs(st.peek(), big32)
+			if err != nil {
+				return nil, err
+			}
+			st.peek().SetBytes(in.mem.get(offset, 32))
+		case MSTORE:
+			offset, _, err := in.memArgs(st.pop(), big32)
+			if err != nil {
+				return nil, err
+			}
+			in.mem.set32(offset, st.pop())
+		case MSTORE8:
+			offset, _, err := in.memArgs(st.pop(), big1)
+			if err != nil {
+				return nil, err
+			}
+			in.mem.store[offset] = byte(st.pop().Uint64() & 0xff)
+		case SLOAD:
+			loc := st.peek()
+			val := in.vm.state.GetState(c.address, types.BytesToHash(loc.Bytes()))
+			loc.SetBytes(val[:])
+		case SSTORE:
+			if c.readOnly {
+				return nil, ErrWriteProtection
+			}
+			loc, val := types.BytesToHash(st.pop().Bytes()), st.pop()
+			gas := SstoreResetGas
+			current := in.vm.state.GetState(c.address, loc)
+			if current == (types.Hash{}) && val.Sign() != 0 {
+				gas = SstoreSetGas
+			}
+			if !c.useGas(gas) {
+				return nil, ErrOutOfGas
+			}
+			if current != (types.Hash{}) && val.Sign() == 0 {
+				in.vm.refund += SstoreClearRefund
+			}
+			in.vm.state.SetState(c.address, loc, types.BytesToHash(val.Bytes()))
+		case JUMP:
+			pos := st.pop()
+			if !c.validJumpdest(pos) {
+				return nil, ErrInvalidJump
+			}
+			in.pc = pos.Uint64()
+			continue
+		case JUMPI:
+			pos, cond := st.pop(), st.pop()
+			if cond.Sign() != 0 {
+				if !c.validJumpdest(pos) {
+					return nil, ErrInvalidJump
+				}
+				in.pc = pos.Uint64()
+				continue
+			}
+		case PC:
+			st.push(new(big.Int).SetUint64(in.pc))
+		case MSIZE:
+			st.push(new(big.Int).SetUint64(uint64(in.mem.len())))
+		case GAS:
+			st.push(new(big.Int).SetUint64(c.gas))
+		case JUMPDEST:
+		case MCOPY:
+			dst, src, length := st.pop(), st.pop(), st.pop()
+			srcOffset, size, err := memRange(src, length)
+			if err != nil {
+				return nil, err
+			}
+			dstOffset, _, err := memRange(dst, length)
+			if err != nil {
+				return nil, err
+			}
+			if err := in.expand(maxUint64(srcOffset, dstOffset) + size); err != nil {
+				return nil, err
+			}
+			if !c.useGas(toWordSize(size) * CopyGas) {
+				return nil, ErrOutOfGas
+			}
+			in.mem.set(dstOffset, size, in.mem.get(srcOffset, size))
+		case PUSH0:
+			st.push(new(big.Int))
+
+		// logging
+		case LOG0, LOG1, LOG2, LOG3, LOG4:
+			if c.readOnly {
+				return nil, ErrWriteProtection
+			}
+			offset, size, err := in.memArgs(st.pop(), st.pop())
+			if err != nil {
+				return nil, err
+			}
+			if size > math.MaxUint64/LogDataGas || !c.useGas(size*LogDataGas) {
+				return nil, ErrOutOfGas
+			}
+			topics := make([]types.Hash, int(op-LOG0))
+			for i := range topics {
+				topics[i] = types.BytesToHash(st.pop().Bytes())
+			}
+			in.vm.state.AddLog(&types.Log{
+				Address: c.address,
+				Topics:  topics,
+				Data:    in.mem.get(offset, size),
+			})
+
+		// closures
+		case CREATE, CREATE2:
+			if c.readOnly {
+				return nil, ErrWriteProtection
+			}
+			value, memOffset, length := st.pop(), st.pop(), st.pop()
+			var salt *big.Int
+			if op == CREATE2 {
+				salt = st.pop()
+			}
+			offset, size, err := in.memArgs(memOffset, length)
+			if err != nil {
+				return nil, err
+			}
+			if op == CREATE2 && !c.useGas(toWordSize(size)*Sha3WordGas) {
+				return nil, ErrOutOfGas
+			}
+			code := in.mem.get(offset, size)
+			var addr types.Address
+			if op == CREATE {
+				addr = CreateAddress(c.address, in.vm.state.GetNonce(c.address))
+			} else {
+				addr = CreateAddress2(c.address, types.BytesToHash(salt.Bytes()), code)
+			}
+
+			gas := callGas(c.gas, nil)
+			c.useGas(gas)
+			ret, left, err := in.vm.create(c.address, addr, code, gas, types.NativeAssetID, value, true)
+			c.gas += left
+			if err != nil {
+				st.push(new(big.Int))
+			} else {
+				st.push(addressToBig(addr))
+			}
+			// only a failing constructor returns data to its creator
+			in.returnData = nil
+			if err == ErrExecutionReverted {
+				in.returnData = ret
+			}
+		case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+			requested, addr := st.pop(), bigToAddress(st.pop())
+			value := new(big.Int)
+			if op == CALL || op == CALLCODE {
+				value = st.pop()
+			}
+			inOffset, inSize, err := memRange(st.pop(), st.pop())
+			if err != nil {
+				return nil, err
+			}
+			retOffset, retSize, err := memRange(st.pop(), st.pop())
+			if err != nil {
+				return nil, err
+			}
+			if op == CALL && value.Sign() != 0 && c.readOnly {
+				return nil, ErrWriteProtection
+			}
+			if err := in.expand(maxUint64(inOffset+inSize, retOffset+retSize)); err != nil {
+				return nil, err
+			}
+			var extra uint64
+			if value.Sign() != 0 {
+				extra = CallValueGas
+				if op == CALL && in.vm.empty(addr) {
+					extra += CallNewAccountGas
+				}
+			}
+			if !c.useGas(extra) {
+				return nil, ErrOutOfGas
+			}
+			gas := callGas(c.gas, requested)
+			c.useGas(gas)
+			if value.Sign() != 0 {
+				gas += CallStipend
+			}
+
+			input := in.mem.get(inOffset, inSize)
+			var (
+				ret  []byte
+				left uint64
+			)
+			switch op {
+			case CALL:
+				ret, left, err = in.vm.call(c.address, addr, input, gas, types.NativeAssetID, value, c.readOnly)
+			case CALLCODE:
+				ret, left, err = in.vm.callCode(c, addr, input, gas, value, false)
+			case DELEGATECALL:
+				ret, left, err = in.vm.callCode(c, addr, input, gas, nil, true)
+			case STATICCALL:
+				ret, left, err = in.vm.call(c.address, addr, input, gas, types.NativeAssetID, nil, true)
+			}
+			c.gas += left
+			st.push(new(big.Int))
+			if err == nil {
+				st.peek().SetUint64(1)
+			}
+			if err == nil || err == ErrExecutionReverted {
+				if uint64(len(ret)) < retSize {
+					retSize = uint64(len(ret))
+				}
+				in.mem.set(retOffset, retSize, ret)
+			}
+			in.returnData = ret
+		case SELFDESTRUCT:
+			if c.readOnly {
+				return nil, ErrWriteProtection
+			}
+			beneficiary := bigToAddress(st.pop())
+			balance := in.vm.state.GetBalance(c.address, types.NativeAssetID)
+			if balance.Sign() != 0 && in.vm.empty(beneficiary) && !c.useGas(CallNewAccountGas) {
+				return nil, ErrOutOfGas
+			}
+			if beneficiary != c.address {
+				in.vm.state.SubBalance(c.address, types.NativeAssetID, balance)
+				in.vm.state.AddBalance(beneficiary, types.NativeAssetID, balance)
+			}
+			if in.vm.created[c.address] {
+				// a contract created by this transaction is deleted and
+				// anything it still holds is burned
+				if beneficiary == c.address {
+					in.vm.state.SubBalance(c.address, types.NativeAssetID, balance)
+				}
+				in.vm.state.SetCode(c.address, nil)
+				in.vm.state.SetNonce(c.address, 0)
+				in.vm.state.ClearStorage(c.address)
+			}
+			return nil, nil
+		case RETURN, REVERT:
+			offset, size, err := in.memArgs(st.pop(), st.pop())
+			if err != nil {
+				return nil, err
+			}
+			ret := in.mem.get(offset, size)
+			if op == REVERT {
+				return ret, ErrExecutionReverted
+			}
+			return ret, nil
+		}
+		in.pc++
+	}
+}
+
+// memArgs validates an (offset, size) pair, charges for memory expansion
+// and grows the memory so that the range can be accessed.
+func (in *interpreter) memArgs(offset, size *big.Int) (uint64, uint64, error) {
+	o, s, err := memRange(offset, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := in.expand(o + s); err != nil {
+		return 0, 0, err
+	}
+	return o, s, nil
+}
+
+// memRange validates an (offset, size) pair. An empty range is at offset 0
+// so that it never expands the memory.
+func memRange(offset, size *big.Int) (uint64, uint64, error) {
+	if size.Sign() == 0 {
+		return 0, 0, nil
+	}
+	if !offset.IsUint64() || !size.IsUint64() {
+		return 0, 0, ErrGasUintOverflow
+	}
+	o, s := offset.Uint64(), size.Uint64()
+	if o > math.MaxUint64-s {
+		return 0, 0, ErrGasUintOverflow
+	}
+	return o, s, nil
+}
+
+// expand charges for and grows the memory to hold end bytes.
+func (in *interpreter) expand(end uint64) error {
+	if end == 0 {
+		return nil
+	}
+	if end > 0x1FFFFFFFE0 {
+		return ErrGasUintOverflow
+	}
+	newSize := toWordSize(end) * 32
+	gas, err := memoryGasCost(in.mem, newSize)
+	if err != nil {
+		return err
+	}
+	if !in.c.useGas(gas) {
+		return ErrOutOfGas
+	}
+	in.mem.resize(newSize)
+	return nil
+}
+
+// getData returns size bytes of data starting at start, right padded with zeros.
+func getData(data []byte, start *big.Int, size uint64) []byte {
+	ret := make([]byte, size)
+	if !start.IsUint64() || start.Uint64() >= uint64(len(data)) {
+		return ret
+	}
+	copy(ret, data[start.Uint64():])
+	return ret
+}
+
+func maxUint64(x, y uint64) uint64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func setBool(x *big.Int, b bool) {
+	if b {
+		x.SetUint64(1)
+	} else {
+		x.SetUint64(0)
+	}
+}
+
+func bigOrZero(x *big.Int) *big.Int {
+	if x == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(x)
+}
+
+func addressToBig(addr types.Address) *big.Int {
+	return new(big.Int).SetBytes(addr[:])
+}
+
+func bigToAddress(x *big.Int) types.Address {
+	return types.BytesToAddress(x.Bytes())
+}
@@ -0,0 +1,47 @@
+package vm
+
+import (
+	"math/big"
+
+	"github.com/go-fusion/common/math"
+)
+
+// memory is the byte addressable scratch space of a single execution.
+type memory struct {
+	store []byte
+}
+
+func newMemory() *memory {
+	return &memory{}
+}
+
+func (m *memory) len() int {
+	return len(m.store)
+}
+
+// resize grows the memory to size bytes. Gas must already be paid.
+func (m *memory) resize(size uint64) {
+	if uint64(len(m.store)) < size {
+		m.store = append(m.store, make([]byte, size-uint64(len(m.store)))...)
+	}
+}
+
+func (m *memory) set(offset, size uint64, value []byte) {
+	if size > 0 {
+		copy(m.store[offset:offset+size], value)
+	}
+}
+
+func (m *memory) set32(offset uint64, val *big.Int) {
+	copy(m.store[offset:offset+32], math.PaddedBigBytes(val, 32))
+}
+
+// get returns a copy of size bytes starting at offset.
+func (m *memory) get(offset, size uint64) []byte {
+	if size == 0 {
+		return nil
+	}
+	cpy := make([]byte, size)
+	copy(cpy, m.store[offset:offset+size])
+	return cpy
+}
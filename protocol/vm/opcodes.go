@@ -0,0 +1,190 @@
+package vm
+
+import (
+	"fmt"
+)
+
+// OpCode is a single byte instruction of the contract VM. The numbering
+// and semantics follow the EVM, see VM for the differences.
+type OpCode byte
+
+// 0x0 range - arithmetic ops.
+const (
+	STOP OpCode = iota
+	ADD
+	MUL
+	SUB
+	DIV
+	SDIV
+	MOD
+	SMOD
+	ADDMOD
+	MULMOD
+	EXP
+	SIGNEXTEND
+)
+
+// 0x10 range - comparison and bitwise ops.
+const (
+	LT OpCode = iota + 0x10
+	GT
+	SLT
+	SGT
+	EQ
+	ISZERO
+	AND
+	OR
+	XOR
+	NOT
+	BYTE
+	SHL
+	SHR
+	SAR
+
+	SHA3 OpCode = 0x20
+)
+
+// 0x30 range - closure state.
+const (
+	ADDRESS OpCode = 0x30 + iota
+	BALANCE
+	ORIGIN
+	CALLER
+	CALLVALUE
+	CALLDATALOAD
+	CALLDATASIZE
+	CALLDATACOPY
+	CODESIZE
+	CODECOPY
+	GASPRICE
+	EXTCODESIZE
+	EXTCODECOPY
+	RETURNDATASIZE
+	RETURNDATACOPY
+	EXTCODEHASH
+)
+
+// 0x40 range - block operations.
+const (
+	BLOCKHASH OpCode = 0x40 + iota
+	COINBASE
+	TIMESTAMP
+	NUMBER
+	_ // PREVRANDAO is not supported
+	GASLIMIT
+	_ // CHAINID is not supported
+	SELFBALANCE
+	BASEFEE
+)
+
+// 0x50 range - storage and execution.
+const (
+	POP OpCode = 0x50 + iota
+	MLOAD
+	MSTORE
+	MSTORE8
+	SLOAD
+	SSTORE
+	JUMP
+	JUMPI
+	PC
+	MSIZE
+	GAS
+	JUMPDEST
+
+	MCOPY OpCode = 0x5e
+	PUSH0 OpCode = 0x5f
+)
+
+// 0x60 range - push, dup and swap.
+const (
+	PUSH1  OpCode = 0x60
+	PUSH32 OpCode = 0x7f
+	DUP1   OpCode = 0x80
+	DUP16  OpCode = 0x8f
+	SWAP1  OpCode = 0x90
+	SWAP16 OpCode = 0x9f
+)
+
+// 0xa0 range - logging ops.
+const (
+	LOG0 OpCode = 0xa0 + iota
+	LOG1
+	LOG2
+	LOG3
+	LOG4
+)
+
+// Fusion extensions.
+const (
+	// ASSETBALANCE pops an address and an asset ID and pushes the balance
+	// of that asset. BALANCE and SELFBALANCE report the native asset.
+	ASSETBALANCE OpCode = 0xee
+)
+
+// 0xf0 range - closures.
+const (
+	CREATE       OpCode = 0xf0
+	CALL         OpCode = 0xf1
+	CALLCODE     OpCode = 0xf2
+	RETURN       OpCode = 0xf3
+	DELEGATECALL OpCode = 0xf4
+	CREATE2      OpCode = 0xf5
+	STATICCALL   OpCode = 0xfa
+	REVERT       OpCode = 0xfd
+	INVALID      OpCode = 0xfe
+	SELFDESTRUCT OpCode = 0xff
+)
+
+var opCodeToString = map[OpCode]string{
+	STOP: "STOP", ADD: "ADD", MUL: "MUL", SUB: "SUB", DIV: "DIV", SDIV: "SDIV",
+	MOD: "MOD", SMOD: "SMOD", ADDMOD: "ADDMOD", MULMOD: "MULMOD", EXP: "EXP",
+	SIGNEXTEND: "SIGNEXTEND",
+
+	LT: "LT", GT: "GT", SLT: "SLT", SGT: "SGT", EQ: "EQ", ISZERO: "ISZERO",
+	AND: "AND", OR: "OR", XOR: "XOR", NOT: "NOT", BYTE: "BYTE",
+	SHL: "SHL", SHR: "SHR", SAR: "SAR", SHA3: "SHA3",
+
+	ADDRESS: "ADDRESS", BALANCE: "BALANCE", ORIGIN: "ORIGIN", CALLER: "CALLER",
+	CALLVALUE: "CALLVALUE", CALLDATALOAD: "CALLDATALOAD", CALLDATASIZE: "CALLDATASIZE",
+	CALLDATACOPY: "CALLDATACOPY", CODESIZE: "CODESIZE", CODECOPY: "CODECOPY",
+	GASPRICE: "GASPRICE", EXTCODESIZE: "EXTCODESIZE", EXTCODECOPY: "EXTCODECOPY",
+	RETURNDATASIZE: "RETURNDATASIZE", RETURNDATACOPY: "RETURNDATACOPY",
+	EXTCODEHASH: "EXTCODEHASH",
+
+	BLOCKHASH: "BLOCKHASH", COINBASE: "COINBASE", TIMESTAMP: "TIMESTAMP",
+	NUMBER: "NUMBER", GASLIMIT: "GASLIMIT", SELFBALANCE: "SELFBALANCE",
+	BASEFEE: "BASEFEE",
+
+	POP: "POP", MLOAD: "MLOAD", MSTORE: "MSTORE", MSTORE8: "MSTORE8",
+	SLOAD: "SLOAD", SSTORE: "SSTORE", JUMP: "JUMP", JUMPI: "JUMPI", PC: "PC",
+	MSIZE: "MSIZE", GAS: "GAS", JUMPDEST: "JUMPDEST", MCOPY: "MCOPY", PUSH0: "PUSH0",
+
+	LOG0: "LOG0", LOG1: "LOG1", LOG2: "LOG2", LOG3: "LOG3", LOG4: "LOG4",
+
+	ASSETBALANCE: "ASSETBALANCE",
+
+	CREATE: "CREATE", CALL: "CALL", CALLCODE: "CALLCODE", RETURN: "RETURN",
+	DELEGATECALL: "DELEGATECALL", CREATE2: "CREATE2", STATICCALL: "STATICCALL",
+	REVERT: "REVERT", INVALID: "INVALID", SELFDESTRUCT: "SELFDESTRUCT",
+}
+
+// IsPush returns true if op pushes immediate bytes from the code.
+func (op OpCode) IsPush() bool {
+	return op >= PUSH1 && op <= PUSH32
+}
+
+func (op OpCode) String() string {
+	switch {
+	case op.IsPush():
+		return fmt.Sprintf("PUSH%d", int(op-PUSH1)+1)
+	case op >= DUP1 && op <= DUP16:
+		return fmt.Sprintf("DUP%d", int(op-DUP1)+1)
+	case op >= SWAP1 && op <= SWAP16:
+		return fmt.Sprintf("SWAP%d", int(op-SWAP1)+1)
+	}
+	if str, ok := opCodeToString[op]; ok {
+		return str
+	}
+	return fmt.Sprintf("opcode 0x%x not defined", byte(op))
+}
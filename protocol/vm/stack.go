@@ -0,0 +1,61 @@
+package vm
+
+import (
+	"math/big"
+)
+
+// stackLimit is the maximum number of words on the stack.
+const stackLimit = 1024
+
+// stack holds 256 bit words. Callers check the required depth with
+// require before popping.
+type stack struct {
+	data []*big.Int
+}
+
+func newStack() *stack {
+	return &stack{data: make([]*big.Int, 0, 16)}
+}
+
+func (st *stack) len() int {
+	return len(st.data)
+}
+
+func (st *stack) push(d *big.Int) {
+	st.data = append(st.data, d)
+}
+
+func (st *stack) pop() *big.Int {
+	ret := st.data[len(st.data)-1]
+	st.data = st.data[:len(st.data)-1]
+	return ret
+}
+
+func (st *stack) peek() *big.Int {
+	return st.data[len(st.data)-1]
+}
+
+// back returns the n'th item counted from the top, starting at 0.
+func (st *stack) back(n int) *big.Int {
+	return st.data[len(st.data)-n-1]
+}
+
+func (st *stack) swap(n int) {
+	st.data[len(st.data)-n], st.data[len(st.data)-1] = st.data[len(st.data)-1], st.data[len(st.data)-n]
+}
+
+func (st *stack) dup(n int) {
+	st.push(new(big.Int).Set(st.data[len(st.data)-n]))
+}
+
+// require returns an error unless pop items can be popped and push items
+// pushed afterwards without overflowing.
+func (st *stack) require(pop, push int) error {
+	if st.len() < pop {
+		return ErrStackUnderflow{st.len(), pop}
+	}
+	if st.len()-pop+push > stackLimit {
+		return ErrStackOverflow{st.len() - pop + push, stackLimit}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package vm
+
+import (
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/go-fusion/protocol/types"
+)
+
+// StateDB is the state a contract executes against. Every method that
+// modifies the state must be revertible through RevertToSnapshot.
+type StateDB interface {
+	GetBalance(types.Address, types.AssetID) *big.Int
+	AddBalance(types.Address, types.AssetID, *big.Int)
+	SubBalance(types.Address, types.AssetID, *big.Int)
+
+	GetNonce(types.Address) uint64
+	SetNonce(types.Address, uint64)
+
+	GetCode(types.Address) []byte
+	SetCode(types.Address, []byte)
+
+	GetState(types.Address, types.Hash) types.Hash
+	SetState(types.Address, types.Hash, types.Hash)
+	ClearStorage(types.Address)
+
+	AddLog(*types.Log)
+
+	Snapshot() int
+	RevertToSnapshot(int)
+}
+
+// GetHashFunc returns the hash of the block at height, or the zero Hash if
+// it is unknown.
+type GetHashFunc func(height uint64) types.Hash
+
+// Context provides the transaction and block information a contract can read.
+type Context struct {
+	Origin    types.Address // sender of the transaction
+	GasPrice  *big.Int
+	Coinbase  types.Address // validator of the block
+	Height    uint64
+	Timestamp uint64
+	GasLimit  uint64 // gas limit of the block
+	BaseFee   *big.Int
+	GetHash   GetHashFunc // for BLOCKHASH, may be nil
+}
+
+// VM executes contract code with the semantics of the Shanghai EVM, with
+// these differences:
+//
+//   - Accounts hold any number of assets. Value moved by a transaction is of
+//     the asset it names, value moved by CALL, CREATE and SELFDESTRUCT is
+//     always the native asset. ASSETBALANCE (0xee) reads the balance of any
+//     asset and every transfer emits a Transfer log.
+//   - Gas costs are those of Istanbul without the net SSTORE metering of
+//     EIP-2200 and without the access lists of EIP-2929: SSTORE costs 20000
+//     to set a slot and 5000 otherwise, clearing a slot refunds 4800 and the
+//     refund is capped to a fifth of the gas used (EIP-3529).
+//   - SELFDESTRUCT behaves as after EIP-6780: the code is only deleted when
+//     the contract was created by the same transaction.
+//   - PREVRANDAO, CHAINID and the Cancun additions other than MCOPY are not
+//     supported and fail as invalid opcodes.
+//
+// It is deterministic: the result depends only on the Context, the state
+// and the input.
+// NOTE: Not goroutine safe; use one VM per transaction.
+type VM struct {
+	Context
+	state StateDB
+
+	depth   int
+	refund  uint64
+	created map[types.Address]bool // contracts created by this transaction
+}
+
+// New returns a VM that executes against state.
+func New(ctx Context, state StateDB) *VM {
+	return &VM{
+		Context: ctx,
+		state:   state,
+		created: make(map[types.Address]bool),
+	}
+}
+
+// Refund returns the gas refunded by the executions so far, before the cap.
+func (vm *VM) Refund() uint64 {
+	return vm.refund
+}
+
+// CreateAddress returns the address of the contract deployed by caller
+// with nonce: the last 20 bytes of keccak256(rlp([caller, nonce])).
+func CreateAddress(caller types.Address, nonce uint64) types.Address {
+	var enc []byte
+	switch {
+	case nonce == 0:
+		enc = []byte{0x80}
+	case nonce < 0x80:
+		enc = []byte{byte(nonce)}
+	default:
+		b := new(big.Int).SetUint64(nonce).Bytes()
+		enc = append([]byte{0x80 + byte(len(b))}, b...)
+	}
+	data := make([]byte, 0, 2+types.AddressBytesNumber+len(enc))
+	data = append(data, 0xc0+byte(1+types.AddressBytesNumber+len(enc)), 0x80+types.AddressBytesNumber)
+	data = append(data, caller[:]...)
+	data = append(data, enc...)
+	return types.BytesToAddress(keccak256(data)[12:])
+}
+
+// CreateAddress2 returns the address of the contract deployed by caller
+// with CREATE2: the last 20 bytes of
+// keccak256(0xff ++ caller ++ salt ++ keccak256(code)).
+func CreateAddress2(caller types.Address, salt types.Hash, code []byte) types.Address {
+	return types.BytesToAddress(keccak256([]byte{0xff}, caller[:], salt[:], keccak256(code))[12:])
+}
+
+// Create runs code as the constructor of a new contract and stores the
+// returned bytes as the contract code. value of asset moves from caller to
+// the new contract before the constructor runs. The address depends on the
+// current nonce of caller, which is left for the caller to increment.
+func (vm *VM) Create(caller types.Address, code []byte, gas uint64, asset types.AssetID, value *big.Int) (ret []byte, addr types.Address, leftOverGas uint64, err error) {
+	addr = CreateAddress(caller, vm.state.GetNonce(caller))
+	ret, leftOverGas, err = vm.create(caller, addr, code, gas, asset, value, false)
+	return ret, addr, leftOverGas, err
+}
+
+// Call executes the code of addr with input. value of asset moves from
+// caller to addr before the code runs.
+func (vm *VM) Call(caller, addr types.Address, input []byte, gas uint64, asset types.AssetID, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	return vm.call(caller, addr, input, gas, asset, value, false)
+}
+
+// create deploys the contract at addr. A contract creating another one
+// increments its own nonce, which a transaction leaves to the caller.
+func (vm *VM) create(caller, addr types.Address, code []byte, gas uint64, asset types.AssetID, value *big.Int, incNonce bool) ([]byte, uint64, error) {
+	if vm.depth > callDepthLimit {
+		return nil, gas, ErrDepth
+	}
+	if value != nil && vm.state.GetBalance(caller, asset).Cmp(value) < 0 {
+		return nil, gas, ErrInsufficientBalance
+	}
+	if incNonce {
+		vm.state.SetNonce(caller, vm.state.GetNonce(caller)+1)
+	}
+	if len(vm.state.GetCode(addr)) != 0 || vm.state.GetNonce(addr) != 0 {
+		return nil, 0, ErrContractAddressTaken
+	}
+
+	snapshot, refund := vm.state.Snapshot(), vm.refund
+	vm.state.SetNonce(addr, 1)
+	if err := vm.transfer(caller, addr, asset, value); err != nil {
+		vm.state.RevertToSnapshot(snapshot)
+		return nil, gas, err
+	}
+	vm.created[addr] = true
+
+	c := newContract(caller, addr, code, nil, value, gas)
+	ret, err := vm.run(c)
+
+	if err == nil && len(ret) > MaxCodeSize {
+		err = ErrMaxCodeSizeExceeded
+	}
+	if err == nil && len(ret) > 0 && ret[0] == 0xef {
+		err = ErrInvalidCode
+	}
+	if err == nil {
+		createDataGas := uint64(len(ret)) * CreateDataGas
+		if c.useGas(createDataGas) {
+			vm.state.SetCode(addr, ret)
+		} else {
+			err = ErrOutOfGas
+		}
+	}
+	return ret, vm.finish(c, snapshot, refund, err), err
+}
+
+// call executes the code of addr in its own context. A read only call and
+// everything it calls cannot modify the state.
+func (vm *VM) call(caller, addr types.Address, input []byte, gas uint64, asset types.AssetID, value *big.Int, readOnly bool) ([]byte, uint64, error) {
+	if vm.depth > callDepthLimit {
+		return nil, gas, ErrDepth
+	}
+
+	snapshot, refund := vm.state.Snapshot(), vm.refund
+	if err := vm.transfer(caller, addr, asset, value); err != nil {
+		return nil, gas, err
+	}
+
+	code := vm.state.GetCode(addr)
+	if len(code) == 0 {
+		return nil, gas, nil
+	}
+
+	c := newContract(caller, addr, code, input, value, gas)
+	c.readOnly = readOnly
+	ret, err := vm.run(c)
+	return ret, vm.finish(c, snapshot, refund, err), err
+}
+
+// callCode executes the code of codeAddr in the context of parent. With
+// DELEGATECALL the caller and value of parent are kept, with CALLCODE the
+// caller is parent itself and value is checked against its balance.
+func (vm *VM) callCode(parent *contract, codeAddr types.Address, input []byte, gas uint64, value *big.Int, delegate bool) ([]byte, uint64, error) {
+	if vm.depth > callDepthLimit {
+		return nil, gas, ErrDepth
+	}
+	caller := parent.address
+	if delegate {
+		caller, value = parent.caller, parent.value
+	} else if vm.state.GetBalance(parent.address, types.NativeAssetID).Cmp(value) < 0 {
+		return nil, gas, ErrInsufficientBalance
+	}
+
+	code := vm.state.GetCode(codeAddr)
+	if len(code) == 0 {
+		return nil, gas, nil
+	}
+
+	snapshot, refund := vm.state.Snapshot(), vm.refund
+	c := newContract(caller, parent.address, code, input, value, gas)
+	c.readOnly = parent.readOnly
+	ret, err := vm.run(c)
+	return ret, vm.finish(c, snapshot, refund, err), err
+}
+
+func (vm *VM) run(c *contract) ([]byte, error) {
+	vm.depth++
+	defer func() { vm.depth-- }()
+	return newInterpreter(vm, c).run()
+}
+
+// finish reverts the state and the refund on error and returns the gas left
+// to the caller. A REVERT keeps the unused gas, any other error consumes
+// all of it.
+func (vm *VM) finish(c *contract, snapshot int, refund uint64, err error) uint64 {
+	if err == nil {
+		return c.gas
+	}
+	vm.state.RevertToSnapshot(snapshot)
+	vm.refund = refund
+	if err == ErrExecutionReverted {
+		return c.gas
+	}
+	return 0
+}
+
+func (vm *VM) transfer(from, to types.Address, asset types.AssetID, value *big.Int) error {
+	if value == nil || value.Sign() == 0 {
+		return nil
+	}
+	if vm.state.GetBalance(from, asset).Cmp(value) < 0 {
+		return ErrInsufficientBalance
+	}
+	vm.state.SubBalance(from, asset, value)
+	vm.state.AddBalance(to, asset, value)
+	vm.state.AddLog(&types.Log{
+		Address: from,
+		Topics:  []types.Hash{types.TransferEventTopic, types.BytesToHash(from[:]), types.BytesToHash(to[:]), types.Hash(asset)},
+		Data:    value.Bytes(),
+	})
+	return nil
+}
+
+// empty returns true if addr has no nonce, native balance or code, in
+// which case moving value to it costs CallNewAccountGas.
+func (vm *VM) empty(addr types.Address) bool {
+	return vm.state.GetNonce(addr) == 0 &&
+		vm.state.GetBalance(addr, types.NativeAssetID).Sign() == 0 &&
+		len(vm.state.GetCode(addr)) == 0
+}
+
+func keccak256(data ...[]byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	for _, b := range data {
+		hasher.Write(b) // nolint: errcheck, gas
+	}
+	return hasher.Sum(nil)
+}
+
+//-----------------------------------------------------------------------------
+
+// contract is the execution frame of one call or creation.
+type contract struct {
+	caller   types.Address
+	address  types.Address
+	code     []byte
+	input    []byte
+	value    *big.Int
+	gas      uint64
+	readOnly bool // set inside STATICCALL
+
+	jumpdests []bool // valid JUMPDEST positions, computed lazily
+}
+
+func newContract(caller, address types.Address, code, input []byte, value *big.Int, gas uint64) *contract {
+	if value == nil {
+		value = new(big.Int)
+	}
+	return &contract{
+		caller:  caller,
+		address: address,
+		code:    code,
+		input:   input,
+		value:   value,
+		gas:     gas,
+	}
+}
+
+func (c *contract) useGas(gas uint64) bool {
+	if c.gas < gas {
+		return false
+	}
+	c.gas -= gas
+	return true
+}
+
+func (c *contract) getOp(n uint64) OpCode {
+	if n < uint64(len(c.code)) {
+		return OpCode(c.code[n])
+	}
+	return STOP
+}
+
+// validJumpdest returns true if dest is a JUMPDEST that is not part of push data.
+func (c *contract) validJumpdest(dest *big.Int) bool {
+	if !dest.IsUint64() || dest.Uint64() >= uint64(len(c.code)) {
+		return false
+	}
+	if c.jumpdests == nil {
+		c.jumpdests = make([]bool, len(c.code))
+		for pc := 0; pc < len(c.code); pc++ {
+			op := OpCode(c.code[pc])
+			if op == JUMPDEST {
+				c.jumpdests[pc] = true
+			} else if op.IsPush() {
+				pc += int(op-PUSH1) + 1
+			}
+		}
+	}
+	return c.jumpdests[dest.Uint64()]
+}
@@ -0,0 +1,506 @@
+package vm_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/go-fusion/protocol/state"
+	"github.com/go-fusion/protocol/types"
+	"github.com/go-fusion/protocol/vm"
+)
+
+var (
+	origin   = types.BytesToAddress([]byte{0x0a})
+	contract = types.BytesToAddress([]byte{0xc0})
+	callee   = types.BytesToAddress([]byte{0xc1})
+	empty    = types.BytesToAddress([]byte{0xe0})
+)
+
+// program assembles contract code.
+type program []byte
+
+func (p program) op(ops ...vm.OpCode) program {
+	for _, op := range ops {
+		p = append(p, byte(op))
+	}
+	return p
+}
+
+// push pushes b with the shortest PUSH instruction.
+func (p program) push(b []byte) program {
+	b = bytes.TrimLeft(b, "\x00")
+	if len(b) == 0 {
+		return p.op(vm.PUSH0)
+	}
+	return append(p.op(vm.PUSH1+vm.OpCode(len(b)-1)), b...)
+}
+
+func (p program) pushInt(n uint64) program {
+	return p.push(new(big.Int).SetUint64(n).Bytes())
+}
+
+// ret returns the word on top of the stack.
+func (p program) ret() program {
+	return p.pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).op(vm.RETURN)
+}
+
+// deploy returns init code that copies runtime, of 1 to 255 bytes, from
+// the end of its own code and returns it.
+func deploy(runtime []byte) []byte {
+	const prefixLen = 9
+	initCode := program{}.pushInt(uint64(len(runtime))).op(vm.DUP1).pushInt(prefixLen).pushInt(0).op(vm.CODECOPY).
+		pushInt(0).op(vm.RETURN)
+	return append(initCode, runtime...)
+}
+
+func newVM() (*state.StateDB, *vm.VM) {
+	s := state.New()
+	s.AddBalance(origin, types.NativeAssetID, big.NewInt(1000000))
+	evm := vm.New(vm.Context{
+		Origin:    origin,
+		GasPrice:  big.NewInt(1),
+		Height:    10,
+		Timestamp: 1000,
+		GasLimit:  8000000,
+		BaseFee:   big.NewInt(1),
+		GetHash: func(height uint64) types.Hash {
+			return types.BytesToHash([]byte{byte(height)})
+		},
+	}, s)
+	return s, evm
+}
+
+// pad left pads b to a 32 byte word.
+func pad(b []byte) []byte {
+	h := types.BytesToHash(b)
+	return h[:]
+}
+
+// word returns n as a 256 bit two's complement word.
+func word(n int64) []byte {
+	x := big.NewInt(n)
+	if n < 0 {
+		x.Add(x, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return pad(x.Bytes())
+}
+
+func TestCreateAddress(t *testing.T) {
+	caller, _ := hex.DecodeString("6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0")
+	for nonce, want := range []string{
+		"cd234a471b72ba2f1ccf0a70fcaba648a5eecd8d",
+		"343c43a37d37dff08ae8c4a11544c718abb4fcf8",
+		"f778b86fa74e846c4f0a1fbd1335fe81c00a0c91",
+		"fffd933a0bc612844eaf0c6fe3e5b8e9b6c1d19c",
+	} {
+		if got := vm.CreateAddress(types.BytesToAddress(caller), uint64(nonce)); hex.EncodeToString(got[:]) != want {
+			t.Errorf("nonce %d: got %x, want %s", nonce, got, want)
+		}
+	}
+
+	// EIP-1014 example 0
+	got := vm.CreateAddress2(types.Address{}, types.Hash{}, []byte{0x00})
+	if want := "4d1a2e2bb4f88f0250f26ffff098b0b30b26bf38"; hex.EncodeToString(got[:]) != want {
+		t.Errorf("CREATE2: got %x, want %s", got, want)
+	}
+}
+
+func TestOpcodes(t *testing.T) {
+	tests := []struct {
+		name string
+		code program
+		want []byte
+	}{
+		{"ADD", program{}.pushInt(1).pushInt(2).op(vm.ADD), word(3)},
+		{"SUB", program{}.pushInt(2).pushInt(5).op(vm.SUB), word(3)},
+		{"SUB wraps", program{}.pushInt(1).pushInt(0).op(vm.SUB), word(-1)},
+		{"DIV by zero", program{}.pushInt(0).pushInt(5).op(vm.DIV), word(0)},
+		{"SDIV", program{}.pushInt(2).pushInt(6).pushInt(0).op(vm.SUB, vm.SDIV), word(-3)},
+		{"MOD", program{}.pushInt(3).pushInt(10).op(vm.MOD), word(1)},
+		{"EXP", program{}.pushInt(10).pushInt(2).op(vm.EXP), word(1024)},
+		{"SIGNEXTEND", program{}.pushInt(0xff).pushInt(0).op(vm.SIGNEXTEND), word(-1)},
+		{"LT", program{}.pushInt(2).pushInt(1).op(vm.LT), word(1)},
+		{"SLT", program{}.pushInt(0).pushInt(1).pushInt(0).op(vm.SUB, vm.SLT), word(1)},
+		{"ISZERO", program{}.pushInt(0).op(vm.ISZERO), word(1)},
+		{"SHL", program{}.pushInt(1).pushInt(4).op(vm.SHL), word(16)},
+		{"SAR", program{}.pushInt(1).pushInt(0).op(vm.SUB).pushInt(4).op(vm.SAR), word(-1)},
+		{"BYTE", program{}.pushInt(0x1234).pushInt(30).op(vm.BYTE), word(0x12)},
+		{"PUSH0", program{}.op(vm.PUSH0), word(0)},
+		{"CALLVALUE", program{}.op(vm.CALLVALUE), word(7)},
+		{"CALLDATALOAD", program{}.pushInt(0).op(vm.CALLDATALOAD), word(0x42)},
+		{"ORIGIN", program{}.op(vm.ORIGIN), pad(origin[:])},
+		{"CALLER", program{}.op(vm.CALLER), pad(origin[:])},
+		{"NUMBER", program{}.op(vm.NUMBER), word(10)},
+		{"GASLIMIT", program{}.op(vm.GASLIMIT), word(8000000)},
+		{"BLOCKHASH", program{}.pushInt(9).op(vm.BLOCKHASH), word(9)},
+		{"BLOCKHASH current", program{}.pushInt(10).op(vm.BLOCKHASH), word(0)},
+		{"SELFBALANCE", program{}.op(vm.SELFBALANCE), word(7)},
+		{"MCOPY", program{}.pushInt(0x55).pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).pushInt(32).op(vm.MCOPY).pushInt(32).op(vm.MLOAD), word(0x55)},
+		{"EXTCODESIZE", program{}.push(callee[:]).op(vm.EXTCODESIZE), word(3)},
+	}
+	for _, tt := range tests {
+		s, evm := newVM()
+		s.SetCode(contract, tt.code.ret())
+		s.SetCode(callee, []byte{0, 0, 0})
+		ret, _, err := evm.Call(origin, contract, word(0x42), 100000, types.NativeAssetID, big.NewInt(7))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(ret, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, ret, tt.want)
+		}
+	}
+}
+
+func TestInvalidOpcodes(t *testing.T) {
+	for _, op := range []vm.OpCode{0x44, 0x46, 0x5c, vm.INVALID} {
+		s, evm := newVM()
+		s.SetCode(contract, []byte{byte(op)})
+		_, left, err := evm.Call(origin, contract, nil, 1000, types.NativeAssetID, nil)
+		if _, ok := err.(vm.ErrInvalidOpCode); !ok {
+			t.Errorf("%x: expected invalid opcode, got %v", byte(op), err)
+		}
+		if left != 0 {
+			t.Errorf("%x: expected all gas consumed, %d left", byte(op), left)
+		}
+	}
+}
+
+func TestGasAccounting(t *testing.T) {
+	slot := types.Hash{}
+	tests := []struct {
+		name   string
+		code   program
+		before types.Hash // value of slot 0 before the call
+		used   uint64
+		refund uint64
+	}{
+		{"arithmetic", program{}.pushInt(1).pushInt(2).op(vm.ADD, vm.POP), types.Hash{}, 3 + 3 + 3 + 2, 0},
+		{"memory expansion", program{}.pushInt(64).op(vm.MLOAD), types.Hash{}, 3 + 3 + 3*3 + 0, 0},
+		{"EXP", program{}.pushInt(0x100).pushInt(2).op(vm.EXP), types.Hash{}, 3 + 3 + 10 + 2*50, 0},
+		{"SSTORE set", program{}.pushInt(1).pushInt(0).op(vm.SSTORE), types.Hash{}, 3 + 2 + 20000, 0},
+		{"SSTORE reset", program{}.pushInt(2).pushInt(0).op(vm.SSTORE), types.BytesToHash([]byte{1}), 3 + 2 + 5000, 0},
+		{"SSTORE clear", program{}.pushInt(0).pushInt(0).op(vm.SSTORE), types.BytesToHash([]byte{1}), 2 + 2 + 5000, 4800},
+		{"SLOAD", program{}.pushInt(0).op(vm.SLOAD), types.Hash{}, 2 + 800, 0},
+		{"LOG1", program{}.pushInt(1).pushInt(32).pushInt(0).op(vm.LOG1), types.Hash{}, 3 + 3 + 2 + 3 + 750 + 32*8, 0},
+		{"CALL empty", program{}.pushInt(0).pushInt(0).pushInt(0).pushInt(0).pushInt(0).push(empty[:]).pushInt(50000).op(vm.CALL),
+			types.Hash{}, 5*2 + 3 + 3 + 700, 0},
+	}
+	for _, tt := range tests {
+		s, evm := newVM()
+		s.SetCode(contract, tt.code)
+		s.SetState(contract, slot, tt.before)
+		_, left, err := evm.Call(origin, contract, nil, 100000, types.NativeAssetID, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if used := 100000 - left; used != tt.used {
+			t.Errorf("%s: used %d gas, want %d", tt.name, used, tt.used)
+		}
+		if evm.Refund() != tt.refund {
+			t.Errorf("%s: refund %d, want %d", tt.name, evm.Refund(), tt.refund)
+		}
+	}
+}
+
+func TestCallValueToEmptyAccount(t *testing.T) {
+	s, evm := newVM()
+	code := program{}.pushInt(0).pushInt(0).pushInt(0).pushInt(0).pushInt(1).push(empty[:]).pushInt(0).op(vm.CALL)
+	s.SetCode(contract, code)
+	s.AddBalance(contract, types.NativeAssetID, big.NewInt(1))
+
+	_, left, err := evm.Call(origin, contract, nil, 100000, types.NativeAssetID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stipend given to the callee comes back unused
+	want := uint64(4*2+3+3+2) + vm.CallGas + vm.CallValueGas + vm.CallNewAccountGas - vm.CallStipend
+	if used := 100000 - left; used != want {
+		t.Errorf("used %d gas, want %d", used, want)
+	}
+	if b := s.GetBalance(empty, types.NativeAssetID); b.Int64() != 1 {
+		t.Errorf("expected the value to be transferred, balance %v", b)
+	}
+}
+
+func TestOutOfGas(t *testing.T) {
+	s, evm := newVM()
+	s.SetCode(contract, program{}.pushInt(1).pushInt(0).op(vm.SSTORE))
+
+	ret, left, err := evm.Call(origin, contract, nil, 10000, types.NativeAssetID, big.NewInt(5))
+	if err != vm.ErrOutOfGas {
+		t.Fatalf("expected out of gas, got %v", err)
+	}
+	if left != 0 || ret != nil {
+		t.Errorf("expected all gas consumed and no output, got %d %x", left, ret)
+	}
+	if v := s.GetState(contract, types.Hash{}); v != (types.Hash{}) {
+		t.Errorf("expected the store to be reverted, got %x", v)
+	}
+	if b := s.GetBalance(contract, types.NativeAssetID); b.Sign() != 0 {
+		t.Errorf("expected the value transfer to be reverted, got %v", b)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	s, evm := newVM()
+	code := program{}.pushInt(1).pushInt(0).op(vm.SSTORE).
+		pushInt(0xbeef).pushInt(0).op(vm.MSTORE).pushInt(2).pushInt(30).op(vm.REVERT)
+	s.SetCode(contract, code)
+
+	ret, left, err := evm.Call(origin, contract, nil, 100000, types.NativeAssetID, big.NewInt(5))
+	if err != vm.ErrExecutionReverted {
+		t.Fatalf("expected revert, got %v", err)
+	}
+	if !bytes.Equal(ret, []byte{0xbe, 0xef}) {
+		t.Errorf("expected the revert data, got %x", ret)
+	}
+	used := uint64(3+2+20000) + 3 + 2 + 3 + 3 + 3 + 3
+	if left != 100000-used {
+		t.Errorf("expected %d gas left, got %d", 100000-used, left)
+	}
+	if v := s.GetState(contract, types.Hash{}); v != (types.Hash{}) {
+		t.Errorf("expected the store to be reverted, got %x", v)
+	}
+	if b := s.GetBalance(origin, types.NativeAssetID); b.Int64() != 1000000 {
+		t.Errorf("expected the value transfer to be reverted, got %v", b)
+	}
+}
+
+func TestInvalidJump(t *testing.T) {
+	s, evm := newVM()
+	// the JUMPDEST at 4 is push data
+	s.SetCode(contract, program{}.pushInt(4).op(vm.JUMP).push([]byte{byte(vm.JUMPDEST)}))
+	if _, left, err := evm.Call(origin, contract, nil, 1000, types.NativeAssetID, nil); err != vm.ErrInvalidJump || left != 0 {
+		t.Errorf("expected invalid jump consuming all gas, got %v with %d left", err, left)
+	}
+
+	s.SetCode(contract, program{}.pushInt(3).op(vm.JUMP, vm.JUMPDEST))
+	if _, _, err := evm.Call(origin, contract, nil, 1000, types.NativeAssetID, nil); err != nil {
+		t.Errorf("expected a valid jump, got %v", err)
+	}
+}
+
+func TestStackUnderflow(t *testing.T) {
+	s, evm := newVM()
+	s.SetCode(contract, program{}.pushInt(1).op(vm.ADD))
+	if _, _, err := evm.Call(origin, contract, nil, 1000, types.NativeAssetID, nil); err == nil {
+		t.Error("expected stack underflow")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s, evm := newVM()
+	runtime := program{}.pushInt(42).ret()
+	addr := vm.CreateAddress(origin, s.GetNonce(origin))
+
+	_, got, left, err := evm.Create(origin, deploy(runtime), 100000, types.NativeAssetID, big.NewInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != addr {
+		t.Errorf("deployed at %x, want %x", got, addr)
+	}
+	if !bytes.Equal(s.GetCode(addr), runtime) {
+		t.Errorf("deployed %x, want %x", s.GetCode(addr), runtime)
+	}
+	if s.GetNonce(addr) != 1 {
+		t.Errorf("expected the contract nonce to start at 1, got %d", s.GetNonce(addr))
+	}
+	if s.GetBalance(addr, types.NativeAssetID).Int64() != 3 {
+		t.Error("expected the value to be transferred")
+	}
+	if used := 100000 - left; used < uint64(len(runtime))*vm.CreateDataGas {
+		t.Errorf("expected the code deposit to be charged, used %d", used)
+	}
+
+	ret, _, err := evm.Call(origin, addr, nil, 100000, types.NativeAssetID, nil)
+	if err != nil || !bytes.Equal(ret, word(42)) {
+		t.Errorf("calling the contract returned %x, %v", ret, err)
+	}
+
+	// deploying code starting with 0xef is rejected
+	_, _, _, err = evm.Create(callee, deploy([]byte{0xef}), 100000, types.NativeAssetID, nil)
+	if err != vm.ErrInvalidCode {
+		t.Errorf("expected invalid code, got %v", err)
+	}
+}
+
+// callProgram calls addr with op, forwarding all gas and returning its
+// status and first output word.
+func callProgram(op vm.OpCode, addr types.Address, value uint64) program {
+	p := program{}.pushInt(32).pushInt(0).pushInt(0).pushInt(0)
+	if op == vm.CALL || op == vm.CALLCODE {
+		p = p.pushInt(value)
+	}
+	p = p.push(addr[:]).op(vm.GAS, op)
+	// store the status at 32 and return both words
+	return p.pushInt(32).op(vm.MSTORE).pushInt(64).pushInt(0).op(vm.RETURN)
+}
+
+func TestCall(t *testing.T) {
+	s, evm := newVM()
+	// callee stores its caller and returns CALLVALUE
+	s.SetCode(callee, program{}.op(vm.CALLER).pushInt(0).op(vm.SSTORE).op(vm.CALLVALUE).ret())
+	s.SetCode(contract, callProgram(vm.CALL, callee, 2))
+	s.AddBalance(contract, types.NativeAssetID, big.NewInt(2))
+
+	ret, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(word(2), word(1)...); !bytes.Equal(ret, want) {
+		t.Errorf("got %x, want %x", ret, want)
+	}
+	if v := s.GetState(callee, types.Hash{}); v != types.BytesToHash(contract[:]) {
+		t.Errorf("expected the callee to see the contract as caller, got %x", v)
+	}
+	if s.GetBalance(callee, types.NativeAssetID).Int64() != 2 {
+		t.Error("expected the value to be transferred")
+	}
+}
+
+func TestCallRevertIsCaught(t *testing.T) {
+	s, evm := newVM()
+	s.SetCode(callee, program{}.pushInt(1).pushInt(0).op(vm.SSTORE).pushInt(7).pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).op(vm.REVERT))
+	s.SetCode(contract, callProgram(vm.CALL, callee, 0))
+
+	ret, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the revert data is copied and the status is 0
+	if want := append(word(7), word(0)...); !bytes.Equal(ret, want) {
+		t.Errorf("got %x, want %x", ret, want)
+	}
+	if v := s.GetState(callee, types.Hash{}); v != (types.Hash{}) {
+		t.Error("expected the callee store to be reverted")
+	}
+}
+
+func TestStaticCall(t *testing.T) {
+	s, evm := newVM()
+	s.SetCode(callee, program{}.pushInt(1).pushInt(0).op(vm.SSTORE))
+	s.SetCode(contract, callProgram(vm.STATICCALL, callee, 0))
+
+	ret, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(word(0), word(0)...); !bytes.Equal(ret, want) {
+		t.Errorf("expected the static call to fail, got %x", ret)
+	}
+	if v := s.GetState(callee, types.Hash{}); v != (types.Hash{}) {
+		t.Error("expected no store under STATICCALL")
+	}
+}
+
+func TestDelegateCall(t *testing.T) {
+	s, evm := newVM()
+	// stores CALLER and returns CALLVALUE, in the storage of the delegator
+	s.SetCode(callee, program{}.op(vm.CALLER).pushInt(0).op(vm.SSTORE).op(vm.CALLVALUE).ret())
+	s.SetCode(contract, callProgram(vm.DELEGATECALL, callee, 0))
+
+	ret, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, big.NewInt(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(word(9), word(1)...); !bytes.Equal(ret, want) {
+		t.Errorf("got %x, want %x", ret, want)
+	}
+	if v := s.GetState(contract, types.Hash{}); v != types.BytesToHash(origin[:]) {
+		t.Errorf("expected the delegator storage to hold the original caller, got %x", v)
+	}
+	if v := s.GetState(callee, types.Hash{}); v != (types.Hash{}) {
+		t.Error("expected the callee storage to be untouched")
+	}
+}
+
+func TestReturnData(t *testing.T) {
+	s, evm := newVM()
+	s.SetCode(callee, program{}.pushInt(5).ret())
+	code := program{}.pushInt(0).pushInt(0).pushInt(0).pushInt(0).push(callee[:]).op(vm.GAS, vm.STATICCALL, vm.POP).
+		op(vm.RETURNDATASIZE).pushInt(0).pushInt(0).op(vm.RETURNDATACOPY).pushInt(32).pushInt(0).op(vm.RETURN)
+	s.SetCode(contract, code)
+
+	ret, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, nil)
+	if err != nil || !bytes.Equal(ret, word(5)) {
+		t.Errorf("got %x, %v", ret, err)
+	}
+
+	// copying past the end of the return data fails
+	s.SetCode(contract, program{}.pushInt(1).pushInt(0).pushInt(0).op(vm.RETURNDATACOPY))
+	if _, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, nil); err != vm.ErrReturnDataOutOfRange {
+		t.Errorf("expected return data out of range, got %v", err)
+	}
+}
+
+func TestNestedCreate(t *testing.T) {
+	s, evm := newVM()
+	runtime := program{}.pushInt(1).ret()
+	initCode := deploy(runtime)
+	if len(initCode) > 32 {
+		t.Fatal("init code too long for the test")
+	}
+	// CREATE(0, 32-len(initCode), len(initCode)) after storing it in memory
+	buf := make([]byte, 32)
+	copy(buf[32-len(initCode):], initCode)
+	create := program{}.push(buf).pushInt(0).op(vm.MSTORE).
+		pushInt(uint64(len(initCode))).pushInt(uint64(32 - len(initCode))).pushInt(0).op(vm.CREATE).ret()
+	s.SetCode(contract, create)
+	s.SetNonce(contract, 1)
+
+	ret, _, err := evm.Call(origin, contract, nil, 200000, types.NativeAssetID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := vm.CreateAddress(contract, 1)
+	if !bytes.Equal(ret, pad(addr[:])) {
+		t.Errorf("created %x, want %x", ret, addr)
+	}
+	if !bytes.Equal(s.GetCode(addr), runtime) {
+		t.Errorf("deployed %x, want %x", s.GetCode(addr), runtime)
+	}
+	if s.GetNonce(contract) != 2 {
+		t.Errorf("expected the creator nonce to be incremented, got %d", s.GetNonce(contract))
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	s, evm := newVM()
+	// calls itself with all its gas until the gas or the depth runs out
+	s.SetCode(contract, program{}.pushInt(0).pushInt(0).pushInt(0).pushInt(0).pushInt(0).push(contract[:]).op(vm.GAS, vm.CALL))
+	if _, _, err := evm.Call(origin, contract, nil, 8000000, types.NativeAssetID, nil); err != nil {
+		t.Errorf("expected the recursion to end gracefully, got %v", err)
+	}
+}
+
+func TestSelfdestruct(t *testing.T) {
+	s, evm := newVM()
+	s.SetCode(contract, program{}.push(callee[:]).op(vm.SELFDESTRUCT))
+	s.AddBalance(contract, types.NativeAssetID, big.NewInt(4))
+	s.SetState(contract, types.Hash{}, types.BytesToHash([]byte{1}))
+
+	if _, _, err := evm.Call(origin, contract, nil, 100000, types.NativeAssetID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetBalance(callee, types.NativeAssetID).Int64() != 4 || s.GetBalance(contract, types.NativeAssetID).Sign() != 0 {
+		t.Error("expected the balance to move to the beneficiary")
+	}
+	// not created by this transaction: the contract survives
+	if len(s.GetCode(contract)) == 0 || s.GetState(contract, types.Hash{}) == (types.Hash{}) {
+		t.Error("expected the contract to survive")
+	}
+
+	_, addr, _, err := evm.Create(origin, program{}.push(callee[:]).op(vm.SELFDESTRUCT), 100000, types.NativeAssetID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.GetCode(addr)) != 0 || s.GetNonce(addr) != 0 {
+		t.Error("expected a contract created by the transaction to be deleted")
+	}
+}
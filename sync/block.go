@@ -1,9 +1,14 @@
 package sync
 
 import (
+	"sort"
+
 	"github.com/go-fusion/p2p"
 )
 
+// BlockProtocolVersion is the version of the block protocol advertised to peers
+const BlockProtocolVersion = 1
+
 // BlockReactor ss
 type BlockReactor struct {
 	p2p.BaseReactor
@@ -15,3 +20,23 @@ func NewBlockReactor() *BlockReactor {
 	blR.BaseReactor = *p2p.NewBaseReactor("BlockReactor", blR)
 	return blR
 }
+
+// ProtocolVersion implements p2p.VersionedReactor
+func (blR *BlockReactor) ProtocolVersion() uint64 {
+	return BlockProtocolVersion
+}
+
+// PeersAbove returns the peers whose head was above height at handshake,
+// highest first, to sync from.
+func (blR *BlockReactor) PeersAbove(height uint64) []p2p.Peer {
+	var peers []p2p.Peer
+	for _, peer := range blR.Switch.Peers().List() {
+		if peer.NodeInfo().HeadHeight > height {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].NodeInfo().HeadHeight > peers[j].NodeInfo().HeadHeight
+	})
+	return peers
+}
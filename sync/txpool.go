@@ -4,6 +4,9 @@ import (
 	"github.com/go-fusion/p2p"
 )
 
+// TxPoolProtocolVersion is the version of the txpool protocol advertised to peers
+const TxPoolProtocolVersion = 1
+
 // TxPoolReactor ss
 type TxPoolReactor struct {
 	p2p.BaseReactor
@@ -15,3 +18,8 @@ func NewPoolReactor() *TxPoolReactor {
 	txR.BaseReactor = *p2p.NewBaseReactor("TxPoolReactor", txR)
 	return txR
 }
+
+// ProtocolVersion implements p2p.VersionedReactor
+func (txR *TxPoolReactor) ProtocolVersion() uint64 {
+	return TxPoolProtocolVersion
+}